@@ -12,8 +12,10 @@ import (
 var (
 	fragmentDuration = flag.Duration("f", 0, "the fragment duration")
 	inputPath        = astiflag.Strings{}
+	mpegtsOffset     = flag.Int64("mpegts", 0, "the MPEG-TS time, in 90kHz units, matching the subtitles start")
 	outputPath       = flag.String("o", "", "the output path")
 	syncDuration     = flag.Duration("s", 0, "the sync duration")
+	ttmlProfile      = flag.String("ttml-profile", "", "the output TTML profile (imsc1.1-text, ebu-tt-d, smpte-tt or netflix-dfxp)")
 )
 
 func main() {
@@ -40,11 +42,17 @@ func main() {
 		astilog.Fatalf("%s while opening %s", err, inputPath[0])
 	}
 
+	// Init write options
+	var o = astisub.Options{
+		Dst:  *outputPath,
+		TTML: astisub.TTMLOptions{Profile: *ttmlProfile},
+	}
+
 	// Switch on subcommand
 	switch s {
 	case "convert":
 		// Write
-		if err = sub.Write(*outputPath); err != nil {
+		if err = sub.WriteWithOptions(o); err != nil {
 			astilog.Fatalf("%s while writing to %s", err, *outputPath)
 		}
 	case "fragment":
@@ -57,7 +65,23 @@ func main() {
 		sub.Fragment(*fragmentDuration)
 
 		// Write
-		if err = sub.Write(*outputPath); err != nil {
+		if err = sub.WriteWithOptions(o); err != nil {
+			astilog.Fatalf("%s while writing to %s", err, *outputPath)
+		}
+	case "hls":
+		// Validate fragment duration
+		if *fragmentDuration <= 0 {
+			astilog.Fatal("Use -f to provide a segment duration")
+		}
+
+		// Segment
+		var segs *astisub.WebVTTSegments
+		if segs, err = sub.SegmentWebVTT(*fragmentDuration, *mpegtsOffset); err != nil {
+			astilog.Fatalf("%s while segmenting %s", err, inputPath[0])
+		}
+
+		// Write
+		if err = segs.Write(*outputPath); err != nil {
 			astilog.Fatalf("%s while writing to %s", err, *outputPath)
 		}
 	case "merge":
@@ -76,7 +100,7 @@ func main() {
 		sub.Merge(sub2)
 
 		// Write
-		if err = sub.Write(*outputPath); err != nil {
+		if err = sub.WriteWithOptions(o); err != nil {
 			astilog.Fatalf("%s while writing to %s", err, *outputPath)
 		}
 	case "sync":
@@ -89,7 +113,7 @@ func main() {
 		sub.Add(*syncDuration)
 
 		// Write
-		if err = sub.Write(*outputPath); err != nil {
+		if err = sub.WriteWithOptions(o); err != nil {
 			astilog.Fatalf("%s while writing to %s", err, *outputPath)
 		}
 	case "unfragment":
@@ -97,7 +121,7 @@ func main() {
 		sub.Unfragment()
 
 		// Write
-		if err = sub.Write(*outputPath); err != nil {
+		if err = sub.WriteWithOptions(o); err != nil {
 			astilog.Fatalf("%s while writing to %s", err, *outputPath)
 		}
 	default:
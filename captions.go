@@ -0,0 +1,326 @@
+package astisub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astitools/map"
+	"github.com/pkg/errors"
+)
+
+// ATSC A/53 Part 4: MPEG-2 Video System Characteristics
+// ATSC A/65: Program and System Information Protocol (caption service descriptor)
+
+// Caption constants
+const (
+	captionDescriptorTagService = 0x86
+	captionNALTypeH264IDR       = 5
+	captionNALTypeH264SEI       = 6
+	captionNALTypeHEVCPrefixSEI = 39
+	captionNALTypeHEVCSuffixSEI = 40
+	captionSEIPayloadTypeT35    = 4
+	captionStreamTypeH264       = 0x1b
+	captionStreamTypeHEVC       = 0x24
+	captionT35CountryCode       = 0xb5
+	captionT35ProviderCode      = 0x31
+	captionUserDataTypeCCData   = 0x3
+)
+
+// Caption channel prefixes
+const (
+	captionChannelPrefixCEA608 = "CC"
+	captionChannelPrefixCEA708 = "SERVICE"
+)
+
+// Vars
+var (
+	captionStartCode      = []byte{0x0, 0x0, 0x1}
+	captionUserIdentifier = []byte("GA94")
+)
+
+// Caption languages are ISO 639-2 codes
+var captionLanguageMapping = astimap.NewMap("und", "").
+	Set("eng", LanguageEnglish).
+	Set("fre", LanguageFrench)
+
+// captionFrame represents the cc_data triplets of a picture
+type captionFrame struct {
+	ccData []byte
+	pts    int64
+}
+
+// ReadEmbeddedCaptions parses CEA-608 and CEA-708 captions carried as ATSC A/53 cc_data in the SEI of an H.264 or
+// HEVC video stream of a transport stream
+// When pid is 0, the first H.264 or HEVC stream is used. Subtitles are indexed by channel, from "CC1" to "CC4" for
+// CEA-608 channels and from "SERVICE1" to "SERVICE63" for CEA-708 services, and only channels holding items are
+// returned. Times are relative to the first PTS found in the stream.
+func ReadEmbeddedCaptions(i io.Reader, pid int) (o map[string]*Subtitles, err error) {
+	// Create demuxer
+	var frames []captionFrame
+	var hevc bool
+	var languages = make(map[string]string)
+	var selected, pts = 0, int64(tsPTSUndefined)
+	var d = newTSDemuxer(func(s tsStream) bool {
+		// Check stream
+		if (s.streamType != captionStreamTypeH264 && s.streamType != captionStreamTypeHEVC) || (pid > 0 && s.pid != pid) || (pid == 0 && selected > 0) {
+			return false
+		}
+		selected, hevc = s.pid, s.streamType == captionStreamTypeHEVC
+
+		// Get languages
+		if ds, ok := s.descriptor(captionDescriptorTagService); ok && len(ds.data) > 0 {
+			for idx, n := 1, int(ds.data[0]&0x1f); n > 0 && idx+6 <= len(ds.data); idx, n = idx+6, n-1 {
+				var c = captionChannelPrefixCEA608 + "1"
+				switch {
+				case ds.data[idx+3]&0x80 > 0:
+					c = captionChannelPrefixCEA708 + strconv.Itoa(int(ds.data[idx+3]&0x3f))
+				case ds.data[idx+3]&0x1 > 0:
+					c = captionChannelPrefixCEA608 + "3"
+				}
+				if l := string(ds.data[idx : idx+3]); captionLanguageMapping.InA(l) {
+					languages[c] = captionLanguageMapping.B(l).(string)
+				}
+			}
+		}
+		return true
+	}, func(p tsPES) (err error) {
+		// Pictures without PTS are displayed after the previous one
+		if p.pts != tsPTSUndefined {
+			pts = p.pts
+		}
+
+		// Get cc_data
+		if b := captionCCData(p.data, hevc); len(b) > 0 {
+			frames = append(frames, captionFrame{ccData: b, pts: pts})
+		}
+		return
+	})
+
+	// Demux
+	if err = d.demux(i); err != nil {
+		err = errors.Wrap(err, "demuxing failed")
+		return
+	}
+
+	// Captions must be decoded in the presentation order whereas pictures are in the decoding order
+	sort.SliceStable(frames, func(a, b int) bool { return frames[a].pts < frames[b].pts })
+
+	// The first picture presented may not be the first one decoded
+	if len(frames) > 0 && frames[0].pts != tsPTSUndefined && frames[0].pts < d.firstPTS {
+		d.firstPTS = frames[0].pts
+	}
+
+	// Decode
+	var d608, d708 = newCEA608Decoder(), NewCEA708Decoder()
+	var end time.Duration
+	for idx, f := range frames {
+		end = d.duration(f.pts)
+		if err = d608.decode(end, f.ccData); err != nil {
+			err = errors.Wrapf(err, "decoding CEA-608 data of frame %d failed", idx)
+			return
+		}
+		if err = d708.Decode(end, f.ccData); err != nil {
+			err = errors.Wrapf(err, "decoding CEA-708 data of frame %d failed", idx)
+			return
+		}
+	}
+
+	// Get subtitles
+	o = captionDecodedSubtitles(d608, d708, end)
+
+	// Add languages
+	for c, s := range o {
+		if l, ok := languages[c]; ok {
+			s.Metadata = &Metadata{Language: l}
+		}
+	}
+	return
+}
+
+// ReadFromEmbeddedCaptions parses the captions of a channel carried in the SEI of a video stream of a transport
+// stream
+// When channel is empty, the first channel holding items is used, CEA-608 channels coming first.
+func ReadFromEmbeddedCaptions(i io.Reader, pid int, channel string) (o *Subtitles, err error) {
+	// Get channels
+	var cs []string
+	if cs, err = captionChannelsToRead(channel); err != nil {
+		return
+	}
+
+	// Read captions
+	var ss map[string]*Subtitles
+	if ss, err = ReadEmbeddedCaptions(i, pid); err != nil {
+		err = errors.Wrap(err, "reading embedded captions failed")
+		return
+	}
+
+	// Get subtitles
+	o = captionSubtitles(ss, cs)
+	return
+}
+
+// captionChannelsToRead returns the channels whose subtitles are looked for, in order
+// When channel is empty, every channel is looked for.
+func captionChannelsToRead(channel string) (cs []string, err error) {
+	cs = captionChannels()
+	if channel == "" {
+		return
+	}
+	for _, c := range cs {
+		if c == strings.ToUpper(channel) {
+			cs = []string{c}
+			return
+		}
+	}
+	err = fmt.Errorf("Invalid caption channel %s", channel)
+	return
+}
+
+// captionSubtitles returns the subtitles of the first channel holding items, or empty subtitles
+func captionSubtitles(ss map[string]*Subtitles, cs []string) *Subtitles {
+	for _, c := range cs {
+		if s, ok := ss[c]; ok {
+			return s
+		}
+	}
+	return NewSubtitles()
+}
+
+// captionDecodedSubtitles returns the subtitles of each channel of the decoders holding items
+// Items still displayed end at the provided time.
+func captionDecodedSubtitles(d608 *cea608Decoder, d708 *CEA708Decoder, end time.Duration) (o map[string]*Subtitles) {
+	o = make(map[string]*Subtitles)
+	for n, s := range d608.subtitles(end) {
+		if len(s.Items) > 0 {
+			o[captionChannelPrefixCEA608+strconv.Itoa(n)] = s
+		}
+	}
+	for n, s := range d708.Subtitles(end) {
+		if len(s.Items) > 0 {
+			o[captionChannelPrefixCEA708+strconv.Itoa(n)] = s
+		}
+	}
+	return
+}
+
+// captionChannels returns the caption channels, CEA-608 channels coming first
+func captionChannels() (o []string) {
+	for n := 1; n <= 4; n++ {
+		o = append(o, captionChannelPrefixCEA608+strconv.Itoa(n))
+	}
+	for n := 1; n <= cea708MaxService; n++ {
+		o = append(o, captionChannelPrefixCEA708+strconv.Itoa(n))
+	}
+	return
+}
+
+// captionCCData returns the cc_data triplets carried by the SEI NAL units of an access unit
+func captionCCData(b []byte, hevc bool) (o []byte) {
+	for len(b) > 0 {
+		// Get next NAL unit
+		var idx = bytes.Index(b, captionStartCode)
+		if idx < 0 {
+			return
+		}
+		b = b[idx+len(captionStartCode):]
+		var n = b
+		if idx = bytes.Index(b, captionStartCode); idx >= 0 {
+			n = b[:idx]
+		}
+
+		// Get SEI
+		if hevc {
+			if len(n) < 2 || (n[0]>>1&0x3f != captionNALTypeHEVCPrefixSEI && n[0]>>1&0x3f != captionNALTypeHEVCSuffixSEI) {
+				continue
+			}
+			n = n[2:]
+		} else {
+			// SEI precede the pictures of H.264 access units
+			if len(n) > 0 && n[0]&0x1f >= 1 && n[0]&0x1f <= captionNALTypeH264IDR {
+				return
+			}
+			if len(n) < 1 || n[0]&0x1f != captionNALTypeH264SEI {
+				continue
+			}
+			n = n[1:]
+		}
+
+		// Loop through SEI messages
+		var rbsp = captionRBSP(n)
+		for len(rbsp) > 0 && rbsp[0] != 0x80 {
+			// Parse header
+			var t, s int
+			var ok bool
+			if t, rbsp, ok = captionSEIValue(rbsp); !ok {
+				break
+			}
+			if s, rbsp, ok = captionSEIValue(rbsp); !ok || s > len(rbsp) {
+				break
+			}
+
+			// Get cc_data
+			if t == captionSEIPayloadTypeT35 {
+				o = append(o, captionT35CCData(rbsp[:s])...)
+			}
+			rbsp = rbsp[s:]
+		}
+	}
+	return
+}
+
+// captionRBSP removes emulation prevention bytes from a NAL unit payload
+func captionRBSP(b []byte) (o []byte) {
+	o = make([]byte, 0, len(b))
+	var zeros int
+	for _, v := range b {
+		if zeros >= 2 && v == 0x3 {
+			zeros = 0
+			continue
+		}
+		if v == 0x0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		o = append(o, v)
+	}
+	return
+}
+
+// captionSEIValue parses a SEI payload type or size, coded as a sum of bytes ending with a byte other than 0xff
+func captionSEIValue(b []byte) (v int, o []byte, ok bool) {
+	for idx, c := range b {
+		v += int(c)
+		if c != 0xff {
+			return v, b[idx+1:], true
+		}
+	}
+	return
+}
+
+// captionT35CCData returns the cc_data triplets of an ATSC user_data_registered_itu_t_t35 SEI payload
+func captionT35CCData(b []byte) []byte {
+	// Check header
+	if len(b) < 10 || b[0] != captionT35CountryCode || binary.BigEndian.Uint16(b[1:]) != captionT35ProviderCode ||
+		!bytes.Equal(b[3:7], captionUserIdentifier) || b[7] != captionUserDataTypeCCData {
+		return nil
+	}
+
+	// Check process_cc_data_flag
+	if b[8]&0x40 == 0 {
+		return nil
+	}
+
+	// Get triplets
+	var n = int(b[8] & 0x1f)
+	if 10+3*n > len(b) {
+		n = (len(b) - 10) / 3
+	}
+	return b[10 : 10+3*n]
+}
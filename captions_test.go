@@ -0,0 +1,186 @@
+package astisub_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// captionTestPair builds a cc_data triplet holding a CEA-608 byte pair with odd parity
+func captionTestPair(field int, b1, b2 byte) []byte {
+	var parity = func(b byte) byte {
+		var n int
+		for v := b; v > 0; v >>= 1 {
+			n += int(v & 0x1)
+		}
+		if n%2 == 0 {
+			return b | 0x80
+		}
+		return b
+	}
+	return []byte{0xfc | byte(field), parity(b1), parity(b2)}
+}
+
+// captionTestAccessUnit builds an access unit holding cc_data in a SEI NAL unit
+func captionTestAccessUnit(hevc bool, ccData []byte) (o []byte) {
+	// SEI payloads
+	var t35 = append([]byte{0xb5, 0x0, 0x31, 'G', 'A', '9', '4', 0x3, 0x40 | byte(len(ccData)/3), 0xff}, ccData...)
+	var rbsp = append([]byte{0x5, 0x4, 0x0, 0x0, 0x1, 0x2, 0x4, byte(len(t35))}, t35...)
+	rbsp = append(rbsp, 0x80)
+
+	// Emulation prevention
+	var sei []byte
+	var zeros int
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 0x3 {
+			sei = append(sei, 0x3)
+			zeros = 0
+		}
+		if b == 0x0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		sei = append(sei, b)
+	}
+
+	// NAL units
+	if hevc {
+		o = append(o, 0x0, 0x0, 0x0, 0x1, 0x46, 0x1, 0x50)
+		o = append(append(o, 0x0, 0x0, 0x1, 0x4e, 0x1), sei...)
+		return append(o, 0x0, 0x0, 0x1, 0x26, 0x1, 0xaf, 0x0, 0x0, 0x3, 0x1)
+	}
+	o = append(o, 0x0, 0x0, 0x0, 0x1, 0x9, 0xf0)
+	o = append(append(o, 0x0, 0x0, 0x1, 0x6), sei...)
+	return append(o, 0x0, 0x0, 0x1, 0x65, 0x88, 0x84, 0x0, 0x0, 0x3, 0x1)
+}
+
+// captionTestTS builds a transport stream whose video stream carries CEA-608 and CEA-708 captions
+func captionTestTS(t *testing.T, hevc bool) (o []byte) {
+	// CEA-708
+	f := astisub.Framerate30
+	frames, err := astisub.EncodeCEA708(map[int]*astisub.Subtitles{1: {Items: []*astisub.Item{{
+		EndAt:   3 * time.Second,
+		Lines:   []astisub.Line{{{Text: "Bonjour"}}},
+		StartAt: time.Second,
+	}}}}, f)
+	assert.NoError(t, err)
+	frames = append(frames, make([][]byte, 120-len(frames))...)
+
+	// CEA-608
+	var pairs = map[int][]byte{
+		// CC1 pop-on caption displayed after 1 second and erased after 2 seconds
+		26: captionTestPair(0, 0x14, 0x20),
+		27: captionTestPair(0, 0x14, 0x20),
+		28: captionTestPair(0, 0x14, 0x60),
+		29: captionTestPair(0, 'H', 'i'),
+		30: captionTestPair(0, 0x14, 0x2f),
+		60: captionTestPair(0, 0x14, 0x2c),
+		// CC3 paint-on caption displayed after 3 seconds
+		0:  captionTestPair(1, 0x14, 0x29),
+		1:  captionTestPair(1, 0x14, 0x60),
+		90: captionTestPair(1, 'Y', 'o'),
+	}
+	for idx := range frames {
+		var b = []byte{0xfc, 0x80, 0x80}
+		if p, ok := pairs[idx]; ok {
+			b = p
+		}
+		if len(frames[idx]) > 6 {
+			b = append(b, frames[idx][3:]...)
+		}
+		frames[idx] = b
+	}
+
+	// PAT and PMT
+	var streamType uint8 = 0x1b
+	if hevc {
+		streamType = 0x24
+	}
+	o = tsTestPATAndPMT(
+		tsTestStream(0x6, 0x102),
+		tsTestStream(streamType, 0x101, 0x86, 0xd, 0xe2, 'e', 'n', 'g', 0x7e, 0x3f, 0xff, 'f', 'r', 'e', 0xc1, 0x3f, 0xff),
+	)
+
+	// Pictures are in the decoding order
+	for idx := 0; idx < len(frames); idx += 2 {
+		for _, i := range []int{idx + 1, idx} {
+			o = append(o, tsTestPackets(0x101, tsTestPES(0xe0, 90000+int64(i*3000), captionTestAccessUnit(hevc, frames[i])))...)
+		}
+	}
+	return
+}
+
+func TestReadEmbeddedCaptions(t *testing.T) {
+	for _, hevc := range []bool{false, true} {
+		// Read
+		ss, err := astisub.ReadEmbeddedCaptions(bytes.NewReader(captionTestTS(t, hevc)), 0)
+		assert.NoError(t, err)
+		assert.Len(t, ss, 3)
+
+		// CC1
+		s := ss["CC1"]
+		assert.Len(t, s.Items, 1)
+		assert.Equal(t, "Hi", s.Items[0].String())
+		assert.Equal(t, time.Second, s.Items[0].StartAt)
+		assert.Equal(t, 2*time.Second, s.Items[0].EndAt)
+		assert.Equal(t, astisub.LanguageEnglish, s.Metadata.Language)
+
+		// CC3
+		s = ss["CC3"]
+		assert.Len(t, s.Items, 1)
+		assert.Equal(t, "Yo", s.Items[0].String())
+		assert.Equal(t, 3*time.Second, s.Items[0].StartAt)
+		assert.Equal(t, 3966666666*time.Nanosecond, s.Items[0].EndAt)
+		assert.Nil(t, s.Metadata)
+
+		// SERVICE1
+		s = ss["SERVICE1"]
+		assert.Len(t, s.Items, 1)
+		assert.Equal(t, "Bonjour", s.Items[0].String())
+		assert.InDelta(t, time.Second, s.Items[0].StartAt, float64(astisub.Framerate30.Duration(1)))
+		assert.InDelta(t, 3*time.Second, s.Items[0].EndAt, float64(astisub.Framerate30.Duration(1)))
+		assert.Equal(t, astisub.LanguageFrench, s.Metadata.Language)
+	}
+
+	// Unknown PID
+	ss, err := astisub.ReadEmbeddedCaptions(bytes.NewReader(captionTestTS(t, false)), 0x102)
+	assert.NoError(t, err)
+	assert.Len(t, ss, 0)
+}
+
+func TestReadFromEmbeddedCaptions(t *testing.T) {
+	// Init
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "example.ts")
+	b := captionTestTS(t, false)
+	err = ioutil.WriteFile(p, b, 0644)
+	assert.NoError(t, err)
+
+	// Default channel
+	s, err := astisub.ReadFromEmbeddedCaptions(bytes.NewReader(b), 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi", s.Items[0].String())
+
+	// Open
+	s, err = astisub.Open(astisub.Options{CaptionChannel: "service1", Src: p})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bonjour", s.Items[0].String())
+
+	// Empty channel
+	s, err = astisub.ReadFromEmbeddedCaptions(bytes.NewReader(b), 0x101, "CC2")
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 0)
+
+	// Invalid channel
+	_, err = astisub.ReadFromEmbeddedCaptions(bytes.NewReader(b), 0, "CC5")
+	assert.Error(t, err)
+}
@@ -0,0 +1,739 @@
+package astisub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// https://en.wikipedia.org/wiki/EIA-608
+// CEA-608-E: Line 21 Data Services
+
+// CEA-608 constants
+const (
+	cea608Columns = 32
+	cea608Rows    = 15
+)
+
+// CEA-608 miscellaneous control codes
+const (
+	cea608CodeBS  = 0x21
+	cea608CodeCR  = 0x2d
+	cea608CodeDER = 0x24
+	cea608CodeEDM = 0x2c
+	cea608CodeENM = 0x2e
+	cea608CodeEOC = 0x2f
+	cea608CodeRCL = 0x20
+	cea608CodeRDC = 0x29
+	cea608CodeRTD = 0x2b
+	cea608CodeRU2 = 0x25
+	cea608CodeRU3 = 0x26
+	cea608CodeRU4 = 0x27
+	cea608CodeTR  = 0x2a
+)
+
+// CEA-608 modes
+const (
+	cea608ModePaintOn = iota
+	cea608ModePopOn
+	cea608ModeRollUp
+	cea608ModeText
+)
+
+// CEA-608 colors
+// Indexes are color codes of preamble address and mid-row codes
+var cea608Colors = []*Color{&ColorWhite, &ColorLime, &ColorBlue, &ColorCyan, &ColorRed, &ColorYellow, &ColorMagenta}
+
+// CEA-608 rows of preamble address codes
+// Indexes are the 3 least significant bits of the first byte of the code
+var cea608PACRows = []int{10, 0, 2, 11, 13, 4, 6, 8}
+
+// CEA-608 basic characters that differ from ASCII
+var cea608BasicCharacters = map[byte]rune{
+	0x2a: 'á',
+	0x5c: 'é',
+	0x5e: 'í',
+	0x5f: 'ó',
+	0x60: 'ú',
+	0x7b: 'ç',
+	0x7c: '÷',
+	0x7d: 'Ñ',
+	0x7e: 'ñ',
+	0x7f: '█',
+}
+
+// CEA-608 special characters
+// Indexes are second bytes minus 0x30, and 0x39 is a transparent space
+var cea608SpecialCharacters = []rune("®°½¿™¢£♪à èâêîôû")
+
+// CEA-608 extended characters
+// Indexes are second bytes minus 0x20
+var (
+	cea608ExtendedCharacters1 = []rune("ÁÉÓÚÜü‘¡*’—©℠•“”ÀÂÇÈÊËëÎÏïÔÙùÛ«»")
+	cea608ExtendedCharacters2 = []rune("ÃãÍÌìÒòÕõ{}\\^_|~ÄäÖöß¥¤│ÅåØø┌┐└┘")
+)
+
+// CEA-608 character codes
+// Values are the byte of basic characters, and the bytes of the control code of special and extended characters.
+var (
+	cea608BasicCodes = func() (o map[rune]byte) {
+		o = make(map[rune]byte)
+		for b := byte(0x20); b < 0x80; b++ {
+			if r, ok := cea608BasicCharacters[b]; ok {
+				o[r] = b
+			} else {
+				o[rune(b)] = b
+			}
+		}
+		return
+	}()
+	cea608CharacterCodes = func() (o map[rune][2]byte) {
+		o = make(map[rune][2]byte)
+		for idx, r := range cea608SpecialCharacters {
+			if r != ' ' {
+				o[r] = [2]byte{0x11, 0x30 + byte(idx)}
+			}
+		}
+		for idx, r := range cea608ExtendedCharacters1 {
+			o[r] = [2]byte{0x12, 0x20 + byte(idx)}
+		}
+		for idx, r := range cea608ExtendedCharacters2 {
+			o[r] = [2]byte{0x13, 0x20 + byte(idx)}
+		}
+		return
+	}()
+)
+
+// cea608Style represents the style of a CEA-608 character
+type cea608Style struct {
+	color     int
+	italics   bool
+	underline bool
+}
+
+// cea608Cell represents a character cell of a CEA-608 memory
+type cea608Cell struct {
+	r     rune
+	style cea608Style
+}
+
+// cea608Memory represents a CEA-608 caption memory
+type cea608Memory [cea608Rows][cea608Columns]cea608Cell
+
+// cea608Channel represents the decoding state of a CEA-608 caption channel
+type cea608Channel struct {
+	baseRow      int
+	column       int
+	depth        int
+	displayed    cea608Memory
+	item         *Item
+	mode         int
+	nonDisplayed cea608Memory
+	row          int
+	s            *Subtitles
+	shown        cea608Memory
+	style        cea608Style
+}
+
+// newCEA608Channel creates a new CEA-608 channel
+func newCEA608Channel() *cea608Channel {
+	return &cea608Channel{
+		baseRow: cea608Rows - 1,
+		mode:    cea608ModePopOn,
+		row:     cea608Rows - 1,
+		s:       NewSubtitles(),
+	}
+}
+
+// memory returns the memory characters are written to
+func (c *cea608Channel) memory() *cea608Memory {
+	if c.mode == cea608ModePopOn {
+		return &c.nonDisplayed
+	}
+	return &c.displayed
+}
+
+// write writes a character at the cursor and moves the cursor forward
+// Characters written beyond the last column replace the last character.
+func (c *cea608Channel) write(r rune) {
+	if c.mode == cea608ModeText {
+		return
+	}
+	if c.column >= cea608Columns {
+		c.column = cea608Columns - 1
+	}
+	c.memory()[c.row][c.column] = cea608Cell{r: r, style: c.style}
+	c.column++
+}
+
+// backspace moves the cursor backward and erases the character it is on
+func (c *cea608Channel) backspace() {
+	if c.mode == cea608ModeText || c.column == 0 {
+		return
+	}
+	c.column--
+	c.memory()[c.row][c.column] = cea608Cell{}
+}
+
+// command executes a miscellaneous control code
+func (c *cea608Channel) command(b byte) {
+	switch b {
+	case cea608CodeBS:
+		c.backspace()
+	case cea608CodeCR:
+		if c.mode == cea608ModeRollUp {
+			c.rollUp()
+		}
+	case cea608CodeDER:
+		if c.mode != cea608ModeText {
+			for col := c.column; col < cea608Columns; col++ {
+				c.memory()[c.row][col] = cea608Cell{}
+			}
+		}
+	case cea608CodeEDM:
+		c.displayed = cea608Memory{}
+	case cea608CodeENM:
+		c.nonDisplayed = cea608Memory{}
+	case cea608CodeEOC:
+		c.displayed, c.nonDisplayed = c.nonDisplayed, c.displayed
+		c.mode = cea608ModePopOn
+	case cea608CodeRCL:
+		c.mode = cea608ModePopOn
+	case cea608CodeRDC:
+		c.mode = cea608ModePaintOn
+	case cea608CodeRTD, cea608CodeTR:
+		c.mode = cea608ModeText
+	case cea608CodeRU2, cea608CodeRU3, cea608CodeRU4:
+		if c.mode != cea608ModeRollUp {
+			c.displayed, c.nonDisplayed = cea608Memory{}, cea608Memory{}
+			c.baseRow, c.row, c.column = cea608Rows-1, cea608Rows-1, 0
+		}
+		c.depth, c.mode = int(b-cea608CodeRU2)+2, cea608ModeRollUp
+	}
+}
+
+// rollUp scrolls the roll-up rows up and clears the base row
+func (c *cea608Channel) rollUp() {
+	for r := c.baseRow - c.depth + 1; r < c.baseRow; r++ {
+		if r >= 0 {
+			c.displayed[r] = c.displayed[r+1]
+		}
+	}
+	c.displayed[c.baseRow] = [cea608Columns]cea608Cell{}
+	c.column = 0
+}
+
+// preambleAddress executes a preamble address code
+func (c *cea608Channel) preambleAddress(b1, b2 byte) {
+	// Get row
+	var row = cea608PACRows[b1&0x7]
+	if b2&0x20 > 0 {
+		row++
+	}
+	if row >= cea608Rows {
+		return
+	}
+
+	// Move roll-up rows
+	if c.mode == cea608ModeRollUp && row != c.baseRow {
+		var m cea608Memory
+		for r := 0; r < c.depth; r++ {
+			if row-r >= 0 && c.baseRow-r >= 0 {
+				m[row-r] = c.displayed[c.baseRow-r]
+			}
+		}
+		c.displayed, c.baseRow = m, row
+	}
+
+	// Update cursor and style
+	var a = int(b2&0x1e) >> 1
+	c.column, c.row, c.style = 0, row, cea608Style{underline: b2&0x1 > 0}
+	switch {
+	case a >= 8:
+		c.column = (a - 8) * 4
+	case a == 7:
+		c.style.italics = true
+	default:
+		c.style.color = a
+	}
+}
+
+// midRow executes a mid-row code, which is displayed as a space
+// Color codes turn italics off whereas the italics code keeps the current color.
+func (c *cea608Channel) midRow(b2 byte) {
+	var a = int(b2&0xe) >> 1
+	if a == 7 {
+		c.style.italics = true
+	} else {
+		c.style.color, c.style.italics = a, false
+	}
+	c.style.underline = b2&0x1 > 0
+	c.write(' ')
+}
+
+// update creates and ends items based on what the channel displays at a given time
+func (c *cea608Channel) update(t time.Duration) {
+	// Nothing has changed
+	if c.displayed == c.shown {
+		return
+	}
+	c.shown = c.displayed
+
+	// End displayed item
+	if c.item != nil {
+		c.end(t)
+	}
+
+	// Create item
+	if c.item = c.displayedItem(); c.item != nil {
+		c.item.StartAt = t
+		c.s.Items = append(c.s.Items, c.item)
+	}
+}
+
+// end ends the displayed item, removing it when it has not been displayed at all
+func (c *cea608Channel) end(t time.Duration) {
+	c.item.EndAt = t
+	if t <= c.item.StartAt {
+		c.s.Items = c.s.Items[:len(c.s.Items)-1]
+	}
+	c.item = nil
+}
+
+// displayedItem returns an item holding the non empty rows of the displayed memory
+// The item geometry is the box surrounding the rows in the caption area, and lines are centered when their centers
+// are aligned.
+func (c *cea608Channel) displayedItem() (i *Item) {
+	// Loop through rows
+	var first, last, left, right = -1, -1, cea608Columns, 0
+	var minCenter, maxCenter = 2 * cea608Columns, 0
+	var ls []Line
+	for r, row := range c.displayed {
+		// Get bounds
+		var start, end = -1, -1
+		for col, cell := range row {
+			if cell.r != 0 && cell.r != ' ' {
+				if start < 0 {
+					start = col
+				}
+				end = col + 1
+			}
+		}
+		if start < 0 {
+			continue
+		}
+
+		// Get line items
+		var l Line
+		var text []rune
+		var style cea608Style
+		var flush = func() {
+			if t := strings.TrimSpace(string(text)); len(t) > 0 {
+				l = append(l, LineItem{InlineStyle: style.styleAttributes(), Text: t})
+			}
+			text = text[:0]
+		}
+		for col := start; col < end; col++ {
+			var cell = row[col]
+			if cell.r == 0 {
+				text = append(text, ' ')
+				continue
+			}
+			if cell.style != style {
+				flush()
+				style = cell.style
+			}
+			text = append(text, cell.r)
+		}
+		flush()
+
+		// Update bounds
+		ls = append(ls, l)
+		if first < 0 {
+			first = r
+		}
+		last = r
+		if start < left {
+			left = start
+		}
+		if end > right {
+			right = end
+		}
+		if start+end < minCenter {
+			minCenter = start + end
+		}
+		if start+end > maxCenter {
+			maxCenter = start + end
+		}
+	}
+	if len(ls) == 0 {
+		return
+	}
+
+	// Create item
+	var ta = TextAlignLeft
+	if len(ls) > 1 && maxCenter-minCenter <= 1 {
+		ta = TextAlignCenter
+	}
+	return &Item{
+		Geometry: &Geometry{
+			DisplayAlign: DisplayAlignBefore,
+			Height:       roundPercentage(float64(last-first+1) * cea708CaptionArea / cea608Rows),
+			Left:         roundPercentage(cea708SafeArea + float64(left)*cea708CaptionArea/cea608Columns),
+			TextAlign:    ta,
+			Top:          roundPercentage(cea708SafeArea + float64(first)*cea708CaptionArea/cea608Rows),
+			Width:        roundPercentage(float64(right-left) * cea708CaptionArea / cea608Columns),
+		},
+		Lines: ls,
+	}
+}
+
+// styleAttributes returns the style attributes of the style
+// Characters are displayed on a black background.
+func (s cea608Style) styleAttributes() (sa *StyleAttributes) {
+	sa = &StyleAttributes{BackgroundColor: &ColorBlack, Color: cea608Colors[s.color]}
+	if s.italics {
+		sa.FontStyle = FontStyleItalic
+	}
+	if s.underline {
+		sa.TextDecoration = "underline"
+	}
+	return
+}
+
+// cea608Field represents the decoding state of a CEA-608 field
+type cea608Field struct {
+	channel  int
+	channels [2]*cea608Channel
+	previous [2]byte
+	xds      bool
+}
+
+// cea608Decoder represents an object capable of decoding CEA-608 byte pairs carried by cc_data triplets into
+// subtitles, one per caption channel
+// Each change of what a channel displays ends its current item and starts a new one.
+type cea608Decoder struct {
+	fields [2]*cea608Field
+}
+
+// newCEA608Decoder creates a new CEA-608 decoder
+func newCEA608Decoder() (d *cea608Decoder) {
+	d = &cea608Decoder{}
+	for idx := range d.fields {
+		d.fields[idx] = &cea608Field{channels: [2]*cea608Channel{newCEA608Channel(), newCEA608Channel()}}
+	}
+	return
+}
+
+// decode decodes the cc_data triplets of a video frame displayed at a given time
+// CEA-708 triplets are ignored.
+func (d *cea608Decoder) decode(t time.Duration, ccData []byte) (err error) {
+	// Check length
+	if len(ccData)%3 != 0 {
+		err = fmt.Errorf("Invalid cc_data length %d", len(ccData))
+		return
+	}
+
+	// Loop through triplets
+	for idx := 0; idx+3 <= len(ccData); idx += 3 {
+		if ccData[idx]&cea708CCValid == 0 {
+			continue
+		}
+		switch ccData[idx] & 0x3 {
+		case cea708CCTypeField1:
+			d.fields[0].decode(ccData[idx+1]&0x7f, ccData[idx+2]&0x7f)
+		case cea708CCTypeField2:
+			d.fields[1].decode(ccData[idx+1]&0x7f, ccData[idx+2]&0x7f)
+		}
+	}
+
+	// Update items
+	for _, f := range d.fields {
+		for _, c := range f.channels {
+			c.update(t)
+		}
+	}
+	return
+}
+
+// decode decodes a byte pair whose parity bits have been removed
+// Control codes are transmitted twice and the repetition is ignored.
+func (f *cea608Field) decode(b1, b2 byte) {
+	// Padding
+	if b1 == 0 && b2 == 0 {
+		return
+	}
+
+	// Control code
+	if b1 >= 0x10 && b1 < 0x20 {
+		// Ignore repetition
+		if f.previous == [2]byte{b1, b2} {
+			f.previous = [2]byte{}
+			return
+		}
+		f.previous = [2]byte{b1, b2}
+
+		// Get channel
+		f.channel, f.xds = int(b1>>3&0x1), false
+		var c, cb1 = f.channels[f.channel], b1 & 0x17
+
+		// Switch on code
+		switch {
+		case (cb1 == 0x14 || cb1 == 0x15) && b2 >= 0x20 && b2 < 0x30:
+			c.command(b2)
+		case cb1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+			if c.column += int(b2 - 0x20); c.column >= cea608Columns {
+				c.column = cea608Columns - 1
+			}
+		case cb1 == 0x11 && b2 >= 0x20 && b2 < 0x30:
+			c.midRow(b2)
+		case cb1 == 0x11 && b2 >= 0x30 && b2 < 0x40:
+			c.write(cea608SpecialCharacters[b2-0x30])
+		case (cb1 == 0x12 || cb1 == 0x13) && b2 >= 0x20 && b2 < 0x40:
+			// Extended characters replace the basic character sent before them
+			c.backspace()
+			if cb1 == 0x12 {
+				c.write(cea608ExtendedCharacters1[b2-0x20])
+			} else {
+				c.write(cea608ExtendedCharacters2[b2-0x20])
+			}
+		case b2 >= 0x40:
+			c.preambleAddress(cb1, b2)
+		}
+		return
+	}
+	f.previous = [2]byte{}
+
+	// Extended data services
+	if b1 > 0 && b1 < 0x10 {
+		f.xds = b1 != 0xf
+		return
+	} else if f.xds {
+		return
+	}
+
+	// Basic characters
+	var c = f.channels[f.channel]
+	for _, b := range []byte{b1, b2} {
+		if b < 0x20 {
+			continue
+		}
+		if r, ok := cea608BasicCharacters[b]; ok {
+			c.write(r)
+		} else {
+			c.write(rune(b))
+		}
+	}
+}
+
+// subtitles returns the subtitles of each caption channel, indexed by channel number
+// Items still displayed end at the provided time.
+func (d *cea608Decoder) subtitles(t time.Duration) (o map[int]*Subtitles) {
+	o = make(map[int]*Subtitles)
+	for fi, f := range d.fields {
+		for ci, c := range f.channels {
+			if c.item != nil {
+				c.end(t)
+			}
+			c.shown = cea608Memory{}
+			o[2*fi+ci+1] = c.s
+		}
+	}
+	return
+}
+
+// cea608EncoderItem represents an item being encoded
+// Codes are grouped by the byte pairs that must be sent in consecutive frames.
+type cea608EncoderItem struct {
+	codes [][][2]byte
+	end   int
+	start int
+}
+
+// encodeCEA608 encodes subtitles into the byte pairs of the CC1 channel, one per video frame starting at 0
+// Items are pop-on captions: each item is loaded in the non-displayed memory once the previous item is displayed,
+// displayed when it starts, and erased when it ends unless the next item replaces it. Lines longer than 32
+// characters are wrapped, lines are centered on the bottom rows, and characters missing from the CEA-608 character
+// sets are dropped.
+func encodeCEA608(s *Subtitles, f Framerate) (pairs [][2]byte) {
+	// Order items
+	var is = append([]*Item{}, s.Items...)
+	sort.SliceStable(is, func(a, b int) bool { return is[a].StartAt < is[b].StartAt })
+
+	// Loop through items
+	var items []*cea608EncoderItem
+	var last int
+	for _, i := range is {
+		var ei = &cea608EncoderItem{codes: cea608ItemCodes(i), end: f.Frames(i.EndAt), start: f.Frames(i.StartAt)}
+		if ei.start < 0 {
+			ei.start = 0
+		}
+		if ei.end <= ei.start || len(ei.codes) == 0 {
+			continue
+		}
+		items = append(items, ei)
+		if ei.end > last {
+			last = ei.end
+		}
+	}
+
+	// Loop through frames
+	var displayed, loaded *cea608EncoderItem
+	var next int
+	var unit [][2]byte
+	for idx := 0; idx <= last || len(unit) > 0 || displayed != nil; idx++ {
+		// Get the next byte pairs once the previous ones have been sent
+		if len(unit) == 0 {
+			// Items that have ended before being displayed are skipped
+			if loaded != nil && loaded.end <= idx {
+				loaded = nil
+			}
+			for ; loaded == nil && next < len(items); next++ {
+				if items[next].end > idx {
+					loaded = items[next]
+				}
+			}
+
+			// Switch on state
+			switch {
+			case loaded != nil && len(loaded.codes) == 0 && loaded.start <= idx:
+				unit, displayed, loaded = cea608Code(0x14, cea608CodeEOC), loaded, nil
+			case displayed != nil && displayed.end <= idx:
+				unit, displayed = cea608Code(0x14, cea608CodeEDM), nil
+			case loaded != nil && len(loaded.codes) > 0:
+				unit, loaded.codes = loaded.codes[0], loaded.codes[1:]
+			}
+		}
+
+		// Add byte pair
+		var p = [2]byte{cea608Parity(0), cea608Parity(0)}
+		if len(unit) > 0 {
+			p, unit = unit[0], unit[1:]
+		}
+		pairs = append(pairs, p)
+	}
+	return
+}
+
+// cea608ItemCodes returns the codes loading an item in the non-displayed memory
+func cea608ItemCodes(i *Item) (codes [][][2]byte) {
+	// Get rows
+	var rows = cea608Wrap(i)
+	if len(rows) == 0 {
+		return
+	} else if len(rows) > cea608Rows {
+		rows = rows[len(rows)-cea608Rows:]
+	}
+
+	// Erase the non-displayed memory
+	codes = append(codes, cea608Code(0x14, cea608CodeRCL), cea608Code(0x14, cea608CodeENM))
+
+	// Loop through rows
+	for idx, r := range rows {
+		// Move the cursor
+		var row, column = cea608Rows - len(rows) + idx, (cea608Columns - len(r)) / 2
+		codes = append(codes, cea608Code(cea608PreambleAddress(row, column)))
+		if column%4 > 0 {
+			codes = append(codes, cea608Code(0x17, 0x20+byte(column%4)))
+		}
+
+		// Basic characters are sent 2 by 2
+		var bs []byte
+		var flush = func() {
+			for idx := 0; idx < len(bs); idx += 2 {
+				var p = [2]byte{cea608Parity(bs[idx]), cea608Parity(0)}
+				if idx+1 < len(bs) {
+					p[1] = cea608Parity(bs[idx+1])
+				}
+				codes = append(codes, [][2]byte{p})
+			}
+			bs = bs[:0]
+		}
+
+		// Loop through characters
+		for _, c := range r {
+			if b, ok := cea608BasicCodes[c]; ok {
+				bs = append(bs, b)
+			} else if cc, ok := cea608CharacterCodes[c]; ok {
+				// Extended characters replace a basic character sent before them for decoders that don't support them
+				if cc[0] != 0x11 {
+					bs = append(bs, ' ')
+				}
+				flush()
+				codes = append(codes, cea608Code(cc[0], cc[1]))
+			}
+		}
+		flush()
+	}
+	return
+}
+
+// cea608Wrap returns the item lines wrapped into rows
+func cea608Wrap(i *Item) (rows [][]rune) {
+	for _, l := range i.Lines {
+		// Get text
+		var ts []string
+		for _, li := range l {
+			if t := strings.TrimSpace(li.Text); t != "" {
+				ts = append(ts, t)
+			}
+		}
+		var row = []rune(strings.Join(ts, " "))
+
+		// Wrap
+		for len(row) > cea608Columns {
+			var n = cea608Columns
+			for idx := cea608Columns; idx > 0; idx-- {
+				if row[idx] == ' ' {
+					n = idx
+					break
+				}
+			}
+			rows = append(rows, row[:n])
+			row = row[n:]
+			for len(row) > 0 && row[0] == ' ' {
+				row = row[1:]
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// cea608PreambleAddress returns the preamble address code moving the cursor to a row and to the closest indent
+// before a column
+func cea608PreambleAddress(row, column int) (b1, b2 byte) {
+	for idx, r := range cea608PACRows {
+		if r == row || r+1 == row {
+			b1, b2 = 0x10|byte(idx), 0x50|byte(column/4)<<1
+			if r+1 == row {
+				b2 |= 0x20
+			}
+			if r == row {
+				break
+			}
+		}
+	}
+	return
+}
+
+// cea608Code returns a control code with parity bits, sent twice
+func cea608Code(b1, b2 byte) [][2]byte {
+	var p = [2]byte{cea608Parity(b1), cea608Parity(b2)}
+	return [][2]byte{p, p}
+}
+
+// cea608Parity adds the odd parity bit to a byte
+func cea608Parity(b byte) byte {
+	var n int
+	for v := b; v > 0; v >>= 1 {
+		n += int(v & 0x1)
+	}
+	if n%2 == 0 {
+		return b | 0x80
+	}
+	return b
+}
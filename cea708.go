@@ -0,0 +1,1287 @@
+package astisub
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://en.wikipedia.org/wiki/CEA-708
+// CEA-708-E: Digital Television (DTV) Closed Captioning
+
+// CEA-708 constants
+const (
+	cea708CaptionArea      = 80 // Percentage of the frame width and height taken by the caption area
+	cea708CCTypeDTVCCData  = 0x2
+	cea708CCTypeDTVCCStart = 0x3
+	cea708CCTypeField1     = 0x0
+	cea708CCTypeField2     = 0x1
+	cea708CCValid          = 0x4
+	cea708MaxBlockSize     = 31
+	cea708MaxColumns       = 42
+	cea708MaxPacketSize    = 128
+	cea708MaxRows          = 15
+	cea708MaxService       = 63
+	cea708SafeArea         = 10 // Percentage of the frame kept empty on each side of the caption area
+	cea708Windows          = 8
+)
+
+// CEA-708 C0 codes
+const (
+	cea708CodeBS   = 0x08
+	cea708CodeCR   = 0x0d
+	cea708CodeEXT1 = 0x10
+	cea708CodeFF   = 0x0c
+	cea708CodeHCR  = 0x0e
+	cea708CodeP16  = 0x18
+)
+
+// CEA-708 C1 codes
+const (
+	cea708CodeCLW = 0x88
+	cea708CodeCW0 = 0x80
+	cea708CodeDF0 = 0x98
+	cea708CodeDLC = 0x8e
+	cea708CodeDLW = 0x8c
+	cea708CodeDLY = 0x8d
+	cea708CodeDSW = 0x89
+	cea708CodeHDW = 0x8a
+	cea708CodeRST = 0x8f
+	cea708CodeSPA = 0x90
+	cea708CodeSPC = 0x91
+	cea708CodeSPL = 0x92
+	cea708CodeSWA = 0x97
+	cea708CodeTGW = 0x8b
+)
+
+// CEA-708 directions
+const (
+	cea708DirectionBottomToTop = 0x3
+	cea708DirectionLeftToRight = 0x0
+	cea708DirectionRightToLeft = 0x1
+	cea708DirectionTopToBottom = 0x2
+)
+
+// CEA-708 edge types
+const (
+	cea708EdgeTypeDepressed = 0x2
+	cea708EdgeTypeNone      = 0x0
+	cea708EdgeTypeRaised    = 0x1
+	cea708EdgeTypeUniform   = 0x3
+)
+
+// CEA-708 justifications
+const (
+	cea708JustifyCenter = 0x2
+	cea708JustifyFull   = 0x3
+	cea708JustifyLeft   = 0x0
+	cea708JustifyRight  = 0x1
+)
+
+// CEA-708 opacities
+const (
+	cea708OpacityFlash       = 0x1
+	cea708OpacitySolid       = 0x0
+	cea708OpacityTranslucent = 0x2
+	cea708OpacityTransparent = 0x3
+)
+
+// CEA-708 pen offsets and sizes
+const (
+	cea708PenOffsetNormal = 0x1
+	cea708PenSizeStandard = 0x1
+)
+
+// CEA-708 font families
+// Indexes are font style IDs
+var cea708FontFamilies = []string{"default", "monospaceSerif", "proportionalSerif", "monospaceSansSerif", "proportionalSansSerif", "casual", "cursive", "smallCaps"}
+
+// CEA-708 G2 characters
+// 0x20 and 0x21 are transparent spaces
+var cea708G2 = map[byte]rune{
+	0x20: ' ',
+	0x21: ' ',
+	0x25: '…',
+	0x2a: 'Š',
+	0x2c: 'Œ',
+	0x30: '█',
+	0x31: '‘',
+	0x32: '’',
+	0x33: '“',
+	0x34: '”',
+	0x35: '•',
+	0x39: '™',
+	0x3a: 'š',
+	0x3c: 'œ',
+	0x3d: '℠',
+	0x3f: 'Ÿ',
+	0x76: '⅛',
+	0x77: '⅜',
+	0x78: '⅝',
+	0x79: '⅞',
+	0x7a: '│',
+	0x7b: '┐',
+	0x7c: '└',
+	0x7d: '─',
+	0x7e: '┘',
+	0x7f: '┌',
+}
+
+// CEA-608 null data of both fields, sent in each frame
+var cea708CEA608NullData = []byte{0xf8 | cea708CCValid | cea708CCTypeField1, 0x80, 0x80, 0xf8 | cea708CCValid | cea708CCTypeField2, 0x80, 0x80}
+
+// CEA-708 G2 codes
+var cea708G2Codes = func() (o map[rune]byte) {
+	o = make(map[rune]byte)
+	for b, r := range cea708G2 {
+		if b > 0x21 {
+			o[r] = b
+		}
+	}
+	return
+}()
+
+// CEA-708 predefined pen styles
+var cea708PenStyles = map[uint8]cea708Pen{
+	1: {background: cea708Color{}, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	2: {background: cea708Color{}, fontStyle: 1, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	3: {background: cea708Color{}, fontStyle: 2, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	4: {background: cea708Color{}, fontStyle: 3, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	5: {background: cea708Color{}, fontStyle: 4, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	6: {background: cea708Color{opacity: cea708OpacityTransparent}, edgeType: cea708EdgeTypeUniform, fontStyle: 3, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+	7: {background: cea708Color{opacity: cea708OpacityTransparent}, edgeType: cea708EdgeTypeUniform, fontStyle: 4, foreground: cea708Color{b: 3, g: 3, r: 3}, offset: cea708PenOffsetNormal, size: cea708PenSizeStandard},
+}
+
+// CEA-708 predefined window styles
+var cea708WindowStyles = map[uint8]cea708WindowAttributes{
+	1: {fill: cea708Color{}, justify: cea708JustifyLeft, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop},
+	2: {fill: cea708Color{opacity: cea708OpacityTransparent}, justify: cea708JustifyLeft, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop},
+	3: {fill: cea708Color{}, justify: cea708JustifyCenter, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop},
+	4: {fill: cea708Color{}, justify: cea708JustifyLeft, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop, wordWrap: true},
+	5: {fill: cea708Color{opacity: cea708OpacityTransparent}, justify: cea708JustifyLeft, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop, wordWrap: true},
+	6: {fill: cea708Color{}, justify: cea708JustifyCenter, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop, wordWrap: true},
+	7: {fill: cea708Color{}, justify: cea708JustifyLeft, printDirection: cea708DirectionTopToBottom, scrollDirection: cea708DirectionRightToLeft},
+}
+
+// cea708Color represents a CEA-708 color with 2-bit components
+type cea708Color struct {
+	b, g, opacity, r uint8
+}
+
+// newCEA708Color creates a CEA-708 color from a color
+func newCEA708Color(c Color) (o cea708Color) {
+	o = cea708Color{b: cea708Component(c.Blue), g: cea708Component(c.Green), r: cea708Component(c.Red)}
+	switch {
+	case c.Alpha == 0:
+		o.opacity = cea708OpacityTransparent
+	case c.Alpha < 0xff:
+		o.opacity = cea708OpacityTranslucent
+	}
+	return
+}
+
+// cea708Component converts an 8-bit color component into a 2-bit color component
+func cea708Component(v uint8) uint8 {
+	return uint8((int(v) + 0x2a) / 0x55)
+}
+
+// parseCEA708Color parses a CEA-708 color with its opacity in the 2 most significant bits
+func parseCEA708Color(b byte) cea708Color {
+	return cea708Color{b: b & 0x3, g: b >> 2 & 0x3, opacity: b >> 6, r: b >> 4 & 0x3}
+}
+
+// byte returns the CEA-708 color with its opacity in the 2 most significant bits
+func (c cea708Color) byte() byte {
+	return c.opacity<<6 | c.r<<4 | c.g<<2 | c.b
+}
+
+// color returns the color
+func (c cea708Color) color() (o *Color) {
+	o = &Color{Alpha: 0xff, Blue: c.b * 0x55, Green: c.g * 0x55, Red: c.r * 0x55}
+	switch c.opacity {
+	case cea708OpacityTranslucent:
+		o.Alpha = 0x80
+	case cea708OpacityTransparent:
+		o.Alpha = 0
+	}
+	return
+}
+
+// cea708Pen represents CEA-708 pen attributes and colors
+type cea708Pen struct {
+	background cea708Color
+	edge       cea708Color
+	edgeType   uint8
+	fontStyle  uint8
+	foreground cea708Color
+	italics    bool
+	offset     uint8
+	size       uint8
+	textTag    uint8
+	underline  bool
+}
+
+// newCEA708Pen creates a CEA-708 pen from style attributes
+// A transparent background without outline would make captions unreadable, therefore the default pen background
+// is kept in that case.
+func newCEA708Pen(sa *StyleAttributes) (p cea708Pen) {
+	// Colors
+	p = cea708PenStyles[1]
+	if sa.Color != nil {
+		p.foreground = newCEA708Color(*sa.Color)
+	}
+	var outline = sa.TextOutline != "" && sa.TextOutline != "none"
+	switch {
+	case sa.BackgroundColor != nil && sa.BackgroundColor.Alpha > 0:
+		p.background = newCEA708Color(*sa.BackgroundColor)
+	case outline:
+		p.background = cea708Color{opacity: cea708OpacityTransparent}
+	}
+
+	// Edge
+	if outline {
+		p.edgeType = cea708EdgeTypeUniform
+		if c, err := ParseColor(strings.Fields(sa.TextOutline)[0]); err == nil {
+			p.edge = newCEA708Color(*c)
+			p.edge.opacity = 0
+		}
+	}
+
+	// Font
+	var family = strings.TrimSpace(strings.Split(sa.FontFamily, ",")[0])
+	for idx, f := range cea708FontFamilies {
+		if f == family {
+			p.fontStyle = uint8(idx)
+		}
+	}
+	p.italics = sa.FontStyle == FontStyleItalic || sa.FontStyle == FontStyleOblique
+	p.underline = strings.Contains(sa.TextDecoration, "underline")
+	return
+}
+
+// styleAttributes returns the style attributes of the pen
+func (p cea708Pen) styleAttributes() (sa *StyleAttributes) {
+	sa = &StyleAttributes{BackgroundColor: p.background.color(), Color: p.foreground.color()}
+	if p.fontStyle > 0 {
+		sa.FontFamily = cea708FontFamilies[p.fontStyle]
+	}
+	if p.italics {
+		sa.FontStyle = FontStyleItalic
+	}
+	if p.underline {
+		sa.TextDecoration = "underline"
+	}
+	switch p.edgeType {
+	case cea708EdgeTypeDepressed, cea708EdgeTypeRaised, cea708EdgeTypeUniform:
+		var c = p.edge
+		c.opacity = cea708OpacitySolid
+		sa.TextOutline = c.color().String() + " 0.05em"
+	}
+	return
+}
+
+// attributes returns the parameters of the SetPenAttributes command
+func (p cea708Pen) attributes() []byte {
+	var b = []byte{p.textTag<<4 | p.offset<<2 | p.size, p.edgeType<<3 | p.fontStyle}
+	if p.italics {
+		b[1] |= 0x80
+	}
+	if p.underline {
+		b[1] |= 0x40
+	}
+	return b
+}
+
+// colors returns the parameters of the SetPenColor command
+func (p cea708Pen) colors() []byte {
+	return []byte{p.foreground.byte(), p.background.byte(), p.edge.byte() & 0x3f}
+}
+
+// cea708WindowAttributes represents CEA-708 window attributes
+type cea708WindowAttributes struct {
+	fill            cea708Color
+	justify         uint8
+	printDirection  uint8
+	scrollDirection uint8
+	wordWrap        bool
+}
+
+// bytes returns the parameters of the SetWindowAttributes command
+// Windows have no border and are displayed with the snap effect.
+func (a cea708WindowAttributes) bytes() []byte {
+	var b = []byte{a.fill.byte(), 0x0, a.printDirection<<4 | a.scrollDirection<<2 | a.justify, 0x0}
+	if a.wordWrap {
+		b[2] |= 0x40
+	}
+	return b
+}
+
+// cea708Cell represents a character cell of a CEA-708 window
+type cea708Cell struct {
+	pen cea708Pen
+	r   rune
+}
+
+// cea708Window represents a CEA-708 window
+type cea708Window struct {
+	anchorHorizontal int
+	anchorPoint      int
+	anchorVertical   int
+	attributes       cea708WindowAttributes
+	columnCount      int
+	pen              cea708Pen
+	penColumn        int
+	penRow           int
+	relative         bool
+	rowCount         int
+	rows             [][]cea708Cell
+	visible          bool
+}
+
+// resize resizes the window grid while keeping its content
+func (w *cea708Window) resize(rowCount, columnCount int) {
+	var rows = make([][]cea708Cell, rowCount)
+	for r := range rows {
+		rows[r] = make([]cea708Cell, columnCount)
+		if r < len(w.rows) {
+			copy(rows[r], w.rows[r])
+		}
+	}
+	w.columnCount, w.rowCount, w.rows = columnCount, rowCount, rows
+}
+
+// clear clears the window content
+func (w *cea708Window) clear() {
+	w.rows = nil
+	w.resize(w.rowCount, w.columnCount)
+}
+
+// write writes a character at the pen location and moves the pen forward
+// Characters written beyond the last column are dropped.
+func (w *cea708Window) write(r rune) {
+	if w.penRow >= w.rowCount || w.penColumn >= w.columnCount {
+		return
+	}
+	w.rows[w.penRow][w.penColumn] = cea708Cell{pen: w.pen, r: r}
+	w.penColumn++
+}
+
+// carriageReturn moves the pen to the beginning of the next row and scrolls the window up when the pen is on
+// the last row
+func (w *cea708Window) carriageReturn() {
+	w.penColumn = 0
+	if w.penRow+1 < w.rowCount {
+		w.penRow++
+		return
+	}
+	if w.rowCount > 0 {
+		w.rows = append(w.rows[1:], make([]cea708Cell, w.columnCount))
+	}
+}
+
+// key returns a key identifying what the window displays
+func (w *cea708Window) key() string {
+	if !w.visible {
+		return ""
+	}
+	return fmt.Sprintf("%d|%d|%d|%v|%d|%d|%v|%+v", w.anchorHorizontal, w.anchorPoint, w.anchorVertical, w.attributes, w.columnCount, w.rowCount, w.relative, w.rows)
+}
+
+// geometry returns the geometry of the window
+// Absolute anchors are expressed on a 16:9 grid and the caption area is the frame title-safe area.
+func (w *cea708Window) geometry() *Geometry {
+	// Get anchor
+	var x, y = float64(w.anchorHorizontal) / 209, float64(w.anchorVertical) / 74
+	if w.relative {
+		x, y = float64(w.anchorHorizontal)/99, float64(w.anchorVertical)/99
+	}
+	x, y = math.Min(x, 1)*cea708CaptionArea+cea708SafeArea, math.Min(y, 1)*cea708CaptionArea+cea708SafeArea
+
+	// Get box
+	var ap = w.anchorPoint
+	if ap > 8 {
+		ap = 0
+	}
+	var width = float64(w.columnCount) * cea708CaptionArea / cea708MaxColumns
+	var height = float64(w.rowCount) * cea708CaptionArea / cea708MaxRows
+	var g = &Geometry{
+		DisplayAlign: []DisplayAlign{DisplayAlignBefore, DisplayAlignCenter, DisplayAlignAfter}[ap/3],
+		Height:       roundPercentage(height),
+		Left:         roundPercentage(x - float64(ap%3)*width/2),
+		TextAlign:    TextAlignLeft,
+		Top:          roundPercentage(y - float64(ap/3)*height/2),
+		Width:        roundPercentage(width),
+	}
+
+	// Get text align
+	switch w.attributes.justify {
+	case cea708JustifyCenter:
+		g.TextAlign = TextAlignCenter
+	case cea708JustifyRight:
+		g.TextAlign = TextAlignRight
+	}
+	return g
+}
+
+// lines returns the non empty rows of the window as lines
+// Line items are runs of characters sharing the same pen, and cells that have not been written are spaces.
+func (w *cea708Window) lines() (ls []Line) {
+	for _, row := range w.rows {
+		// Loop through cells
+		var l Line
+		var text []rune
+		var pen cea708Pen
+		var flush = func() {
+			if t := strings.TrimSpace(string(text)); len(t) > 0 {
+				l = append(l, LineItem{InlineStyle: pen.styleAttributes(), Text: t})
+			}
+			text = text[:0]
+		}
+		for _, c := range row {
+			if c.r == 0 {
+				text = append(text, ' ')
+				continue
+			}
+			if c.pen != pen {
+				flush()
+				pen = c.pen
+			}
+			text = append(text, c.r)
+		}
+		flush()
+
+		// Add line
+		if len(l) > 0 {
+			ls = append(ls, l)
+		}
+	}
+	return
+}
+
+// cea708Displayed represents what a window displays
+type cea708Displayed struct {
+	item *Item
+	key  string
+}
+
+// cea708Service represents the decoding state of a CEA-708 caption service
+type cea708Service struct {
+	changed   bool
+	current   int
+	delayEnd  time.Duration
+	delayed   [][]byte
+	delaying  bool
+	displayed [cea708Windows]cea708Displayed
+	regions   map[string]*Region
+	s         *Subtitles
+	windows   [cea708Windows]*cea708Window
+}
+
+// newCEA708Service creates a new CEA-708 service
+func newCEA708Service() *cea708Service {
+	return &cea708Service{
+		regions: make(map[string]*Region),
+		s:       NewSubtitles(),
+	}
+}
+
+// process processes codes
+// DelayCancel and Reset are processed as soon as they are received, while other codes received during a delay
+// are processed once it has expired.
+func (s *cea708Service) process(codes [][]byte, t time.Duration) {
+	s.changed = true
+	for idx, c := range codes {
+		switch {
+		case c[0] == cea708CodeDLC:
+			var d = s.delayed
+			s.delayed, s.delaying = nil, false
+			s.process(d, t)
+		case c[0] == cea708CodeRST:
+			s.delayed, s.delaying = nil, false
+			s.windows = [cea708Windows]*cea708Window{}
+		case s.delaying:
+			s.delayed = append(s.delayed, c)
+		default:
+			s.execute(c, t)
+			if s.delaying {
+				s.delayed = append(s.delayed, codes[idx+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// expire processes codes whose delay has expired
+func (s *cea708Service) expire(t time.Duration) {
+	if !s.delaying || t < s.delayEnd {
+		return
+	}
+	var d = s.delayed
+	s.delayed, s.delaying = nil, false
+	s.process(d, t)
+}
+
+// execute executes a code
+func (s *cea708Service) execute(c []byte, t time.Duration) {
+	// Get current window
+	var w = s.windows[s.current]
+
+	// Switch on code
+	switch b := c[0]; {
+	case b == cea708CodeBS:
+		if w != nil && w.penColumn > 0 {
+			w.penColumn--
+			if w.penRow < w.rowCount && w.penColumn < w.columnCount {
+				w.rows[w.penRow][w.penColumn] = cea708Cell{}
+			}
+		}
+	case b == cea708CodeCR:
+		if w != nil {
+			w.carriageReturn()
+		}
+	case b == cea708CodeEXT1:
+		if w != nil && len(c) == 2 && c[1] >= 0x20 {
+			if r, ok := cea708G2[c[1]]; ok {
+				w.write(r)
+			} else if c[1] < 0x80 || c[1] >= 0xa0 {
+				w.write('_')
+			}
+		}
+	case b == cea708CodeFF:
+		if w != nil {
+			w.clear()
+			w.penColumn, w.penRow = 0, 0
+		}
+	case b == cea708CodeHCR:
+		if w != nil && w.penRow < w.rowCount {
+			w.rows[w.penRow] = make([]cea708Cell, w.columnCount)
+			w.penColumn = 0
+		}
+	case b == cea708CodeP16:
+		if w != nil {
+			w.write(rune(c[1])<<8 | rune(c[2]))
+		}
+	case b >= 0x20 && b < 0x80, b >= 0xa0:
+		if w != nil {
+			var r = rune(b)
+			if b == 0x7f {
+				r = '♪'
+			}
+			w.write(r)
+		}
+	case b >= cea708CodeCW0 && b < cea708CodeCW0+cea708Windows:
+		s.current = int(b - cea708CodeCW0)
+	case b == cea708CodeCLW, b == cea708CodeDLW, b == cea708CodeDSW, b == cea708CodeHDW, b == cea708CodeTGW:
+		for idx := 0; idx < cea708Windows; idx++ {
+			if c[1]&(1<<uint(idx)) == 0 || s.windows[idx] == nil {
+				continue
+			}
+			switch b {
+			case cea708CodeCLW:
+				s.windows[idx].clear()
+			case cea708CodeDLW:
+				s.windows[idx] = nil
+			case cea708CodeDSW:
+				s.windows[idx].visible = true
+			case cea708CodeHDW:
+				s.windows[idx].visible = false
+			case cea708CodeTGW:
+				s.windows[idx].visible = !s.windows[idx].visible
+			}
+		}
+	case b == cea708CodeDLY:
+		s.delayEnd, s.delaying = t+time.Duration(c[1])*100*time.Millisecond, true
+	case b == cea708CodeSPA:
+		if w != nil {
+			w.pen.textTag, w.pen.offset, w.pen.size = c[1]>>4, c[1]>>2&0x3, c[1]&0x3
+			w.pen.italics, w.pen.underline, w.pen.edgeType, w.pen.fontStyle = c[2]&0x80 > 0, c[2]&0x40 > 0, c[2]>>3&0x7, c[2]&0x7
+		}
+	case b == cea708CodeSPC:
+		if w != nil {
+			w.pen.foreground, w.pen.background, w.pen.edge = parseCEA708Color(c[1]), parseCEA708Color(c[2]), parseCEA708Color(c[3]&0x3f)
+		}
+	case b == cea708CodeSPL:
+		if w != nil {
+			w.penRow, w.penColumn = int(c[1]&0xf), int(c[2]&0x3f)
+		}
+	case b == cea708CodeSWA:
+		if w != nil {
+			w.attributes = cea708WindowAttributes{
+				fill:            parseCEA708Color(c[1]),
+				justify:         c[3] & 0x3,
+				printDirection:  c[3] >> 4 & 0x3,
+				scrollDirection: c[3] >> 2 & 0x3,
+				wordWrap:        c[3]&0x40 > 0,
+			}
+		}
+	case b >= cea708CodeDF0:
+		s.defineWindow(int(b-cea708CodeDF0), c[1:])
+	}
+}
+
+// defineWindow creates a window or updates an existing one, and makes it the current window
+// Style IDs set to 0 select the first predefined styles for new windows and keep the current styles otherwise.
+func (s *cea708Service) defineWindow(id int, p []byte) {
+	// Get window
+	var w = s.windows[id]
+	var ws, ps = p[5] >> 3 & 0x7, p[5] & 0x7
+	if w == nil {
+		w = &cea708Window{}
+		s.windows[id] = w
+		if ws == 0 {
+			ws = 1
+		}
+		if ps == 0 {
+			ps = 1
+		}
+	}
+
+	// Update window
+	w.visible = p[0]&0x20 > 0
+	w.relative = p[1]&0x80 > 0
+	w.anchorVertical, w.anchorHorizontal, w.anchorPoint = int(p[1]&0x7f), int(p[2]), int(p[3]>>4)
+	if ws > 0 {
+		w.attributes = cea708WindowStyles[ws]
+	}
+	if ps > 0 {
+		w.pen = cea708PenStyles[ps]
+	}
+	w.resize(int(p[3]&0xf)+1, int(p[4]&0x3f)+1)
+	s.current = id
+}
+
+// update creates and ends items based on what windows display at a given time
+// Windows are only checked when codes have been processed since the last update.
+func (s *cea708Service) update(t time.Duration) {
+	if !s.changed {
+		return
+	}
+	s.changed = false
+	for idx, w := range s.windows {
+		// Nothing has changed
+		var k string
+		if w != nil {
+			k = w.key()
+		}
+		var d = &s.displayed[idx]
+		if k == d.key {
+			continue
+		}
+
+		// End displayed item
+		if d.item != nil {
+			s.end(d.item, t)
+		}
+
+		// Create item
+		*d = cea708Displayed{key: k}
+		if k == "" {
+			continue
+		}
+		var ls = w.lines()
+		if len(ls) == 0 {
+			continue
+		}
+		d.item = &Item{Lines: ls, Region: s.region(w), StartAt: t}
+		s.s.Items = append(s.s.Items, d.item)
+	}
+}
+
+// end ends an item, removing it when it has not been displayed at all
+func (s *cea708Service) end(i *Item, t time.Duration) {
+	i.EndAt = t
+	if t > i.StartAt {
+		return
+	}
+	for idx := len(s.s.Items) - 1; idx >= 0; idx-- {
+		if s.s.Items[idx] == i {
+			s.s.Items = append(s.s.Items[:idx], s.s.Items[idx+1:]...)
+			break
+		}
+	}
+}
+
+// region returns the region matching the window geometry and fill, creating it if needed
+func (s *cea708Service) region(w *cea708Window) (r *Region) {
+	var g = w.geometry()
+	var sa = &StyleAttributes{BackgroundColor: w.attributes.fill.color()}
+	var k = fmt.Sprintf("%+v|%s", *g, sa.BackgroundColor)
+	var ok bool
+	if r, ok = s.regions[k]; ok {
+		return
+	}
+	r = &Region{Geometry: g, ID: fmt.Sprintf("window%d", len(s.regions)+1), InlineStyle: sa}
+	s.regions[k] = r
+	s.s.Regions[r.ID] = r
+	return
+}
+
+// CEA708Decoder represents an object capable of decoding DTVCC packets carried by cc_data triplets into
+// subtitles, one per caption service
+// Each window displaying text becomes an item whose region holds the window geometry and fill, and an item
+// ends as soon as what its window displays changes.
+type CEA708Decoder struct {
+	packet   []byte
+	services map[int]*cea708Service
+}
+
+// NewCEA708Decoder creates a new CEA-708 decoder
+func NewCEA708Decoder() *CEA708Decoder {
+	return &CEA708Decoder{services: make(map[int]*cea708Service)}
+}
+
+// Decode decodes the cc_data triplets of a video frame displayed at a given time
+// CEA-608 triplets are ignored.
+func (d *CEA708Decoder) Decode(t time.Duration, ccData []byte) (err error) {
+	// Check length
+	if len(ccData)%3 != 0 {
+		err = fmt.Errorf("Invalid cc_data length %d", len(ccData))
+		return
+	}
+
+	// Expire delays
+	for _, s := range d.services {
+		s.expire(t)
+	}
+
+	// Loop through triplets
+	for idx := 0; idx < len(ccData); idx += 3 {
+		// Only valid DTVCC triplets are processed
+		if ccData[idx]&cea708CCValid == 0 {
+			continue
+		}
+		switch ccData[idx] & 0x3 {
+		case cea708CCTypeDTVCCStart:
+			d.packet = append(d.packet[:0], ccData[idx+1:idx+3]...)
+		case cea708CCTypeDTVCCData:
+			if len(d.packet) == 0 {
+				continue
+			}
+			d.packet = append(d.packet, ccData[idx+1:idx+3]...)
+		default:
+			continue
+		}
+
+		// Packet is complete
+		var size = int(d.packet[0]&0x3f) * 2
+		if size == 0 {
+			size = cea708MaxPacketSize
+		}
+		if len(d.packet) >= size {
+			d.decodePacket(append([]byte{}, d.packet[1:size]...), t)
+			d.packet = d.packet[:0]
+		}
+	}
+
+	// Update items
+	for _, s := range d.services {
+		s.update(t)
+	}
+	return
+}
+
+// decodePacket decodes the service blocks of a DTVCC packet
+func (d *CEA708Decoder) decodePacket(b []byte, t time.Duration) {
+	for len(b) > 0 {
+		// Parse header
+		var service, size = int(b[0] >> 5), int(b[0] & 0x1f)
+		b = b[1:]
+		if service == 0 {
+			return
+		}
+		if service == 7 {
+			if len(b) == 0 {
+				return
+			}
+			service, b = int(b[0]&0x3f), b[1:]
+		}
+		if size > len(b) {
+			size = len(b)
+		}
+
+		// Get service
+		s, ok := d.services[service]
+		if !ok {
+			s = newCEA708Service()
+			d.services[service] = s
+		}
+
+		// Process codes
+		s.process(cea708Codes(b[:size]), t)
+		b = b[size:]
+	}
+}
+
+// Subtitles returns the subtitles of each caption service found so far, indexed by service number
+// Items still displayed end at the provided time.
+func (d *CEA708Decoder) Subtitles(t time.Duration) (o map[int]*Subtitles) {
+	o = make(map[int]*Subtitles)
+	for n, s := range d.services {
+		for idx := range s.displayed {
+			if s.displayed[idx].item != nil {
+				s.end(s.displayed[idx].item, t)
+			}
+			s.displayed[idx] = cea708Displayed{}
+		}
+		s.changed = true
+		o[n] = s.s
+	}
+	return
+}
+
+// cea708Codes splits service block data into codes
+// Incomplete codes are dropped.
+func cea708Codes(b []byte) (o [][]byte) {
+	for len(b) > 0 {
+		var n = cea708CodeLength(b)
+		if n > len(b) {
+			return
+		}
+		o = append(o, b[:n])
+		b = b[n:]
+	}
+	return
+}
+
+// cea708CodeLength returns the length of the code at the beginning of data
+func cea708CodeLength(b []byte) int {
+	switch c := b[0]; {
+	case c == cea708CodeEXT1:
+		if len(b) < 2 {
+			return 2
+		}
+		switch e := b[1]; {
+		case e < 0x20:
+			return 2 + int(e>>3)
+		case e >= 0x80 && e < 0x88:
+			return 6
+		case e >= 0x88 && e < 0x90:
+			return 7
+		case e >= 0x90 && e < 0xa0:
+			if len(b) < 3 {
+				return 3
+			}
+			return 3 + int(b[2]&0x3f)
+		}
+		return 2
+	case c < 0x10:
+		return 1
+	case c < 0x18:
+		return 2
+	case c < 0x20:
+		return 3
+	case c >= cea708CodeCLW && c <= cea708CodeDLY:
+		return 2
+	case c == cea708CodeSPA, c == cea708CodeSPL:
+		return 3
+	case c == cea708CodeSPC:
+		return 4
+	case c == cea708CodeSWA:
+		return 5
+	case c >= cea708CodeDF0 && c < 0xa0:
+		return 7
+	}
+	return 1
+}
+
+// DecodeCEA708 decodes the cc_data triplets of consecutive video frames into subtitles, one per caption service
+// indexed by service number
+func DecodeCEA708(frames [][]byte, f Framerate) (o map[int]*Subtitles, err error) {
+	// Check framerate
+	if f.IsZero() {
+		err = fmt.Errorf("Invalid framerate %s", f)
+		return
+	}
+
+	// Loop through frames
+	var d = NewCEA708Decoder()
+	for idx, b := range frames {
+		if err = d.Decode(f.Duration(idx), b); err != nil {
+			err = errors.Wrapf(err, "decoding frame %d failed", idx)
+			return
+		}
+	}
+	o = d.Subtitles(f.Duration(len(frames)))
+	return
+}
+
+// cea708EncoderItem represents an item being encoded
+type cea708EncoderItem struct {
+	codes     [][]byte
+	deleted   bool
+	displayed bool
+	end       int
+	previous  *cea708EncoderItem
+	sent      bool
+	start     int
+	window    int
+}
+
+// ready checks whether the item's window has been fully sent
+func (i *cea708EncoderItem) ready() bool {
+	return len(i.codes) == 0
+}
+
+// cea708Packet represents a DTVCC packet being built
+type cea708Packet struct {
+	b       []byte
+	block   int
+	header  int
+	max     int
+	service int
+}
+
+// add adds a code to the packet, and returns false if it doesn't fit
+// Codes are never split across service blocks.
+func (p *cea708Packet) add(service int, c []byte) bool {
+	// Get header size
+	var h int
+	if len(p.b) == 0 || p.service != service || p.block+len(c) > cea708MaxBlockSize {
+		h = 1
+		if service > 6 {
+			h = 2
+		}
+	}
+
+	// Check size
+	if len(p.b)+h+len(c) > p.max {
+		return false
+	}
+
+	// Add header
+	if h > 0 {
+		p.block, p.header, p.service = 0, len(p.b), service
+		if service > 6 {
+			p.b = append(p.b, 7<<5, byte(service))
+		} else {
+			p.b = append(p.b, byte(service)<<5)
+		}
+	}
+
+	// Add code
+	p.b = append(p.b, c...)
+	p.block += len(c)
+	p.b[p.header] = p.b[p.header]&0xe0 | byte(p.block)
+	return true
+}
+
+// ccData returns the packet as cc_data triplets
+// An odd number of bytes is padded with a null service block header.
+func (p *cea708Packet) ccData(sequence int) (o []byte) {
+	// Add header
+	var b = append([]byte{0x0}, p.b...)
+	if len(b)%2 > 0 {
+		b = append(b, 0x0)
+	}
+	b[0] = byte(sequence&0x3)<<6 | byte(len(b)/2&0x3f)
+
+	// Split into triplets
+	for idx := 0; idx < len(b); idx += 2 {
+		var t byte = 0xf8 | cea708CCValid | cea708CCTypeDTVCCData
+		if idx == 0 {
+			t = 0xf8 | cea708CCValid | cea708CCTypeDTVCCStart
+		}
+		o = append(o, t, b[idx], b[idx+1])
+	}
+	return
+}
+
+// EncodeCEA708 encodes subtitles into DTVCC packets, one caption service per subtitles indexed by service number,
+// and returns the cc_data triplets of each video frame starting at 0
+// Each item is sent ahead of time in a hidden window that is displayed when the item starts and deleted when it
+// ends. Lines longer than 42 characters are wrapped, and CEA-608 triplets carry null data.
+func EncodeCEA708(services map[int]*Subtitles, f Framerate) (frames [][]byte, err error) {
+	// Get number of triplets per frame
+	var count int
+	if n := f.nominal(); !f.IsZero() && n > 0 {
+		count = 600 / n
+	}
+	if count < 3 {
+		err = fmt.Errorf("Invalid framerate %s", f)
+		return
+	} else if count > 31 {
+		count = 31
+	}
+
+	// Get service numbers
+	var ns []int
+	for n := range services {
+		if n < 1 || n > cea708MaxService {
+			err = fmt.Errorf("Invalid service number %d", n)
+			return
+		}
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+
+	// Loop through services
+	var items = make(map[int][]*cea708EncoderItem)
+	var last int
+	for _, n := range ns {
+		// Order items
+		var is = append([]*Item{}, services[n].Items...)
+		sort.SliceStable(is, func(a, b int) bool { return is[a].StartAt < is[b].StartAt })
+
+		// Loop through items
+		for idx, i := range is {
+			// Get frames
+			var ei = &cea708EncoderItem{end: f.Frames(i.EndAt), start: f.Frames(i.StartAt), window: len(items[n]) % cea708Windows}
+			if ei.start < 0 {
+				ei.start = 0
+			}
+			if ei.end <= ei.start {
+				continue
+			}
+
+			// Get codes
+			if ei.codes, err = cea708ItemCodes(i, ei.window); err != nil {
+				err = errors.Wrapf(err, "getting codes of item %d of service %d failed", idx, n)
+				return
+			} else if len(ei.codes) == 0 {
+				continue
+			}
+
+			// Items can't be sent before the item previously using the same window has been deleted
+			if k := len(items[n]) - cea708Windows; k >= 0 {
+				ei.previous = items[n][k]
+			}
+
+			// Add item
+			items[n] = append(items[n], ei)
+			if ei.end > last {
+				last = ei.end
+			}
+		}
+	}
+
+	// Loop through frames
+	var sequence int
+	frames = make([][]byte, last+1)
+	for idx := range frames {
+		// Loop through services
+		var p = &cea708Packet{max: int(math.Min(cea708MaxPacketSize, float64(2*(count-2)))) - 1}
+		for _, n := range ns {
+			// Delete windows of items that have ended
+			var m byte
+			var ended []*cea708EncoderItem
+			for _, i := range items[n] {
+				if !i.deleted && i.end <= idx {
+					ended = append(ended, i)
+					if i.sent {
+						m |= 1 << uint(i.window)
+					}
+				}
+			}
+			if m == 0 || p.add(n, []byte{cea708CodeDLW, m}) {
+				for _, i := range ended {
+					i.deleted = true
+				}
+			}
+
+			// Display windows of items that have started
+			cea708Display(p, n, items[n], idx)
+
+			// Send windows
+			for _, i := range items[n] {
+				if i.deleted || i.ready() {
+					continue
+				}
+				if i.previous != nil && !i.previous.deleted {
+					break
+				}
+				for len(i.codes) > 0 && p.add(n, i.codes[0]) {
+					i.codes, i.sent = i.codes[1:], true
+				}
+				if !i.ready() {
+					break
+				}
+			}
+
+			// Display windows that have just been sent
+			cea708Display(p, n, items[n], idx)
+		}
+
+		// Add CEA-608 null data
+		var b = append([]byte{}, cea708CEA608NullData...)
+
+		// Add packet
+		if len(p.b) > 0 {
+			b = append(b, p.ccData(sequence)...)
+			sequence++
+		}
+
+		// Add padding
+		for len(b) < 3*count {
+			b = append(b, 0xf8|cea708CCTypeDTVCCData, 0x0, 0x0)
+		}
+		frames[idx] = b
+	}
+	return
+}
+
+// cea708Display displays the windows of items that have started and have been fully sent
+func cea708Display(p *cea708Packet, service int, items []*cea708EncoderItem, frame int) {
+	var m byte
+	var is []*cea708EncoderItem
+	for _, i := range items {
+		if !i.deleted && !i.displayed && i.ready() && i.start <= frame {
+			m |= 1 << uint(i.window)
+			is = append(is, i)
+		}
+	}
+	if m > 0 && p.add(service, []byte{cea708CodeDSW, m}) {
+		for _, i := range is {
+			i.displayed = true
+		}
+	}
+}
+
+// cea708ItemCodes returns the codes defining a hidden window holding the item
+// The window is anchored in the caption area based on the item's geometry, or at the bottom center of the caption
+// area when it has none.
+func cea708ItemCodes(i *Item, window int) (codes [][]byte, err error) {
+	// Get rows
+	var rows = cea708Rows(i)
+	if len(rows) == 0 {
+		return
+	} else if len(rows) > cea708MaxRows {
+		err = fmt.Errorf("Item has %d rows whereas the maximum is %d", len(rows), cea708MaxRows)
+		return
+	}
+	var columns int
+	for _, r := range rows {
+		if len(r) > columns {
+			columns = len(r)
+		}
+	}
+
+	// Get geometry
+	var g = i.geometry()
+	if g == nil {
+		g = &Geometry{DisplayAlign: DisplayAlignAfter, Height: cea708CaptionArea, Left: cea708SafeArea, TextAlign: TextAlignCenter, Top: cea708SafeArea, Width: cea708CaptionArea}
+	}
+	var ta = g.TextAlign
+	if ta == "" {
+		ta = ComputedStyle(i, nil).TextAlign
+	}
+
+	// Get anchor
+	var a = cea708WindowAttributes{fill: cea708Color{opacity: cea708OpacityTransparent}, printDirection: cea708DirectionLeftToRight, scrollDirection: cea708DirectionBottomToTop}
+	var column, row int
+	switch ta {
+	case TextAlignCenter:
+		a.justify, column = cea708JustifyCenter, 1
+	case TextAlignEnd, TextAlignRight:
+		a.justify, column = cea708JustifyRight, 2
+	}
+	switch g.DisplayAlign {
+	case DisplayAlignAfter:
+		row = 2
+	case DisplayAlignCenter:
+		row = 1
+	}
+	var ah, av = cea708Anchor(g.Left + float64(column)*g.Width/2), cea708Anchor(g.anchor())
+
+	// Get fill
+	if i.Region != nil {
+		var sa = &StyleAttributes{}
+		mergeStyleAttributes(sa, i.Region.InlineStyle, nil)
+		mergeStyleAttributes(sa, i.Region.Style.styleAttributes(), nil)
+		if sa.BackgroundColor != nil {
+			a.fill = newCEA708Color(*sa.BackgroundColor)
+		}
+	}
+
+	// Define window
+	// Windows are hidden, and row and column counts are locked
+	codes = append(codes,
+		[]byte{cea708CodeDF0 + byte(window), 0x18, 0x80 | av, ah, byte(row*3+column)<<4 | byte(len(rows)-1), byte(columns - 1), 0x0},
+		append([]byte{cea708CodeSWA}, a.bytes()...),
+	)
+
+	// Loop through rows
+	var pen cea708Pen
+	for r, cs := range rows {
+		codes = append(codes, []byte{cea708CodeSPL, byte(r), 0x0})
+		for idx, c := range cs {
+			if (r == 0 && idx == 0) || c.pen != pen {
+				pen = c.pen
+				codes = append(codes, append([]byte{cea708CodeSPA}, pen.attributes()...), append([]byte{cea708CodeSPC}, pen.colors()...))
+			}
+			codes = append(codes, cea708EncodeRune(c.r))
+		}
+	}
+	return
+}
+
+// cea708Anchor converts a percentage of the frame into a relative anchor coordinate of the caption area
+func cea708Anchor(v float64) byte {
+	return byte(math.Max(0, math.Min(99, math.Floor((v-cea708SafeArea)/cea708CaptionArea*99+0.5))))
+}
+
+// cea708Rows returns the item lines as rows of cells, wrapping lines longer than the maximum number of columns
+// Line items are separated by a space.
+func cea708Rows(i *Item) (rows [][]cea708Cell) {
+	for _, l := range i.Lines {
+		// Loop through line items
+		var row []cea708Cell
+		for idx := range l {
+			var t = strings.TrimSpace(l[idx].Text)
+			if t == "" {
+				continue
+			}
+			var pen = newCEA708Pen(ComputedStyle(i, &l[idx]))
+			if len(row) > 0 {
+				row = append(row, cea708Cell{pen: row[len(row)-1].pen, r: ' '})
+			}
+			for _, r := range t {
+				row = append(row, cea708Cell{pen: pen, r: r})
+			}
+		}
+
+		// Wrap
+		for len(row) > cea708MaxColumns {
+			var n = cea708MaxColumns
+			for idx := cea708MaxColumns; idx > 0; idx-- {
+				if row[idx].r == ' ' {
+					n = idx
+					break
+				}
+			}
+			rows = append(rows, row[:n])
+			row = row[n:]
+			for len(row) > 0 && row[0].r == ' ' {
+				row = row[1:]
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// cea708EncodeRune encodes a character
+// Characters missing from the G0, G1 and G2 code sets are encoded as 16-bit characters, and other control characters
+// as spaces.
+func cea708EncodeRune(r rune) []byte {
+	switch {
+	case r == '♪':
+		return []byte{0x7f}
+	case r < 0x20:
+		return []byte{' '}
+	case r < 0x7f, r >= 0xa0 && r <= 0xff:
+		return []byte{byte(r)}
+	}
+	if b, ok := cea708G2Codes[r]; ok {
+		return []byte{cea708CodeEXT1, b}
+	}
+	if r <= 0xffff {
+		return []byte{cea708CodeP16, byte(r >> 8), byte(r)}
+	}
+	return []byte{'_'}
+}
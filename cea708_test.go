@@ -0,0 +1,156 @@
+package astisub_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// cea708TestFrame builds the cc_data triplets of a frame holding a DTVCC packet with a single service block
+func cea708TestFrame(service byte, data ...byte) (o []byte) {
+	var b = append([]byte{0x0, service<<5 | byte(len(data))}, data...)
+	if len(b)%2 > 0 {
+		b = append(b, 0x0)
+	}
+	b[0] = byte(len(b) / 2)
+	for idx := 0; idx < len(b); idx += 2 {
+		var t byte = 0xfe
+		if idx == 0 {
+			t = 0xff
+		}
+		o = append(o, t, b[idx], b[idx+1])
+	}
+	return
+}
+
+func TestDecodeCEA708(t *testing.T) {
+	// Init
+	var frames = make([][]byte, 120)
+	// Visible window 1 anchored at the bottom center with 2 rows and 32 columns
+	frames[0] = cea708TestFrame(1, 0x99, 0x20, 0xe3, 0x32, 0x71, 0x1f, 0x0, 'H', 'i', 0xd, 0x18, 0x4, 0x16, 0x10, 0x39)
+	// Hide window 1 after 1 second
+	frames[30] = cea708TestFrame(1, 0x8d, 0xa, 0x8a, 0x2)
+	// Clear window 1, write red italic text on a transparent background and display it
+	frames[90] = cea708TestFrame(1, 0x81, 0xc, 0x91, 0x30, 0xc0, 0x0, 0x90, 0x5, 0x80, 'Y', 'o', 0x89, 0x2)
+	for idx := range frames {
+		if frames[idx] == nil {
+			frames[idx] = []byte{0xfc, 0x80, 0x80, 0xfa, 0x0, 0x0}
+		}
+	}
+
+	// Decode
+	ss, err := astisub.DecodeCEA708(frames, astisub.Framerate30)
+	assert.NoError(t, err)
+	assert.Len(t, ss, 1)
+	s := ss[1]
+	assert.Len(t, s.Items, 2)
+
+	// First item
+	assert.Equal(t, time.Duration(0), s.Items[0].StartAt)
+	assert.Equal(t, 2*time.Second, s.Items[0].EndAt)
+	assert.Equal(t, "Hi - Ж™", s.Items[0].String())
+	assert.Equal(t, &astisub.ColorWhite, s.Items[0].Lines[0][0].InlineStyle.Color)
+	assert.Equal(t, &astisub.ColorBlack, s.Items[0].Lines[0][0].InlineStyle.BackgroundColor)
+	g := s.Items[0].Region.Geometry
+	assert.Equal(t, astisub.DisplayAlignAfter, g.DisplayAlign)
+	assert.Equal(t, astisub.TextAlignLeft, g.TextAlign)
+	assert.InDelta(t, 50.4, g.Left+g.Width/2, 0.1)
+	assert.InDelta(t, 90, g.Bottom(), 0.1)
+	assert.InDelta(t, 10.67, g.Height, 0.01)
+	assert.Equal(t, s.Items[0].Region, s.Regions[s.Items[0].Region.ID])
+
+	// Second item
+	assert.Equal(t, 3*time.Second, s.Items[1].StartAt)
+	assert.Equal(t, 4*time.Second, s.Items[1].EndAt)
+	assert.Equal(t, "Yo", s.Items[1].String())
+	sa := s.Items[1].Lines[0][0].InlineStyle
+	assert.Equal(t, &astisub.ColorRed, sa.Color)
+	assert.Equal(t, uint8(0), sa.BackgroundColor.Alpha)
+	assert.Equal(t, astisub.FontStyleItalic, sa.FontStyle)
+
+	// Invalid cc_data
+	err = astisub.NewCEA708Decoder().Decode(0, []byte{0xfc})
+	assert.Error(t, err)
+}
+
+func TestEncodeCEA708(t *testing.T) {
+	// Init
+	ss := map[int]*astisub.Subtitles{
+		1: {Items: []*astisub.Item{
+			{
+				EndAt:   2 * time.Second,
+				Lines:   []astisub.Line{{{Text: "Hello"}, {InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorYellow, FontStyle: astisub.FontStyleItalic}, Text: "world"}}},
+				StartAt: time.Second,
+			},
+			{
+				EndAt:   4 * time.Second,
+				Lines:   []astisub.Line{{{Text: "This line is long enough to be wrapped into two rows"}}},
+				StartAt: 2 * time.Second,
+			},
+		}},
+		2: {Items: []*astisub.Item{
+			{
+				EndAt:    3 * time.Second,
+				Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 20, Left: 10, TextAlign: astisub.TextAlignLeft, Top: 10, Width: 50},
+				Lines:    []astisub.Line{{{Text: "Bonjour ♪"}}},
+				StartAt:  time.Second,
+			},
+		}},
+	}
+
+	// Encode
+	f := astisub.Framerate2997
+	frames, err := astisub.EncodeCEA708(ss, f)
+	assert.NoError(t, err)
+	assert.Len(t, frames, f.Frames(4*time.Second)+1)
+	for _, b := range frames {
+		assert.Len(t, b, 60)
+	}
+
+	// Decode
+	ds, err := astisub.DecodeCEA708(frames, f)
+	assert.NoError(t, err)
+	assert.Len(t, ds, 2)
+
+	// First service
+	s := ds[1]
+	assert.Len(t, s.Items, 2)
+	assert.InDelta(t, time.Second, s.Items[0].StartAt, float64(f.Duration(1)))
+	assert.InDelta(t, 2*time.Second, s.Items[0].EndAt, float64(f.Duration(1)))
+	assert.Equal(t, "Hello world", s.Items[0].String())
+	assert.Len(t, s.Items[0].Lines[0], 2)
+	assert.Equal(t, &astisub.ColorYellow, s.Items[0].Lines[0][1].InlineStyle.Color)
+	assert.Equal(t, astisub.FontStyleItalic, s.Items[0].Lines[0][1].InlineStyle.FontStyle)
+	g := s.Items[0].Region.Geometry
+	assert.Equal(t, astisub.DisplayAlignAfter, g.DisplayAlign)
+	assert.Equal(t, astisub.TextAlignCenter, g.TextAlign)
+	assert.InDelta(t, 50, g.Left+g.Width/2, 0.5)
+	assert.InDelta(t, 90, g.Bottom(), 0.5)
+	assert.InDelta(t, 2*time.Second, s.Items[1].StartAt, float64(f.Duration(1)))
+	assert.Equal(t, "This line is long enough to be wrapped - into two rows", s.Items[1].String())
+
+	// Second service
+	s = ds[2]
+	assert.Len(t, s.Items, 1)
+	assert.Equal(t, "Bonjour ♪", s.Items[0].String())
+	g = s.Items[0].Region.Geometry
+	assert.Equal(t, astisub.DisplayAlignBefore, g.DisplayAlign)
+	assert.InDelta(t, 10, g.Left, 0.5)
+	assert.InDelta(t, 10, g.Top, 0.5)
+
+	// Round trip
+	frames2, err := astisub.EncodeCEA708(ds, f)
+	assert.NoError(t, err)
+	ds2, err := astisub.DecodeCEA708(frames2, f)
+	assert.NoError(t, err)
+	assert.Equal(t, ds[1].Items[1].String(), ds2[1].Items[1].String())
+	assert.Equal(t, ds[2].Items[0].Region.Geometry, ds2[2].Items[0].Region.Geometry)
+
+	// Invalid options
+	_, err = astisub.EncodeCEA708(ss, astisub.Framerate{})
+	assert.Error(t, err)
+	_, err = astisub.EncodeCEA708(map[int]*astisub.Subtitles{64: {}}, f)
+	assert.Error(t, err)
+}
@@ -0,0 +1,723 @@
+package astisub
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"time"
+
+	"github.com/asticode/go-astitools/map"
+	"github.com/pkg/errors"
+)
+
+// https://www.etsi.org/deliver/etsi_en/300700_300799/300743/01.06.01_60/en_300743v010601p.pdf
+
+// DVB constants
+const (
+	dvbDataIdentifier          = 0x20
+	dvbDefaultDisplayHeight    = 576
+	dvbDefaultDisplayWidth     = 720
+	dvbDescriptorTagSubtitling = 0x59
+	dvbDescriptorEntrySize     = 8
+	dvbObjectCodingPixels      = 0x0
+	dvbPageStateModeChange     = 0x2
+	dvbStreamTypePrivateData   = 0x06
+	dvbSyncByte                = 0x0f
+)
+
+// DVB CLUT entry flags
+const (
+	dvbCLUTEntryFlag2Bit      = 0x80
+	dvbCLUTEntryFlag4Bit      = 0x40
+	dvbCLUTEntryFlag8Bit      = 0x20
+	dvbCLUTEntryFlagFullRange = 0x1
+)
+
+// DVB pixel data sub-block types
+const (
+	dvbPixelDataType2BitString   = 0x10
+	dvbPixelDataType4BitString   = 0x11
+	dvbPixelDataType8BitString   = 0x12
+	dvbPixelDataTypeEndOfLine    = 0xf0
+	dvbPixelDataTypeMapTable2To4 = 0x20
+	dvbPixelDataTypeMapTable2To8 = 0x21
+	dvbPixelDataTypeMapTable4To8 = 0x22
+)
+
+// DVB region depths
+const (
+	dvbRegionDepth2Bit = 0x1
+	dvbRegionDepth4Bit = 0x2
+	dvbRegionDepth8Bit = 0x3
+)
+
+// DVB region object types
+const (
+	dvbRegionObjectTypeCharacter  = 0x1
+	dvbRegionObjectTypeCharacters = 0x2
+)
+
+// DVB segment types
+const (
+	dvbSegmentTypeCLUTDefinition    = 0x12
+	dvbSegmentTypeDisplayDefinition = 0x14
+	dvbSegmentTypeObjectData        = 0x13
+	dvbSegmentTypePageComposition   = 0x10
+	dvbSegmentTypeRegionComposition = 0x11
+)
+
+// DVB languages are ISO 639-2/B codes, unmapped ones being kept as is
+var dvbLanguageMapping = astimap.NewMap("und", "").
+	Set("eng", LanguageEnglish).
+	Set("fre", LanguageFrench)
+
+// Default map tables
+var (
+	dvbDefaultMapTable2To4 = []uint8{0x0, 0x7, 0x8, 0xf}
+	dvbDefaultMapTable2To8 = []uint8{0x00, 0x77, 0x88, 0xff}
+	dvbDefaultMapTable4To8 = []uint8{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+)
+
+// ReadFromDVB parses DVB bitmap subtitles (EN 300 743) out of a transport stream
+// When pid is 0, the first stream with a subtitling descriptor is used. When page is 0, the first composition page
+// of the stream's subtitling descriptor is used. Times are relative to the first PTS found in the stream, and each
+// item holds the image of a display set.
+func ReadFromDVB(i io.Reader, pid, page int) (o *Subtitles, err error) {
+	// Init
+	o = NewSubtitles()
+	var p = newDVBPage(page)
+
+	// Create demuxer
+	var d *tsDemuxer
+	d = newTSDemuxer(func(s tsStream) bool {
+		// Check stream
+		if (pid > 0 && s.pid != pid) || (pid == 0 && len(p.pids) > 0) {
+			return false
+		}
+		var ds, ok = s.descriptor(dvbDescriptorTagSubtitling)
+		if !ok || s.streamType != dvbStreamTypePrivateData {
+			return false
+		}
+
+		// Loop through descriptor entries
+		for idx := 0; idx+dvbDescriptorEntrySize <= len(ds.data); idx += dvbDescriptorEntrySize {
+			var cp = int(binary.BigEndian.Uint16(ds.data[idx+4:]))
+			if p.compositionPageID > 0 && cp != p.compositionPageID {
+				continue
+			}
+			p.compositionPageID = cp
+			p.ancillaryPageID = int(binary.BigEndian.Uint16(ds.data[idx+6:]))
+			if l := string(ds.data[idx : idx+3]); l != "und" {
+				o.Metadata = &Metadata{Language: l}
+				if dvbLanguageMapping.InA(l) {
+					o.Metadata.Language = dvbLanguageMapping.B(l).(string)
+				}
+			}
+			p.pids[s.pid] = true
+			return true
+		}
+		return false
+	}, func(pes tsPES) (err error) {
+		// Only private streams carry subtitles
+		if pes.streamID != tsStreamIDPrivate || len(pes.data) < 2 || pes.data[0] != dvbDataIdentifier {
+			return
+		}
+
+		// Parse segments
+		var ds bool
+		if ds, err = p.parseSegments(pes.data[2:]); err != nil {
+			err = errors.Wrap(err, "parsing segments failed")
+			return
+		}
+
+		// Add display set
+		if ds {
+			p.addDisplaySet(o, d.duration(pes.pts))
+		}
+		return
+	})
+
+	// Demux
+	if err = d.demux(i); err != nil {
+		err = errors.Wrap(err, "demuxing failed")
+		return
+	}
+	return
+}
+
+// dvbCLUT represents a DVB colour look-up table
+type dvbCLUT struct {
+	c2 [4]color.NRGBA
+	c4 [16]color.NRGBA
+	c8 [256]color.NRGBA
+}
+
+// newDVBCLUT creates a CLUT holding the default entries
+func newDVBCLUT() (c *dvbCLUT) {
+	// 2-bit entries
+	c = &dvbCLUT{}
+	c.c2[1] = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	c.c2[2] = color.NRGBA{A: 0xff}
+	c.c2[3] = color.NRGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}
+
+	// 4-bit entries
+	for idx := 1; idx < 16; idx++ {
+		var v = uint8(0xff)
+		if idx >= 8 {
+			v = 0x7f
+		}
+		c.c4[idx] = color.NRGBA{R: dvbBit(idx, 0x1, v), G: dvbBit(idx, 0x2, v), B: dvbBit(idx, 0x4, v), A: 0xff}
+	}
+
+	// 8-bit entries
+	for idx := 1; idx < 256; idx++ {
+		if idx < 8 {
+			c.c8[idx] = color.NRGBA{R: dvbBit(idx, 0x1, 0xff), G: dvbBit(idx, 0x2, 0xff), B: dvbBit(idx, 0x4, 0xff), A: 0x3f}
+			continue
+		}
+		var v = func(b1, b5 int) uint8 { return dvbBit(idx, b1, 0x55) + dvbBit(idx, b5, 0xaa) }
+		var a = uint8(0xff)
+		var base uint8
+		switch idx & 0x88 {
+		case 0x08:
+			a = 0x7f
+		case 0x80:
+			base = 0x7f
+			v = func(b1, b5 int) uint8 { return dvbBit(idx, b1, 0x2b) + dvbBit(idx, b5, 0x55) }
+		case 0x88:
+			v = func(b1, b5 int) uint8 { return dvbBit(idx, b1, 0x2b) + dvbBit(idx, b5, 0x55) }
+		}
+		c.c8[idx] = color.NRGBA{R: base + v(0x1, 0x10), G: base + v(0x2, 0x20), B: base + v(0x4, 0x40), A: a}
+	}
+	return
+}
+
+// dvbBit returns v if the bit is set in i and 0 otherwise
+func dvbBit(i, bit int, v uint8) uint8 {
+	if i&bit > 0 {
+		return v
+	}
+	return 0
+}
+
+// color returns the color of an entry for a region depth
+func (c dvbCLUT) color(depth, idx uint8) color.NRGBA {
+	switch depth {
+	case dvbRegionDepth2Bit:
+		return c.c2[idx&0x3]
+	case dvbRegionDepth4Bit:
+		return c.c4[idx&0xf]
+	default:
+		return c.c8[idx]
+	}
+}
+
+// dvbColor converts a DVB Y/Cr/Cb/T entry into a color
+func dvbColor(y, cr, cb, t uint8) color.NRGBA {
+	// Y = 0 means full transparency
+	if y == 0 {
+		return color.NRGBA{}
+	}
+	var r, g, b = color.YCbCrToRGB(y, cb, cr)
+	return color.NRGBA{R: r, G: g, B: b, A: 0xff - t}
+}
+
+// dvbRegionObject represents an object positioned within a region
+type dvbRegionObject struct {
+	id uint16
+	x  int
+	y  int
+}
+
+// dvbRegion represents a DVB region
+type dvbRegion struct {
+	clutID  uint8
+	depth   uint8
+	height  int
+	objects []dvbRegionObject
+	pixels  []uint8
+	width   int
+}
+
+// set sets the pixel code of a pixel of the region
+func (r *dvbRegion) set(x, y int, code uint8) {
+	if x >= 0 && x < r.width && y >= 0 && y < r.height {
+		r.pixels[y*r.width+x] = code
+	}
+}
+
+// dvbPageRegion represents a region positioned within the page
+type dvbPageRegion struct {
+	id uint8
+	x  int
+	y  int
+}
+
+// dvbPage represents the state of a DVB subtitles page
+type dvbPage struct {
+	ancillaryPageID   int
+	cluts             map[uint8]*dvbCLUT
+	compositionPageID int
+	displayHeight     int
+	displayWidth      int
+	item              *Item
+	pageRegions       []dvbPageRegion
+	pids              map[int]bool
+	regions           map[uint8]*dvbRegion
+	timeout           time.Duration
+}
+
+// newDVBPage creates a new DVB page
+func newDVBPage(compositionPageID int) *dvbPage {
+	return &dvbPage{
+		cluts:             make(map[uint8]*dvbCLUT),
+		compositionPageID: compositionPageID,
+		displayHeight:     dvbDefaultDisplayHeight,
+		displayWidth:      dvbDefaultDisplayWidth,
+		pids:              make(map[int]bool),
+		regions:           make(map[uint8]*dvbRegion),
+	}
+}
+
+// parseSegments parses the segments of a PES data field and returns whether a display set has been composed
+func (p *dvbPage) parseSegments(b []byte) (ds bool, err error) {
+	// Loop through segments
+	for len(b) >= 6 && b[0] == dvbSyncByte {
+		// Parse header
+		var t = b[1]
+		var pageID = int(binary.BigEndian.Uint16(b[2:]))
+		var l = int(binary.BigEndian.Uint16(b[4:]))
+		if 6+l > len(b) {
+			err = errors.New("Segment length overflows data field")
+			return
+		}
+		var data = b[6 : 6+l]
+		b = b[6+l:]
+
+		// Pick page
+		if p.compositionPageID == 0 {
+			p.compositionPageID = pageID
+		}
+		if pageID != p.compositionPageID && (p.ancillaryPageID == 0 || pageID != p.ancillaryPageID) {
+			continue
+		}
+
+		// Switch on segment type
+		switch t {
+		case dvbSegmentTypeCLUTDefinition:
+			p.parseCLUTDefinition(data)
+		case dvbSegmentTypeDisplayDefinition:
+			p.parseDisplayDefinition(data)
+		case dvbSegmentTypeObjectData:
+			p.parseObjectData(data)
+		case dvbSegmentTypePageComposition:
+			p.parsePageComposition(data)
+			ds = true
+		case dvbSegmentTypeRegionComposition:
+			p.parseRegionComposition(data)
+		}
+	}
+	return
+}
+
+// parseDisplayDefinition parses a display definition segment
+func (p *dvbPage) parseDisplayDefinition(b []byte) {
+	if len(b) < 5 {
+		return
+	}
+	p.displayWidth = int(binary.BigEndian.Uint16(b[1:])) + 1
+	p.displayHeight = int(binary.BigEndian.Uint16(b[3:])) + 1
+}
+
+// parsePageComposition parses a page composition segment
+func (p *dvbPage) parsePageComposition(b []byte) {
+	if len(b) < 2 {
+		return
+	}
+
+	// Mode change resets the page
+	if b[1]>>2&0x3 == dvbPageStateModeChange {
+		p.cluts = make(map[uint8]*dvbCLUT)
+		p.regions = make(map[uint8]*dvbRegion)
+	}
+
+	// Parse regions
+	p.timeout = time.Duration(b[0]) * time.Second
+	p.pageRegions = []dvbPageRegion{}
+	for idx := 2; idx+6 <= len(b); idx += 6 {
+		p.pageRegions = append(p.pageRegions, dvbPageRegion{
+			id: b[idx],
+			x:  int(binary.BigEndian.Uint16(b[idx+2:])),
+			y:  int(binary.BigEndian.Uint16(b[idx+4:])),
+		})
+	}
+}
+
+// parseRegionComposition parses a region composition segment
+func (p *dvbPage) parseRegionComposition(b []byte) {
+	if len(b) < 10 {
+		return
+	}
+
+	// Get region
+	var width, height = int(binary.BigEndian.Uint16(b[2:])), int(binary.BigEndian.Uint16(b[4:]))
+	var r, ok = p.regions[b[0]]
+	if !ok || r.width != width || r.height != height {
+		r = &dvbRegion{height: height, pixels: make([]uint8, width*height), width: width}
+		p.regions[b[0]] = r
+	}
+	r.depth = b[6] >> 2 & 0x7
+	r.clutID = b[7]
+
+	// Fill region
+	if b[1]&0x8 > 0 {
+		var code = b[8]
+		switch r.depth {
+		case dvbRegionDepth2Bit:
+			code = b[9] >> 2 & 0x3
+		case dvbRegionDepth4Bit:
+			code = b[9] >> 4
+		}
+		for idx := range r.pixels {
+			r.pixels[idx] = code
+		}
+	}
+
+	// Parse objects
+	r.objects = []dvbRegionObject{}
+	for idx := 10; idx+6 <= len(b); idx += 6 {
+		var t = b[idx+2] >> 6
+		r.objects = append(r.objects, dvbRegionObject{
+			id: binary.BigEndian.Uint16(b[idx:]),
+			x:  int(binary.BigEndian.Uint16(b[idx+2:]) & 0xfff),
+			y:  int(binary.BigEndian.Uint16(b[idx+4:]) & 0xfff),
+		})
+		if t == dvbRegionObjectTypeCharacter || t == dvbRegionObjectTypeCharacters {
+			idx += 2
+		}
+	}
+}
+
+// parseCLUTDefinition parses a CLUT definition segment
+func (p *dvbPage) parseCLUTDefinition(b []byte) {
+	if len(b) < 2 {
+		return
+	}
+
+	// Get CLUT
+	var c, ok = p.cluts[b[0]]
+	if !ok {
+		c = newDVBCLUT()
+		p.cluts[b[0]] = c
+	}
+
+	// Loop through entries
+	for idx := 2; idx+2 <= len(b); {
+		// Parse entry
+		var id, flags = b[idx], b[idx+1]
+		var rgba color.NRGBA
+		if flags&dvbCLUTEntryFlagFullRange > 0 {
+			if idx+6 > len(b) {
+				return
+			}
+			rgba = dvbColor(b[idx+2], b[idx+3], b[idx+4], b[idx+5])
+			idx += 6
+		} else {
+			if idx+4 > len(b) {
+				return
+			}
+			var v = binary.BigEndian.Uint16(b[idx+2:])
+			rgba = dvbColor(uint8(v>>10)<<2, uint8(v>>6&0xf)<<4, uint8(v>>2&0xf)<<4, uint8(v&0x3)<<6)
+			idx += 4
+		}
+
+		// Set entry
+		if flags&dvbCLUTEntryFlag2Bit > 0 {
+			c.c2[id&0x3] = rgba
+		}
+		if flags&dvbCLUTEntryFlag4Bit > 0 {
+			c.c4[id&0xf] = rgba
+		}
+		if flags&dvbCLUTEntryFlag8Bit > 0 {
+			c.c8[id] = rgba
+		}
+	}
+}
+
+// parseObjectData parses an object data segment and draws the object in the regions referencing it
+func (p *dvbPage) parseObjectData(b []byte) {
+	// Only pixel objects are supported
+	if len(b) < 7 || b[2]>>2&0x3 != dvbObjectCodingPixels {
+		return
+	}
+	var id = binary.BigEndian.Uint16(b)
+
+	// Get fields
+	var tl, bl = int(binary.BigEndian.Uint16(b[3:])), int(binary.BigEndian.Uint16(b[5:]))
+	if 7+tl+bl > len(b) {
+		return
+	}
+	var top = b[7 : 7+tl]
+	var bottom = b[7+tl : 7+tl+bl]
+	if bl == 0 {
+		bottom = top
+	}
+
+	// Loop through regions
+	for _, r := range p.regions {
+		for _, ro := range r.objects {
+			if ro.id == id {
+				dvbDrawField(r, top, ro.x, ro.y)
+				dvbDrawField(r, bottom, ro.x, ro.y+1)
+			}
+		}
+	}
+}
+
+// dvbDrawField draws the pixel data sub-blocks of a field in a region
+// Field lines are interlaced: each line of a field is 2 lines apart in the region.
+func dvbDrawField(r *dvbRegion, b []byte, x0, y0 int) {
+	// Init map tables
+	var m24 = append([]uint8{}, dvbDefaultMapTable2To4...)
+	var m28 = append([]uint8{}, dvbDefaultMapTable2To8...)
+	var m48 = append([]uint8{}, dvbDefaultMapTable4To8...)
+
+	// Loop through sub-blocks
+	var x, y = x0, y0
+	for idx := 0; idx < len(b); {
+		var t = b[idx]
+		idx++
+
+		// Get map table
+		var m []uint8
+		var bits int
+		switch t {
+		case dvbPixelDataType2BitString:
+			bits = 2
+			switch r.depth {
+			case dvbRegionDepth4Bit:
+				m = m24
+			case dvbRegionDepth8Bit:
+				m = m28
+			}
+		case dvbPixelDataType4BitString:
+			bits = 4
+			if r.depth == dvbRegionDepth8Bit {
+				m = m48
+			}
+		case dvbPixelDataType8BitString:
+			bits = 8
+		case dvbPixelDataTypeMapTable2To4:
+			if idx+2 <= len(b) {
+				m24 = []uint8{b[idx] >> 4, b[idx] & 0xf, b[idx+1] >> 4, b[idx+1] & 0xf}
+			}
+			idx += 2
+			continue
+		case dvbPixelDataTypeMapTable2To8:
+			if idx+4 <= len(b) {
+				m28 = append([]uint8{}, b[idx:idx+4]...)
+			}
+			idx += 4
+			continue
+		case dvbPixelDataTypeMapTable4To8:
+			if idx+16 <= len(b) {
+				m48 = append([]uint8{}, b[idx:idx+16]...)
+			}
+			idx += 16
+			continue
+		case dvbPixelDataTypeEndOfLine:
+			x = x0
+			y += 2
+			continue
+		default:
+			return
+		}
+
+		// Decode pixel string
+		var br = &dvbBitReader{b: b[idx:]}
+		dvbDecodePixelString(br, bits, func(code uint8, n int) {
+			if m != nil && int(code) < len(m) {
+				code = m[code]
+			}
+			for i := 0; i < n; i++ {
+				r.set(x, y, code)
+				x++
+			}
+		})
+		idx += br.bytes()
+	}
+}
+
+// dvbBitReader represents a big endian bit reader
+type dvbBitReader struct {
+	b   []byte
+	pos int
+}
+
+// read reads n bits, and returns 0 once data is exhausted
+func (r *dvbBitReader) read(n int) (v uint32) {
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos/8 < len(r.b) && r.b[r.pos/8]&(0x80>>uint(r.pos%8)) > 0 {
+			v |= 1
+		}
+		r.pos++
+	}
+	return
+}
+
+// done returns whether data is exhausted
+func (r *dvbBitReader) done() bool {
+	return r.pos/8 >= len(r.b)
+}
+
+// bytes returns the number of bytes read, the last one being padded
+func (r *dvbBitReader) bytes() int {
+	return (r.pos + 7) / 8
+}
+
+// dvbDecodePixelString decodes a 2, 4 or 8-bit pixel code string and calls fn for each run of pixels
+func dvbDecodePixelString(r *dvbBitReader, bits int, fn func(code uint8, n int)) {
+	for !r.done() {
+		// Pixel code
+		if c := r.read(bits); c != 0 {
+			fn(uint8(c), 1)
+			continue
+		}
+
+		// Run-length
+		switch bits {
+		case 2:
+			if r.read(1) == 1 {
+				var n = int(r.read(3)) + 3
+				fn(uint8(r.read(2)), n)
+			} else if r.read(1) == 1 {
+				fn(0, 1)
+			} else {
+				switch r.read(2) {
+				case 0:
+					return
+				case 1:
+					fn(0, 2)
+				case 2:
+					var n = int(r.read(4)) + 12
+					fn(uint8(r.read(2)), n)
+				case 3:
+					var n = int(r.read(8)) + 29
+					fn(uint8(r.read(2)), n)
+				}
+			}
+		case 4:
+			if r.read(1) == 0 {
+				var n = int(r.read(3))
+				if n == 0 {
+					return
+				}
+				fn(0, n+2)
+			} else if r.read(1) == 0 {
+				var n = int(r.read(2)) + 4
+				fn(uint8(r.read(4)), n)
+			} else {
+				switch r.read(2) {
+				case 0:
+					fn(0, 1)
+				case 1:
+					fn(0, 2)
+				case 2:
+					var n = int(r.read(4)) + 9
+					fn(uint8(r.read(4)), n)
+				case 3:
+					var n = int(r.read(8)) + 25
+					fn(uint8(r.read(4)), n)
+				}
+			}
+		default:
+			if r.read(1) == 0 {
+				var n = int(r.read(7))
+				if n == 0 {
+					return
+				}
+				fn(0, n)
+			} else {
+				var n = int(r.read(7))
+				fn(uint8(r.read(8)), n)
+			}
+		}
+	}
+}
+
+// addDisplaySet renders the page and adds an item to the subtitles
+// The previous item ends when the new display set starts, unless its page time-out expired earlier.
+func (p *dvbPage) addDisplaySet(s *Subtitles, t time.Duration) {
+	// End previous item
+	if p.item != nil && (p.item.EndAt > t || p.item.EndAt == p.item.StartAt) {
+		p.item.EndAt = t
+	}
+	p.item = nil
+
+	// Render page
+	var i = p.render()
+	if i == nil {
+		return
+	}
+
+	// Add item
+	p.item = &Item{EndAt: t + p.timeout, Image: i, StartAt: t}
+	s.Items = append(s.Items, p.item)
+}
+
+// render renders the regions of the page into an image, and returns nil if it is fully transparent
+func (p *dvbPage) render() *Image {
+	// Get bounds
+	var bounds image.Rectangle
+	for _, pr := range p.pageRegions {
+		if r, ok := p.regions[pr.id]; ok {
+			bounds = bounds.Union(image.Rect(pr.x, pr.y, pr.x+r.width, pr.y+r.height))
+		}
+	}
+	if bounds.Empty() {
+		return nil
+	}
+
+	// Draw regions
+	var img = image.NewNRGBA(bounds)
+	var visible bool
+	for _, pr := range p.pageRegions {
+		// Get region
+		var r, ok = p.regions[pr.id]
+		if !ok {
+			continue
+		}
+
+		// Get CLUT
+		var c *dvbCLUT
+		if c, ok = p.cluts[r.clutID]; !ok {
+			c = newDVBCLUT()
+		}
+
+		// Draw pixels
+		for y := 0; y < r.height; y++ {
+			for x := 0; x < r.width; x++ {
+				if rgba := c.color(r.depth, r.pixels[y*r.width+x]); rgba.A > 0 {
+					img.SetNRGBA(pr.x+x, pr.y+y, rgba)
+					visible = true
+				}
+			}
+		}
+	}
+	if !visible {
+		return nil
+	}
+
+	// Move image to the origin
+	var o = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	copy(o.Pix, img.Pix)
+	return &Image{
+		FrameHeight: p.displayHeight,
+		FrameWidth:  p.displayWidth,
+		Image:       o,
+		Left:        bounds.Min.X,
+		Top:         bounds.Min.Y,
+	}
+}
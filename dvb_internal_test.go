@@ -0,0 +1,35 @@
+package astisub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDVBDecodePixelString(t *testing.T) {
+	// Init
+	var decode = func(b []byte, bits int) (o []uint8, n int) {
+		var r = &dvbBitReader{b: b}
+		dvbDecodePixelString(r, bits, func(code uint8, n int) {
+			for i := 0; i < n; i++ {
+				o = append(o, code)
+			}
+		})
+		return o, r.bytes()
+	}
+
+	// 2-bit: 3, 2 x 5, 0, 0 x 2, 1 x 14, end
+	o, n := decode([]byte{0xca, 0x84, 0x10, 0x89, 0x00}, 2)
+	assert.Equal(t, []uint8{3, 2, 2, 2, 2, 2, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, o)
+	assert.Equal(t, 5, n)
+
+	// 4-bit: 5, 0 x 4, 7 x 4, end
+	o, n = decode([]byte{0x50, 0x20, 0x87, 0x00}, 4)
+	assert.Equal(t, []uint8{5, 0, 0, 0, 0, 7, 7, 7, 7}, o)
+	assert.Equal(t, 4, n)
+
+	// 8-bit: 200, 0 x 3, 9 x 4, end
+	o, n = decode([]byte{0xc8, 0x00, 0x03, 0x00, 0x84, 0x09, 0x00, 0x00}, 8)
+	assert.Equal(t, []uint8{200, 0, 0, 0, 9, 9, 9, 9}, o)
+	assert.Equal(t, 8, n)
+}
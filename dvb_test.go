@@ -0,0 +1,142 @@
+package astisub_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// tsTestPackets splits a payload into TS packets padded with 0xff
+func tsTestPackets(pid uint16, payload []byte) (o []byte) {
+	for idx := 0; idx == 0 || len(payload) > 0; idx++ {
+		var p = []byte{0x47, byte(pid >> 8 & 0x1f), byte(pid), 0x10}
+		if idx == 0 {
+			p[1] |= 0x40
+		}
+		var n = 184
+		if len(payload) < n {
+			n = len(payload)
+		}
+		p = append(p, payload[:n]...)
+		payload = payload[n:]
+		o = append(o, append(p, bytes.Repeat([]byte{0xff}, 188-len(p))...)...)
+	}
+	return
+}
+
+// tsTestSection builds a PSI section with a dummy CRC
+func tsTestSection(tableID uint8, data []byte) []byte {
+	var b = []byte{0x0, tableID, 0xb0, 0x0, 0x0, 0x1, 0xc1, 0x0, 0x0}
+	b = append(b, data...)
+	binary.BigEndian.PutUint16(b[2:], uint16(0xb000|len(b)))
+	return append(b, 0x0, 0x0, 0x0, 0x0)
+}
+
+// tsTestPATAndPMT builds the PAT and a PMT whose PID is 0x100
+func tsTestPATAndPMT(streams ...[]byte) []byte {
+	var pmt = []byte{0xe0, 0x0, 0xf0, 0x0}
+	for _, s := range streams {
+		pmt = append(pmt, s...)
+	}
+	return append(tsTestPackets(0, tsTestSection(0x0, []byte{0x0, 0x1, 0xe1, 0x0})), tsTestPackets(0x100, tsTestSection(0x2, pmt))...)
+}
+
+// tsTestStream builds a PMT elementary stream entry
+func tsTestStream(streamType uint8, pid uint16, descriptors ...byte) []byte {
+	var b = []byte{streamType, byte(0xe0 | pid>>8), byte(pid), 0xf0, byte(len(descriptors))}
+	return append(b, descriptors...)
+}
+
+// tsTestPES builds a PES holding a PTS
+func tsTestPES(streamID uint8, pts int64, data []byte) []byte {
+	var b = []byte{0x0, 0x0, 0x1, streamID, 0x0, 0x0, 0x80, 0x80, 0x5,
+		byte(0x21 | pts>>29&0xe), byte(pts >> 22), byte(pts>>14 | 0x1), byte(pts >> 7), byte(pts<<1 | 0x1)}
+	b = append(b, data...)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)-6))
+	return b
+}
+
+// dvbTestSegment builds a DVB subtitling segment
+func dvbTestSegment(t uint8, page uint16, data ...byte) []byte {
+	var b = []byte{0x0f, t, byte(page >> 8), byte(page), byte(len(data) >> 8), byte(len(data))}
+	return append(b, data...)
+}
+
+func TestReadFromDVB(t *testing.T) {
+	// Init
+	var b = tsTestPATAndPMT(
+		tsTestStream(0x1b, 0x101),
+		tsTestStream(0x06, 0x102, 0x59, 0x8, 'f', 'r', 'e', 0x10, 0x0, 0x2, 0x0, 0x2),
+	)
+	var n = len(b)
+	b = append(b, tsTestPackets(0x101, tsTestPES(0xe0, 90000, []byte{0x0, 0x0, 0x1}))...)
+	var ds = []byte{0x20, 0x0}
+	ds = append(ds, dvbTestSegment(0x10, 2, 0x5, 0x4, 0x0, 0x0, 0x0, 0x64, 0x1, 0xf4)...)
+	ds = append(ds, dvbTestSegment(0x11, 2, 0x0, 0x8, 0x0, 0x4, 0x0, 0x2, 0x8, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0)...)
+	ds = append(ds, dvbTestSegment(0x12, 2, 0x0, 0x0, 0x1, 0x41, 0xff, 0x80, 0x80, 0x0)...)
+	ds = append(ds, dvbTestSegment(0x13, 2, 0x0, 0x1, 0x0, 0x0, 0x5, 0x0, 0x0, 0x11, 0x11, 0x0d, 0x00, 0xf0)...)
+	ds = append(ds, dvbTestSegment(0x80, 2)...)
+	ds = append(ds, 0xff)
+	b = append(b, tsTestPackets(0x102, tsTestPES(0xbd, 180000, ds))...)
+	b = append(b, tsTestPackets(0x102, tsTestPES(0xbd, 360000, append([]byte{0x20, 0x0}, dvbTestSegment(0x10, 2, 0x5, 0x0)...)))...)
+
+	// Read
+	s, err := astisub.ReadFromDVB(bytes.NewReader(b), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageFrench, s.Metadata.Language)
+	assert.Len(t, s.Items, 1)
+	assert.Equal(t, time.Second, s.Items[0].StartAt)
+	assert.Equal(t, 3*time.Second, s.Items[0].EndAt)
+	i := s.Items[0].Image
+	assert.Equal(t, 720, i.FrameWidth)
+	assert.Equal(t, 576, i.FrameHeight)
+	assert.Equal(t, 100, i.Left)
+	assert.Equal(t, 500, i.Top)
+	assert.Equal(t, 4, i.Image.Bounds().Dx())
+	assert.Equal(t, 2, i.Image.Bounds().Dy())
+	for y := 0; y < 2; y++ {
+		assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.NRGBAModel.Convert(i.Image.At(1, y)))
+		assert.Equal(t, uint8(0), color.NRGBAModel.Convert(i.Image.At(2, y)).(color.NRGBA).A)
+	}
+
+	// Page time-out
+	b = b[:len(b)-188]
+	s, err = astisub.ReadFromDVB(bytes.NewReader(b), 0x102, 2)
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 1)
+	assert.Equal(t, 6*time.Second, s.Items[0].EndAt)
+
+	// Unknown page
+	s, err = astisub.ReadFromDVB(bytes.NewReader(b), 0x102, 3)
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 0)
+
+	// Unmapped language
+	s, err = astisub.ReadFromDVB(bytes.NewReader(bytes.Replace(b, []byte("fre"), []byte("ger"), 1)), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "ger", s.Metadata.Language)
+
+	// Page on the second subtitle stream
+	s, err = astisub.ReadFromDVB(bytes.NewReader(append(tsTestPATAndPMT(
+		tsTestStream(0x1b, 0x101),
+		tsTestStream(0x06, 0x103, 0x59, 0x8, 'e', 'n', 'g', 0x10, 0x0, 0x5, 0x0, 0x5),
+		tsTestStream(0x06, 0x102, 0x59, 0x8, 'f', 'r', 'e', 0x10, 0x0, 0x2, 0x0, 0x2),
+	), b[n:]...)), 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageFrench, s.Metadata.Language)
+	assert.Len(t, s.Items, 1)
+
+	// PNG
+	w := &bytes.Buffer{}
+	err = i.WriteToPNG(w)
+	assert.NoError(t, err)
+	p, err := png.Decode(w)
+	assert.NoError(t, err)
+	assert.Equal(t, i.Image.Bounds(), p.Bounds())
+}
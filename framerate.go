@@ -0,0 +1,184 @@
+package astisub
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://en.wikipedia.org/wiki/SMPTE_timecode
+
+// Framerates
+var (
+	Framerate23976         = Framerate{Denominator: 1001, Numerator: 24000}
+	Framerate24            = Framerate{Denominator: 1, Numerator: 24}
+	Framerate25            = Framerate{Denominator: 1, Numerator: 25}
+	Framerate2997          = Framerate{Denominator: 1001, Numerator: 30000}
+	Framerate2997DropFrame = Framerate{Denominator: 1001, DropFrame: true, Numerator: 30000}
+	Framerate30            = Framerate{Denominator: 1, Numerator: 30}
+	Framerate50            = Framerate{Denominator: 1, Numerator: 50}
+	Framerate5994          = Framerate{Denominator: 1001, Numerator: 60000}
+	Framerate5994DropFrame = Framerate{Denominator: 1001, DropFrame: true, Numerator: 60000}
+	Framerate60            = Framerate{Denominator: 1, Numerator: 60}
+)
+
+// Vars
+var bigIntNanosecondsPerSecond = big.NewInt(int64(time.Second))
+
+// Framerate represents a framerate as a rational number of frames per second
+// DropFrame indicates whether SMPTE timecodes using this framerate are drop-frame timecodes
+type Framerate struct {
+	Denominator int
+	DropFrame   bool
+	Numerator   int
+}
+
+// NewFramerate creates a new integer framerate
+func NewFramerate(i int) Framerate {
+	return Framerate{Denominator: 1, Numerator: i}
+}
+
+// IsZero returns whether the framerate is undefined
+func (f Framerate) IsZero() bool {
+	return f.Numerator <= 0 || f.Denominator <= 0
+}
+
+// Float64 returns the framerate as a float64
+func (f Framerate) Float64() float64 {
+	if f.IsZero() {
+		return 0
+	}
+	return float64(f.Numerator) / float64(f.Denominator)
+}
+
+// String implements the Stringer interface
+func (f Framerate) String() (o string) {
+	o = strconv.FormatFloat(math.Floor(f.Float64()*1000+0.5)/1000, 'f', -1, 64)
+	if f.DropFrame {
+		o += " DF"
+	}
+	return
+}
+
+// Duration returns the exact duration of a number of frames
+func (f Framerate) Duration(frames int) time.Duration {
+	if f.IsZero() {
+		return 0
+	}
+	return time.Duration(divRound(new(big.Int).Mul(big.NewInt(int64(frames)), new(big.Int).Mul(big.NewInt(int64(f.Denominator)), bigIntNanosecondsPerSecond)), big.NewInt(int64(f.Numerator))))
+}
+
+// Frames returns the number of frames closest to a duration
+func (f Framerate) Frames(d time.Duration) int {
+	if f.IsZero() {
+		return 0
+	}
+	return int(divRound(new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(f.Numerator))), new(big.Int).Mul(big.NewInt(int64(f.Denominator)), bigIntNanosecondsPerSecond)))
+}
+
+// divRound divides a by b and rounds the result to the nearest integer
+func divRound(a, b *big.Int) int64 {
+	var q, m = new(big.Int).DivMod(a, b, new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(b) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q.Int64()
+}
+
+// ratDuration converts a number of seconds into a duration
+func ratDuration(r *big.Rat) time.Duration {
+	return time.Duration(divRound(new(big.Int).Mul(r.Num(), bigIntNanosecondsPerSecond), r.Denom()))
+}
+
+// nominal returns the integer framerate used to count frames in SMPTE timecodes
+func (f Framerate) nominal() int {
+	return int(math.Floor(f.Float64() + 0.5))
+}
+
+// dropFramesPerMinute returns the number of frame numbers dropped every minute except every tenth minute
+func (f Framerate) dropFramesPerMinute() int {
+	if !f.DropFrame {
+		return 0
+	}
+	return f.nominal() / 15
+}
+
+// timecode represents a SMPTE timecode
+type timecode struct {
+	frames, hours, minutes, seconds int
+}
+
+// parseTimecode parses a timecode in "hh:mm:ss:ff" or drop-frame "hh:mm:ss;ff" format
+func parseTimecode(i string) (t timecode, err error) {
+	// Split
+	var parts = strings.FieldsFunc(strings.TrimSpace(i), func(r rune) bool { return r == ':' || r == ';' || r == '.' || r == ',' })
+	if len(parts) != 4 {
+		err = fmt.Errorf("Invalid timecode %s", i)
+		return
+	}
+
+	// Parse
+	var values = []*int{&t.hours, &t.minutes, &t.seconds, &t.frames}
+	for idx, p := range parts {
+		if *values[idx], err = strconv.Atoi(p); err != nil {
+			err = errors.Wrapf(err, "atoi of %s failed", p)
+			return
+		}
+	}
+	return
+}
+
+// string formats the timecode, using ";" as frames separator for drop-frame timecodes
+func (t timecode) string(dropFrame bool) string {
+	var sep = ":"
+	if dropFrame {
+		sep = ";"
+	}
+	return fmt.Sprintf("%.2d:%.2d:%.2d%s%.2d", t.hours, t.minutes, t.seconds, sep, t.frames)
+}
+
+// frameCount returns the number of frames elapsed since 00:00:00:00 at a timecode
+func (f Framerate) frameCount(t timecode) int {
+	var minutes = 60*t.hours + t.minutes
+	return ((60*minutes+t.seconds)*f.nominal() + t.frames) - f.dropFramesPerMinute()*(minutes-minutes/10)
+}
+
+// timecode returns the timecode of a frame
+func (f Framerate) timecode(frames int) (t timecode) {
+	// Take dropped frame numbers into account
+	var n, d = f.nominal(), f.dropFramesPerMinute()
+	if n == 0 {
+		return
+	}
+	if d > 0 {
+		var framesPerMinute = 60*n - d
+		var framesPer10Minutes = 10*framesPerMinute + d
+		var tens, remainder = frames / framesPer10Minutes, frames % framesPer10Minutes
+		frames += 9 * d * tens
+		if remainder > d {
+			frames += d * ((remainder - d) / framesPerMinute)
+		}
+	}
+
+	// Split
+	t.frames = frames % n
+	t.seconds = (frames / n) % 60
+	t.minutes = (frames / n / 60) % 60
+	t.hours = frames / n / 3600
+	return
+}
+
+// timecodeToDuration converts a SMPTE timecode into a duration
+func (f Framerate) timecodeToDuration(t timecode) time.Duration {
+	return f.Duration(f.frameCount(t))
+}
+
+// durationToTimecode converts a duration into a SMPTE timecode
+func (f Framerate) durationToTimecode(d time.Duration) timecode {
+	return f.timecode(f.Frames(d))
+}
@@ -0,0 +1,48 @@
+package astisub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramerate(t *testing.T) {
+	// String
+	assert.Equal(t, "23.976", Framerate23976.String())
+	assert.Equal(t, "29.97 DF", Framerate2997DropFrame.String())
+	assert.Equal(t, "25", Framerate25.String())
+
+	// Frames <=> duration
+	assert.Equal(t, 1001*time.Second, Framerate23976.Duration(24000))
+	assert.Equal(t, 41708333*time.Nanosecond, Framerate23976.Duration(1))
+	assert.Equal(t, 24000, Framerate23976.Frames(1001*time.Second))
+	assert.Equal(t, 1, Framerate23976.Frames(41708333*time.Nanosecond))
+	assert.Equal(t, 1, Framerate30.Frames(33333333*time.Nanosecond))
+	assert.Equal(t, time.Duration(0), Framerate{}.Duration(10))
+
+	// Non drop-frame timecode
+	tc, err := parseTimecode("01:00:00:00")
+	assert.NoError(t, err)
+	assert.Equal(t, 3603600*time.Millisecond, Framerate2997.timecodeToDuration(tc))
+	assert.Equal(t, tc, Framerate2997.durationToTimecode(3603600*time.Millisecond))
+
+	// Drop-frame timecode
+	for _, v := range []struct {
+		f  Framerate
+		n  int
+		tc string
+	}{
+		{f: Framerate2997DropFrame, n: 1799, tc: "00:00:59;29"},
+		{f: Framerate2997DropFrame, n: 1800, tc: "00:01:00;02"},
+		{f: Framerate2997DropFrame, n: 17982, tc: "00:10:00;00"},
+		{f: Framerate2997DropFrame, n: 107892, tc: "01:00:00;00"},
+		{f: Framerate5994DropFrame, n: 3600, tc: "00:01:00;04"},
+	} {
+		tc, err = parseTimecode(v.tc)
+		assert.NoError(t, err)
+		assert.Equal(t, v.n, v.f.frameCount(tc))
+		assert.Equal(t, v.tc, v.f.timecode(v.n).string(true))
+	}
+	assert.Equal(t, 3599996400*time.Microsecond, Framerate2997DropFrame.timecodeToDuration(timecode{hours: 1}))
+}
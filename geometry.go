@@ -0,0 +1,63 @@
+package astisub
+
+import "math"
+
+// Geometry represents a normalized position within the video frame
+// The box is expressed in percentages of the frame width and height. DisplayAlign anchors the text
+// block vertically within the box and TextAlign aligns its lines horizontally.
+type Geometry struct {
+	DisplayAlign DisplayAlign
+	Height       float64
+	Left         float64
+	TextAlign    TextAlign
+	Top          float64
+	Width        float64
+}
+
+// newDefaultGeometry creates the geometry of a subtitle displayed at the bottom center of the frame
+func newDefaultGeometry() *Geometry {
+	return &Geometry{
+		DisplayAlign: DisplayAlignAfter,
+		Height:       100,
+		TextAlign:    TextAlignCenter,
+		Width:        100,
+	}
+}
+
+// Bottom returns the position of the bottom edge of the box
+func (g Geometry) Bottom() float64 {
+	return g.Top + g.Height
+}
+
+// Right returns the position of the right edge of the box
+func (g Geometry) Right() float64 {
+	return g.Left + g.Width
+}
+
+// anchor returns the vertical position the text block is anchored to
+func (g Geometry) anchor() float64 {
+	switch g.DisplayAlign {
+	case DisplayAlignAfter:
+		return g.Bottom()
+	case DisplayAlignCenter:
+		return g.Top + g.Height/2
+	default:
+		return g.Top
+	}
+}
+
+// geometry returns the geometry of the item, falling back on its region's
+func (i Item) geometry() *Geometry {
+	if i.Geometry != nil {
+		return i.Geometry
+	}
+	if i.Region != nil {
+		return i.Region.Geometry
+	}
+	return nil
+}
+
+// roundPercentage rounds a percentage to 2 decimals
+func roundPercentage(i float64) float64 {
+	return math.Floor(i*100+0.5) / 100
+}
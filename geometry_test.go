@@ -0,0 +1,71 @@
+package astisub_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeometry(t *testing.T) {
+	// TTML
+	s, err := astisub.ReadFromTTML(strings.NewReader(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling" tts:extent="1920px 1080px">
+	<head>
+		<layout>
+			<region xml:id="top" tts:origin="192px 0px" tts:extent="80% 20%" tts:textAlign="center"/>
+			<region xml:id="bottom" tts:origin="10% 70%" tts:extent="80% 20%" tts:displayAlign="after" tts:textAlign="left"/>
+			<region xml:id="unpositioned" tts:textAlign="left"/>
+		</layout>
+	</head>
+	<body>
+		<div>
+			<p begin="1s" end="2s" region="top">subtitle-1</p>
+			<p begin="3s" end="4s" region="bottom">subtitle-2</p>
+		</div>
+	</body>
+</tt>`))
+	assert.NoError(t, err)
+	assert.Equal(t, &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 20, Left: 10, TextAlign: astisub.TextAlignCenter, Width: 80}, s.Regions["top"].Geometry)
+	assert.Equal(t, &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 20, Left: 10, TextAlign: astisub.TextAlignLeft, Top: 70, Width: 80}, s.Regions["bottom"].Geometry)
+	assert.Nil(t, s.Regions["unpositioned"].Geometry)
+
+	// WebVTT
+	w := &bytes.Buffer{}
+	err = s.WriteToWebVTT(w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "00:00:01.000 --> 00:00:02.000 line:0 region:top size:80%\n")
+	assert.Contains(t, w.String(), "00:00:03.000 --> 00:00:04.000 align:left line:90%,end position:10%,line-left region:bottom size:80%\n")
+	s2, err := astisub.ReadFromWebVTT(strings.NewReader(`WEBVTT
+
+1
+00:00:01.000 --> 00:00:02.000 line:0 size:80%
+subtitle-1
+
+2
+00:00:03.000 --> 00:00:04.000 align:left line:90%,end position:10%,line-left size:80%
+subtitle-2
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 100, Left: 10, TextAlign: astisub.TextAlignCenter, Width: 80}, s2.Items[0].Geometry)
+	assert.Equal(t, &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 90, Left: 10, TextAlign: astisub.TextAlignLeft, Width: 80}, s2.Items[1].Geometry)
+
+	// STL
+	w.Reset()
+	err = s.WriteToSTL(w)
+	assert.NoError(t, err)
+	s3, err := astisub.ReadFromSTL(bytes.NewReader(w.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, s3.Items[0].Geometry.Top)
+	assert.Equal(t, astisub.TextAlignCenter, s3.Items[0].Geometry.TextAlign)
+	assert.InDelta(t, 82.61, s3.Items[1].Geometry.Top, 0.01)
+	assert.Equal(t, astisub.TextAlignLeft, s3.Items[1].Geometry.TextAlign)
+
+	// TTML regions are generated for items without region
+	w.Reset()
+	err = s2.WriteToTTML(w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `<region xml:id="geometry_1" tts:extent="80% 100%" tts:origin="10% 0%" tts:textAlign="center"></region>`)
+	assert.Contains(t, w.String(), `<p begin="00:00:01.000" end="00:00:02.000" region="geometry_1">`)
+}
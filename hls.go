@@ -0,0 +1,240 @@
+package astisub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://tools.ietf.org/html/rfc8216
+
+// HLS timestamps are expressed in a 90kHz clock
+const hlsClockRate = 90000
+
+// OpenHLS opens a .m3u8 subtitles media playlist and reads its .vtt segments into a single timeline
+// Each segment's X-TIMESTAMP-MAP offset is applied so that times are expressed in the MPEG-TS timeline, MPEG-TS
+// timestamps being unwrapped against the previous segment's when they roll over, and cues duplicated or fragmented
+// across segment boundaries are merged back.
+func OpenHLS(src string) (s *Subtitles, err error) {
+	// Open the file
+	var f *os.File
+	if f, err = os.Open(src); err != nil {
+		err = errors.Wrapf(err, "opening %s failed", src)
+		return
+	}
+	defer f.Close()
+
+	// Read
+	return readHLS(f, filepath.Dir(src))
+}
+
+// readHLS reads a .m3u8 subtitles media playlist whose relative segment URIs are resolved against dir
+func readHLS(i io.Reader, dir string) (o *Subtitles, err error) {
+	// Init
+	o = NewSubtitles()
+	var scanner = bufio.NewScanner(i)
+
+	// Validate the header
+	if !scanner.Scan() || strings.TrimSpace(strings.TrimPrefix(scanner.Text(), string(BytesBOM))) != "#EXTM3U" {
+		err = errors.New("Invalid playlist header")
+		return
+	}
+
+	// Loop through lines
+	var mpegts = int64(tsPTSUndefined)
+	for scanner.Scan() {
+		// Only URIs are processed
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Read segment
+		var s *Subtitles
+		if s, err = readHLSSegment(line, dir); err != nil {
+			err = errors.Wrapf(err, "reading segment %s failed", line)
+			return
+		}
+
+		// Apply offset
+		if s.Metadata != nil && s.Metadata.WebVTTTimestampMap != nil {
+			var m = *s.Metadata.WebVTTTimestampMap
+			m.MPEGTS = tsUnwrapPTS(m.MPEGTS, mpegts)
+			mpegts = m.MPEGTS
+			s.Add(m.Offset())
+		}
+
+		// Merge
+		o.Merge(s)
+	}
+
+	// Remove cues duplicated across segments
+	o.removeDuplicates()
+
+	// Unfragment
+	o.Unfragment()
+	return
+}
+
+// readHLSSegment reads a .vtt segment
+func readHLSSegment(uri, dir string) (s *Subtitles, err error) {
+	// Only local segments are supported
+	if strings.Contains(uri, "://") {
+		err = fmt.Errorf("Segment %s is not local", uri)
+		return
+	}
+
+	// Open the file
+	var p = uri
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		err = errors.Wrapf(err, "opening %s failed", p)
+		return
+	}
+	defer f.Close()
+
+	// Read
+	if s, err = ReadFromWebVTT(f); err != nil {
+		err = errors.Wrapf(err, "reading %s failed", p)
+		return
+	}
+	return
+}
+
+// WebVTTSegment represents a .vtt HLS segment
+type WebVTTSegment struct {
+	Duration  time.Duration
+	StartAt   time.Duration
+	Subtitles *Subtitles
+}
+
+// WebVTTSegments represents the .vtt segments of an HLS subtitles rendition
+type WebVTTSegments struct {
+	MPEGTSOffset   int64
+	Segments       []*WebVTTSegment
+	TargetDuration time.Duration
+}
+
+// SegmentWebVTT splits subtitles into .vtt HLS segments of a target duration
+// Cues spanning segment boundaries are fragmented so that each segment they overlap contains them.
+// The MPEG-TS offset, expressed in 90kHz units, is the MPEG-TS time matching the subtitles start.
+func (s Subtitles) SegmentWebVTT(targetDuration time.Duration, mpegtsOffset int64) (o *WebVTTSegments, err error) {
+	// Validate target duration
+	if targetDuration <= 0 {
+		err = fmt.Errorf("Invalid target duration %s", targetDuration)
+		return
+	}
+
+	// Create segments
+	o = &WebVTTSegments{MPEGTSOffset: mpegtsOffset, TargetDuration: targetDuration}
+	var d = s.ordered().Duration()
+	for idx, ss := range s.segment(targetDuration) {
+		var g = &WebVTTSegment{
+			Duration:  targetDuration,
+			StartAt:   time.Duration(idx) * targetDuration,
+			Subtitles: ss,
+		}
+		if g.StartAt+targetDuration > d {
+			g.Duration = d - g.StartAt
+		}
+		o.Segments = append(o.Segments, g)
+	}
+	return
+}
+
+// timestampMap returns the X-TIMESTAMP-MAP of the segments
+func (s WebVTTSegments) timestampMap() *WebVTTTimestampMap {
+	return &WebVTTTimestampMap{MPEGTS: s.MPEGTSOffset}
+}
+
+// Write writes the segments in the directory of the playlist dst and the playlist itself
+// Segments are named after the playlist: "playlist.m3u8" leads to "playlist_0.vtt", "playlist_1.vtt", etc.
+func (s WebVTTSegments) Write(dst string) (err error) {
+	// Get segment pattern
+	var pattern = strings.TrimSuffix(filepath.Base(dst), filepath.Ext(dst)) + "_%d.vtt"
+
+	// Loop through segments
+	for idx, g := range s.Segments {
+		// Create the file
+		var p = filepath.Join(filepath.Dir(dst), fmt.Sprintf(pattern, idx))
+		var f *os.File
+		if f, err = os.Create(p); err != nil {
+			err = errors.Wrapf(err, "creating %s failed", p)
+			return
+		}
+
+		// Write segment
+		err = g.Subtitles.writeWebVTT(f, WebVTTOptions{TimestampMap: s.timestampMap()})
+		f.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "writing segment %s failed", p)
+			return
+		}
+	}
+
+	// Create the playlist
+	var f *os.File
+	if f, err = os.Create(dst); err != nil {
+		err = errors.Wrapf(err, "creating %s failed", dst)
+		return
+	}
+	defer f.Close()
+
+	// Write the playlist
+	if err = s.WriteToM3U8(f, pattern); err != nil {
+		err = errors.Wrapf(err, "writing playlist %s failed", dst)
+		return
+	}
+	return
+}
+
+// WriteSegment writes the segment at index idx in .vtt format
+func (s WebVTTSegments) WriteSegment(o io.Writer, idx int) (err error) {
+	// Validate index
+	if idx < 0 || idx >= len(s.Segments) {
+		err = fmt.Errorf("Invalid segment index %d", idx)
+		return
+	}
+
+	// Write segment
+	return s.Segments[idx].Subtitles.writeWebVTT(o, WebVTTOptions{TimestampMap: s.timestampMap()})
+}
+
+// WriteToM3U8 writes the subtitles media playlist in .m3u8 format
+// Segment URIs are built by formatting the pattern with the segment index, e.g. "segment_%d.vtt".
+func (s WebVTTSegments) WriteToM3U8(o io.Writer, pattern string) (err error) {
+	// Add header
+	var c []byte
+	c = append(c, []byte("#EXTM3U\n")...)
+	c = append(c, []byte("#EXT-X-VERSION:3\n")...)
+	c = append(c, []byte(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(s.TargetDuration.Seconds()))))...)
+	c = append(c, []byte("#EXT-X-MEDIA-SEQUENCE:0\n")...)
+	c = append(c, []byte("#EXT-X-PLAYLIST-TYPE:VOD\n")...)
+
+	// Add segments
+	for idx, g := range s.Segments {
+		c = append(c, []byte(fmt.Sprintf("#EXTINF:%.3f,\n", g.Duration.Seconds()))...)
+		c = append(c, []byte(fmt.Sprintf(pattern, idx))...)
+		c = append(c, bytesLineSeparator...)
+	}
+
+	// Add footer
+	c = append(c, []byte("#EXT-X-ENDLIST\n")...)
+
+	// Write
+	if _, err = o.Write(c); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
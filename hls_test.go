@@ -0,0 +1,131 @@
+package astisub_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentWebVTT(t *testing.T) {
+	// Init
+	s := &astisub.Subtitles{Items: []*astisub.Item{
+		{EndAt: 2 * time.Second, Lines: []astisub.Line{{{Text: "1"}}}, StartAt: time.Second},
+		{EndAt: 9 * time.Second, Lines: []astisub.Line{{{Text: "2"}}}, StartAt: 5 * time.Second},
+		{EndAt: 10 * time.Second, Lines: []astisub.Line{{{Text: "3"}}}, StartAt: 9 * time.Second},
+	}}
+
+	// Invalid target duration
+	_, err := s.SegmentWebVTT(0, 0)
+	assert.Error(t, err)
+
+	// Segment
+	g, err := s.SegmentWebVTT(4*time.Second, 900000)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(g.Segments))
+	assert.Equal(t, []time.Duration{4 * time.Second, 4 * time.Second, 2 * time.Second}, []time.Duration{g.Segments[0].Duration, g.Segments[1].Duration, g.Segments[2].Duration})
+	assert.Equal(t, []time.Duration{0, 4 * time.Second, 8 * time.Second}, []time.Duration{g.Segments[0].StartAt, g.Segments[1].StartAt, g.Segments[2].StartAt})
+	assert.Equal(t, 1, len(g.Segments[1].Subtitles.Items))
+	assert.Equal(t, 2, len(g.Segments[2].Subtitles.Items))
+	assert.Equal(t, 3, len(s.Items))
+	assert.Equal(t, 5*time.Second, s.Items[1].StartAt)
+	assert.Equal(t, 9*time.Second, s.Items[1].EndAt)
+
+	// Write segment
+	w := &bytes.Buffer{}
+	err = g.WriteSegment(w, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n1\n00:00:08.000 --> 00:00:09.000\n2\n\n2\n00:00:09.000 --> 00:00:10.000\n3\n", w.String())
+	err = g.WriteSegment(w, 3)
+	assert.Error(t, err)
+
+	// Write playlist
+	w.Reset()
+	err = g.WriteToM3U8(w, "segment_%d.vtt")
+	assert.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:4.000,\nsegment_0.vtt\n#EXTINF:4.000,\nsegment_1.vtt\n#EXTINF:2.000,\nsegment_2.vtt\n#EXT-X-ENDLIST\n", w.String())
+
+	// Write files
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = g.Write(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	c, err := ioutil.ReadFile(filepath.Join(dir, "playlist_1.vtt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(c), "X-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000")
+	c, err = ioutil.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(c), "playlist_2.vtt")
+
+	// Read files
+	r, err := astisub.OpenHLS(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(r.Items))
+	for idx, i := range s.Items {
+		assert.Equal(t, i.StartAt+10*time.Second, r.Items[idx].StartAt)
+		assert.Equal(t, i.EndAt+10*time.Second, r.Items[idx].EndAt)
+		assert.Equal(t, i.String(), r.Items[idx].String())
+	}
+	r, err = astisub.OpenFile(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(r.Items))
+
+	// Unordered items
+	s = &astisub.Subtitles{Items: []*astisub.Item{s.Items[2], s.Items[0], s.Items[1]}}
+	g, err = s.SegmentWebVTT(4*time.Second, 900000)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(g.Segments))
+	assert.Equal(t, 2*time.Second, g.Segments[2].Duration)
+	assert.Equal(t, 2, len(g.Segments[2].Subtitles.Items))
+	assert.Equal(t, "3", s.Items[0].String())
+}
+
+func TestOpenHLS(t *testing.T) {
+	// Init
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for n, c := range map[string]string{
+		"playlist.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000,\n0.vtt\n#EXTINF:4.000,\n1.vtt\n#EXT-X-ENDLIST\n",
+		"0.vtt":         "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:180000,LOCAL:00:00:01.000\n\n00:00:03.000 --> 00:00:07.000\nHello\n",
+		"1.vtt":         "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:540000,LOCAL:00:00:05.000\n\n00:00:03.000 --> 00:00:07.000\nHello\n\n00:00:07.000 --> 00:00:08.000\nWorld\n",
+		"invalid.m3u8":  "#EXT-X-TARGETDURATION:4\n",
+		"missing.m3u8":  "#EXTM3U\n#EXTINF:4.000,\n2.vtt\n",
+		"rollover.m3u8": "#EXTM3U\n#EXTINF:4.000,\n3.vtt\n#EXTINF:4.000,\n4.vtt\n",
+		"3.vtt":         "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:8589754592,LOCAL:00:00:00.000\n\n00:00:00.000 --> 00:00:01.000\nBefore\n",
+		"4.vtt":         "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:180000,LOCAL:00:00:00.000\n\n00:00:00.000 --> 00:00:01.000\nAfter\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, n), []byte(c), 0644))
+	}
+
+	// Read
+	s, err := astisub.OpenHLS(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(s.Items))
+	assert.Equal(t, 4*time.Second, s.Items[0].StartAt)
+	assert.Equal(t, 8*time.Second, s.Items[0].EndAt)
+	assert.Equal(t, "Hello", s.Items[0].String())
+	assert.Equal(t, 8*time.Second, s.Items[1].StartAt)
+	assert.Equal(t, 9*time.Second, s.Items[1].EndAt)
+
+	// MPEG-TS timestamps rolling over
+	s, err = astisub.OpenHLS(filepath.Join(dir, "rollover.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(s.Items))
+	assert.Equal(t, "Before", s.Items[0].String())
+	assert.Equal(t, time.Duration(8589754592)*time.Second/90000, s.Items[0].StartAt)
+	assert.Equal(t, "After", s.Items[1].String())
+	assert.Equal(t, 4*time.Second, s.Items[1].StartAt-s.Items[0].StartAt)
+
+	// Errors
+	_, err = astisub.OpenHLS(filepath.Join(dir, "invalid.m3u8"))
+	assert.Error(t, err)
+	_, err = astisub.OpenHLS(filepath.Join(dir, "missing.m3u8"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package astisub
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Image represents a bitmap subtitle positioned within the video frame
+// Left and Top are expressed in pixels of a frame of FrameWidth x FrameHeight. Items holding an image are handled
+// like text items when syncing, fragmenting, merging and ordering, but can only be written in bitmap formats.
+type Image struct {
+	FrameHeight int
+	FrameWidth  int
+	Image       image.Image
+	Left        int
+	Top         int
+}
+
+// WriteToPNG writes the image in .png format
+func (i Image) WriteToPNG(o io.Writer) (err error) {
+	if err = png.Encode(o, i.Image); err != nil {
+		err = errors.Wrap(err, "encoding png failed")
+		return
+	}
+	return
+}
+
+// WriteToPNGSequence writes the images of items as a numbered .png sequence next to the index dst, and the index
+// Images are named after the index: "index.txt" leads to "index_0001.png", "index_0002.png", etc. Each line of the
+// index holds, separated by tabs, the image name, its start and end times, and its position within the frame.
+func (s Subtitles) WriteToPNGSequence(dst string) (err error) {
+	// Loop through items
+	var prefix = strings.TrimSuffix(dst, filepath.Ext(dst))
+	var c = []byte("# name\tstart\tend\tleft\ttop\n")
+	for idx, i := range s.Items {
+		// Only images are supported
+		if i.Image == nil {
+			err = fmt.Errorf("Item %d has no image", idx)
+			return
+		}
+
+		// Create the file
+		var p = fmt.Sprintf("%s_%04d.png", prefix, idx+1)
+		var f *os.File
+		if f, err = os.Create(p); err != nil {
+			err = errors.Wrapf(err, "creating %s failed", p)
+			return
+		}
+
+		// Write image
+		err = i.Image.WriteToPNG(f)
+		f.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "writing %s failed", p)
+			return
+		}
+
+		// Add index line
+		c = append(c, []byte(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\n", filepath.Base(p), formatDurationWebVTT(i.StartAt), formatDurationWebVTT(i.EndAt), i.Image.Left, i.Image.Top))...)
+	}
+
+	// Write index
+	if err = writeFile(dst, c); err != nil {
+		err = errors.Wrap(err, "writing index failed")
+		return
+	}
+	return
+}
+
+// Rectangle returns the position of the image within the frame
+// It's empty when there's no image.
+func (i Image) Rectangle() image.Rectangle {
+	if i.Image == nil {
+		return image.Rectangle{}
+	}
+	var b = i.Image.Bounds()
+	return image.Rect(i.Left, i.Top, i.Left+b.Dx(), i.Top+b.Dy())
+}
+
+// equal returns whether images have the same position and pixels
+func (i *Image) equal(j *Image) bool {
+	// Check pointers
+	if i == j {
+		return true
+	} else if i == nil || j == nil {
+		return false
+	}
+
+	// Check position
+	if i.FrameHeight != j.FrameHeight || i.FrameWidth != j.FrameWidth || i.Rectangle() != j.Rectangle() {
+		return false
+	}
+
+	// Check pixels
+	if i.Image == nil || j.Image == nil {
+		return i.Image == nil && j.Image == nil
+	}
+	var bi, bj = i.Image.Bounds(), j.Image.Bounds()
+	for y := 0; y < bi.Dy(); y++ {
+		for x := 0; x < bi.Dx(); x++ {
+			if color.NRGBAModel.Convert(i.Image.At(bi.Min.X+x, bi.Min.Y+y)) != color.NRGBAModel.Convert(j.Image.At(bj.Min.X+x, bj.Min.Y+y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ImageNotSupportedError is returned when writing items holding an image in a format that only supports text
+type ImageNotSupportedError struct {
+	Format string
+	Item   int
+}
+
+// Error implements the error interface
+func (e ImageNotSupportedError) Error() string {
+	return fmt.Sprintf("Image of item %d is not supported by %s", e.Item, e.Format)
+}
+
+// textOnly returns an ImageNotSupportedError if an item holds an image
+func (s Subtitles) textOnly(format string) error {
+	for idx, i := range s.Items {
+		if i.Image != nil {
+			return ImageNotSupportedError{Format: format, Item: idx}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package astisub_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestImage creates a 2x1 image whose first pixel has a specific color
+func newTestImage(c color.Color, left, top int) *astisub.Image {
+	var i = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	i.Set(0, 0, c)
+	return &astisub.Image{FrameHeight: 576, FrameWidth: 720, Image: i, Left: left, Top: top}
+}
+
+func TestImage(t *testing.T) {
+	// Rectangle
+	i := newTestImage(color.White, 10, 20)
+	assert.Equal(t, image.Rect(10, 20, 12, 21), i.Rectangle())
+	assert.Equal(t, image.Rectangle{}, astisub.Image{Left: 10, Top: 20}.Rectangle())
+
+	// Fragment, add and unfragment
+	s := &astisub.Subtitles{Items: []*astisub.Item{
+		{EndAt: 3 * time.Second, Image: i, StartAt: time.Second},
+		{EndAt: 5 * time.Second, Image: newTestImage(color.White, 10, 20), StartAt: 3 * time.Second},
+		{EndAt: 6 * time.Second, Image: newTestImage(color.Black, 10, 20), StartAt: 5 * time.Second},
+	}}
+	s.Fragment(2 * time.Second)
+	assert.Len(t, s.Items, 5)
+	assert.Equal(t, i, s.Items[0].Image)
+	assert.Equal(t, i, s.Items[1].Image)
+	s.Add(time.Second)
+	s.Unfragment()
+	assert.Len(t, s.Items, 2)
+	assert.Equal(t, 2*time.Second, s.Items[0].StartAt)
+	assert.Equal(t, 6*time.Second, s.Items[0].EndAt)
+	assert.Equal(t, 6*time.Second, s.Items[1].StartAt)
+	assert.Equal(t, 7*time.Second, s.Items[1].EndAt)
+
+	// Merge and order
+	s.Merge(&astisub.Subtitles{Items: []*astisub.Item{{EndAt: time.Second, Image: newTestImage(color.White, 0, 0)}}})
+	assert.Len(t, s.Items, 3)
+	assert.Equal(t, 0, s.Items[0].Image.Left)
+
+	// Text writers
+	for _, fn := range []func(w *bytes.Buffer) error{
+		func(w *bytes.Buffer) error { return s.WriteToSRT(w) },
+		func(w *bytes.Buffer) error { return s.WriteToSTL(w) },
+		func(w *bytes.Buffer) error { return s.WriteToTTML(w) },
+		func(w *bytes.Buffer) error { return s.WriteToWebVTT(w) },
+		func(w *bytes.Buffer) error { return s.WriteToMP4(w) },
+		func(w *bytes.Buffer) error { return s.WriteToMatroska(w) },
+	} {
+		w := &bytes.Buffer{}
+		err := fn(w)
+		assert.IsType(t, astisub.ImageNotSupportedError{}, errors.Cause(err))
+		assert.Equal(t, 0, w.Len())
+	}
+}
+
+func TestWriteToPNGSequence(t *testing.T) {
+	// Init
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := &astisub.Subtitles{Items: []*astisub.Item{
+		{EndAt: 2 * time.Second, Image: newTestImage(color.White, 10, 20), StartAt: time.Second},
+		{EndAt: 4 * time.Second, Image: newTestImage(color.Black, 30, 40), StartAt: 3 * time.Second},
+	}}
+
+	// Write
+	err = s.WriteToPNGSequence(filepath.Join(dir, "index.txt"))
+	assert.NoError(t, err)
+	c, err := ioutil.ReadFile(filepath.Join(dir, "index.txt"))
+	assert.NoError(t, err)
+	ls := strings.Split(strings.TrimSpace(string(c)), "\n")
+	assert.Len(t, ls, 3)
+	assert.Equal(t, "index_0001.png\t00:00:01.000\t00:00:02.000\t10\t20", ls[1])
+	_, err = os.Stat(filepath.Join(dir, "index_0002.png"))
+	assert.NoError(t, err)
+
+	// Text items
+	err = astisub.Subtitles{Items: []*astisub.Item{{Lines: []astisub.Line{{{Text: "text"}}}}}}.WriteToPNGSequence(filepath.Join(dir, "text.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,1228 @@
+package astisub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astitools/map"
+	"github.com/pkg/errors"
+)
+
+// https://www.matroska.org/technical/specs/index.html
+// https://www.matroska.org/technical/specs/subtitles/index.html
+
+// Matroska codecs
+const (
+	MatroskaCodecASS    = "S_TEXT/ASS"
+	MatroskaCodecSSA    = "S_TEXT/SSA"
+	MatroskaCodecUTF8   = "S_TEXT/UTF8"
+	MatroskaCodecWebVTT = "S_TEXT/WEBVTT"
+)
+
+// EBML element IDs
+const (
+	ebmlIDCRC32              = 0xbf
+	ebmlIDDocType            = 0x4282
+	ebmlIDDocTypeReadVersion = 0x4285
+	ebmlIDDocTypeVersion     = 0x4287
+	ebmlIDEBML               = 0x1a45dfa3
+	ebmlIDEBMLMaxIDLength    = 0x42f2
+	ebmlIDEBMLMaxSizeLength  = 0x42f3
+	ebmlIDEBMLReadVersion    = 0x42f7
+	ebmlIDEBMLVersion        = 0x4286
+	ebmlIDVoid               = 0xec
+)
+
+// Matroska element IDs
+const (
+	matroskaIDBlock           = 0xa1
+	matroskaIDBlockAddID      = 0xee
+	matroskaIDBlockAdditional = 0xa5
+	matroskaIDBlockAdditions  = 0x75a1
+	matroskaIDBlockDuration   = 0x9b
+	matroskaIDBlockGroup      = 0xa0
+	matroskaIDBlockMore       = 0xa6
+	matroskaIDCluster         = 0x1f43b675
+	matroskaIDCodecID         = 0x86
+	matroskaIDCodecPrivate    = 0x63a2
+	matroskaIDCueClusterPos   = 0xf1
+	matroskaIDCuePoint        = 0xbb
+	matroskaIDCues            = 0x1c53bb6b
+	matroskaIDCueTime         = 0xb3
+	matroskaIDCueTrack        = 0xf7
+	matroskaIDCueTrackPos     = 0xb7
+	matroskaIDDuration        = 0x4489
+	matroskaIDFlagDefault     = 0x88
+	matroskaIDFlagForced      = 0x55aa
+	matroskaIDFlagLacing      = 0x9c
+	matroskaIDInfo            = 0x1549a966
+	matroskaIDLanguage        = 0x22b59c
+	matroskaIDMuxingApp       = 0x4d80
+	matroskaIDName            = 0x536e
+	matroskaIDSeek            = 0x4dbb
+	matroskaIDSeekHead        = 0x114d9b74
+	matroskaIDSeekID          = 0x53ab
+	matroskaIDSeekPosition    = 0x53ac
+	matroskaIDSegment         = 0x18538067
+	matroskaIDSimpleBlock     = 0xa3
+	matroskaIDTimecode        = 0xe7
+	matroskaIDTimecodeScale   = 0x2ad7b1
+	matroskaIDTrackEntry      = 0xae
+	matroskaIDTrackNumber     = 0xd7
+	matroskaIDTracks          = 0x1654ae6b
+	matroskaIDTrackType       = 0x83
+	matroskaIDTrackUID        = 0x73c5
+	matroskaIDWritingApp      = 0x5741
+)
+
+// Matroska defaults
+const (
+	matroskaDefaultLanguage      = "eng"
+	matroskaDefaultTimecodeScale = 1000000
+	matroskaMaxRelativeTimecode  = math.MaxInt16
+	matroskaTrackTypeSubtitle    = 0x11
+)
+
+// Matroska languages are ISO 639-2/B codes
+var matroskaLanguageMapping = astimap.NewMap("und", "").
+	Set("eng", LanguageEnglish).
+	Set("fre", LanguageFrench)
+
+// Regexps
+var matroskaASSOverrideRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+// MatroskaTrack represents a Matroska subtitles track
+type MatroskaTrack struct {
+	Codec    string
+	Default  bool
+	Forced   bool
+	Language string
+	Name     string
+	Number   int
+}
+
+// matroskaTrack represents a Matroska track being read
+type matroskaTrack struct {
+	MatroskaTrack
+	blocks       []matroskaBlock
+	codecPrivate []byte
+	trackType    uint64
+	uid          uint64
+}
+
+// matroskaBlock represents a Matroska block being read
+// Duration is negative when the block has no duration.
+type matroskaBlock struct {
+	additional []byte
+	data       []byte
+	duration   int64
+	timecode   int64
+}
+
+// matroska represents a Matroska content being read
+type matroska struct {
+	timecodeScale uint64
+	tracks        []*matroskaTrack
+}
+
+// ebmlElement represents an EBML element
+// Offset is the position of the element header in the parsed data.
+type ebmlElement struct {
+	data        []byte
+	id          uint32
+	offset      int
+	unknownSize bool
+}
+
+// readEBMLVint reads an EBML variable size integer
+// IDs keep their length marker whereas sizes don't. Sizes whose bits are all set are unknown.
+func readEBMLVint(b []byte, keepMarker bool) (v uint64, n int, unknown bool, err error) {
+	// Get length
+	if len(b) == 0 || b[0] == 0 {
+		err = errors.New("Invalid vint")
+		return
+	}
+	for n = 1; b[0]&(0x80>>uint(n-1)) == 0; n++ {
+	}
+	if len(b) < n {
+		err = fmt.Errorf("Vint of length %d is out of bounds", n)
+		return
+	}
+
+	// Get value
+	v = uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xff >> uint(n))
+	}
+	unknown = v == uint64(0xff>>uint(n))
+	for idx := 1; idx < n; idx++ {
+		v = v<<8 | uint64(b[idx])
+		unknown = unknown && b[idx] == 0xff
+	}
+	return
+}
+
+// readEBMLElements splits data into EBML elements
+// Elements of unknown size extend until the end of data.
+func readEBMLElements(b []byte) (o []ebmlElement, err error) {
+	var l = len(b)
+	for len(b) > 0 {
+		// Read id
+		var id uint64
+		var n int
+		var offset = l - len(b)
+		if id, n, _, err = readEBMLVint(b, true); err != nil {
+			err = errors.Wrap(err, "reading id failed")
+			return
+		}
+		b = b[n:]
+
+		// Read size
+		var size uint64
+		var unknown bool
+		if size, n, unknown, err = readEBMLVint(b, false); err != nil {
+			err = errors.Wrapf(err, "reading size of element %x failed", id)
+			return
+		}
+		b = b[n:]
+		if unknown {
+			size = uint64(len(b))
+		} else if size > uint64(len(b)) {
+			err = fmt.Errorf("Size %d of element %x is out of bounds", size, id)
+			return
+		}
+
+		// Add element
+		o = append(o, ebmlElement{data: b[:size], id: uint32(id), offset: offset, unknownSize: unknown})
+		b = b[size:]
+	}
+	return
+}
+
+// uint returns the element data as an unsigned integer
+func (e ebmlElement) uint() (o uint64) {
+	for _, c := range e.data {
+		o = o<<8 | uint64(c)
+	}
+	return
+}
+
+// string returns the element data as a string
+func (e ebmlElement) string() string {
+	return string(bytes.TrimRight(e.data, "\x00"))
+}
+
+// ReadMatroskaTracks lists the subtitles tracks of a .mkv/.webm content
+func ReadMatroskaTracks(i io.Reader) (o []MatroskaTrack, err error) {
+	// Read
+	var m *matroska
+	if m, err = readMatroska(i); err != nil {
+		err = errors.Wrap(err, "reading matroska failed")
+		return
+	}
+
+	// Loop through tracks
+	for _, t := range m.tracks {
+		if t.trackType == matroskaTrackTypeSubtitle {
+			o = append(o, t.MatroskaTrack)
+		}
+	}
+	return
+}
+
+// ReadFromMatroska parses a text subtitles track of a .mkv/.webm content
+// A zero track number selects the first supported subtitles track.
+func ReadFromMatroska(i io.Reader, trackNumber int) (o *Subtitles, err error) {
+	// Read
+	var m *matroska
+	if m, err = readMatroska(i); err != nil {
+		err = errors.Wrap(err, "reading matroska failed")
+		return
+	}
+
+	// Get track
+	var t *matroskaTrack
+	for _, v := range m.tracks {
+		if trackNumber > 0 && v.Number != trackNumber {
+			continue
+		}
+		if v.trackType == matroskaTrackTypeSubtitle && isMatroskaCodecSupported(v.Codec) {
+			t = v
+			break
+		} else if trackNumber > 0 {
+			err = fmt.Errorf("Track %d is not a supported subtitles track", trackNumber)
+			return
+		}
+	}
+	if t == nil {
+		if trackNumber > 0 {
+			err = fmt.Errorf("Track %d doesn't exist", trackNumber)
+		} else {
+			err = errors.New("No supported subtitles track found")
+		}
+		return
+	}
+
+	// Decode blocks
+	switch t.Codec {
+	case MatroskaCodecASS, MatroskaCodecSSA:
+		o = t.ass(m.timecodeScale)
+	case MatroskaCodecWebVTT:
+		if o, err = t.webvtt(m.timecodeScale); err != nil {
+			err = errors.Wrapf(err, "decoding blocks of track %d failed", t.Number)
+			return
+		}
+	default:
+		o = t.utf8(m.timecodeScale)
+	}
+
+	// Add metadata
+	if len(t.Language) > 0 && t.Language != "und" {
+		o.Metadata = &Metadata{Language: t.Language}
+		if matroskaLanguageMapping.InA(t.Language) {
+			o.Metadata.Language = matroskaLanguageMapping.B(t.Language).(string)
+		}
+	}
+	o.Order()
+	return
+}
+
+// isMatroskaCodecSupported checks whether blocks of a codec can be decoded
+func isMatroskaCodecSupported(codec string) bool {
+	switch codec {
+	case MatroskaCodecASS, MatroskaCodecSSA, MatroskaCodecUTF8, MatroskaCodecWebVTT:
+		return true
+	}
+	return false
+}
+
+// readMatroska reads the tracks and blocks of a .mkv/.webm content
+func readMatroska(i io.Reader) (m *matroska, err error) {
+	// Read all
+	var b []byte
+	if b, err = ioutil.ReadAll(i); err != nil {
+		err = errors.Wrap(err, "reading all failed")
+		return
+	}
+
+	// Read top level elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(b); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Get segment
+	var segment *ebmlElement
+	for idx := range es {
+		if es[idx].id == matroskaIDSegment {
+			segment = &es[idx]
+			break
+		}
+	}
+	if segment == nil {
+		err = errors.New("No segment found")
+		return
+	}
+
+	// Read segment elements
+	if es, err = readEBMLElements(segment.data); err != nil {
+		err = errors.Wrap(err, "reading segment elements failed")
+		return
+	}
+
+	// Loop through segment elements
+	m = &matroska{timecodeScale: matroskaDefaultTimecodeScale}
+	for _, e := range es {
+		switch e.id {
+		case matroskaIDCluster:
+			if err = m.readCluster(e); err != nil {
+				err = errors.Wrap(err, "reading cluster failed")
+				return
+			}
+		case matroskaIDInfo:
+			if err = m.readInfo(e); err != nil {
+				err = errors.Wrap(err, "reading info failed")
+				return
+			}
+		case matroskaIDTracks:
+			if err = m.readTracks(e); err != nil {
+				err = errors.Wrap(err, "reading tracks failed")
+				return
+			}
+		}
+	}
+	return
+}
+
+// readInfo reads the segment info
+func (m *matroska) readInfo(e ebmlElement) (err error) {
+	var es []ebmlElement
+	if es, err = readEBMLElements(e.data); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+	for _, c := range es {
+		if c.id == matroskaIDTimecodeScale && c.uint() > 0 {
+			m.timecodeScale = c.uint()
+		}
+	}
+	return
+}
+
+// readTracks reads the track entries
+func (m *matroska) readTracks(e ebmlElement) (err error) {
+	// Read elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(e.data); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Loop through track entries
+	for _, te := range es {
+		if te.id != matroskaIDTrackEntry {
+			continue
+		}
+		var cs []ebmlElement
+		if cs, err = readEBMLElements(te.data); err != nil {
+			err = errors.Wrap(err, "reading track entry elements failed")
+			return
+		}
+		var t = &matroskaTrack{MatroskaTrack: MatroskaTrack{Default: true, Language: matroskaDefaultLanguage}}
+		for _, c := range cs {
+			switch c.id {
+			case matroskaIDCodecID:
+				t.Codec = c.string()
+			case matroskaIDCodecPrivate:
+				t.codecPrivate = c.data
+			case matroskaIDFlagDefault:
+				t.Default = c.uint() > 0
+			case matroskaIDFlagForced:
+				t.Forced = c.uint() > 0
+			case matroskaIDLanguage:
+				t.Language = c.string()
+			case matroskaIDName:
+				t.Name = c.string()
+			case matroskaIDTrackNumber:
+				t.Number = int(c.uint())
+			case matroskaIDTrackType:
+				t.trackType = c.uint()
+			case matroskaIDTrackUID:
+				t.uid = c.uint()
+			}
+		}
+		m.tracks = append(m.tracks, t)
+	}
+	return
+}
+
+// track returns the track with a given number
+func (m *matroska) track(number uint64) *matroskaTrack {
+	for _, t := range m.tracks {
+		if uint64(t.Number) == number {
+			return t
+		}
+	}
+	return nil
+}
+
+// readCluster reads the blocks of a cluster
+// Clusters of unknown size contain the following clusters.
+func (m *matroska) readCluster(e ebmlElement) (err error) {
+	// Read elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(e.data); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Loop through elements
+	var timecode int64
+	for _, c := range es {
+		switch c.id {
+		case matroskaIDBlockGroup:
+			// Read elements
+			var gs []ebmlElement
+			if gs, err = readEBMLElements(c.data); err != nil {
+				err = errors.Wrap(err, "reading block group elements failed")
+				return
+			}
+
+			// Loop through elements
+			var b = matroskaBlock{duration: -1}
+			var data []byte
+			for _, g := range gs {
+				switch g.id {
+				case matroskaIDBlock:
+					data = g.data
+				case matroskaIDBlockAdditions:
+					if b.additional, err = readMatroskaBlockAdditional(g); err != nil {
+						err = errors.Wrap(err, "reading block additions failed")
+						return
+					}
+				case matroskaIDBlockDuration:
+					b.duration = int64(g.uint())
+				}
+			}
+
+			// Add block
+			if err = m.addBlock(data, timecode, b); err != nil {
+				err = errors.Wrap(err, "adding block failed")
+				return
+			}
+		case matroskaIDCluster:
+			if err = m.readCluster(c); err != nil {
+				err = errors.Wrap(err, "reading cluster failed")
+				return
+			}
+		case matroskaIDSimpleBlock:
+			if err = m.addBlock(c.data, timecode, matroskaBlock{duration: -1}); err != nil {
+				err = errors.Wrap(err, "adding simple block failed")
+				return
+			}
+		case matroskaIDTimecode:
+			timecode = int64(c.uint())
+		}
+	}
+	return
+}
+
+// readMatroskaBlockAdditional returns the first block additional of block additions
+func readMatroskaBlockAdditional(e ebmlElement) (o []byte, err error) {
+	var es []ebmlElement
+	if es, err = readEBMLElements(e.data); err != nil {
+		return
+	}
+	for _, c := range es {
+		if c.id != matroskaIDBlockMore {
+			continue
+		}
+		var ms []ebmlElement
+		if ms, err = readEBMLElements(c.data); err != nil {
+			return
+		}
+		for _, m := range ms {
+			if m.id == matroskaIDBlockAdditional {
+				return m.data, nil
+			}
+		}
+	}
+	return
+}
+
+// addBlock parses a block's header and adds it to its track
+func (m *matroska) addBlock(data []byte, clusterTimecode int64, b matroskaBlock) (err error) {
+	// Read track number
+	var number uint64
+	var n int
+	if number, n, _, err = readEBMLVint(data, false); err != nil {
+		err = errors.Wrap(err, "reading track number failed")
+		return
+	}
+
+	// Only subtitles tracks are processed
+	var t = m.track(number)
+	if t == nil || t.trackType != matroskaTrackTypeSubtitle {
+		return
+	}
+
+	// Read header
+	if len(data) < n+3 {
+		err = errors.New("Invalid block header")
+		return
+	}
+	if data[n+2]&0x6 > 0 {
+		err = errors.New("Laced blocks are not supported")
+		return
+	}
+
+	// Add block
+	b.data = data[n+3:]
+	b.timecode = clusterTimecode + int64(int16(binary.BigEndian.Uint16(data[n:])))
+	t.blocks = append(t.blocks, b)
+	return
+}
+
+// times returns the time boundaries of a block
+// Blocks without duration last until the next block.
+func (t *matroskaTrack) times(idx int, timecodeScale uint64) (startAt, endAt time.Duration) {
+	var b = t.blocks[idx]
+	startAt = time.Duration(b.timecode * int64(timecodeScale))
+	endAt = startAt
+	if b.duration >= 0 {
+		endAt = time.Duration((b.timecode + b.duration) * int64(timecodeScale))
+	} else if idx+1 < len(t.blocks) {
+		endAt = time.Duration(t.blocks[idx+1].timecode * int64(timecodeScale))
+	}
+	return
+}
+
+// utf8 decodes S_TEXT/UTF8 blocks
+func (t *matroskaTrack) utf8(timecodeScale uint64) (o *Subtitles) {
+	o = NewSubtitles()
+	for idx, b := range t.blocks {
+		// Get text
+		var text = strings.TrimSpace(strings.Replace(string(b.data), "\r\n", "\n", -1))
+		if len(text) == 0 {
+			continue
+		}
+
+		// Add item
+		var i = &Item{}
+		i.StartAt, i.EndAt = t.times(idx, timecodeScale)
+		for _, l := range strings.Split(text, "\n") {
+			i.Lines = append(i.Lines, Line{{Text: l}})
+		}
+		o.Items = append(o.Items, i)
+	}
+	return
+}
+
+// webvtt decodes S_TEXT/WEBVTT blocks
+// The codec private data is a .vtt header holding the regions cues may refer to. The first line of block
+// additional data holds cue settings.
+func (t *matroskaTrack) webvtt(timecodeScale uint64) (o *Subtitles, err error) {
+	// Read header
+	if o, err = ReadFromWebVTT(bytes.NewReader(t.codecPrivate)); err != nil {
+		err = errors.Wrap(err, "reading webvtt header failed")
+		return
+	}
+
+	// Loop through blocks
+	for idx, b := range t.blocks {
+		// Create item
+		var i = &Item{InlineStyle: &StyleAttributes{}}
+		i.StartAt, i.EndAt = t.times(idx, timecodeScale)
+
+		// Parse settings
+		var settings = strings.Fields(strings.SplitN(string(b.additional), "\n", 2)[0])
+		parseWebVTTCueSettings(i, settings, o.Regions)
+
+		// Add lines
+		if text := strings.TrimSpace(strings.Replace(string(b.data), "\r\n", "\n", -1)); len(text) > 0 {
+			for _, l := range strings.Split(text, "\n") {
+				i.Lines = append(i.Lines, Line{{Text: l}})
+			}
+		}
+		o.Items = append(o.Items, i)
+	}
+	return
+}
+
+// ass decodes S_TEXT/ASS and S_TEXT/SSA blocks
+// Blocks are "ReadOrder,Layer,Style,Name,MarginL,MarginR,MarginV,Effect,Text" dialogue events: only the text is kept,
+// override tags being removed.
+func (t *matroskaTrack) ass(timecodeScale uint64) (o *Subtitles) {
+	o = NewSubtitles()
+	for idx, b := range t.blocks {
+		// Get text
+		var fields = strings.SplitN(string(b.data), ",", 9)
+		var text = matroskaASSOverrideRegexp.ReplaceAllString(fields[len(fields)-1], "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(strings.TrimSpace(text))
+		if len(text) == 0 {
+			continue
+		}
+
+		// Add item
+		var i = &Item{}
+		i.StartAt, i.EndAt = t.times(idx, timecodeScale)
+		for _, l := range strings.Split(text, "\n") {
+			i.Lines = append(i.Lines, Line{{Text: l}})
+		}
+		o.Items = append(o.Items, i)
+	}
+	return
+}
+
+// matroskaASSHeader is the S_TEXT/ASS codec private data
+const matroskaASSHeader = `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,0,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// MatroskaOptions represents Matroska write options
+// Codec defaults to S_TEXT/UTF8.
+type MatroskaOptions struct {
+	Codec   string
+	Default bool
+	Forced  bool
+	Name    string
+}
+
+// ebmlWriteElement creates an EBML element
+func ebmlWriteElement(id uint32, payloads ...[]byte) []byte {
+	// Get id
+	var o = make([]byte, 4)
+	binary.BigEndian.PutUint32(o, id)
+	o = bytes.TrimLeft(o, "\x00")
+
+	// Add size
+	var size uint64
+	for _, p := range payloads {
+		size += uint64(len(p))
+	}
+	o = append(o, ebmlVint(size)...)
+
+	// Add payloads
+	for _, p := range payloads {
+		o = append(o, p...)
+	}
+	return o
+}
+
+// ebmlStripCRC32 returns the data of a master element without its CRC-32 element
+// Its checksum would be stale once children are added or rewritten.
+func ebmlStripCRC32(b []byte) (o []byte, err error) {
+	// Read elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(b); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Loop through elements
+	for _, e := range es {
+		if e.id != ebmlIDCRC32 {
+			o = append(o, ebmlWriteElement(e.id, e.data)...)
+		}
+	}
+	return
+}
+
+// ebmlVint serializes a variable size integer on as few bytes as possible
+// Values whose bits are all set being reserved, they use one more byte.
+func ebmlVint(i uint64) (o []byte) {
+	var n = 1
+	for ; n < 8 && i >= 1<<uint(7*n)-1; n++ {
+	}
+	for idx := n - 1; idx >= 0; idx-- {
+		o = append(o, byte(i>>uint(8*idx)))
+	}
+	o[0] |= 0x80 >> uint(n-1)
+	return
+}
+
+// ebmlUint serializes an unsigned integer on as few bytes as possible
+func ebmlUint(i uint64) (o []byte) {
+	for o = []byte{byte(i)}; i > 0xff; {
+		i >>= 8
+		o = append([]byte{byte(i)}, o...)
+	}
+	return
+}
+
+// ebmlFloat serializes a float
+func ebmlFloat(f float64) []byte {
+	var o = make([]byte, 8)
+	binary.BigEndian.PutUint64(o, math.Float64bits(f))
+	return o
+}
+
+// ebmlBool serializes a boolean
+func ebmlBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// matroskaWriter represents an object capable of encoding subtitles as a Matroska track
+type matroskaWriter struct {
+	o             MatroskaOptions
+	s             Subtitles
+	timecodeScale uint64
+}
+
+// newMatroskaWriter creates a new Matroska writer
+func newMatroskaWriter(s Subtitles, opts []MatroskaOptions, timecodeScale uint64) (w *matroskaWriter, err error) {
+	// Images are not supported
+	if err = s.textOnly("matroska"); err != nil {
+		return
+	}
+
+	// Get options
+	// Items are ordered since block timecodes are relative to increasing cluster timecodes
+	w = &matroskaWriter{s: *s.ordered(), timecodeScale: timecodeScale}
+	if len(opts) > 0 {
+		w.o = opts[0]
+	}
+	if len(w.o.Codec) == 0 {
+		w.o.Codec = MatroskaCodecUTF8
+	}
+
+	// Validate codec
+	switch w.o.Codec {
+	case MatroskaCodecASS, MatroskaCodecUTF8, MatroskaCodecWebVTT:
+	default:
+		err = fmt.Errorf("Invalid codec %s", w.o.Codec)
+		return
+	}
+	return
+}
+
+// timecode converts a duration into a timecode
+func (w *matroskaWriter) timecode(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(d) / int64(w.timecodeScale)
+}
+
+// trackEntry creates the track entry
+func (w *matroskaWriter) trackEntry(number, uid uint64) (o []byte, err error) {
+	// Get language
+	var l = "und"
+	if w.s.Metadata != nil {
+		if matroskaLanguageMapping.InB(w.s.Metadata.Language) {
+			l = matroskaLanguageMapping.A(w.s.Metadata.Language).(string)
+		} else if len(w.s.Metadata.Language) == 3 {
+			l = w.s.Metadata.Language
+		}
+	}
+
+	// Get codec private
+	var cp []byte
+	switch w.o.Codec {
+	case MatroskaCodecASS:
+		cp = []byte(matroskaASSHeader)
+	case MatroskaCodecWebVTT:
+		if cp, err = w.s.webvttHeader(); err != nil {
+			err = errors.Wrap(err, "creating webvtt header failed")
+			return
+		}
+	}
+
+	// Create elements
+	var es = [][]byte{
+		ebmlWriteElement(matroskaIDTrackNumber, ebmlUint(number)),
+		ebmlWriteElement(matroskaIDTrackUID, ebmlUint(uid)),
+		ebmlWriteElement(matroskaIDTrackType, ebmlUint(matroskaTrackTypeSubtitle)),
+		ebmlWriteElement(matroskaIDFlagDefault, ebmlBool(w.o.Default)),
+		ebmlWriteElement(matroskaIDFlagForced, ebmlBool(w.o.Forced)),
+		ebmlWriteElement(matroskaIDFlagLacing, ebmlBool(false)),
+		ebmlWriteElement(matroskaIDLanguage, []byte(l)),
+		ebmlWriteElement(matroskaIDCodecID, []byte(w.o.Codec)),
+	}
+	if len(w.o.Name) > 0 {
+		es = append(es, ebmlWriteElement(matroskaIDName, []byte(w.o.Name)))
+	}
+	if len(cp) > 0 {
+		es = append(es, ebmlWriteElement(matroskaIDCodecPrivate, cp))
+	}
+	return ebmlWriteElement(matroskaIDTrackEntry, es...), nil
+}
+
+// clusters creates the clusters holding the items of the track, the first cluster being located at the provided
+// position in the segment
+// A new cluster starts whenever block timecodes wouldn't fit in their 16 bits relative timecode.
+func (w *matroskaWriter) clusters(number, position uint64) (o []byte, cps []matroskaCuePoint) {
+	var blocks [][]byte
+	var clusterPosition uint64
+	var clusterTimecode int64
+	for idx, i := range w.s.Items {
+		// Get timecodes
+		var startAt, endAt = w.timecode(i.StartAt), w.timecode(i.EndAt)
+
+		// Flush cluster
+		if len(blocks) > 0 && startAt-clusterTimecode > matroskaMaxRelativeTimecode {
+			o = append(o, ebmlWriteElement(matroskaIDCluster, append([][]byte{ebmlWriteElement(matroskaIDTimecode, ebmlUint(uint64(clusterTimecode)))}, blocks...)...)...)
+			blocks = [][]byte{}
+		}
+		if len(blocks) == 0 {
+			clusterPosition = position + uint64(len(o))
+			clusterTimecode = startAt
+		}
+
+		// Create block
+		var data, additional = w.blockData(idx, i)
+		var b = append(ebmlVint(number), 0, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-3:], uint16(startAt-clusterTimecode))
+		var es = [][]byte{
+			ebmlWriteElement(matroskaIDBlock, b, data),
+			ebmlWriteElement(matroskaIDBlockDuration, ebmlUint(uint64(endAt-startAt))),
+		}
+		if len(additional) > 0 {
+			es = append(es, ebmlWriteElement(matroskaIDBlockAdditions, ebmlWriteElement(matroskaIDBlockMore, ebmlWriteElement(matroskaIDBlockAddID, ebmlUint(1)), ebmlWriteElement(matroskaIDBlockAdditional, additional))))
+		}
+		blocks = append(blocks, ebmlWriteElement(matroskaIDBlockGroup, es...))
+
+		// Add cue point
+		cps = append(cps, matroskaCuePoint{
+			positions: [][]byte{ebmlWriteElement(matroskaIDCueTrackPos,
+				ebmlWriteElement(matroskaIDCueTrack, ebmlUint(number)),
+				ebmlWriteElement(matroskaIDCueClusterPos, ebmlUint(clusterPosition)),
+			)},
+			time: uint64(startAt),
+		})
+	}
+
+	// Flush last cluster
+	if len(blocks) > 0 {
+		o = append(o, ebmlWriteElement(matroskaIDCluster, append([][]byte{ebmlWriteElement(matroskaIDTimecode, ebmlUint(uint64(clusterTimecode)))}, blocks...)...)...)
+	}
+	return
+}
+
+// blockData returns the block data and block additional data of an item
+func (w *matroskaWriter) blockData(idx int, i *Item) (data, additional []byte) {
+	var ls []string
+	for _, l := range i.Lines {
+		ls = append(ls, l.String())
+	}
+	switch w.o.Codec {
+	case MatroskaCodecASS:
+		data = []byte(fmt.Sprintf("%d,0,Default,,0,0,0,,%s", idx, strings.Join(ls, `\N`)))
+	case MatroskaCodecWebVTT:
+		data = []byte(strings.Join(ls, "\n"))
+		additional = []byte(strings.Join(webvttItemSettings(i), " "))
+	default:
+		data = []byte(strings.Join(ls, "\n"))
+	}
+	return
+}
+
+// WriteToMatroska writes subtitles in a subtitles only .mks Matroska file
+func (s Subtitles) WriteToMatroska(o io.Writer, opts ...MatroskaOptions) (err error) {
+	// Do not write anything if no subtitles
+	if len(s.Items) == 0 {
+		err = ErrNoSubtitlesToWrite
+		return
+	}
+
+	// Create writer
+	var w *matroskaWriter
+	if w, err = newMatroskaWriter(s, opts, matroskaDefaultTimecodeScale); err != nil {
+		err = errors.Wrap(err, "creating writer failed")
+		return
+	}
+
+	// Create track entry
+	var te []byte
+	if te, err = w.trackEntry(1, 1); err != nil {
+		err = errors.Wrap(err, "creating track entry failed")
+		return
+	}
+
+	// Create clusters
+	var cs, _ = w.clusters(1, 0)
+
+	// Write
+	if _, err = o.Write(append(ebmlWriteElement(ebmlIDEBML,
+		ebmlWriteElement(ebmlIDEBMLVersion, ebmlUint(1)),
+		ebmlWriteElement(ebmlIDEBMLReadVersion, ebmlUint(1)),
+		ebmlWriteElement(ebmlIDEBMLMaxIDLength, ebmlUint(4)),
+		ebmlWriteElement(ebmlIDEBMLMaxSizeLength, ebmlUint(8)),
+		ebmlWriteElement(ebmlIDDocType, []byte("matroska")),
+		ebmlWriteElement(ebmlIDDocTypeVersion, ebmlUint(4)),
+		ebmlWriteElement(ebmlIDDocTypeReadVersion, ebmlUint(2)),
+	), ebmlWriteElement(matroskaIDSegment,
+		ebmlWriteElement(matroskaIDInfo,
+			ebmlWriteElement(matroskaIDTimecodeScale, ebmlUint(matroskaDefaultTimecodeScale)),
+			ebmlWriteElement(matroskaIDMuxingApp, []byte("astisub")),
+			ebmlWriteElement(matroskaIDWritingApp, []byte("astisub")),
+			ebmlWriteElement(matroskaIDDuration, ebmlFloat(float64(w.timecode(w.s.Duration())))),
+		),
+		ebmlWriteElement(matroskaIDTracks, te),
+		cs,
+	)...)); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
+
+// AppendToMatroska appends subtitles as a new track of an existing .mkv content
+// Subtitles blocks are stored in clusters added at the end of the segment. Since positions are changing, the seek head
+// is rewritten at the beginning of the segment and the cues, updated with the subtitles blocks, at its end.
+func (s Subtitles) AppendToMatroska(i io.Reader, o io.Writer, opts ...MatroskaOptions) (err error) {
+	// Do not write anything if no subtitles
+	if len(s.Items) == 0 {
+		err = ErrNoSubtitlesToWrite
+		return
+	}
+
+	// Read all
+	var b []byte
+	if b, err = ioutil.ReadAll(i); err != nil {
+		err = errors.Wrap(err, "reading all failed")
+		return
+	}
+
+	// Read top level elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(b); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Loop through top level elements
+	var c []byte
+	var segmentFound bool
+	for _, e := range es {
+		// Not a segment
+		if e.id != matroskaIDSegment {
+			c = append(c, ebmlWriteElement(e.id, e.data)...)
+			continue
+		}
+		segmentFound = true
+
+		// Rewrite segment
+		var d []byte
+		if d, err = s.appendToMatroskaSegment(e, opts); err != nil {
+			err = errors.Wrap(err, "appending to segment failed")
+			return
+		}
+		c = append(c, ebmlWriteElement(matroskaIDSegment, d)...)
+	}
+
+	// No segment
+	if !segmentFound {
+		err = errors.New("No segment found")
+		return
+	}
+
+	// Write
+	if _, err = o.Write(c); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
+
+// appendToMatroskaSegment returns the data of a segment the subtitles track has been added to
+func (s Subtitles) appendToMatroskaSegment(segment ebmlElement, opts []MatroskaOptions) (o []byte, err error) {
+	// Read elements
+	var es []ebmlElement
+	if es, err = readEBMLElements(segment.data); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Read info and tracks
+	var m = &matroska{timecodeScale: matroskaDefaultTimecodeScale}
+	for _, e := range es {
+		if e.unknownSize {
+			err = fmt.Errorf("Element %x has an unknown size", e.id)
+			return
+		}
+		switch e.id {
+		case matroskaIDInfo:
+			err = m.readInfo(e)
+		case matroskaIDTracks:
+			err = m.readTracks(e)
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "reading element %x failed", e.id)
+			return
+		}
+	}
+
+	// Get track number and uid
+	var number, uid uint64 = 1, 1
+	for _, t := range m.tracks {
+		if uint64(t.Number) >= number {
+			number = uint64(t.Number) + 1
+		}
+		if t.uid >= uid {
+			uid = t.uid + 1
+		}
+	}
+
+	// Create writer
+	var w *matroskaWriter
+	if w, err = newMatroskaWriter(s, opts, m.timecodeScale); err != nil {
+		err = errors.Wrap(err, "creating writer failed")
+		return
+	}
+
+	// Create track entry
+	var te []byte
+	if te, err = w.trackEntry(number, uid); err != nil {
+		err = errors.Wrap(err, "creating track entry failed")
+		return
+	}
+
+	// Loop through elements
+	// Positions are relative to the end of the seek head until its size is known
+	var b []byte
+	var cues []ebmlElement
+	var positions = make(map[uint64]uint64)
+	var seeks []matroskaSeek
+	for _, e := range es {
+		// Seek head, cues and void elements are rewritten, and the segment checksum would be stale
+		switch e.id {
+		case ebmlIDCRC32, ebmlIDVoid, matroskaIDSeekHead:
+			continue
+		case matroskaIDCues:
+			cues = append(cues, e)
+			continue
+		}
+
+		// Index element
+		positions[uint64(e.offset)] = uint64(len(b))
+		if e.id != matroskaIDCluster {
+			seeks = append(seeks, matroskaSeek{id: e.id, position: uint64(len(b))})
+		}
+
+		// Add element
+		if e.id == matroskaIDTracks {
+			var d []byte
+			if d, err = ebmlStripCRC32(e.data); err != nil {
+				err = errors.Wrap(err, "stripping tracks CRC-32 failed")
+				return
+			}
+			b = append(b, ebmlWriteElement(e.id, d, te)...)
+		} else {
+			b = append(b, ebmlWriteElement(e.id, e.data)...)
+		}
+	}
+
+	// Get seek head size
+	seeks = append(seeks, matroskaSeek{id: matroskaIDCues})
+	var seekHeadSize = uint64(len(matroskaSeekHead(seeks)))
+
+	// Add clusters
+	var cs, cps = w.clusters(number, seekHeadSize+uint64(len(b)))
+	b = append(b, cs...)
+
+	// Read cue points
+	for _, e := range cues {
+		var ps []matroskaCuePoint
+		if ps, err = readMatroskaCuePoints(e, func(p uint64) (uint64, bool) {
+			v, ok := positions[p]
+			return seekHeadSize + v, ok
+		}); err != nil {
+			err = errors.Wrap(err, "reading cue points failed")
+			return
+		}
+		cps = append(cps, ps...)
+	}
+	sort.SliceStable(cps, func(i, j int) bool { return cps[i].time < cps[j].time })
+
+	// Update seek positions
+	for idx := range seeks {
+		seeks[idx].position += seekHeadSize
+	}
+	seeks[len(seeks)-1].position = seekHeadSize + uint64(len(b))
+
+	// Add cues
+	var ps [][]byte
+	for _, cp := range cps {
+		ps = append(ps, cp.element())
+	}
+	o = append(append(matroskaSeekHead(seeks), b...), ebmlWriteElement(matroskaIDCues, ps...)...)
+	return
+}
+
+// matroskaSeek represents a Matroska seek head entry
+// Position is relative to the segment data.
+type matroskaSeek struct {
+	id       uint32
+	position uint64
+}
+
+// matroskaSeekHead creates a seek head
+// Positions are written on 8 bytes so that the seek head size doesn't depend on them.
+func matroskaSeekHead(seeks []matroskaSeek) []byte {
+	var ss [][]byte
+	for _, s := range seeks {
+		var id, position = make([]byte, 4), make([]byte, 8)
+		binary.BigEndian.PutUint32(id, s.id)
+		binary.BigEndian.PutUint64(position, s.position)
+		ss = append(ss, ebmlWriteElement(matroskaIDSeek,
+			ebmlWriteElement(matroskaIDSeekID, bytes.TrimLeft(id, "\x00")),
+			ebmlWriteElement(matroskaIDSeekPosition, position),
+		))
+	}
+	return ebmlWriteElement(matroskaIDSeekHead, ss...)
+}
+
+// matroskaCuePoint represents a Matroska cue point being written
+// Positions are serialized cue track positions.
+type matroskaCuePoint struct {
+	positions [][]byte
+	time      uint64
+}
+
+// element serializes the cue point
+func (c matroskaCuePoint) element() []byte {
+	return ebmlWriteElement(matroskaIDCuePoint, append([][]byte{ebmlWriteElement(matroskaIDCueTime, ebmlUint(c.time))}, c.positions...)...)
+}
+
+// readMatroskaCuePoints reads the cue points of a cues element whose cluster positions are updated by the provided
+// function
+// Cue track positions whose cluster can't be found are dropped, and so are cue points left without any of them.
+// CRC-32 elements are dropped as well since cue points are rewritten.
+func readMatroskaCuePoints(cues ebmlElement, position func(p uint64) (uint64, bool)) (o []matroskaCuePoint, err error) {
+	// Read cue points
+	var es []ebmlElement
+	if es, err = readEBMLElements(cues.data); err != nil {
+		err = errors.Wrap(err, "reading elements failed")
+		return
+	}
+
+	// Loop through cue points
+	for _, e := range es {
+		if e.id != matroskaIDCuePoint {
+			continue
+		}
+		var cs []ebmlElement
+		if cs, err = readEBMLElements(e.data); err != nil {
+			err = errors.Wrap(err, "reading cue point elements failed")
+			return
+		}
+
+		// Loop through cue point elements
+		var cp matroskaCuePoint
+		for _, c := range cs {
+			switch c.id {
+			case matroskaIDCueTime:
+				cp.time = c.uint()
+			case matroskaIDCueTrackPos:
+				// Read track position elements
+				var ts []ebmlElement
+				if ts, err = readEBMLElements(c.data); err != nil {
+					err = errors.Wrap(err, "reading cue track position elements failed")
+					return
+				}
+
+				// Update cluster position
+				var found bool
+				var ps [][]byte
+				for _, t := range ts {
+					if t.id == matroskaIDCueClusterPos {
+						var p uint64
+						if p, found = position(t.uint()); !found {
+							break
+						}
+						ps = append(ps, ebmlWriteElement(t.id, ebmlUint(p)))
+					} else if t.id != ebmlIDCRC32 {
+						ps = append(ps, ebmlWriteElement(t.id, t.data))
+					}
+				}
+				if found {
+					cp.positions = append(cp.positions, ebmlWriteElement(c.id, ps...))
+				}
+			}
+		}
+		if len(cp.positions) > 0 {
+			o = append(o, cp)
+		}
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package astisub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendToMatroskaSeekHeadAndCues(t *testing.T) {
+	// Init
+	var cuePoint = func(time, position uint64) []byte {
+		return ebmlWriteElement(matroskaIDCuePoint,
+			ebmlWriteElement(matroskaIDCueTime, ebmlUint(time)),
+			ebmlWriteElement(matroskaIDCueTrackPos, ebmlWriteElement(matroskaIDCueTrack, ebmlUint(1)), ebmlWriteElement(matroskaIDCueClusterPos, ebmlUint(position))),
+		)
+	}
+	var es = [][]byte{
+		ebmlWriteElement(matroskaIDSeekHead),
+		ebmlWriteElement(ebmlIDVoid, make([]byte, 10)),
+		ebmlWriteElement(matroskaIDInfo, ebmlWriteElement(matroskaIDTimecodeScale, ebmlUint(matroskaDefaultTimecodeScale))),
+		ebmlWriteElement(matroskaIDTracks, ebmlWriteElement(matroskaIDTrackEntry, ebmlWriteElement(matroskaIDTrackNumber, ebmlUint(1)), ebmlWriteElement(matroskaIDTrackType, ebmlUint(1)), ebmlWriteElement(matroskaIDCodecID, []byte("V_VP9")))),
+		ebmlWriteElement(matroskaIDCluster, ebmlWriteElement(matroskaIDTimecode, ebmlUint(0)), ebmlWriteElement(matroskaIDSimpleBlock, []byte{0x81, 0, 0, 0}, []byte("video"))),
+	}
+	var clusterPosition = uint64(len(bytes.Join(es[:4], nil)))
+	es = append(es, ebmlWriteElement(matroskaIDCues, cuePoint(0, clusterPosition), cuePoint(500, clusterPosition+1)))
+	i := append(ebmlWriteElement(ebmlIDEBML, ebmlWriteElement(ebmlIDDocType, []byte("matroska"))), ebmlWriteElement(matroskaIDSegment, es...)...)
+
+	// Append unordered items spanning more than a cluster
+	s := Subtitles{Items: []*Item{
+		{EndAt: 41 * time.Second, Lines: []Line{{{Text: "3"}}}, StartAt: 40 * time.Second},
+		{EndAt: 3 * time.Second, Lines: []Line{{{Text: "2"}}}, StartAt: 2 * time.Second},
+		{EndAt: 2 * time.Second, Lines: []Line{{{Text: "1"}}}, StartAt: time.Second},
+	}}
+	w := &bytes.Buffer{}
+	err := s.AppendToMatroska(bytes.NewReader(i), w)
+	assert.NoError(t, err)
+
+	// Read segment
+	ss, err := readEBMLElements(w.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, ss, 2)
+	ss, err = readEBMLElements(ss[1].data)
+	assert.NoError(t, err)
+	var ids []uint32
+	var clusters = make(map[uint64]bool)
+	for _, e := range ss {
+		ids = append(ids, e.id)
+		if e.id == matroskaIDCluster {
+			clusters[uint64(e.offset)] = true
+		}
+	}
+	assert.Equal(t, []uint32{matroskaIDSeekHead, matroskaIDInfo, matroskaIDTracks, matroskaIDCluster, matroskaIDCluster, matroskaIDCluster, matroskaIDCues}, ids)
+
+	// Seek head
+	seeks, err := readEBMLElements(ss[0].data)
+	assert.NoError(t, err)
+	var positions = make(map[uint32]uint64)
+	for _, seek := range seeks {
+		cs, err := readEBMLElements(seek.data)
+		assert.NoError(t, err)
+		assert.Len(t, cs, 2)
+		positions[uint32(cs[0].uint())] = cs[1].uint()
+	}
+	assert.Equal(t, map[uint32]uint64{
+		matroskaIDCues:   uint64(ss[6].offset),
+		matroskaIDInfo:   uint64(ss[1].offset),
+		matroskaIDTracks: uint64(ss[2].offset),
+	}, positions)
+
+	// Cues
+	var found []uint64
+	cps, err := readMatroskaCuePoints(ss[6], func(p uint64) (uint64, bool) {
+		found = append(found, p)
+		return p, clusters[p]
+	})
+	assert.NoError(t, err)
+	var times []uint64
+	for _, cp := range cps {
+		times = append(times, cp.time)
+	}
+	assert.Equal(t, []uint64{0, 1000, 2000, 40000}, times)
+	assert.Equal(t, []uint64{uint64(ss[3].offset), uint64(ss[4].offset), uint64(ss[4].offset), uint64(ss[5].offset)}, found)
+
+	// Read
+	r, err := ReadFromMatroska(bytes.NewReader(w.Bytes()), 2)
+	assert.NoError(t, err)
+	assert.Len(t, r.Items, 3)
+	assert.Equal(t, 40*time.Second, r.Items[2].StartAt)
+}
+
+func TestAppendToMatroskaCRC32(t *testing.T) {
+	// Init
+	var crc = func(id uint32, payloads ...[]byte) []byte {
+		var c = make([]byte, 4)
+		binary.LittleEndian.PutUint32(c, crc32.ChecksumIEEE(bytes.Join(payloads, nil)))
+		return ebmlWriteElement(id, append([][]byte{ebmlWriteElement(ebmlIDCRC32, c)}, payloads...)...)
+	}
+	var es = [][]byte{
+		crc(matroskaIDSeekHead, ebmlWriteElement(matroskaIDSeek, ebmlWriteElement(matroskaIDSeekID, []byte{0x16, 0x54, 0xae, 0x6b}), ebmlWriteElement(matroskaIDSeekPosition, ebmlUint(0)))),
+		crc(matroskaIDInfo, ebmlWriteElement(matroskaIDTimecodeScale, ebmlUint(matroskaDefaultTimecodeScale))),
+		crc(matroskaIDTracks, ebmlWriteElement(matroskaIDTrackEntry, ebmlWriteElement(matroskaIDTrackNumber, ebmlUint(1)), ebmlWriteElement(matroskaIDTrackType, ebmlUint(1)), ebmlWriteElement(matroskaIDCodecID, []byte("V_VP9")))),
+	}
+	var clusterPosition = uint64(len(bytes.Join(es, nil)))
+	es = append(es,
+		crc(matroskaIDCluster, ebmlWriteElement(matroskaIDTimecode, ebmlUint(0)), ebmlWriteElement(matroskaIDSimpleBlock, []byte{0x81, 0, 0, 0}, []byte("video"))),
+		crc(matroskaIDCues, crc(matroskaIDCuePoint,
+			ebmlWriteElement(matroskaIDCueTime, ebmlUint(0)),
+			crc(matroskaIDCueTrackPos, ebmlWriteElement(matroskaIDCueTrack, ebmlUint(1)), ebmlWriteElement(matroskaIDCueClusterPos, ebmlUint(clusterPosition))),
+		)),
+	)
+	i := append(ebmlWriteElement(ebmlIDEBML, ebmlWriteElement(ebmlIDDocType, []byte("matroska"))), crc(matroskaIDSegment, es...)...)
+
+	// Append
+	s := Subtitles{Items: []*Item{{EndAt: 2 * time.Second, Lines: []Line{{{Text: "1"}}}, StartAt: time.Second}}}
+	w := &bytes.Buffer{}
+	err := s.AppendToMatroska(bytes.NewReader(i), w)
+	assert.NoError(t, err)
+
+	// Checksums left must be valid
+	var masters = map[uint32]bool{
+		matroskaIDCluster:     true,
+		matroskaIDCuePoint:    true,
+		matroskaIDCueTrackPos: true,
+		matroskaIDCues:        true,
+		matroskaIDInfo:        true,
+		matroskaIDSeek:        true,
+		matroskaIDSeekHead:    true,
+		matroskaIDSegment:     true,
+		matroskaIDTrackEntry:  true,
+		matroskaIDTracks:      true,
+	}
+	var crcs []uint32
+	var check func(b []byte)
+	check = func(b []byte) {
+		es, err := readEBMLElements(b)
+		assert.NoError(t, err)
+		for _, e := range es {
+			if !masters[e.id] {
+				continue
+			}
+			cs, err := readEBMLElements(e.data)
+			assert.NoError(t, err)
+			for idx, c := range cs {
+				if c.id == ebmlIDCRC32 {
+					crcs = append(crcs, e.id)
+					assert.Equal(t, 0, idx)
+					assert.Equal(t, crc32.ChecksumIEEE(e.data[len(ebmlWriteElement(c.id, c.data)):]), binary.LittleEndian.Uint32(c.data))
+				}
+			}
+			check(e.data)
+		}
+	}
+	check(w.Bytes())
+	assert.Equal(t, []uint32{matroskaIDInfo, matroskaIDCluster}, crcs)
+
+	// Read
+	r, err := ReadFromMatroska(bytes.NewReader(w.Bytes()), 2)
+	assert.NoError(t, err)
+	assert.Len(t, r.Items, 1)
+}
@@ -0,0 +1,169 @@
+package astisub_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// ebmlTestElement builds an EBML element whose size is coded on 8 bytes
+func ebmlTestElement(id uint32, payloads ...[]byte) []byte {
+	var b = make([]byte, 4)
+	binary.BigEndian.PutUint32(b, id)
+	b = bytes.TrimLeft(b, "\x00")
+	var p = bytes.Join(payloads, nil)
+	var s = make([]byte, 8)
+	binary.BigEndian.PutUint64(s, uint64(len(p)))
+	s[0] = 0x1
+	return append(append(b, s...), p...)
+}
+
+// ebmlTestBlock builds a block payload
+func ebmlTestBlock(track uint8, timecode int16, data string) []byte {
+	var b = []byte{0x80 | track, 0, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(timecode))
+	return append(b, []byte(data)...)
+}
+
+func TestReadFromMatroska(t *testing.T) {
+	// Init
+	b := append(ebmlTestElement(0x1a45dfa3, ebmlTestElement(0x4282, []byte("matroska"))), ebmlTestElement(0x18538067,
+		ebmlTestElement(0x1549a966, ebmlTestElement(0x2ad7b1, []byte{0x0f, 0x42, 0x40})),
+		ebmlTestElement(0x1654ae6b,
+			ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{1}), ebmlTestElement(0x83, []byte{1}), ebmlTestElement(0x86, []byte("V_VP9"))),
+			ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{2}), ebmlTestElement(0x83, []byte{0x11}), ebmlTestElement(0x86, []byte("S_TEXT/UTF8")), ebmlTestElement(0x22b59c, []byte("fre")), ebmlTestElement(0x536e, []byte("French")), ebmlTestElement(0x55aa, []byte{1})),
+			ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{3}), ebmlTestElement(0x83, []byte{0x11}), ebmlTestElement(0x86, []byte("S_TEXT/ASS")), ebmlTestElement(0x88, []byte{0})),
+			ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{4}), ebmlTestElement(0x83, []byte{0x11}), ebmlTestElement(0x86, []byte("S_TEXT/WEBVTT")), ebmlTestElement(0x63a2, []byte("WEBVTT\n\nREGION\nid:top\nwidth:40%\n"))),
+			ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{5}), ebmlTestElement(0x83, []byte{0x11}), ebmlTestElement(0x86, []byte("S_HDMV/PGS"))),
+		),
+		ebmlTestElement(0x1f43b675, ebmlTestElement(0xe7, []byte{0x03, 0xe8}),
+			ebmlTestElement(0xa3, ebmlTestBlock(1, 0, "video")),
+			ebmlTestElement(0xa0, ebmlTestElement(0xa1, ebmlTestBlock(2, 0, "Bonjour\r\nle monde")), ebmlTestElement(0x9b, []byte{0x03, 0xe8})),
+			ebmlTestElement(0xa0, ebmlTestElement(0xa1, ebmlTestBlock(3, 500, `0,0,Default,,0,0,0,,{\i1}Hello\Nworld`)), ebmlTestElement(0x9b, []byte{0x01, 0xf4})),
+			ebmlTestElement(0xa0, ebmlTestElement(0xa1, ebmlTestBlock(4, 1000, "Cue\n\nsuite")), ebmlTestElement(0x9b, []byte{0x01, 0xf4}), ebmlTestElement(0x75a1, ebmlTestElement(0xa6, ebmlTestElement(0xee, []byte{1}), ebmlTestElement(0xa5, []byte("align:left region:top\n"))))),
+		),
+		ebmlTestElement(0x1f43b675, ebmlTestElement(0xe7, []byte{0x0b, 0xb8}),
+			ebmlTestElement(0xa3, ebmlTestBlock(2, 0, "Au revoir")),
+			ebmlTestElement(0xa3, ebmlTestBlock(2, 1000, "00:00:01,000 --> 00:00:02,000")),
+		),
+	)...)
+
+	// List tracks
+	ts, err := astisub.ReadMatroskaTracks(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, []astisub.MatroskaTrack{
+		{Codec: astisub.MatroskaCodecUTF8, Default: true, Forced: true, Language: "fre", Name: "French", Number: 2},
+		{Codec: astisub.MatroskaCodecASS, Language: "eng", Number: 3},
+		{Codec: astisub.MatroskaCodecWebVTT, Default: true, Language: "eng", Number: 4},
+		{Codec: "S_HDMV/PGS", Default: true, Language: "eng", Number: 5},
+	}, ts)
+
+	// UTF8
+	s, err := astisub.ReadFromMatroska(bytes.NewReader(b), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageFrench, s.Metadata.Language)
+	assert.Equal(t, 3, len(s.Items))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, []time.Duration{s.Items[0].StartAt, s.Items[0].EndAt})
+	assert.Equal(t, []astisub.Line{{{Text: "Bonjour"}}, {{Text: "le monde"}}}, s.Items[0].Lines)
+	assert.Equal(t, []time.Duration{3 * time.Second, 4 * time.Second}, []time.Duration{s.Items[1].StartAt, s.Items[1].EndAt})
+	assert.Equal(t, "Au revoir", s.Items[1].String())
+	assert.Equal(t, []time.Duration{4 * time.Second, 4 * time.Second}, []time.Duration{s.Items[2].StartAt, s.Items[2].EndAt})
+	assert.Equal(t, "00:00:01,000 --> 00:00:02,000", s.Items[2].String())
+
+	// ASS
+	s, err = astisub.ReadFromMatroska(bytes.NewReader(b), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageEnglish, s.Metadata.Language)
+	assert.Equal(t, 1, len(s.Items))
+	assert.Equal(t, []time.Duration{1500 * time.Millisecond, 2 * time.Second}, []time.Duration{s.Items[0].StartAt, s.Items[0].EndAt})
+	assert.Equal(t, []astisub.Line{{{Text: "Hello"}}, {{Text: "world"}}}, s.Items[0].Lines)
+
+	// WebVTT
+	s, err = astisub.ReadFromMatroska(bytes.NewReader(b), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(s.Items))
+	assert.Equal(t, []time.Duration{2 * time.Second, 2500 * time.Millisecond}, []time.Duration{s.Items[0].StartAt, s.Items[0].EndAt})
+	assert.Equal(t, []astisub.Line{{{Text: "Cue"}}, {{Text: ""}}, {{Text: "suite"}}}, s.Items[0].Lines)
+	assert.Equal(t, astisub.TextAlignLeft, s.Items[0].InlineStyle.Align)
+	assert.Equal(t, s.Regions["top"], s.Items[0].Region)
+
+	// Errors
+	_, err = astisub.ReadFromMatroska(bytes.NewReader(b), 1)
+	assert.Error(t, err)
+	_, err = astisub.ReadFromMatroska(bytes.NewReader(b), 5)
+	assert.Error(t, err)
+	_, err = astisub.ReadFromMatroska(bytes.NewReader(b), 6)
+	assert.Error(t, err)
+	_, err = astisub.ReadFromMatroska(bytes.NewReader(b[:len(b)-1]), 0)
+	assert.Error(t, err)
+}
+
+func TestWriteToMatroska(t *testing.T) {
+	// Init
+	s := &astisub.Subtitles{
+		Items: []*astisub.Item{
+			{EndAt: 2 * time.Second, Lines: []astisub.Line{{{Text: "1"}}}, StartAt: time.Second},
+			{EndAt: 40 * time.Second, InlineStyle: &astisub.StyleAttributes{Align: astisub.TextAlignLeft}, Lines: []astisub.Line{{{Text: "2"}}, {{Text: "3"}}}, StartAt: 35 * time.Second},
+		},
+		Metadata: &astisub.Metadata{Language: astisub.LanguageFrench},
+	}
+
+	// No subtitles to write
+	err := astisub.Subtitles{}.WriteToMatroska(&bytes.Buffer{})
+	assert.EqualError(t, err, astisub.ErrNoSubtitlesToWrite.Error())
+
+	// Invalid codec
+	err = s.WriteToMatroska(&bytes.Buffer{}, astisub.MatroskaOptions{Codec: "S_VOBSUB"})
+	assert.Error(t, err)
+
+	// Loop through codecs
+	for _, c := range []string{astisub.MatroskaCodecASS, astisub.MatroskaCodecUTF8, astisub.MatroskaCodecWebVTT} {
+		// Write
+		w := &bytes.Buffer{}
+		err = s.WriteToMatroska(w, astisub.MatroskaOptions{Codec: c, Default: true, Forced: true, Name: "French"})
+		assert.NoError(t, err)
+
+		// Read
+		ts, err := astisub.ReadMatroskaTracks(bytes.NewReader(w.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, []astisub.MatroskaTrack{{Codec: c, Default: true, Forced: true, Language: "fre", Name: "French", Number: 1}}, ts)
+		r, err := astisub.ReadFromMatroska(bytes.NewReader(w.Bytes()), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, astisub.LanguageFrench, r.Metadata.Language)
+		assert.Equal(t, 2, len(r.Items), c)
+		for idx, i := range s.Items {
+			assert.Equal(t, i.StartAt, r.Items[idx].StartAt, c)
+			assert.Equal(t, i.EndAt, r.Items[idx].EndAt, c)
+			assert.Equal(t, i.Lines, r.Items[idx].Lines, c)
+		}
+		if c == astisub.MatroskaCodecWebVTT {
+			assert.Equal(t, astisub.TextAlignLeft, r.Items[1].InlineStyle.Align)
+		}
+	}
+
+	// Append
+	i := append(ebmlTestElement(0x1a45dfa3, ebmlTestElement(0x4282, []byte("matroska"))), ebmlTestElement(0x18538067,
+		ebmlTestElement(0x114d9b74),
+		ebmlTestElement(0x1549a966, ebmlTestElement(0x2ad7b1, []byte{0x0f, 0x42, 0x40})),
+		ebmlTestElement(0x1654ae6b, ebmlTestElement(0xae, ebmlTestElement(0xd7, []byte{1}), ebmlTestElement(0x73c5, []byte{7}), ebmlTestElement(0x83, []byte{1}), ebmlTestElement(0x86, []byte("V_VP9")))),
+		ebmlTestElement(0x1f43b675, ebmlTestElement(0xe7, []byte{0}), ebmlTestElement(0xa3, ebmlTestBlock(1, 0, "video"))),
+		ebmlTestElement(0x1c53bb6b),
+	)...)
+	w := &bytes.Buffer{}
+	err = s.AppendToMatroska(bytes.NewReader(i), w)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(w.Bytes(), []byte{0x11, 0x4d, 0x9b, 0x74}))
+	ts, err := astisub.ReadMatroskaTracks(bytes.NewReader(w.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, []astisub.MatroskaTrack{{Codec: astisub.MatroskaCodecUTF8, Language: "fre", Number: 2}}, ts)
+	r, err := astisub.ReadFromMatroska(bytes.NewReader(w.Bytes()), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(r.Items))
+	assert.Equal(t, s.Items[1].Lines, r.Items[1].Lines)
+	err = s.AppendToMatroska(bytes.NewReader(ebmlTestElement(0x1a45dfa3)), w)
+	assert.Error(t, err)
+}
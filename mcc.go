@@ -0,0 +1,500 @@
+package astisub
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SMPTE ST 334-2: Caption Distribution Packet (CDP) definition
+
+// MCC constants
+const (
+	mccCDPFooterID          = 0x74
+	mccCDPFlagCaptionActive = 0x2
+	mccCDPFlagCCData        = 0x40
+	mccCDPFlagReserved      = 0x1
+	mccCDPFlagTimeCode      = 0x80
+	mccCDPHeaderSize        = 7
+	mccCDPSectionCCData     = 0x72
+	mccCDPSectionTimeCode   = 0x71
+	mccDID                  = 0x61
+	mccFileFormat           = "File Format=MacCaption_MCC V"
+	mccSDID                 = 0x01
+)
+
+// MCC header
+var mccHeader = `///////////////////////////////////////////////////////////////////////////////////
+// Computer Prompting and Captioning Company
+// Ancillary Data Packet Transfer File
+//
+// Permission to generate this format is granted provided that
+//   1. This ANC Transfer file format is used on an as-is basis and no warranty is given, and
+//   2. This entire descriptive information text is included in a generated .mcc file.
+//
+// General file format:
+//   HH:MM:SS:FF(tab)[Hexadecimal ANC data in groups of 2 characters]
+//     Hexadecimal data starts with the Ancillary Data Packet DID (Data ID defined in S291M)
+//       and concludes with the Check Sum following the User Data Words.
+//     Each time code line must contain at most one complete ancillary data packet.
+//     To transfer additional ANC Data successive lines may contain identical time code.
+//     Time Code Rate=[24, 25, 30, 30DF, 50, 60]
+//
+//   ANC data bytes may be represented by one ASCII character according to the following schema:
+//     G  FAh 00h 00h
+//     H  2 x (FAh 00h 00h)
+//     I  3 x (FAh 00h 00h)
+//     J  4 x (FAh 00h 00h)
+//     K  5 x (FAh 00h 00h)
+//     L  6 x (FAh 00h 00h)
+//     M  7 x (FAh 00h 00h)
+//     N  8 x (FAh 00h 00h)
+//     O  9 x (FAh 00h 00h)
+//     P  FBh 80h 80h
+//     Q  FCh 80h 80h
+//     R  FDh 80h 80h
+//     S  96h 69h
+//     T  61h 01h
+//     U  E1h 00h 00h 00h
+//     Z  00h
+//
+///////////////////////////////////////////////////////////////////////////////////`
+
+// MCC substitutions
+// They are ordered so that the longest matching substitution is used when compressing
+var mccSubstitutions = []struct {
+	b []byte
+	c byte
+}{
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 9), c: 'O'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 8), c: 'N'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 7), c: 'M'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 6), c: 'L'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 5), c: 'K'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 4), c: 'J'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 3), c: 'I'},
+	{b: bytes.Repeat([]byte{0xfa, 0x0, 0x0}, 2), c: 'H'},
+	{b: []byte{0xe1, 0x0, 0x0, 0x0}, c: 'U'},
+	{b: []byte{0xfa, 0x0, 0x0}, c: 'G'},
+	{b: []byte{0xfb, 0x80, 0x80}, c: 'P'},
+	{b: []byte{0xfc, 0x80, 0x80}, c: 'Q'},
+	{b: []byte{0xfd, 0x80, 0x80}, c: 'R'},
+	{b: []byte{0x96, 0x69}, c: 'S'},
+	{b: []byte{0x61, 0x01}, c: 'T'},
+	{b: []byte{0x0}, c: 'Z'},
+}
+
+// MCC CDP frame rates
+// Indexes are CDP frame rate codes
+var mccCDPFramerates = []Framerate{{}, Framerate23976, Framerate24, Framerate25, Framerate2997, Framerate30, Framerate50, Framerate5994, Framerate60}
+
+// MCCOptions represents MCC options
+// Framerate defaults to the subtitles framerate or to 29.97 drop-frame, and Service defaults to 1.
+type MCCOptions struct {
+	Framerate Framerate
+	Service   int
+}
+
+// ReadMCC parses a .mcc content and returns the subtitles of each caption channel
+// Subtitles are decoded from CEA-608 and CEA-708 cc_data, indexed by channel like with ReadEmbeddedCaptions and
+// timed with the SMPTE timecodes. Only channels holding items are returned.
+func ReadMCC(i io.Reader) (o map[string]*Subtitles, err error) {
+	// Read
+	var f Framerate
+	if o, f, err = readMCC(i); err != nil {
+		return
+	}
+
+	// Add metadata
+	for _, s := range o {
+		s.Metadata = &Metadata{Framerate: f}
+	}
+	return
+}
+
+// ReadFromMCC parses the captions of a channel of a .mcc content
+// When channel is empty, the first channel holding items is used, CEA-608 channels coming first.
+func ReadFromMCC(i io.Reader, channel string) (o *Subtitles, err error) {
+	// Get channels
+	var cs []string
+	if cs, err = captionChannelsToRead(channel); err != nil {
+		return
+	}
+
+	// Read
+	var f Framerate
+	var ss map[string]*Subtitles
+	if ss, f, err = readMCC(i); err != nil {
+		return
+	}
+
+	// Get subtitles
+	o = captionSubtitles(ss, cs)
+	o.Metadata = &Metadata{Framerate: f}
+	return
+}
+
+// readMCC parses a .mcc content and returns the subtitles of each caption channel holding items, and the framerate
+func readMCC(i io.Reader) (o map[string]*Subtitles, f Framerate, err error) {
+	// Loop through lines
+	var d608, d708 = newCEA608Decoder(), NewCEA708Decoder()
+	var last timecode
+	var rate string
+	var s = bufio.NewScanner(i)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for idx := 0; s.Scan(); idx++ {
+		// Check file format
+		var l = strings.TrimSpace(strings.TrimPrefix(s.Text(), string(BytesBOM)))
+		if idx == 0 {
+			if !strings.HasPrefix(l, mccFileFormat) {
+				err = fmt.Errorf("Invalid file format %s", l)
+				return
+			}
+			continue
+		}
+
+		// Skip comments and empty lines
+		if len(l) == 0 || strings.HasPrefix(l, "//") {
+			continue
+		}
+
+		// Header
+		var parts = strings.SplitN(l, "\t", 2)
+		if len(parts) == 1 {
+			if kv := strings.SplitN(l, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "Time Code Rate" {
+				rate = strings.TrimSpace(kv[1])
+				if f, err = mccFramerate(rate); err != nil {
+					err = errors.Wrap(err, "parsing time code rate failed")
+					return
+				}
+			}
+			continue
+		}
+
+		// Check time code rate
+		if f.IsZero() {
+			err = errors.New("No time code rate before data")
+			return
+		}
+
+		// Parse timecode
+		// Field suffixes such as ".1" are ignored
+		var tc timecode
+		if tc, err = parseTimecode(strings.SplitN(parts[0], ".", 2)[0]); err != nil {
+			err = errors.Wrapf(err, "parsing timecode of line %d failed", idx+1)
+			return
+		}
+
+		// Parse packet
+		var b []byte
+		if b, err = mccDecompress(strings.TrimSpace(parts[1])); err != nil {
+			err = errors.Wrapf(err, "decompressing line %d failed", idx+1)
+			return
+		}
+		var ccData []byte
+		var code int
+		if ccData, code, err = mccParseANC(b); err != nil {
+			err = errors.Wrapf(err, "parsing ancillary data packet of line %d failed", idx+1)
+			return
+		}
+
+		// CDP frame rates are more accurate than time code rates which can't distinguish 29.97 from 30
+		if code > 0 && code < len(mccCDPFramerates) {
+			if c := mccCDPFramerates[code]; c.nominal() == f.nominal() && c.Denominator != f.Denominator {
+				f = Framerate{Denominator: c.Denominator, DropFrame: f.DropFrame, Numerator: c.Numerator}
+			}
+		}
+
+		// Decode
+		if err = d608.decode(f.timecodeToDuration(tc), ccData); err != nil {
+			err = errors.Wrapf(err, "decoding CEA-608 data of line %d failed", idx+1)
+			return
+		}
+		if err = d708.Decode(f.timecodeToDuration(tc), ccData); err != nil {
+			err = errors.Wrapf(err, "decoding CEA-708 data of line %d failed", idx+1)
+			return
+		}
+		last = tc
+	}
+	if err = s.Err(); err != nil {
+		err = errors.Wrap(err, "scanning failed")
+		return
+	}
+
+	// Get subtitles
+	o = captionDecodedSubtitles(d608, d708, f.Duration(f.frameCount(last)+1))
+	return
+}
+
+// mccFramerate returns the framerate of a time code rate
+func mccFramerate(i string) (f Framerate, err error) {
+	switch i {
+	case "24":
+		f = Framerate24
+	case "25":
+		f = Framerate25
+	case "30":
+		f = Framerate30
+	case "30DF":
+		f = Framerate2997DropFrame
+	case "50":
+		f = Framerate50
+	case "60":
+		f = Framerate60
+	case "60DF":
+		f = Framerate5994DropFrame
+	default:
+		err = fmt.Errorf("Invalid time code rate %s", i)
+	}
+	return
+}
+
+// mccDecompress decodes the hexadecimal data of a line, expanding substitution characters
+func mccDecompress(i string) (o []byte, err error) {
+	for idx := 0; idx < len(i); {
+		// Substitution character
+		var found bool
+		for _, s := range mccSubstitutions {
+			if i[idx] == s.c {
+				o = append(o, s.b...)
+				found = true
+				break
+			}
+		}
+		if found {
+			idx++
+			continue
+		}
+
+		// Hexadecimal byte
+		if idx+2 > len(i) {
+			err = fmt.Errorf("Invalid hexadecimal data %s", i[idx:])
+			return
+		}
+		var b []byte
+		if b, err = hex.DecodeString(i[idx : idx+2]); err != nil {
+			err = errors.Wrapf(err, "decoding %s failed", i[idx:idx+2])
+			return
+		}
+		o = append(o, b...)
+		idx += 2
+	}
+	return
+}
+
+// mccCompress encodes data in hexadecimal, using substitution characters when possible
+func mccCompress(i []byte) string {
+	var buf = &bytes.Buffer{}
+	for idx := 0; idx < len(i); {
+		// Substitution character
+		var found bool
+		for _, s := range mccSubstitutions {
+			if bytes.HasPrefix(i[idx:], s.b) {
+				buf.WriteByte(s.c)
+				idx += len(s.b)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		// Hexadecimal byte
+		fmt.Fprintf(buf, "%.2X", i[idx])
+		idx++
+	}
+	return buf.String()
+}
+
+// mccParseANC parses an ancillary data packet and returns the cc_data triplets and frame rate code of its CDP
+// Packets that don't hold a CDP are ignored.
+func mccParseANC(b []byte) (ccData []byte, frameRate int, err error) {
+	// Check header
+	if len(b) < 3 || b[0] != mccDID || b[1] != mccSDID {
+		return
+	}
+	var n = int(b[2])
+	if len(b) < 3+n {
+		err = fmt.Errorf("Invalid data count %d", n)
+		return
+	}
+	b = b[3 : 3+n]
+
+	// Parse CDP header
+	if len(b) < mccCDPHeaderSize || b[0] != 0x96 || b[1] != 0x69 {
+		err = errors.New("Invalid CDP header")
+		return
+	}
+	var flags = b[4]
+	frameRate = int(b[3] >> 4)
+	b = b[mccCDPHeaderSize:]
+
+	// Skip time code section
+	if flags&mccCDPFlagTimeCode > 0 {
+		if len(b) < 5 || b[0] != mccCDPSectionTimeCode {
+			err = errors.New("Invalid CDP time code section")
+			return
+		}
+		b = b[5:]
+	}
+
+	// Parse cc_data section
+	if flags&mccCDPFlagCCData == 0 {
+		return
+	}
+	if len(b) < 2 || b[0] != mccCDPSectionCCData || len(b) < 2+3*int(b[1]&0x1f) {
+		err = errors.New("Invalid CDP cc_data section")
+		return
+	}
+	ccData = b[2 : 2+3*int(b[1]&0x1f)]
+	return
+}
+
+// mccANC builds the ancillary data packet holding the CDP of a frame
+func mccANC(ccData []byte, frameRate byte, sequence int) (o []byte) {
+	// Build CDP
+	var cdp = []byte{0x96, 0x69, 0x0, frameRate<<4 | 0xf, mccCDPFlagCCData | mccCDPFlagCaptionActive | mccCDPFlagReserved, byte(sequence >> 8), byte(sequence), mccCDPSectionCCData, 0xe0 | byte(len(ccData)/3)}
+	cdp = append(cdp, ccData...)
+	cdp = append(cdp, mccCDPFooterID, byte(sequence>>8), byte(sequence), 0x0)
+	cdp[2] = byte(len(cdp))
+
+	// The CDP checksum makes the sum of its bytes a multiple of 256
+	var sum byte
+	for _, v := range cdp {
+		sum += v
+	}
+	cdp[len(cdp)-1] = -sum
+
+	// Build packet
+	o = append([]byte{mccDID, mccSDID, byte(len(cdp))}, cdp...)
+	sum = 0
+	for _, v := range o {
+		sum += v
+	}
+	return append(o, sum)
+}
+
+// mccTimeCodeRate returns the time code rate and CDP frame rate code of a framerate
+func mccTimeCodeRate(f Framerate) (rate string, code byte, err error) {
+	// Get CDP frame rate code
+	for idx, c := range mccCDPFramerates {
+		if !c.IsZero() && math.Abs(c.Float64()-f.Float64()) < 0.001 {
+			code = byte(idx)
+		}
+	}
+	if code == 0 {
+		err = fmt.Errorf("Invalid framerate %s", f)
+		return
+	}
+
+	// Get time code rate
+	rate = fmt.Sprintf("%d", f.nominal())
+	if f.DropFrame {
+		rate += "DF"
+	}
+	if _, err = mccFramerate(rate); err != nil {
+		err = errors.Wrapf(err, "invalid framerate %s", f)
+		return
+	}
+	return
+}
+
+// WriteToMCC writes subtitles in .mcc format
+// Items are encoded both as a CEA-708 caption service and as CEA-608 pop-on captions in the CC1 channel, and a line
+// is written for each frame, starting at 00:00:00:00.
+func (s Subtitles) WriteToMCC(o io.Writer, opts ...MCCOptions) (err error) {
+	// Do not write anything if no subtitles
+	if len(s.Items) == 0 {
+		err = ErrNoSubtitlesToWrite
+		return
+	}
+
+	// Images are not supported
+	if err = s.textOnly("MCC"); err != nil {
+		return
+	}
+
+	// Get options
+	var opt = MCCOptions{Framerate: Framerate2997DropFrame, Service: 1}
+	if s.Metadata != nil && !s.Metadata.Framerate.IsZero() {
+		opt.Framerate = s.Metadata.Framerate
+	}
+	if len(opts) > 0 {
+		if !opts[0].Framerate.IsZero() {
+			opt.Framerate = opts[0].Framerate
+		}
+		if opts[0].Service > 0 {
+			opt.Service = opts[0].Service
+		}
+	}
+
+	// Get time code rate
+	var rate string
+	var code byte
+	if rate, code, err = mccTimeCodeRate(opt.Framerate); err != nil {
+		err = errors.Wrap(err, "getting time code rate failed")
+		return
+	}
+
+	// Encode
+	var frames [][]byte
+	if frames, err = EncodeCEA708(map[int]*Subtitles{opt.Service: &s}, opt.Framerate); err != nil {
+		err = errors.Wrap(err, "encoding CEA-708 failed")
+		return
+	}
+
+	// CEA-608 byte pairs replace the null data of the field 1 triplets, and captions may end a few frames after
+	// CEA-708 ones
+	for idx, p := range encodeCEA608(&s, opt.Framerate) {
+		if idx >= len(frames) {
+			var b = append([]byte{}, cea708CEA608NullData...)
+			for len(b) < len(frames[0]) {
+				b = append(b, 0xf8|cea708CCTypeDTVCCData, 0x0, 0x0)
+			}
+			frames = append(frames, b)
+		}
+		frames[idx][1], frames[idx][2] = p[0], p[1]
+	}
+
+	// Create UUID
+	var u = make([]byte, 16)
+	if _, err = rand.Read(u); err != nil {
+		err = errors.Wrap(err, "creating UUID failed")
+		return
+	}
+	u[6], u[8] = u[6]&0x0f|0x40, u[8]&0x3f|0x80
+
+	// Write header
+	var version = "1.0"
+	if rate == "60DF" {
+		version = "2.0"
+	}
+	var now = Now()
+	var buf = &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s%s\n\n%s\n\n", mccFileFormat, version, mccHeader)
+	fmt.Fprintf(buf, "UUID=%X-%X-%X-%X-%X\n", u[:4], u[4:6], u[6:8], u[8:10], u[10:])
+	fmt.Fprintf(buf, "Creation Program=astisub\n")
+	fmt.Fprintf(buf, "Creation Date=%s\n", now.Format("Monday, January 2, 2006"))
+	fmt.Fprintf(buf, "Creation Time=%s\n", now.Format("15:04:05"))
+	fmt.Fprintf(buf, "Time Code Rate=%s\n\n", rate)
+
+	// Loop through frames
+	for idx, ccData := range frames {
+		fmt.Fprintf(buf, "%s\t%s\n", opt.Framerate.timecode(idx).string(opt.Framerate.DropFrame), mccCompress(mccANC(ccData, code, idx&0xffff)))
+	}
+
+	// Write
+	if _, err = io.Copy(o, buf); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package astisub_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMCC(t *testing.T) {
+	// Init
+	astisub.Now = func() (t time.Time) {
+		t, _ = time.Parse("060102", "170702")
+		return
+	}
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "example.mcc")
+
+	// No subtitles to write
+	w := &bytes.Buffer{}
+	err = astisub.Subtitles{}.WriteToMCC(w)
+	assert.EqualError(t, err, astisub.ErrNoSubtitlesToWrite.Error())
+
+	// Write
+	s, err := astisub.OpenFile("./testdata/example-in.srt")
+	assert.NoError(t, err)
+	err = s.WriteWithOptions(astisub.Options{Dst: p, MCC: astisub.MCCOptions{Framerate: astisub.Framerate2997DropFrame, Service: 2}})
+	assert.NoError(t, err)
+	c, err := ioutil.ReadFile(p)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(c), "File Format=MacCaption_MCC V1.0\n"))
+	assert.Contains(t, string(c), "\nCreation Date=Sunday, July 2, 2017\n")
+	assert.Contains(t, string(c), "\nTime Code Rate=30DF\n\n00:00:00;00\tT")
+	assert.Contains(t, string(c), "\n00:02:33;13\t")
+
+	// Read
+	for _, channel := range []string{"SERVICE2", "CC1"} {
+		s2, err := astisub.Open(astisub.Options{CaptionChannel: channel, Src: p})
+		assert.NoError(t, err)
+		assert.Equal(t, astisub.Framerate2997DropFrame, s2.Metadata.Framerate)
+		assert.Len(t, s2.Items, len(s.Items))
+		for idx, i := range s.Items {
+			assert.Equal(t, i.String(), s2.Items[idx].String())
+			assert.InDelta(t, i.StartAt, s2.Items[idx].StartAt, float64(20*time.Millisecond))
+			assert.InDelta(t, i.EndAt, s2.Items[idx].EndAt, float64(20*time.Millisecond))
+		}
+	}
+	ss, err := astisub.ReadMCC(bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.Len(t, ss, 2)
+	s2, err := astisub.ReadFromMCC(bytes.NewReader(c), "")
+	assert.NoError(t, err)
+	assert.Equal(t, ss["CC1"].Items[0].String(), s2.Items[0].String())
+	s2, err = astisub.ReadFromMCC(bytes.NewReader(c), "SERVICE1")
+	assert.NoError(t, err)
+	assert.Len(t, s2.Items, 0)
+	_, err = astisub.ReadFromMCC(bytes.NewReader(c), "CC5")
+	assert.Error(t, err)
+
+	// CEA-608 characters and wrapping
+	s = &astisub.Subtitles{Items: []*astisub.Item{{EndAt: 4 * time.Second, Lines: []astisub.Line{{{Text: "Ça coûte 5€ * 2 ♪ à l'été, très cher {ok}"}}}, StartAt: 3 * time.Second}}}
+	w = &bytes.Buffer{}
+	err = s.WriteToMCC(w)
+	assert.NoError(t, err)
+	s2, err = astisub.ReadFromMCC(bytes.NewReader(w.Bytes()), "CC1")
+	assert.NoError(t, err)
+	assert.Len(t, s2.Items, 1)
+	assert.Equal(t, "Ça coûte 5 * 2 ♪ à l'été, très - cher {ok}", s2.Items[0].String())
+	assert.InDelta(t, 3*time.Second, s2.Items[0].StartAt, float64(20*time.Millisecond))
+	assert.InDelta(t, 4*time.Second, s2.Items[0].EndAt, float64(20*time.Millisecond))
+
+	// CDP frame rate
+	s2, err = astisub.ReadFromMCC(strings.NewReader("File Format=MacCaption_MCC V1.0\n\nTime Code Rate=30\n\n00:00:01:00\tT13S134F43ZZ72E2QR74ZZ7DZZ\n"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.Framerate2997, s2.Metadata.Framerate)
+
+	// Invalid content
+	_, err = astisub.ReadFromMCC(strings.NewReader("invalid"), "")
+	assert.Error(t, err)
+	_, err = astisub.ReadFromMCC(strings.NewReader("File Format=MacCaption_MCC V1.0\n00:00:01:00\tT13\n"), "")
+	assert.Error(t, err)
+}
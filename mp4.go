@@ -0,0 +1,1213 @@
+package astisub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astitools/map"
+	"github.com/pkg/errors"
+)
+
+// https://www.iso.org/standard/68960.html (ISO/IEC 14496-12)
+// https://www.iso.org/standard/63107.html (ISO/IEC 14496-30)
+
+// MP4 codecs
+const (
+	MP4CodecSTPP = "stpp"
+	MP4CodecWVTT = "wvtt"
+	mp4CodecTX3G = "tx3g"
+)
+
+// MP4 defaults
+const (
+	mp4DefaultTimescale = 1000
+	mp4DefaultTrackID   = 1
+)
+
+// MP4 handler types
+const (
+	mp4HandlerTypeSubtitle = "subt"
+	mp4HandlerTypeText     = "text"
+)
+
+// MP4 box flags
+const (
+	mp4FlagDataReferenceSelfContained        = 0x1
+	mp4FlagTFHDBaseDataOffsetPresent         = 0x1
+	mp4FlagTFHDDefaultBaseIsMoof             = 0x20000
+	mp4FlagTFHDDefaultSampleDurationPresent  = 0x8
+	mp4FlagTFHDDefaultSampleSizePresent      = 0x10
+	mp4FlagTFHDSampleDescriptionIndexPresent = 0x2
+	mp4FlagTKHDEnabledInMovie                = 0x3
+	mp4FlagTRUNDataOffsetPresent             = 0x1
+	mp4FlagTRUNFirstSampleFlagsPresent       = 0x4
+	mp4FlagTRUNSampleCompositionTimePresent  = 0x800
+	mp4FlagTRUNSampleDurationPresent         = 0x100
+	mp4FlagTRUNSampleFlagsPresent            = 0x400
+	mp4FlagTRUNSampleSizePresent             = 0x200
+)
+
+// MP4 unity matrix
+var mp4Matrix = []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+
+// MP4 languages are ISO 639-2/T codes
+var mp4LanguageMapping = astimap.NewMap("und", "").
+	Set("eng", LanguageEnglish).
+	Set("fra", LanguageFrench)
+
+// mp4ReadBox represents a box being read
+// Offset is the position of the box start in the file.
+type mp4ReadBox struct {
+	offset  int
+	payload []byte
+	typ     string
+}
+
+// mp4ReadBoxes splits a payload located at offset in the file into boxes
+func mp4ReadBoxes(b []byte, offset int) (o []mp4ReadBox, err error) {
+	for i := 0; i < len(b); {
+		// Not enough data for the header
+		if len(b)-i < 8 {
+			err = fmt.Errorf("Invalid box header at offset %d", offset+i)
+			return
+		}
+
+		// Get size
+		var size, header = uint64(binary.BigEndian.Uint32(b[i:])), 8
+		switch size {
+		case 0:
+			size = uint64(len(b) - i)
+		case 1:
+			if len(b)-i < 16 {
+				err = fmt.Errorf("Invalid box header at offset %d", offset+i)
+				return
+			}
+			size, header = binary.BigEndian.Uint64(b[i+8:]), 16
+		}
+		if size < uint64(header) || size > uint64(len(b)-i) {
+			err = fmt.Errorf("Invalid box size %d at offset %d", size, offset+i)
+			return
+		}
+
+		// Add box
+		o = append(o, mp4ReadBox{offset: offset + i, payload: b[i+header : i+int(size)], typ: string(b[i+4 : i+8])})
+		i += int(size)
+	}
+	return
+}
+
+// mp4ReadChild returns the first box of a given type
+func mp4ReadChild(bs []mp4ReadBox, typ string) (b mp4ReadBox, ok bool) {
+	for _, b = range bs {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return mp4ReadBox{}, false
+}
+
+// mp4Cursor reads big endian fields out of a payload
+// Reading out of bounds returns zero values and records an error.
+type mp4Cursor struct {
+	b   []byte
+	err error
+	o   int
+}
+
+// next returns the next n bytes
+func (c *mp4Cursor) next(n int) []byte {
+	if c.err != nil || n < 0 || c.o+n > len(c.b) {
+		if c.err == nil {
+			c.err = fmt.Errorf("Reading %d bytes at offset %d out of %d failed", n, c.o, len(c.b))
+		}
+		return make([]byte, 8)
+	}
+	c.o += n
+	return c.b[c.o-n : c.o]
+}
+
+// uint8 reads an uint8
+func (c *mp4Cursor) uint8() uint8 {
+	return c.next(1)[0]
+}
+
+// uint16 reads an uint16
+func (c *mp4Cursor) uint16() uint16 {
+	return binary.BigEndian.Uint16(c.next(2))
+}
+
+// uint32 reads an uint32
+func (c *mp4Cursor) uint32() uint32 {
+	return binary.BigEndian.Uint32(c.next(4))
+}
+
+// uint64 reads an uint64
+func (c *mp4Cursor) uint64() uint64 {
+	return binary.BigEndian.Uint64(c.next(8))
+}
+
+// versionAndFlags reads a full box header
+func (c *mp4Cursor) versionAndFlags() (version uint8, flags uint32) {
+	version = c.uint8()
+	var b = c.next(3)
+	flags = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	return
+}
+
+// mp4Track represents a track being read
+type mp4Track struct {
+	codec                 string
+	config                []byte
+	decodeTime            uint64
+	defaultSampleDuration uint32
+	defaultSampleSize     uint32
+	id                    uint32
+	language              string
+	samples               []mp4ReadSample
+	timescale             uint32
+}
+
+// mp4ReadSample represents a sample being read
+type mp4ReadSample struct {
+	data      []byte
+	decodedAt uint64
+	duration  uint64
+}
+
+// duration converts a number of timescale units into a duration
+func (t *mp4Track) duration(ticks uint64) time.Duration {
+	if t.timescale == 0 {
+		return 0
+	}
+	return ratDuration(new(big.Rat).SetFrac(new(big.Int).SetUint64(ticks), big.NewInt(int64(t.timescale))))
+}
+
+// addSample adds a sample read at a given offset of the file
+func (t *mp4Track) addSample(b []byte, offset, size uint64, duration uint64) (err error) {
+	// The sum of offset and size may overflow
+	if offset > uint64(len(b)) || size > uint64(len(b))-offset {
+		err = fmt.Errorf("Sample at offset %d with size %d is out of bounds", offset, size)
+		return
+	}
+	t.samples = append(t.samples, mp4ReadSample{data: b[offset : offset+size], decodedAt: t.decodeTime, duration: duration})
+	t.decodeTime += duration
+	return
+}
+
+// readMP4 reads a .mp4 content preceded by the init segment whose path is provided, if any
+func readMP4(i io.Reader, initSegment string, trackID int) (o *Subtitles, err error) {
+	// No init segment
+	if initSegment == "" {
+		return ReadFromMP4(i, trackID)
+	}
+
+	// Open the init segment
+	var f *os.File
+	if f, err = os.Open(initSegment); err != nil {
+		err = errors.Wrapf(err, "opening %s failed", initSegment)
+		return
+	}
+	defer f.Close()
+
+	// Read
+	return ReadFromMP4(io.MultiReader(f, i), trackID)
+}
+
+// ReadFromMP4 parses the subtitles track of a .mp4 content
+// Both regular and fragmented MP4 files are supported as long as they contain their moov box, which means media
+// segments must be preceded by their init segment, and wvtt, stpp and tx3g tracks can be decoded. A zero track ID
+// selects the first subtitles track.
+func ReadFromMP4(i io.Reader, trackID int) (o *Subtitles, err error) {
+	// Read all
+	var b []byte
+	if b, err = ioutil.ReadAll(i); err != nil {
+		err = errors.Wrap(err, "reading all failed")
+		return
+	}
+
+	// Read top level boxes
+	var bs []mp4ReadBox
+	if bs, err = mp4ReadBoxes(b, 0); err != nil {
+		err = errors.Wrap(err, "reading boxes failed")
+		return
+	}
+
+	// Read moov
+	var moov, ok = mp4ReadChild(bs, "moov")
+	if !ok {
+		err = errors.New("No moov box found, the init segment may be missing, in which case it can be provided with Options.InitSegment")
+		return
+	}
+	var ts map[uint32]*mp4Track
+	var ids []uint32
+	if ts, ids, err = mp4ReadMoov(b, moov); err != nil {
+		err = errors.Wrap(err, "reading moov failed")
+		return
+	}
+
+	// Read moofs
+	for _, bx := range bs {
+		if bx.typ != "moof" {
+			continue
+		}
+		if err = mp4ReadMoof(b, bx, ts); err != nil {
+			err = errors.Wrapf(err, "reading moof at offset %d failed", bx.offset)
+			return
+		}
+	}
+
+	// Get track
+	var t *mp4Track
+	if trackID > 0 {
+		if t, ok = ts[uint32(trackID)]; !ok {
+			err = fmt.Errorf("Track %d doesn't exist", trackID)
+			return
+		} else if len(t.codec) == 0 {
+			err = fmt.Errorf("Track %d is not a supported subtitles track", trackID)
+			return
+		}
+	} else {
+		for _, id := range ids {
+			if len(ts[id].codec) > 0 {
+				t = ts[id]
+				break
+			}
+		}
+		if t == nil {
+			err = errors.New("No supported subtitles track found")
+			return
+		}
+	}
+
+	// Decode samples
+	switch t.codec {
+	case MP4CodecSTPP:
+		o, err = t.stpp()
+	case MP4CodecWVTT:
+		o, err = t.wvtt()
+	default:
+		o, err = t.tx3g()
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "decoding %s samples of track %d failed", t.codec, t.id)
+		return
+	}
+
+	// Add metadata
+	if len(t.language) > 0 && t.language != "und" {
+		o.Metadata = &Metadata{Language: t.language}
+		if mp4LanguageMapping.InA(t.language) {
+			o.Metadata.Language = mp4LanguageMapping.B(t.language).(string)
+		}
+	}
+
+	// Merge samples
+	o.Order()
+	o.removeDuplicates()
+	o.Unfragment()
+	return
+}
+
+// mp4ReadMoov reads the tracks of a moov box
+func mp4ReadMoov(b []byte, moov mp4ReadBox) (ts map[uint32]*mp4Track, ids []uint32, err error) {
+	// Read children
+	var bs []mp4ReadBox
+	if bs, err = mp4ReadBoxes(moov.payload, moov.offset+8); err != nil {
+		err = errors.Wrap(err, "reading boxes failed")
+		return
+	}
+
+	// Read tracks
+	ts = make(map[uint32]*mp4Track)
+	for _, bx := range bs {
+		if bx.typ != "trak" {
+			continue
+		}
+		var t *mp4Track
+		if t, err = mp4ReadTrak(b, bx); err != nil {
+			err = errors.Wrapf(err, "reading trak at offset %d failed", bx.offset)
+			return
+		}
+		ts[t.id] = t
+		ids = append(ids, t.id)
+	}
+
+	// Read track defaults
+	if mvex, ok := mp4ReadChild(bs, "mvex"); ok {
+		var cs []mp4ReadBox
+		if cs, err = mp4ReadBoxes(mvex.payload, mvex.offset+8); err != nil {
+			err = errors.Wrap(err, "reading mvex boxes failed")
+			return
+		}
+		for _, c := range cs {
+			if c.typ != "trex" {
+				continue
+			}
+			var cr = &mp4Cursor{b: c.payload}
+			cr.versionAndFlags()
+			var id = cr.uint32()
+			cr.uint32()
+			var duration, size = cr.uint32(), cr.uint32()
+			if cr.err != nil {
+				err = errors.Wrap(cr.err, "reading trex failed")
+				return
+			}
+			if t, ok := ts[id]; ok {
+				t.defaultSampleDuration, t.defaultSampleSize = duration, size
+			}
+		}
+	}
+	return
+}
+
+// mp4ReadPath returns the box located at a path of children
+func mp4ReadPath(bx mp4ReadBox, path ...string) (o mp4ReadBox, err error) {
+	o = bx
+	for _, p := range path {
+		var bs []mp4ReadBox
+		if bs, err = mp4ReadBoxes(o.payload, o.offset+8); err != nil {
+			err = errors.Wrapf(err, "reading boxes of %s failed", o.typ)
+			return
+		}
+		var ok bool
+		if o, ok = mp4ReadChild(bs, p); !ok {
+			err = fmt.Errorf("No %s box found", p)
+			return
+		}
+	}
+	return
+}
+
+// mp4ReadTrak reads a trak box and the samples described by its sample tables
+func mp4ReadTrak(b []byte, trak mp4ReadBox) (t *mp4Track, err error) {
+	// Get boxes
+	t = &mp4Track{}
+	var tkhd, mdhd, stbl mp4ReadBox
+	if tkhd, err = mp4ReadPath(trak, "tkhd"); err != nil {
+		return
+	}
+	if mdhd, err = mp4ReadPath(trak, "mdia", "mdhd"); err != nil {
+		return
+	}
+	if stbl, err = mp4ReadPath(trak, "mdia", "minf", "stbl"); err != nil {
+		return
+	}
+
+	// Read track ID
+	var c = &mp4Cursor{b: tkhd.payload}
+	if v, _ := c.versionAndFlags(); v == 1 {
+		c.next(16)
+	} else {
+		c.next(8)
+	}
+	t.id = c.uint32()
+	if c.err != nil {
+		err = errors.Wrap(c.err, "reading tkhd failed")
+		return
+	}
+
+	// Read timescale and language
+	c = &mp4Cursor{b: mdhd.payload}
+	if v, _ := c.versionAndFlags(); v == 1 {
+		c.next(16)
+		t.timescale = c.uint32()
+		c.next(8)
+	} else {
+		c.next(8)
+		t.timescale = c.uint32()
+		c.next(4)
+	}
+	var l = c.uint16()
+	if c.err != nil {
+		err = errors.Wrap(c.err, "reading mdhd failed")
+		return
+	}
+	t.language = string([]byte{byte(l>>10&0x1f) + 0x60, byte(l>>5&0x1f) + 0x60, byte(l&0x1f) + 0x60})
+
+	// Read sample entry
+	var stsd mp4ReadBox
+	if stsd, err = mp4ReadPath(stbl, "stsd"); err != nil {
+		return
+	}
+	if len(stsd.payload) < 8 {
+		err = errors.New("Invalid stsd")
+		return
+	}
+	var es []mp4ReadBox
+	if es, err = mp4ReadBoxes(stsd.payload[8:], stsd.offset+16); err != nil {
+		err = errors.Wrap(err, "reading sample entries failed")
+		return
+	}
+	if len(es) > 0 {
+		switch es[0].typ {
+		case MP4CodecSTPP, mp4CodecTX3G:
+			t.codec = es[0].typ
+		case MP4CodecWVTT:
+			t.codec = es[0].typ
+			if len(es[0].payload) >= 8 {
+				var cs []mp4ReadBox
+				if cs, err = mp4ReadBoxes(es[0].payload[8:], es[0].offset+16); err != nil {
+					err = errors.Wrap(err, "reading wvtt boxes failed")
+					return
+				}
+				if vttC, ok := mp4ReadChild(cs, "vttC"); ok {
+					t.config = vttC.payload
+				}
+			}
+		}
+	}
+
+	// Read sample tables
+	if err = t.readSampleTables(b, stbl); err != nil {
+		err = errors.Wrap(err, "reading sample tables failed")
+		return
+	}
+	return
+}
+
+// readSampleTables reads the samples described by the sample tables of a stbl box
+func (t *mp4Track) readSampleTables(b []byte, stbl mp4ReadBox) (err error) {
+	// Get boxes
+	var bs []mp4ReadBox
+	if bs, err = mp4ReadBoxes(stbl.payload, stbl.offset+8); err != nil {
+		err = errors.Wrap(err, "reading boxes failed")
+		return
+	}
+
+	// Read sizes
+	var sizes []uint64
+	if stsz, ok := mp4ReadChild(bs, "stsz"); ok {
+		var c = &mp4Cursor{b: stsz.payload}
+		c.versionAndFlags()
+		var size, count = c.uint32(), c.uint32()
+		if size > 0 && uint64(size)*uint64(count) > uint64(len(b)) {
+			err = fmt.Errorf("Samples of size %d can't fit %d times in the file", size, count)
+			return
+		}
+		for idx := uint32(0); idx < count && c.err == nil; idx++ {
+			if size > 0 {
+				sizes = append(sizes, uint64(size))
+			} else {
+				sizes = append(sizes, uint64(c.uint32()))
+			}
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading stsz failed")
+			return
+		}
+	}
+
+	// Fragmented files have no samples in their sample tables
+	if len(sizes) == 0 {
+		return
+	}
+
+	// Read durations
+	// Sample counts can't be trusted and are bounded by the number of sizes
+	var durations []uint64
+	if stts, ok := mp4ReadChild(bs, "stts"); ok {
+		var c = &mp4Cursor{b: stts.payload}
+		c.versionAndFlags()
+		for n := c.uint32(); n > 0 && c.err == nil; n-- {
+			var count, delta = c.uint32(), c.uint32()
+			for ; count > 0 && len(durations) < len(sizes) && c.err == nil; count-- {
+				durations = append(durations, uint64(delta))
+			}
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading stts failed")
+			return
+		}
+	}
+
+	// Read chunk offsets
+	var offsets []uint64
+	if stco, ok := mp4ReadChild(bs, "stco"); ok {
+		var c = &mp4Cursor{b: stco.payload}
+		c.versionAndFlags()
+		for n := c.uint32(); n > 0 && c.err == nil; n-- {
+			offsets = append(offsets, uint64(c.uint32()))
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading stco failed")
+			return
+		}
+	} else if co64, ok := mp4ReadChild(bs, "co64"); ok {
+		var c = &mp4Cursor{b: co64.payload}
+		c.versionAndFlags()
+		for n := c.uint32(); n > 0 && c.err == nil; n-- {
+			offsets = append(offsets, c.uint64())
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading co64 failed")
+			return
+		}
+	}
+
+	// Read samples per chunk
+	type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+	var entries []stscEntry
+	if stsc, ok := mp4ReadChild(bs, "stsc"); ok {
+		var c = &mp4Cursor{b: stsc.payload}
+		c.versionAndFlags()
+		for n := c.uint32(); n > 0 && c.err == nil; n-- {
+			var e = stscEntry{firstChunk: c.uint32(), samplesPerChunk: c.uint32()}
+			c.uint32()
+			entries = append(entries, e)
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading stsc failed")
+			return
+		}
+	}
+
+	// Loop through chunks
+	var idx int
+	for chunk := range offsets {
+		// Get number of samples
+		var n uint32
+		for _, e := range entries {
+			if uint32(chunk+1) >= e.firstChunk {
+				n = e.samplesPerChunk
+			}
+		}
+
+		// Add samples
+		var offset = offsets[chunk]
+		for ; n > 0 && idx < len(durations) && idx < len(sizes); n-- {
+			if err = t.addSample(b, offset, sizes[idx], durations[idx]); err != nil {
+				err = errors.Wrapf(err, "adding sample %d failed", idx)
+				return
+			}
+			offset += sizes[idx]
+			idx++
+		}
+	}
+	return
+}
+
+// mp4ReadMoof reads the samples described by the track fragments of a moof box
+func mp4ReadMoof(b []byte, moof mp4ReadBox, ts map[uint32]*mp4Track) (err error) {
+	// Get boxes
+	var bs []mp4ReadBox
+	if bs, err = mp4ReadBoxes(moof.payload, moof.offset+8); err != nil {
+		err = errors.Wrap(err, "reading boxes failed")
+		return
+	}
+
+	// Loop through track fragments
+	for _, traf := range bs {
+		if traf.typ != "traf" {
+			continue
+		}
+		if err = mp4ReadTraf(b, moof, traf, ts); err != nil {
+			err = errors.Wrapf(err, "reading traf at offset %d failed", traf.offset)
+			return
+		}
+	}
+	return
+}
+
+// mp4ReadTraf reads the samples described by a traf box
+func mp4ReadTraf(b []byte, moof, traf mp4ReadBox, ts map[uint32]*mp4Track) (err error) {
+	// Get boxes
+	var bs []mp4ReadBox
+	if bs, err = mp4ReadBoxes(traf.payload, traf.offset+8); err != nil {
+		err = errors.Wrap(err, "reading boxes failed")
+		return
+	}
+
+	// Read header
+	var tfhd, ok = mp4ReadChild(bs, "tfhd")
+	if !ok {
+		err = errors.New("No tfhd box found")
+		return
+	}
+	var c = &mp4Cursor{b: tfhd.payload}
+	var _, flags = c.versionAndFlags()
+	var t *mp4Track
+	if t, ok = ts[c.uint32()]; !ok {
+		return
+	}
+	var baseDataOffset = uint64(moof.offset)
+	if flags&mp4FlagTFHDBaseDataOffsetPresent > 0 {
+		baseDataOffset = c.uint64()
+	}
+	if flags&mp4FlagTFHDSampleDescriptionIndexPresent > 0 {
+		c.uint32()
+	}
+	var defaultSampleDuration, defaultSampleSize = t.defaultSampleDuration, t.defaultSampleSize
+	if flags&mp4FlagTFHDDefaultSampleDurationPresent > 0 {
+		defaultSampleDuration = c.uint32()
+	}
+	if flags&mp4FlagTFHDDefaultSampleSizePresent > 0 {
+		defaultSampleSize = c.uint32()
+	}
+	if c.err != nil {
+		err = errors.Wrap(c.err, "reading tfhd failed")
+		return
+	}
+
+	// Read decode time
+	if tfdt, ok := mp4ReadChild(bs, "tfdt"); ok {
+		c = &mp4Cursor{b: tfdt.payload}
+		if v, _ := c.versionAndFlags(); v == 1 {
+			t.decodeTime = c.uint64()
+		} else {
+			t.decodeTime = uint64(c.uint32())
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading tfdt failed")
+			return
+		}
+	}
+
+	// Loop through track runs
+	var offset = baseDataOffset
+	for _, trun := range bs {
+		if trun.typ != "trun" {
+			continue
+		}
+
+		// Read header
+		c = &mp4Cursor{b: trun.payload}
+		var _, flags = c.versionAndFlags()
+		var count = c.uint32()
+		if flags&mp4FlagTRUNDataOffsetPresent > 0 {
+			offset = uint64(int64(baseDataOffset) + int64(int32(c.uint32())))
+		}
+		if flags&mp4FlagTRUNFirstSampleFlagsPresent > 0 {
+			c.uint32()
+		}
+
+		// Loop through samples
+		for idx := uint32(0); idx < count && c.err == nil; idx++ {
+			var duration, size = defaultSampleDuration, defaultSampleSize
+			if flags&mp4FlagTRUNSampleDurationPresent > 0 {
+				duration = c.uint32()
+			}
+			if flags&mp4FlagTRUNSampleSizePresent > 0 {
+				size = c.uint32()
+			}
+			if flags&mp4FlagTRUNSampleFlagsPresent > 0 {
+				c.uint32()
+			}
+			if flags&mp4FlagTRUNSampleCompositionTimePresent > 0 {
+				c.uint32()
+			}
+			if c.err != nil {
+				break
+			}
+			if err = t.addSample(b, offset, uint64(size), uint64(duration)); err != nil {
+				err = errors.Wrapf(err, "adding sample %d failed", idx)
+				return
+			}
+			offset += uint64(size)
+		}
+		if c.err != nil {
+			err = errors.Wrap(c.err, "reading trun failed")
+			return
+		}
+	}
+	return
+}
+
+// wvtt decodes wvtt samples
+// The track config is a .vtt header holding the regions cues may refer to.
+func (t *mp4Track) wvtt() (o *Subtitles, err error) {
+	// Read header
+	if o, err = ReadFromWebVTT(bytes.NewReader(t.config)); err != nil {
+		err = errors.Wrap(err, "reading webvtt header failed")
+		return
+	}
+
+	// Loop through samples
+	for _, s := range t.samples {
+		// Read boxes
+		var bs []mp4ReadBox
+		if bs, err = mp4ReadBoxes(s.data, 0); err != nil {
+			err = errors.Wrap(err, "reading sample boxes failed")
+			return
+		}
+
+		// Loop through cues
+		for _, vttc := range bs {
+			if vttc.typ != "vttc" {
+				continue
+			}
+			var cs []mp4ReadBox
+			if cs, err = mp4ReadBoxes(vttc.payload, 0); err != nil {
+				err = errors.Wrap(err, "reading vttc boxes failed")
+				return
+			}
+
+			// Create item
+			var i = &Item{
+				EndAt:       t.duration(s.decodedAt + s.duration),
+				InlineStyle: &StyleAttributes{},
+				StartAt:     t.duration(s.decodedAt),
+			}
+
+			// Parse settings
+			var settings []string
+			if sttg, ok := mp4ReadChild(cs, "sttg"); ok {
+				settings = strings.Fields(string(sttg.payload))
+			}
+			parseWebVTTCueSettings(i, settings, o.Regions)
+
+			// Add lines
+			if payl, ok := mp4ReadChild(cs, "payl"); ok {
+				if text := strings.TrimSpace(strings.Replace(string(payl.payload), "\r\n", "\n", -1)); len(text) > 0 {
+					for _, l := range strings.Split(text, "\n") {
+						i.Lines = append(i.Lines, Line{{Text: l}})
+					}
+				}
+			}
+			o.Items = append(o.Items, i)
+		}
+	}
+	return
+}
+
+// stpp decodes stpp samples
+// Each sample is a TTML document whose times are expressed in the track timeline.
+func (t *mp4Track) stpp() (o *Subtitles, err error) {
+	o = NewSubtitles()
+	for idx, s := range t.samples {
+		var ss *Subtitles
+		if ss, err = ReadFromTTML(bytes.NewReader(s.data)); err != nil {
+			err = errors.Wrapf(err, "reading ttml of sample %d failed", idx)
+			return
+		}
+		o.Merge(ss)
+	}
+	return
+}
+
+// tx3g decodes tx3g samples
+// Each sample is made of a 16 bits text length followed by the text, modifier boxes being ignored.
+func (t *mp4Track) tx3g() (o *Subtitles, err error) {
+	o = NewSubtitles()
+	for idx, s := range t.samples {
+		// Get text
+		var c = &mp4Cursor{b: s.data}
+		var text = string(c.next(int(c.uint16())))
+		if c.err != nil {
+			err = errors.Wrapf(c.err, "reading text of sample %d failed", idx)
+			return
+		}
+
+		// Empty sample
+		if text = strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1)); len(text) == 0 {
+			continue
+		}
+
+		// Add item
+		var i = &Item{EndAt: t.duration(s.decodedAt + s.duration), StartAt: t.duration(s.decodedAt)}
+		for _, l := range strings.Split(text, "\n") {
+			i.Lines = append(i.Lines, Line{{Text: l}})
+		}
+		o.Items = append(o.Items, i)
+	}
+	return
+}
+
+// MP4Options represents fragmented MP4 write options
+// Codec defaults to wvtt, Timescale to 1000 and TrackID to 1. A zero FragmentDuration leads to a single fragment.
+type MP4Options struct {
+	Codec            string
+	FragmentDuration time.Duration
+	Timescale        uint32
+	TrackID          uint32
+	TTML             TTMLOptions
+}
+
+// defaults returns the options with default values for missing ones
+func (o MP4Options) defaults() MP4Options {
+	if len(o.Codec) == 0 {
+		o.Codec = MP4CodecWVTT
+	}
+	if o.Timescale == 0 {
+		o.Timescale = mp4DefaultTimescale
+	}
+	if o.TrackID == 0 {
+		o.TrackID = mp4DefaultTrackID
+	}
+	return o
+}
+
+// ticks converts a duration into a number of timescale units
+func (o MP4Options) ticks(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64(divRound(new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(o.Timescale))), bigIntNanosecondsPerSecond))
+}
+
+// MP4Segments represents the init segment and the media segments of a fragmented MP4 subtitles track
+type MP4Segments struct {
+	Init     []byte
+	Segments []*MP4Segment
+}
+
+// MP4Segment represents a fragmented MP4 media segment
+type MP4Segment struct {
+	Data     []byte
+	Duration time.Duration
+	StartAt  time.Duration
+}
+
+// SegmentMP4 packages subtitles as a fragmented MP4 subtitles track
+// Media segments are cut on Fragment boundaries: each of them contains one movie fragment whose samples cover
+// the whole segment duration.
+func (s Subtitles) SegmentMP4(opts ...MP4Options) (o *MP4Segments, err error) {
+	// Images are not supported
+	if err = s.textOnly("mp4"); err != nil {
+		return
+	}
+
+	// Get options
+	var opt MP4Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt = opt.defaults()
+
+	// Validate codec
+	if opt.Codec != MP4CodecSTPP && opt.Codec != MP4CodecWVTT {
+		err = fmt.Errorf("Invalid codec %s", opt.Codec)
+		return
+	}
+
+	// Create init segment
+	o = &MP4Segments{}
+	if o.Init, err = s.mp4InitSegment(opt); err != nil {
+		err = errors.Wrap(err, "creating init segment failed")
+		return
+	}
+
+	// Get segments
+	var d = s.ordered().Duration()
+	var fd = opt.FragmentDuration
+	if fd <= 0 {
+		fd = d
+	}
+	var ss []*Subtitles
+	if fd > 0 {
+		ss = s.segment(fd)
+	}
+
+	// Loop through segments
+	for idx, sg := range ss {
+		// Init segment
+		var g = &MP4Segment{
+			Duration: fd,
+			StartAt:  time.Duration(idx) * fd,
+		}
+		if g.StartAt+fd > d {
+			g.Duration = d - g.StartAt
+		}
+
+		// Create samples
+		var samples []mp4Sample
+		switch opt.Codec {
+		case MP4CodecSTPP:
+			samples, err = sg.mp4STPPSamples(g.StartAt, g.StartAt+g.Duration, opt)
+		default:
+			samples, err = sg.mp4WVTTSamples(g.StartAt, g.StartAt+g.Duration, opt)
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "creating samples of segment %d failed", idx)
+			return
+		}
+
+		// Create media segment
+		g.Data = mp4MediaSegment(uint32(idx+1), opt.ticks(g.StartAt), samples, opt)
+		o.Segments = append(o.Segments, g)
+	}
+	return
+}
+
+// Write writes the init segment and the media segments next to dst
+// Files are named after dst: "subtitles.mp4" leads to "subtitles_init.mp4", "subtitles_0.m4s", "subtitles_1.m4s", etc.
+func (s MP4Segments) Write(dst string) (err error) {
+	// Get prefix
+	var prefix = strings.TrimSuffix(dst, filepath.Ext(dst))
+
+	// Write init segment
+	if err = writeFile(prefix+"_init.mp4", s.Init); err != nil {
+		err = errors.Wrap(err, "writing init segment failed")
+		return
+	}
+
+	// Write media segments
+	for idx, g := range s.Segments {
+		if err = writeFile(fmt.Sprintf("%s_%d.m4s", prefix, idx), g.Data); err != nil {
+			err = errors.Wrapf(err, "writing media segment %d failed", idx)
+			return
+		}
+	}
+	return
+}
+
+// writeFile creates a file and writes data into it
+func writeFile(dst string, data []byte) (err error) {
+	// Create the file
+	var f *os.File
+	if f, err = os.Create(dst); err != nil {
+		err = errors.Wrapf(err, "creating %s failed", dst)
+		return
+	}
+	defer f.Close()
+
+	// Write
+	if _, err = f.Write(data); err != nil {
+		err = errors.Wrapf(err, "writing to %s failed", dst)
+		return
+	}
+	return
+}
+
+// WriteToMP4 writes subtitles as a single fragmented MP4 file: the init segment followed by every media segment
+func (s Subtitles) WriteToMP4(o io.Writer, opts ...MP4Options) (err error) {
+	// Do not write anything if no subtitles
+	if len(s.Items) == 0 {
+		err = ErrNoSubtitlesToWrite
+		return
+	}
+
+	// Segment
+	var g *MP4Segments
+	if g, err = s.SegmentMP4(opts...); err != nil {
+		err = errors.Wrap(err, "segmenting failed")
+		return
+	}
+
+	// Write
+	var c = g.Init
+	for _, sg := range g.Segments {
+		c = append(c, sg.Data...)
+	}
+	if _, err = o.Write(c); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
+
+// mp4Sample represents a fragmented MP4 sample
+type mp4Sample struct {
+	data     []byte
+	duration uint32
+}
+
+// mp4WVTTSamples creates the wvtt samples covering [startAt, endAt)
+// A new sample starts each time the set of active cues changes, gaps being filled with empty cue samples.
+func (s Subtitles) mp4WVTTSamples(startAt, endAt time.Duration, opt MP4Options) (o []mp4Sample, err error) {
+	// Get boundaries
+	var bs = []time.Duration{startAt, endAt}
+	for _, i := range s.Items {
+		for _, b := range []time.Duration{i.StartAt, i.EndAt} {
+			if b > startAt && b < endAt {
+				bs = append(bs, b)
+			}
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i] < bs[j] })
+
+	// Loop through boundaries
+	for idx := 1; idx < len(bs); idx++ {
+		// Get duration
+		var d = opt.ticks(bs[idx]) - opt.ticks(bs[idx-1])
+		if d == 0 {
+			continue
+		}
+
+		// Add active cues
+		var data []byte
+		for _, i := range s.Items {
+			if i.StartAt <= bs[idx-1] && i.EndAt >= bs[idx] {
+				data = append(data, mp4VTTCBox(i)...)
+			}
+		}
+
+		// Add empty cue
+		if len(data) == 0 {
+			data = mp4Box("vtte")
+		}
+		o = append(o, mp4Sample{data: data, duration: uint32(d)})
+	}
+	return
+}
+
+// mp4VTTCBox creates the vttc box of an item
+func mp4VTTCBox(i *Item) []byte {
+	var ps [][]byte
+	if ss := webvttItemSettings(i); len(ss) > 0 {
+		ps = append(ps, mp4Box("sttg", []byte(strings.Join(ss, " "))))
+	}
+	var ls []string
+	for _, l := range i.Lines {
+		ls = append(ls, l.String())
+	}
+	ps = append(ps, mp4Box("payl", []byte(strings.Join(ls, "\n"))))
+	return mp4Box("vttc", ps...)
+}
+
+// mp4STPPSamples creates the stpp sample covering [startAt, endAt): a single TTML document
+func (s Subtitles) mp4STPPSamples(startAt, endAt time.Duration, opt MP4Options) (o []mp4Sample, err error) {
+	// Write TTML
+	var buf = &bytes.Buffer{}
+	if err = s.writeTTML(buf, opt.TTML); err != nil {
+		err = errors.Wrap(err, "writing ttml failed")
+		return
+	}
+
+	// Add sample
+	o = append(o, mp4Sample{data: buf.Bytes(), duration: uint32(opt.ticks(endAt) - opt.ticks(startAt))})
+	return
+}
+
+// mp4InitSegment creates the init segment
+func (s Subtitles) mp4InitSegment(opt MP4Options) (o []byte, err error) {
+	// Get language
+	var l = "und"
+	if s.Metadata != nil {
+		if mp4LanguageMapping.InB(s.Metadata.Language) {
+			l = mp4LanguageMapping.A(s.Metadata.Language).(string)
+		} else if len(s.Metadata.Language) == 3 {
+			l = s.Metadata.Language
+		}
+	}
+
+	// Get codec specific boxes
+	var handlerType, sampleEntry, mediaHeader []byte
+	switch opt.Codec {
+	case MP4CodecSTPP:
+		handlerType = []byte(mp4HandlerTypeSubtitle)
+		mediaHeader = mp4FullBox("sthd", 0, 0)
+		sampleEntry = mp4Box("stpp", make([]byte, 6), mp4Fields(uint16(1)), []byte("http://www.w3.org/ns/ttml\x00\x00\x00"))
+	default:
+		// Config is the .vtt header
+		var h []byte
+		if h, err = s.webvttHeader(); err != nil {
+			err = errors.Wrap(err, "creating webvtt header failed")
+			return
+		}
+		handlerType = []byte(mp4HandlerTypeText)
+		mediaHeader = mp4FullBox("nmhd", 0, 0)
+		sampleEntry = mp4Box("wvtt", make([]byte, 6), mp4Fields(uint16(1)), mp4Box("vttC", h))
+	}
+
+	// Create boxes
+	o = append(mp4Box("ftyp", []byte("iso6"), mp4Fields(uint32(0)), []byte("iso6cmfc")),
+		mp4Box("moov",
+			mp4FullBox("mvhd", 0, 0, mp4Fields(uint32(0), uint32(0), opt.Timescale, uint32(0), uint32(0x10000), uint16(0x100), uint16(0), uint32(0), uint32(0), mp4Matrix, make([]byte, 24), opt.TrackID+1)),
+			mp4Box("trak",
+				mp4FullBox("tkhd", 0, mp4FlagTKHDEnabledInMovie, mp4Fields(uint32(0), uint32(0), opt.TrackID, uint32(0), uint32(0), make([]byte, 8), uint16(0), uint16(0), uint16(0), uint16(0), mp4Matrix, uint32(0), uint32(0))),
+				mp4Box("mdia",
+					mp4FullBox("mdhd", 0, 0, mp4Fields(uint32(0), uint32(0), opt.Timescale, uint32(0), mp4Language(l), uint16(0))),
+					mp4FullBox("hdlr", 0, 0, mp4Fields(uint32(0), handlerType, make([]byte, 12), []byte("astisub\x00"))),
+					mp4Box("minf",
+						mediaHeader,
+						mp4Box("dinf", mp4FullBox("dref", 0, 0, mp4Fields(uint32(1)), mp4FullBox("url ", 0, mp4FlagDataReferenceSelfContained))),
+						mp4Box("stbl",
+							mp4FullBox("stsd", 0, 0, mp4Fields(uint32(1)), sampleEntry),
+							mp4FullBox("stts", 0, 0, mp4Fields(uint32(0))),
+							mp4FullBox("stsc", 0, 0, mp4Fields(uint32(0))),
+							mp4FullBox("stsz", 0, 0, mp4Fields(uint32(0), uint32(0))),
+							mp4FullBox("stco", 0, 0, mp4Fields(uint32(0))),
+						),
+					),
+				),
+			),
+			mp4Box("mvex", mp4FullBox("trex", 0, 0, mp4Fields(opt.TrackID, uint32(1), uint32(0), uint32(0), uint32(0)))),
+		)...)
+	return
+}
+
+// mp4MediaSegment creates a media segment made of one movie fragment
+func mp4MediaSegment(sequenceNumber uint32, baseMediaDecodeTime uint64, samples []mp4Sample, opt MP4Options) []byte {
+	// Get mdat
+	var data [][]byte
+	for _, s := range samples {
+		data = append(data, s.data)
+	}
+	var mdat = mp4Box("mdat", data...)
+
+	// Get moof
+	var moof = func(dataOffset int32) []byte {
+		var entries = []interface{}{uint32(len(samples)), dataOffset}
+		for _, s := range samples {
+			entries = append(entries, s.duration, uint32(len(s.data)))
+		}
+		return mp4Box("moof",
+			mp4FullBox("mfhd", 0, 0, mp4Fields(sequenceNumber)),
+			mp4Box("traf",
+				mp4FullBox("tfhd", 0, mp4FlagTFHDDefaultBaseIsMoof, mp4Fields(opt.TrackID)),
+				mp4FullBox("tfdt", 1, 0, mp4Fields(baseMediaDecodeTime)),
+				mp4FullBox("trun", 0, mp4FlagTRUNDataOffsetPresent|mp4FlagTRUNSampleDurationPresent|mp4FlagTRUNSampleSizePresent, mp4Fields(entries...)),
+			),
+		)
+	}
+
+	// Samples start right after the mdat header
+	var m = moof(0)
+	m = moof(int32(len(m) + 8))
+	return append(append(mp4Box("styp", []byte("msdh"), mp4Fields(uint32(0)), []byte("msdhmsix")), m...), mdat...)
+}
+
+// mp4Box creates a box
+func mp4Box(typ string, payloads ...[]byte) []byte {
+	var size = 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	var o = make([]byte, 8, size)
+	binary.BigEndian.PutUint32(o, uint32(size))
+	copy(o[4:], typ)
+	for _, p := range payloads {
+		o = append(o, p...)
+	}
+	return o
+}
+
+// mp4FullBox creates a full box
+func mp4FullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	return mp4Box(typ, append([][]byte{{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}}, payloads...)...)
+}
+
+// mp4Fields serializes fields in big endian
+// Fields are either fixed-size values or byte slices
+func mp4Fields(fields ...interface{}) []byte {
+	var buf = &bytes.Buffer{}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case []byte:
+			buf.Write(v)
+		default:
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+// mp4Language packs an ISO 639-2/T code on 15 bits
+func mp4Language(i string) (o uint16) {
+	if len(i) != 3 {
+		i = "und"
+	}
+	for idx := 0; idx < 3; idx++ {
+		o = o<<5 | uint16(i[idx]-0x60)&0x1f
+	}
+	return
+}
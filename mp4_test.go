@@ -0,0 +1,271 @@
+package astisub_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// mp4Boxes splits an ISO BMFF payload into boxes
+func mp4Boxes(t *testing.T, b []byte) (types []string, payloads map[string][]byte) {
+	payloads = make(map[string][]byte)
+	for len(b) > 0 {
+		if !assert.True(t, len(b) >= 8) {
+			return
+		}
+		var size = int(binary.BigEndian.Uint32(b))
+		if !assert.True(t, size >= 8 && size <= len(b)) {
+			return
+		}
+		types = append(types, string(b[4:8]))
+		payloads[string(b[4:8])] = b[8:size]
+		b = b[size:]
+	}
+	return
+}
+
+// mp4Box returns the payload of a box located at a path
+func mp4Box(t *testing.T, b []byte, path ...string) []byte {
+	for _, p := range path {
+		// Full box headers and sample entry headers are skipped
+		var skip int
+		switch p {
+		case "dref", "stpp", "stsd", "wvtt":
+			skip = 8
+		}
+		_, ps := mp4Boxes(t, b)
+		b = ps[p][skip:]
+	}
+	return b
+}
+
+func TestSegmentMP4(t *testing.T) {
+	// Init
+	s := &astisub.Subtitles{
+		Items: []*astisub.Item{
+			{EndAt: 2 * time.Second, Lines: []astisub.Line{{{Text: "1"}}}, StartAt: time.Second},
+			{EndAt: 6 * time.Second, InlineStyle: &astisub.StyleAttributes{Align: astisub.TextAlignLeft}, Lines: []astisub.Line{{{Text: "2"}}, {{Text: "3"}}}, StartAt: 3 * time.Second},
+		},
+		Metadata: &astisub.Metadata{Language: astisub.LanguageFrench},
+	}
+
+	// Invalid codec
+	_, err := s.SegmentMP4(astisub.MP4Options{Codec: "invalid"})
+	assert.Error(t, err)
+
+	// wvtt
+	g, err := s.SegmentMP4(astisub.MP4Options{FragmentDuration: 4 * time.Second})
+	assert.NoError(t, err)
+	types, _ := mp4Boxes(t, g.Init)
+	assert.Equal(t, []string{"ftyp", "moov"}, types)
+	types, _ = mp4Boxes(t, mp4Box(t, g.Init, "moov"))
+	assert.Equal(t, []string{"mvhd", "trak", "mvex"}, types)
+	mdhd := mp4Box(t, g.Init, "moov", "trak", "mdia", "mdhd")
+	assert.Equal(t, uint32(1000), binary.BigEndian.Uint32(mdhd[12:]))
+	assert.Equal(t, uint16(('f'-0x60)<<10|('r'-0x60)<<5|('a'-0x60)), binary.BigEndian.Uint16(mdhd[20:]))
+	assert.Equal(t, []byte("text"), mp4Box(t, g.Init, "moov", "trak", "mdia", "hdlr")[8:12])
+	assert.Equal(t, []byte("WEBVTT"), mp4Box(t, g.Init, "moov", "trak", "mdia", "minf", "stbl", "stsd", "wvtt")[8:])
+	assert.Equal(t, 2, len(g.Segments))
+	assert.Equal(t, []time.Duration{0, 4 * time.Second}, []time.Duration{g.Segments[0].StartAt, g.Segments[1].StartAt})
+	assert.Equal(t, []time.Duration{4 * time.Second, 2 * time.Second}, []time.Duration{g.Segments[0].Duration, g.Segments[1].Duration})
+
+	// First media segment
+	types, ps := mp4Boxes(t, g.Segments[0].Data)
+	assert.Equal(t, []string{"styp", "moof", "mdat"}, types)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(mp4Box(t, ps["moof"], "mfhd")[4:]))
+	assert.Equal(t, uint64(0), binary.BigEndian.Uint64(mp4Box(t, ps["moof"], "traf", "tfdt")[4:]))
+	trun := mp4Box(t, ps["moof"], "traf", "trun")
+	assert.Equal(t, uint32(4), binary.BigEndian.Uint32(trun[4:]))
+	assert.Equal(t, uint32(len(ps["moof"])+16), binary.BigEndian.Uint32(trun[8:]))
+	var durations []uint32
+	var samples [][]byte
+	var offset = 0
+	for idx := 0; idx < 4; idx++ {
+		durations = append(durations, binary.BigEndian.Uint32(trun[12+8*idx:]))
+		size := int(binary.BigEndian.Uint32(trun[16+8*idx:]))
+		samples = append(samples, ps["mdat"][offset:offset+size])
+		offset += size
+	}
+	assert.Equal(t, len(ps["mdat"]), offset)
+	assert.Equal(t, []uint32{1000, 1000, 1000, 1000}, durations)
+	types, _ = mp4Boxes(t, samples[0])
+	assert.Equal(t, []string{"vtte"}, types)
+	assert.Equal(t, []byte("1"), mp4Box(t, samples[1], "vttc", "payl"))
+	types, _ = mp4Boxes(t, samples[2])
+	assert.Equal(t, []string{"vtte"}, types)
+	assert.Equal(t, []byte("align:left"), mp4Box(t, samples[3], "vttc", "sttg"))
+	assert.Equal(t, []byte("2\n3"), mp4Box(t, samples[3], "vttc", "payl"))
+
+	// Second media segment
+	_, ps = mp4Boxes(t, g.Segments[1].Data)
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(mp4Box(t, ps["moof"], "mfhd")[4:]))
+	assert.Equal(t, uint64(4000), binary.BigEndian.Uint64(mp4Box(t, ps["moof"], "traf", "tfdt")[4:]))
+	trun = mp4Box(t, ps["moof"], "traf", "trun")
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(trun[4:]))
+	assert.Equal(t, uint32(2000), binary.BigEndian.Uint32(trun[12:]))
+	assert.Equal(t, []byte("2\n3"), mp4Box(t, ps["mdat"], "vttc", "payl"))
+
+	// stpp
+	g, err = s.SegmentMP4(astisub.MP4Options{Codec: astisub.MP4CodecSTPP, FragmentDuration: 4 * time.Second, Timescale: 90000, TrackID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("subt"), mp4Box(t, g.Init, "moov", "trak", "mdia", "hdlr")[8:12])
+	assert.Equal(t, []byte("http://www.w3.org/ns/ttml\x00\x00\x00"), mp4Box(t, g.Init, "moov", "trak", "mdia", "minf", "stbl", "stsd", "stpp"))
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(mp4Box(t, g.Init, "moov", "mvex", "trex")[4:]))
+	_, ps = mp4Boxes(t, g.Segments[1].Data)
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(mp4Box(t, ps["moof"], "traf", "tfhd")[4:]))
+	assert.Equal(t, uint64(360000), binary.BigEndian.Uint64(mp4Box(t, ps["moof"], "traf", "tfdt")[4:]))
+	trun = mp4Box(t, ps["moof"], "traf", "trun")
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(trun[4:]))
+	assert.Equal(t, uint32(180000), binary.BigEndian.Uint32(trun[12:]))
+	assert.Equal(t, uint32(len(ps["mdat"])), binary.BigEndian.Uint32(trun[16:]))
+	assert.Contains(t, string(ps["mdat"]), `begin="00:00:04.000" end="00:00:06.000"`)
+	ts, err := astisub.ReadFromTTML(bytes.NewReader(ps["mdat"]))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ts.Items))
+
+	// Single file
+	w := &bytes.Buffer{}
+	err = s.WriteToMP4(w)
+	assert.NoError(t, err)
+	types, _ = mp4Boxes(t, w.Bytes())
+	assert.Equal(t, []string{"ftyp", "moov", "styp", "moof", "mdat"}, types)
+
+	// Open media segments
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = g.Write(filepath.Join(dir, "subtitles.mp4"))
+	assert.NoError(t, err)
+	r, err := astisub.Open(astisub.Options{InitSegment: filepath.Join(dir, "subtitles_init.mp4"), Src: filepath.Join(dir, "subtitles_1.m4s")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(r.Items))
+	assert.Equal(t, 4*time.Second, r.Items[0].StartAt)
+	assert.Equal(t, "2 - 3", r.Items[0].String())
+	_, err = astisub.Open(astisub.Options{Src: filepath.Join(dir, "subtitles_1.m4s")})
+	assert.Error(t, err)
+	_, err = astisub.Open(astisub.Options{InitSegment: filepath.Join(dir, "missing.mp4"), Src: filepath.Join(dir, "subtitles_1.m4s")})
+	assert.Error(t, err)
+}
+
+// mp4TestBox builds a box
+func mp4TestBox(typ string, payloads ...[]byte) []byte {
+	var p = bytes.Join(payloads, nil)
+	var b = make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(len(p)+8))
+	copy(b[4:], typ)
+	return append(b, p...)
+}
+
+// mp4TestUint32s serializes uint32s in big endian
+func mp4TestUint32s(is ...uint32) []byte {
+	var b = make([]byte, 4*len(is))
+	for idx, i := range is {
+		binary.BigEndian.PutUint32(b[4*idx:], i)
+	}
+	return b
+}
+
+func TestReadFromMP4(t *testing.T) {
+	// Init
+	s := &astisub.Subtitles{
+		Items: []*astisub.Item{
+			{EndAt: 2 * time.Second, Lines: []astisub.Line{{{Text: "1"}}}, StartAt: time.Second},
+			{EndAt: 6 * time.Second, InlineStyle: &astisub.StyleAttributes{Align: astisub.TextAlignLeft}, Lines: []astisub.Line{{{Text: "2"}}, {{Text: "3"}}}, StartAt: 3 * time.Second},
+		},
+		Metadata: &astisub.Metadata{Language: astisub.LanguageFrench},
+	}
+
+	// Loop through codecs
+	for _, c := range []string{astisub.MP4CodecSTPP, astisub.MP4CodecWVTT} {
+		w := &bytes.Buffer{}
+		err := s.WriteToMP4(w, astisub.MP4Options{Codec: c, FragmentDuration: 4 * time.Second, Timescale: 90000, TrackID: 2})
+		assert.NoError(t, err)
+		r, err := astisub.ReadFromMP4(bytes.NewReader(w.Bytes()), 0)
+		assert.NoError(t, err, c)
+		assert.Equal(t, astisub.LanguageFrench, r.Metadata.Language)
+		assert.Equal(t, 2, len(r.Items), c)
+		for idx, i := range s.Items {
+			assert.Equal(t, i.StartAt, r.Items[idx].StartAt, c)
+			assert.Equal(t, i.EndAt, r.Items[idx].EndAt, c)
+			assert.Equal(t, i.String(), r.Items[idx].String(), c)
+		}
+		if c == astisub.MP4CodecWVTT {
+			assert.Equal(t, astisub.TextAlignLeft, r.Items[1].InlineStyle.Align)
+		}
+		_, err = astisub.ReadFromMP4(bytes.NewReader(w.Bytes()), 3)
+		assert.Error(t, err)
+	}
+
+	// wvtt cues looking like .vtt syntax
+	w := &bytes.Buffer{}
+	err := (&astisub.Subtitles{Items: []*astisub.Item{{EndAt: 2 * time.Second, Lines: []astisub.Line{{{Text: "A --> B"}}, {{Text: ""}}, {{Text: "C"}}}, StartAt: time.Second}}}).WriteToMP4(w, astisub.MP4Options{})
+	assert.NoError(t, err)
+	r, err := astisub.ReadFromMP4(bytes.NewReader(w.Bytes()), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(r.Items))
+	assert.Equal(t, []astisub.Line{{{Text: "A --> B"}}, {{Text: ""}}, {{Text: "C"}}}, r.Items[0].Lines)
+
+	// tx3g
+	ftyp := mp4TestBox("ftyp", []byte("isom"), mp4TestUint32s(0), []byte("isom"))
+	var samples = [][]byte{{0, 0}, append([]byte{0, 11}, []byte("Hello\nWorld")...), append([]byte{0, 1, '!'}, mp4TestBox("styl", mp4TestUint32s(0))...)}
+	moovWithChunkOffsets := func(co, stts []byte) []byte {
+		return mp4TestBox("moov", mp4TestBox("trak",
+			mp4TestBox("tkhd", mp4TestUint32s(3, 0, 0, 5, 0, 0)),
+			mp4TestBox("mdia",
+				mp4TestBox("mdhd", mp4TestUint32s(0, 0, 0, 600, 0), []byte{0x15, 0xc7, 0, 0}),
+				mp4TestBox("hdlr", mp4TestUint32s(0, 0), []byte("sbtl"), mp4TestUint32s(0, 0, 0), []byte{0}),
+				mp4TestBox("minf", mp4TestBox("stbl",
+					mp4TestBox("stsd", mp4TestUint32s(0, 1), mp4TestBox("tx3g", make([]byte, 8))),
+					mp4TestBox("stts", stts),
+					mp4TestBox("stsc", mp4TestUint32s(0, 1, 1, 3, 1)),
+					mp4TestBox("stsz", mp4TestUint32s(0, 0, 3, uint32(len(samples[0])), uint32(len(samples[1])), uint32(len(samples[2])))),
+					co,
+				)),
+			),
+		))
+	}
+	moov := func(offset uint32, stts []byte) []byte {
+		return moovWithChunkOffsets(mp4TestBox("stco", mp4TestUint32s(0, 1, offset)), stts)
+	}
+	stts := mp4TestUint32s(0, 2, 1, 600, 2, 300)
+	b := append(append(ftyp, moov(uint32(len(ftyp)+len(moov(0, stts))+8), stts)...), mp4TestBox("mdat", samples...)...)
+	r, err = astisub.ReadFromMP4(bytes.NewReader(b), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageEnglish, r.Metadata.Language)
+	assert.Equal(t, 2, len(r.Items))
+	assert.Equal(t, time.Second, r.Items[0].StartAt)
+	assert.Equal(t, 1500*time.Millisecond, r.Items[0].EndAt)
+	assert.Equal(t, []astisub.Line{{{Text: "Hello"}}, {{Text: "World"}}}, r.Items[0].Lines)
+	assert.Equal(t, 1500*time.Millisecond, r.Items[1].StartAt)
+	assert.Equal(t, 2*time.Second, r.Items[1].EndAt)
+	assert.Equal(t, "!", r.Items[1].String())
+
+	// Sample counts bounded by sizes
+	stts = mp4TestUint32s(0, 1, 0xffffffff, 300)
+	b = append(append(ftyp, moov(uint32(len(ftyp)+len(moov(0, stts))+8), stts)...), mp4TestBox("mdat", samples...)...)
+	r, err = astisub.ReadFromMP4(bytes.NewReader(b), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(r.Items))
+
+	// Huge chunk offsets
+	for _, offset := range []uint64{0xffffffffffffffff, 0xffffffffffffffff - uint64(len(samples[1])) + 1, uint64(len(b)) + 1} {
+		co64 := make([]byte, 8)
+		binary.BigEndian.PutUint64(co64, offset)
+		b = append(append(ftyp, moovWithChunkOffsets(mp4TestBox("co64", mp4TestUint32s(0, 1), co64), stts)...), mp4TestBox("mdat", samples...)...)
+		assert.NotPanics(t, func() {
+			_, err = astisub.ReadFromMP4(bytes.NewReader(b), 5)
+		})
+		assert.Error(t, err)
+	}
+
+	// Missing moov
+	_, err = astisub.ReadFromMP4(bytes.NewReader(ftyp), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,601 @@
+package astisub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://blog.thescorpius.com/index.php/2017/07/15/presentation-graphic-stream-sup-files-bluray-subtitle-format/
+
+// PGS constants
+const (
+	pgsCompositionStateEpochStart = 0x80
+	pgsDecodeRate                 = 128000000 // bits per second
+	pgsDefaultFrameHeight         = 1080
+	pgsDefaultFrameWidth          = 1920
+	pgsFrameRate                  = 0x10
+	pgsHeaderSize                 = 13
+	pgsMaxSegmentSize             = math.MaxUint16
+	pgsObjectFlagCropped          = 0x80
+	pgsPaletteSize                = 256
+	pgsSequenceFlagFirst          = 0x80
+	pgsSequenceFlagLast           = 0x40
+	pgsTransparentEntry           = 0xff
+)
+
+// PGS segment types
+const (
+	pgsSegmentTypeEnd                     = 0x80
+	pgsSegmentTypeObjectDefinition        = 0x15
+	pgsSegmentTypePaletteDefinition       = 0x14
+	pgsSegmentTypePresentationComposition = 0x16
+	pgsSegmentTypeWindowDefinition        = 0x17
+)
+
+// Bytes
+var bytesPGSMagic = []byte("PG")
+
+// pgsCompositionObject represents an object positioned by a presentation composition
+type pgsCompositionObject struct {
+	crop     *image.Rectangle
+	objectID uint16
+	x        int
+	y        int
+}
+
+// pgsObject represents a decoded object
+type pgsObject struct {
+	height int
+	pixels []uint8
+	width  int
+}
+
+// pgsDisplaySet represents the state of a PGS decoder
+type pgsDisplaySet struct {
+	compositions []pgsCompositionObject
+	data         map[uint16][]byte
+	frameHeight  int
+	frameWidth   int
+	item         *Item
+	objects      map[uint16]*pgsObject
+	paletteID    uint8
+	palettes     map[uint8]*[pgsPaletteSize]color.NRGBA
+	pts          int64
+}
+
+// ReadFromPGS parses a Blu-ray PGS .sup content
+// Each display set showing objects leads to an item starting at its presentation time and ending when the next
+// display set is presented.
+func ReadFromPGS(i io.Reader) (o *Subtitles, err error) {
+	// Read all
+	var b []byte
+	if b, err = ioutil.ReadAll(i); err != nil {
+		err = errors.Wrap(err, "reading all failed")
+		return
+	}
+
+	// Loop through segments
+	o = NewSubtitles()
+	var ds = &pgsDisplaySet{
+		data:     make(map[uint16][]byte),
+		objects:  make(map[uint16]*pgsObject),
+		palettes: make(map[uint8]*[pgsPaletteSize]color.NRGBA),
+	}
+	for len(b) > 0 {
+		// Parse header
+		if len(b) < pgsHeaderSize || string(b[:2]) != string(bytesPGSMagic) {
+			err = errors.New("Invalid segment header")
+			return
+		}
+		var pts = int64(binary.BigEndian.Uint32(b[2:]))
+		var t = b[10]
+		var l = int(binary.BigEndian.Uint16(b[11:]))
+		if pgsHeaderSize+l > len(b) {
+			err = fmt.Errorf("Segment size %d overflows content", l)
+			return
+		}
+		var data = b[pgsHeaderSize : pgsHeaderSize+l]
+		b = b[pgsHeaderSize+l:]
+
+		// Switch on segment type
+		switch t {
+		case pgsSegmentTypeEnd:
+			if err = ds.end(o); err != nil {
+				err = errors.Wrap(err, "ending display set failed")
+				return
+			}
+		case pgsSegmentTypeObjectDefinition:
+			ds.parseObjectDefinition(data)
+		case pgsSegmentTypePaletteDefinition:
+			ds.parsePaletteDefinition(data)
+		case pgsSegmentTypePresentationComposition:
+			ds.parsePresentationComposition(data, pts)
+		}
+	}
+	return
+}
+
+// parsePresentationComposition parses a presentation composition segment
+func (ds *pgsDisplaySet) parsePresentationComposition(b []byte, pts int64) {
+	if len(b) < 11 {
+		return
+	}
+
+	// Epoch start resets the decoder
+	if b[7]&pgsCompositionStateEpochStart > 0 {
+		ds.data = make(map[uint16][]byte)
+		ds.objects = make(map[uint16]*pgsObject)
+		ds.palettes = make(map[uint8]*[pgsPaletteSize]color.NRGBA)
+	}
+
+	// Parse composition
+	ds.compositions = []pgsCompositionObject{}
+	ds.frameHeight = int(binary.BigEndian.Uint16(b[2:]))
+	ds.frameWidth = int(binary.BigEndian.Uint16(b))
+	ds.paletteID = b[9]
+	ds.pts = pts
+
+	// Parse composition objects
+	for idx, n := 11, 0; n < int(b[10]) && idx+8 <= len(b); n++ {
+		var c = pgsCompositionObject{
+			objectID: binary.BigEndian.Uint16(b[idx:]),
+			x:        int(binary.BigEndian.Uint16(b[idx+4:])),
+			y:        int(binary.BigEndian.Uint16(b[idx+6:])),
+		}
+		if b[idx+3]&pgsObjectFlagCropped > 0 && idx+16 <= len(b) {
+			var x, y = int(binary.BigEndian.Uint16(b[idx+8:])), int(binary.BigEndian.Uint16(b[idx+10:]))
+			var r = image.Rect(x, y, x+int(binary.BigEndian.Uint16(b[idx+12:])), y+int(binary.BigEndian.Uint16(b[idx+14:])))
+			c.crop = &r
+			idx += 8
+		}
+		ds.compositions = append(ds.compositions, c)
+		idx += 8
+	}
+}
+
+// parsePaletteDefinition parses a palette definition segment
+func (ds *pgsDisplaySet) parsePaletteDefinition(b []byte) {
+	if len(b) < 2 {
+		return
+	}
+
+	// Get palette
+	var p, ok = ds.palettes[b[0]]
+	if !ok {
+		p = &[pgsPaletteSize]color.NRGBA{}
+		ds.palettes[b[0]] = p
+	}
+
+	// Loop through entries
+	for idx := 2; idx+5 <= len(b); idx += 5 {
+		var r, g, bl = pgsYCbCrToRGB(b[idx+1], b[idx+3], b[idx+2])
+		p[b[idx]] = color.NRGBA{R: r, G: g, B: bl, A: b[idx+4]}
+	}
+}
+
+// parseObjectDefinition parses an object definition segment
+// Objects may be split into several segments, and are decoded once the last one has been received.
+func (ds *pgsDisplaySet) parseObjectDefinition(b []byte) {
+	if len(b) < 4 {
+		return
+	}
+
+	// Append data
+	var id = binary.BigEndian.Uint16(b)
+	if b[3]&pgsSequenceFlagFirst > 0 {
+		if len(b) < 7 {
+			return
+		}
+		ds.data[id] = append([]byte{}, b[7:]...)
+	} else if d, ok := ds.data[id]; ok {
+		ds.data[id] = append(d, b[4:]...)
+	}
+
+	// Decode object
+	if d, ok := ds.data[id]; ok && b[3]&pgsSequenceFlagLast > 0 && len(d) >= 4 {
+		ds.objects[id] = pgsDecodeObject(int(binary.BigEndian.Uint16(d)), int(binary.BigEndian.Uint16(d[2:])), d[4:])
+		delete(ds.data, id)
+	}
+}
+
+// pgsDecodeObject decodes RLE object data
+func pgsDecodeObject(width, height int, b []byte) (o *pgsObject) {
+	o = &pgsObject{height: height, pixels: make([]uint8, width*height), width: width}
+	for i := range o.pixels {
+		o.pixels[i] = pgsTransparentEntry
+	}
+	var x, y int
+	for idx := 0; idx < len(b) && y < height; {
+		// Color
+		var c, n = b[idx], 1
+		idx++
+
+		// Run-length
+		if c == 0 {
+			if idx >= len(b) {
+				break
+			}
+			var f = b[idx]
+			idx++
+			switch {
+			case f == 0:
+				x, y = 0, y+1
+				continue
+			case f&0x40 > 0:
+				if idx >= len(b) {
+					return
+				}
+				n = int(f&0x3f)<<8 | int(b[idx])
+				idx++
+			default:
+				n = int(f & 0x3f)
+			}
+			if f&0x80 > 0 {
+				if idx >= len(b) {
+					return
+				}
+				c = b[idx]
+				idx++
+			}
+		}
+
+		// Set pixels
+		for ; n > 0; n-- {
+			if x < width {
+				o.pixels[y*width+x] = c
+			}
+			x++
+		}
+	}
+	return
+}
+
+// end renders the display set and adds an item to the subtitles
+func (ds *pgsDisplaySet) end(s *Subtitles) (err error) {
+	// End previous item
+	var t = time.Duration(ds.pts) * time.Second / tsClockRate
+	if ds.item != nil {
+		ds.item.EndAt = t
+		ds.item = nil
+	}
+
+	// Get palette
+	var p, ok = ds.palettes[ds.paletteID]
+	if !ok {
+		p = &[pgsPaletteSize]color.NRGBA{}
+	}
+
+	// Get bounds
+	var bounds image.Rectangle
+	for _, c := range ds.compositions {
+		var o, ok = ds.objects[c.objectID]
+		if !ok {
+			err = fmt.Errorf("Object %d is not defined", c.objectID)
+			return
+		}
+		bounds = bounds.Union(c.rectangle(o))
+	}
+	if bounds.Empty() {
+		return
+	}
+
+	// Draw objects
+	var img = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for _, c := range ds.compositions {
+		var o = ds.objects[c.objectID]
+		var r = c.rectangle(o)
+		var src image.Point
+		if c.crop != nil {
+			src = c.crop.Min
+		}
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				if sx, sy := src.X+x, src.Y+y; sx < o.width && sy < o.height {
+					img.SetNRGBA(r.Min.X-bounds.Min.X+x, r.Min.Y-bounds.Min.Y+y, p[o.pixels[sy*o.width+sx]])
+				}
+			}
+		}
+	}
+
+	// Add item
+	ds.item = &Item{
+		EndAt: t,
+		Image: &Image{
+			FrameHeight: ds.frameHeight,
+			FrameWidth:  ds.frameWidth,
+			Image:       img,
+			Left:        bounds.Min.X,
+			Top:         bounds.Min.Y,
+		},
+		StartAt: t,
+	}
+	s.Items = append(s.Items, ds.item)
+	return
+}
+
+// rectangle returns the position of the composition object within the frame
+func (c pgsCompositionObject) rectangle(o *pgsObject) image.Rectangle {
+	if c.crop != nil {
+		return image.Rect(c.x, c.y, c.x+c.crop.Dx(), c.y+c.crop.Dy())
+	}
+	return image.Rect(c.x, c.y, c.x+o.width, c.y+o.height)
+}
+
+// pgsYCbCrToRGB converts a BT.709 limited range color to RGB
+func pgsYCbCrToRGB(y, cb, cr uint8) (r, g, b uint8) {
+	var fy = (float64(y) - 16) * 255 / 219
+	var fcb = (float64(cb) - 128) * 255 / 224
+	var fcr = (float64(cr) - 128) * 255 / 224
+	return pgsClamp(fy + 1.5748*fcr), pgsClamp(fy - 0.1873*fcb - 0.4681*fcr), pgsClamp(fy + 1.8556*fcb)
+}
+
+// pgsRGBToYCbCr converts an RGB color to BT.709 limited range
+func pgsRGBToYCbCr(r, g, b uint8) (y, cb, cr uint8) {
+	var fr, fg, fb = float64(r), float64(g), float64(b)
+	y = pgsClamp(16 + 219*(0.2126*fr+0.7152*fg+0.0722*fb)/255)
+	cb = pgsClamp(128 + 224*(-0.1146*fr-0.3854*fg+0.5*fb)/255)
+	cr = pgsClamp(128 + 224*(0.5*fr-0.4542*fg-0.0458*fb)/255)
+	return
+}
+
+// pgsClamp rounds and clamps a value to a byte
+func pgsClamp(i float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(i+0.5))))
+}
+
+// WriteToPGS writes subtitles in Blu-ray PGS .sup format
+// Each item leads to an epoch start display set presented at its start and to a display set clearing it at its end.
+// Items must hold an image, whose frame size defaults to 1920x1080, and can't overlap since a single object is
+// displayed at a time.
+func (s Subtitles) WriteToPGS(o io.Writer) (err error) {
+	// Do not write anything if no subtitles
+	if len(s.Items) == 0 {
+		err = ErrNoSubtitlesToWrite
+		return
+	}
+
+	// Loop through ordered items
+	var c []byte
+	var is = s.ordered().Items
+	for idx, i := range is {
+		// Only images are supported
+		if i.Image == nil {
+			err = fmt.Errorf("Item %d has no image", idx)
+			return
+		}
+
+		// Check overlap
+		if idx > 0 && i.StartAt < is[idx-1].EndAt {
+			err = fmt.Errorf("Item %d starting at %s overlaps the previous item", idx, i.StartAt)
+			return
+		}
+
+		// Add display sets
+		c = append(c, pgsWriteDisplaySet(i, uint16(2*idx))...)
+		c = append(c, pgsWriteClearDisplaySet(i, uint16(2*idx+1))...)
+	}
+
+	// Write
+	if _, err = o.Write(c); err != nil {
+		err = errors.Wrap(err, "writing failed")
+		return
+	}
+	return
+}
+
+// pgsFrameSize returns the frame size of an image
+func pgsFrameSize(i *Image) (w, h int) {
+	w, h = i.FrameWidth, i.FrameHeight
+	if w <= 0 || h <= 0 {
+		w, h = pgsDefaultFrameWidth, pgsDefaultFrameHeight
+	}
+	return
+}
+
+// pgsTicks converts a duration into 90kHz ticks
+func pgsTicks(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return int64(d * tsClockRate / time.Second)
+}
+
+// pgsWriteDisplaySet writes the display set presenting an item
+// Decoding starts early enough for the object to be decoded at its presentation time.
+func pgsWriteDisplaySet(i *Item, number uint16) (c []byte) {
+	// Get times
+	var r = i.Image.Rectangle()
+	var pts = pgsTicks(i.StartAt)
+	var dts = pts - int64(math.Ceil(float64(r.Dx()*r.Dy()*8*tsClockRate)/pgsDecodeRate))
+	if dts < 0 {
+		dts = 0
+	}
+
+	// Get palette and pixels
+	var palette, pixels = pgsPalettize(i.Image.Image)
+
+	// Add presentation composition
+	var fw, fh = pgsFrameSize(i.Image)
+	c = append(c, pgsWriteSegment(pgsSegmentTypePresentationComposition, pts, dts, pgsPresentationComposition(fw, fh, number, &r))...)
+
+	// Add window definition
+	c = append(c, pgsWriteSegment(pgsSegmentTypeWindowDefinition, dts, dts, pgsWindowDefinition(r))...)
+
+	// Add palette definition
+	var pd = []byte{0x0, 0x0}
+	for idx, cl := range append(palette, make([]color.NRGBA, pgsPaletteSize-len(palette))...) {
+		if idx < len(palette) || idx == pgsTransparentEntry {
+			var y, cb, cr = pgsRGBToYCbCr(cl.R, cl.G, cl.B)
+			pd = append(pd, uint8(idx), y, cr, cb, cl.A)
+		}
+	}
+	c = append(c, pgsWriteSegment(pgsSegmentTypePaletteDefinition, dts, dts, pd)...)
+
+	// Add object definitions
+	var d = pgsEncodeObject(pixels, r.Dx(), r.Dy())
+	var h = make([]byte, 7)
+	binary.BigEndian.PutUint32(h[3:], uint32(len(d)+4))
+	h[3] = pgsSequenceFlagFirst
+	h = append(h, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(h[7:], uint16(r.Dx()))
+	binary.BigEndian.PutUint16(h[9:], uint16(r.Dy()))
+	for first := true; first || len(d) > 0; first = false {
+		// Get data
+		var n = pgsMaxSegmentSize - len(h)
+		if n >= len(d) {
+			n = len(d)
+			h[3] |= pgsSequenceFlagLast
+		}
+		c = append(c, pgsWriteSegment(pgsSegmentTypeObjectDefinition, pts, dts, append(h, d[:n]...))...)
+		d = d[n:]
+
+		// Next segments only hold the object id, its version and the sequence flag
+		h = []byte{0x0, 0x0, 0x0, 0x0}
+	}
+
+	// Add end
+	c = append(c, pgsWriteSegment(pgsSegmentTypeEnd, dts, dts, nil)...)
+	return
+}
+
+// pgsWriteClearDisplaySet writes the display set clearing an item
+func pgsWriteClearDisplaySet(i *Item, number uint16) (c []byte) {
+	var r = i.Image.Rectangle()
+	var pts = pgsTicks(i.EndAt)
+	var fw, fh = pgsFrameSize(i.Image)
+	c = append(c, pgsWriteSegment(pgsSegmentTypePresentationComposition, pts, pts, pgsPresentationComposition(fw, fh, number, nil))...)
+	c = append(c, pgsWriteSegment(pgsSegmentTypeWindowDefinition, pts, pts, pgsWindowDefinition(r))...)
+	c = append(c, pgsWriteSegment(pgsSegmentTypeEnd, pts, pts, nil)...)
+	return
+}
+
+// pgsPresentationComposition builds a presentation composition segment showing an object at a position or nothing
+func pgsPresentationComposition(frameWidth, frameHeight int, number uint16, r *image.Rectangle) (c []byte) {
+	c = make([]byte, 11)
+	binary.BigEndian.PutUint16(c, uint16(frameWidth))
+	binary.BigEndian.PutUint16(c[2:], uint16(frameHeight))
+	c[4] = pgsFrameRate
+	binary.BigEndian.PutUint16(c[5:], number)
+	if r != nil {
+		c[7] = pgsCompositionStateEpochStart
+		c[10] = 1
+		c = append(c, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0)
+		binary.BigEndian.PutUint16(c[15:], uint16(r.Min.X))
+		binary.BigEndian.PutUint16(c[17:], uint16(r.Min.Y))
+	}
+	return
+}
+
+// pgsWindowDefinition builds a window definition segment holding a single window
+func pgsWindowDefinition(r image.Rectangle) (c []byte) {
+	c = make([]byte, 10)
+	c[0] = 1
+	binary.BigEndian.PutUint16(c[2:], uint16(r.Min.X))
+	binary.BigEndian.PutUint16(c[4:], uint16(r.Min.Y))
+	binary.BigEndian.PutUint16(c[6:], uint16(r.Dx()))
+	binary.BigEndian.PutUint16(c[8:], uint16(r.Dy()))
+	return
+}
+
+// pgsWriteSegment builds a segment
+func pgsWriteSegment(t uint8, pts, dts int64, data []byte) (c []byte) {
+	c = make([]byte, pgsHeaderSize)
+	copy(c, bytesPGSMagic)
+	binary.BigEndian.PutUint32(c[2:], uint32(pts))
+	binary.BigEndian.PutUint32(c[6:], uint32(dts))
+	c[10] = t
+	binary.BigEndian.PutUint16(c[11:], uint16(len(data)))
+	return append(c, data...)
+}
+
+// pgsPalettize converts an image into a palette of at most 255 colors and its pixels' palette entries
+// The last entry is kept for transparency, and colors are quantized until they fit in the palette.
+func pgsPalettize(i image.Image) (palette []color.NRGBA, pixels []uint8) {
+	// Get colors
+	var b = i.Bounds()
+	var cs = make([]color.NRGBA, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cs = append(cs, color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA))
+		}
+	}
+
+	// Loop through quantization levels
+	for shift := uint(0); shift < 8; shift++ {
+		// Loop through colors
+		var entries = make(map[color.NRGBA]uint8)
+		var fits = true
+		palette = []color.NRGBA{}
+		pixels = make([]uint8, len(cs))
+		for idx, c := range cs {
+			// Transparent
+			if c.A == 0 {
+				pixels[idx] = pgsTransparentEntry
+				continue
+			}
+
+			// Get entry
+			var q = color.NRGBA{R: c.R >> shift << shift, G: c.G >> shift << shift, B: c.B >> shift << shift, A: c.A >> shift << shift}
+			var e, ok = entries[q]
+			if !ok {
+				if len(palette) == pgsTransparentEntry {
+					fits = false
+					break
+				}
+				e = uint8(len(palette))
+				entries[q] = e
+				palette = append(palette, q)
+			}
+			pixels[idx] = e
+		}
+
+		// Palette fits
+		if fits {
+			break
+		}
+	}
+	return
+}
+
+// pgsEncodeObject encodes pixels' palette entries as RLE object data
+func pgsEncodeObject(pixels []uint8, width, height int) (c []byte) {
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; {
+			// Get run
+			var e = pixels[y*width+x]
+			var n = 1
+			for x+n < width && n < 0x3fff && pixels[y*width+x+n] == e {
+				n++
+			}
+			x += n
+
+			// Add run
+			switch {
+			case e != 0 && n < 3:
+				for ; n > 0; n-- {
+					c = append(c, e)
+				}
+			case e == 0 && n < 0x40:
+				c = append(c, 0x0, uint8(n))
+			case e == 0:
+				c = append(c, 0x0, 0x40|uint8(n>>8), uint8(n))
+			case n < 0x40:
+				c = append(c, 0x0, 0x80|uint8(n), e)
+			default:
+				c = append(c, 0x0, 0xc0|uint8(n>>8), uint8(n), e)
+			}
+		}
+
+		// End of line
+		c = append(c, 0x0, 0x0)
+	}
+	return
+}
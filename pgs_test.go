@@ -0,0 +1,99 @@
+package astisub_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPGS(t *testing.T) {
+	// Init
+	i1 := image.NewNRGBA(image.Rect(0, 0, 100, 2))
+	for x := 0; x < 100; x++ {
+		i1.SetNRGBA(x, 0, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	}
+	i1.SetNRGBA(50, 1, color.NRGBA{R: 0xff, A: 0x80})
+	i2 := image.NewNRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			i2.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 0xff})
+		}
+	}
+	s := &astisub.Subtitles{Items: []*astisub.Item{
+		{EndAt: 2 * time.Second, Image: &astisub.Image{FrameHeight: 1080, FrameWidth: 1920, Image: i1, Left: 910, Top: 1000}, StartAt: time.Second},
+		{EndAt: 4 * time.Second, Image: &astisub.Image{Image: i2, Left: 10, Top: 20}, StartAt: 3 * time.Second},
+	}}
+
+	// Write
+	w := &bytes.Buffer{}
+	err := s.WriteToPGS(w)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("PG"), w.Bytes()[:2])
+
+	// Read
+	s2, err := astisub.ReadFromPGS(bytes.NewReader(w.Bytes()))
+	assert.NoError(t, err)
+	assert.Len(t, s2.Items, 2)
+	assert.Equal(t, time.Second, s2.Items[0].StartAt)
+	assert.Equal(t, 2*time.Second, s2.Items[0].EndAt)
+	assert.Equal(t, 3*time.Second, s2.Items[1].StartAt)
+	assert.Equal(t, 4*time.Second, s2.Items[1].EndAt)
+	assert.Equal(t, image.Rect(910, 1000, 1010, 1002), s2.Items[0].Image.Rectangle())
+	assert.Equal(t, 1920, s2.Items[0].Image.FrameWidth)
+	assert.Equal(t, 1080, s2.Items[0].Image.FrameHeight)
+	assert.Equal(t, image.Rect(10, 20, 310, 320), s2.Items[1].Image.Rectangle())
+	assert.Equal(t, 1920, s2.Items[1].Image.FrameWidth)
+
+	// Pixels
+	assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, s2.Items[0].Image.Image.At(99, 0))
+	assert.Equal(t, uint8(0), s2.Items[0].Image.Image.At(49, 1).(color.NRGBA).A)
+	c := s2.Items[0].Image.Image.At(50, 1).(color.NRGBA)
+	assert.InDelta(t, 0xff, int(c.R), 2)
+	assert.InDelta(t, 0, int(c.G), 2)
+	assert.Equal(t, uint8(0x80), c.A)
+	c = s2.Items[1].Image.Image.At(200, 100).(color.NRGBA)
+	assert.InDelta(t, 200, int(c.R), 16)
+	assert.InDelta(t, 100, int(c.G), 16)
+	assert.InDelta(t, 44, int(c.B), 16)
+
+	// Unordered items
+	w.Reset()
+	err = (&astisub.Subtitles{Items: []*astisub.Item{s.Items[1], s.Items[0]}}).WriteToPGS(w)
+	assert.NoError(t, err)
+	s2, err = astisub.ReadFromPGS(bytes.NewReader(w.Bytes()))
+	assert.NoError(t, err)
+	assert.Len(t, s2.Items, 2)
+	assert.Equal(t, time.Second, s2.Items[0].StartAt)
+
+	// Overlapping items
+	s.Items[1].StartAt = 1500 * time.Millisecond
+	err = s.WriteToPGS(&bytes.Buffer{})
+	assert.Error(t, err)
+
+	// Object filling a segment exactly
+	// Lines are made of a 2 bytes run of the first palette entry followed by 1 byte runs and a 2 bytes end of line.
+	i3 := image.NewNRGBA(image.Rect(0, 0, 16378, 4))
+	for y := 0; y < 4; y++ {
+		i3.SetNRGBA(0, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+		for x := 1; x < 16378; x++ {
+			i3.SetNRGBA(x, y, color.NRGBA{R: uint8(x % 2 * 0xff), A: 0xff})
+		}
+	}
+	w.Reset()
+	err = (&astisub.Subtitles{Items: []*astisub.Item{{EndAt: time.Second, Image: &astisub.Image{Image: i3}}}}).WriteToPGS(w)
+	assert.NoError(t, err)
+	s2, err = astisub.ReadFromPGS(bytes.NewReader(w.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, s2.Items, 1) {
+		assert.Equal(t, i3.Bounds(), s2.Items[0].Image.Rectangle())
+	}
+
+	// Text items
+	err = astisub.Subtitles{Items: []*astisub.Item{{Lines: []astisub.Line{{{Text: "text"}}}}}}.WriteToPGS(w)
+	assert.Error(t, err)
+}
@@ -0,0 +1,525 @@
+// Package render renders text subtitles into bitmaps using TTF/OTF fonts, without depending on cgo or a GPU
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/asticode/go-astisub"
+	"github.com/pkg/errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Render constants
+const (
+	renderItalicShear = 0.2
+	renderSafeArea    = 10 // Percentage of the frame kept empty on each side when items have no geometry
+)
+
+// Options represents render options
+// Font is the path to a TTF/OTF font file. Bold and italic variants are synthesized from it unless their own font
+// files are provided.
+type Options struct {
+	Font           string
+	FontBold       string
+	FontBoldItalic string
+	FontItalic     string
+	FrameHeight    int
+	FrameWidth     int
+}
+
+// renderFontVariant represents a font variant
+type renderFontVariant struct {
+	bold   bool
+	italic bool
+}
+
+// renderer represents an object capable of rendering text items into images
+type renderer struct {
+	faces    map[renderFace]font.Face
+	fonts    map[renderFontVariant]*opentype.Font
+	o        Options
+	viewport astisub.Viewport
+}
+
+// renderFace represents a font face key
+type renderFace struct {
+	size    int
+	variant renderFontVariant
+}
+
+// renderWord represents a laid out word
+type renderWord struct {
+	face    font.Face
+	spaced  bool
+	spacing int
+	style   *astisub.StyleAttributes
+	synth   renderFontVariant
+	text    string
+	width   int
+}
+
+// renderLine represents a laid out visual line
+type renderLine struct {
+	ascent int
+	height int
+	width  int
+	words  []*renderWord
+}
+
+// Render renders the text of each item into an image the size of the frame
+// Lines, colors, bold, italic, outline and background are taken from the item's computed style, and the text block
+// is laid out within the item's geometry, or within the frame title-safe area when it has none. Returned items are
+// copies of the input items holding the rendered image, and can be written with bitmap writers such as WriteToPGS.
+func Render(s astisub.Subtitles, o Options) (r *astisub.Subtitles, err error) {
+	// Create renderer
+	var rd *renderer
+	if rd, err = newRenderer(o); err != nil {
+		err = errors.Wrap(err, "creating renderer failed")
+		return
+	}
+
+	// Loop through items
+	r = &astisub.Subtitles{Metadata: s.Metadata, Regions: s.Regions, Styles: s.Styles}
+	for idx, i := range s.Items {
+		// Render item
+		var img *astisub.Image
+		if img, err = rd.render(i); err != nil {
+			err = errors.Wrapf(err, "rendering item %d failed", idx)
+			return
+		}
+
+		// Nothing to display
+		if img == nil {
+			continue
+		}
+
+		// Add item
+		var c = &astisub.Item{}
+		*c = *i
+		c.Image = img
+		r.Items = append(r.Items, c)
+	}
+	return
+}
+
+// newRenderer creates a new renderer
+func newRenderer(o Options) (r *renderer, err error) {
+	// Validate frame size
+	if o.FrameWidth <= 0 || o.FrameHeight <= 0 {
+		err = fmt.Errorf("Invalid frame size %dx%d", o.FrameWidth, o.FrameHeight)
+		return
+	}
+
+	// Create renderer
+	r = &renderer{
+		faces:    make(map[renderFace]font.Face),
+		fonts:    make(map[renderFontVariant]*opentype.Font),
+		o:        o,
+		viewport: astisub.Viewport{Height: o.FrameHeight, Width: o.FrameWidth},
+	}
+
+	// Parse fonts
+	for v, p := range map[renderFontVariant]string{
+		{}:                         o.Font,
+		{bold: true}:               o.FontBold,
+		{bold: true, italic: true}: o.FontBoldItalic,
+		{italic: true}:             o.FontItalic,
+	} {
+		// No font
+		if len(p) == 0 {
+			if v == (renderFontVariant{}) {
+				err = errors.New("No font provided")
+				return
+			}
+			continue
+		}
+
+		// Read font
+		var b []byte
+		if b, err = ioutil.ReadFile(p); err != nil {
+			err = errors.Wrapf(err, "reading %s failed", p)
+			return
+		}
+
+		// Parse font
+		if r.fonts[v], err = opentype.Parse(b); err != nil {
+			err = errors.Wrapf(err, "parsing %s failed", p)
+			return
+		}
+	}
+	return
+}
+
+// face returns the face of a variant at a size, and the variant that needs to be synthesized
+func (r *renderer) face(v renderFontVariant, size int) (f font.Face, synth renderFontVariant, err error) {
+	// Get the closest font
+	var fv = v
+	if _, ok := r.fonts[fv]; !ok {
+		if fv = (renderFontVariant{bold: v.bold}); r.fonts[fv] == nil {
+			if fv = (renderFontVariant{italic: v.italic}); r.fonts[fv] == nil {
+				fv = renderFontVariant{}
+			}
+		}
+	}
+	synth = renderFontVariant{bold: v.bold && !fv.bold, italic: v.italic && !fv.italic}
+
+	// Get face
+	var ok bool
+	var k = renderFace{size: size, variant: fv}
+	if f, ok = r.faces[k]; ok {
+		return
+	}
+	if f, err = opentype.NewFace(r.fonts[fv], &opentype.FaceOptions{DPI: 72, Hinting: font.HintingFull, Size: float64(size)}); err != nil {
+		err = errors.Wrapf(err, "creating face of size %d failed", size)
+		return
+	}
+	r.faces[k] = f
+	return
+}
+
+// length converts a length into pixels, falling back on a default value
+func (r *renderer) length(l []astisub.Length, vertical bool, def float64) float64 {
+	if len(l) == 0 {
+		return def
+	}
+	p, err := l[len(l)-1].Pixels(r.viewport, vertical)
+	if err != nil {
+		return def
+	}
+	return p
+}
+
+// geometry returns the item geometry, which defaults to its region's
+func geometry(i *astisub.Item) *astisub.Geometry {
+	if i.Geometry != nil {
+		return i.Geometry
+	}
+	if i.Region != nil {
+		return i.Region.Geometry
+	}
+	return nil
+}
+
+// render renders an item into an image
+func (r *renderer) render(i *astisub.Item) (o *astisub.Image, err error) {
+	// Get box
+	var g = geometry(i)
+	if g == nil {
+		g = &astisub.Geometry{
+			DisplayAlign: astisub.DisplayAlignAfter,
+			Height:       100 - 2*renderSafeArea,
+			Left:         renderSafeArea,
+			TextAlign:    astisub.TextAlignCenter,
+			Top:          renderSafeArea,
+			Width:        100 - 2*renderSafeArea,
+		}
+	}
+	var box = image.Rect(
+		int(g.Left*float64(r.o.FrameWidth)/100),
+		int(g.Top*float64(r.o.FrameHeight)/100),
+		int(g.Right()*float64(r.o.FrameWidth)/100),
+		int(g.Bottom()*float64(r.o.FrameHeight)/100),
+	)
+
+	// Get alignments
+	var is = astisub.ComputedStyle(i, nil)
+	var da, ta = g.DisplayAlign, g.TextAlign
+	if len(da) == 0 {
+		da = is.DisplayAlign
+	}
+	if len(ta) == 0 {
+		ta = is.TextAlign
+	}
+
+	// Lay out lines
+	var lines []*renderLine
+	if lines, err = r.layout(i, box.Dx(), is.WrapOption != "noWrap"); err != nil {
+		err = errors.Wrap(err, "laying out failed")
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	// Get block position
+	var h int
+	for _, l := range lines {
+		h += l.height
+	}
+	var y = box.Min.Y
+	switch da {
+	case astisub.DisplayAlignAfter:
+		y = box.Max.Y - h
+	case astisub.DisplayAlignCenter:
+		y = box.Min.Y + (box.Dy()-h)/2
+	}
+
+	// Draw lines
+	var canvas = image.NewNRGBA(image.Rect(0, 0, r.o.FrameWidth, r.o.FrameHeight))
+	var texts []func()
+	for _, l := range lines {
+		// Get line position
+		var x = box.Min.X
+		switch ta {
+		case astisub.TextAlignCenter:
+			x += (box.Dx() - l.width) / 2
+		case astisub.TextAlignEnd, astisub.TextAlignRight:
+			x = box.Max.X - l.width
+		}
+
+		// Loop through words
+		for _, w := range l.words {
+			// Draw background
+			if w.spaced {
+				x += w.spacing
+			}
+			if c := w.style.BackgroundColor; c != nil && c.Alpha > 0 {
+				var bx = x
+				if w.spaced {
+					bx -= w.spacing
+				}
+				draw.Draw(canvas, image.Rect(bx, y, x+w.width, y+l.height), image.NewUniform(renderColor(c)), image.Point{}, draw.Over)
+			}
+
+			// Draw outline and store text so that it is drawn over every outline
+			var m = r.mask(w, x, y+l.ascent)
+			var wc = renderColor(w.style.Color)
+			if oc, ot := r.outline(w.style); ot > 0 {
+				draw.DrawMask(canvas, m.Bounds(), image.NewUniform(oc), image.Point{}, renderDilate(m, ot), m.Bounds().Min, draw.Over)
+			}
+			texts = append(texts, func() {
+				draw.DrawMask(canvas, m.Bounds(), image.NewUniform(wc), image.Point{}, m, m.Bounds().Min, draw.Over)
+			})
+			x += w.width
+		}
+		y += l.height
+	}
+	for _, fn := range texts {
+		fn()
+	}
+
+	// Crop
+	var bounds image.Rectangle
+	for py := 0; py < r.o.FrameHeight; py++ {
+		for px := 0; px < r.o.FrameWidth; px++ {
+			if canvas.Pix[canvas.PixOffset(px, py)+3] > 0 {
+				bounds = bounds.Union(image.Rect(px, py, px+1, py+1))
+			}
+		}
+	}
+	if bounds.Empty() {
+		return
+	}
+	var img = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), canvas, bounds.Min, draw.Src)
+	o = &astisub.Image{
+		FrameHeight: r.o.FrameHeight,
+		FrameWidth:  r.o.FrameWidth,
+		Image:       img,
+		Left:        bounds.Min.X,
+		Top:         bounds.Min.Y,
+	}
+	return
+}
+
+// layout splits the item lines into words and wraps them into visual lines fitting a width
+func (r *renderer) layout(i *astisub.Item, width int, wrap bool) (o []*renderLine, err error) {
+	for _, il := range i.Lines {
+		// Loop through line items
+		var l = &renderLine{}
+		for idx := range il {
+			// Get style
+			var li = il[idx]
+			var sa = astisub.ComputedStyle(i, &li)
+			if sa.Visibility == "hidden" {
+				continue
+			}
+
+			// Get face
+			var size = int(math.Max(1, r.length(sa.FontSize, true, r.length([]astisub.Length{{Unit: astisub.LengthUnitCell, Value: 1}}, true, 0))+0.5))
+			var f font.Face
+			var synth renderFontVariant
+			if f, synth, err = r.face(renderFontVariant{bold: sa.FontWeight == "bold", italic: sa.FontStyle == astisub.FontStyleItalic || sa.FontStyle == astisub.FontStyleOblique}, size); err != nil {
+				err = errors.Wrap(err, "getting face failed")
+				return
+			}
+
+			// Loop through words
+			for _, t := range strings.FieldsFunc(li.Text, unicode.IsSpace) {
+				// Create word
+				var w = &renderWord{
+					face:    f,
+					spaced:  len(l.words) > 0,
+					spacing: font.MeasureString(f, " ").Ceil(),
+					style:   sa,
+					synth:   synth,
+					text:    t,
+				}
+				w.width = font.MeasureString(f, t).Ceil() + r.padding(w)
+
+				// Wrap
+				if wrap && len(l.words) > 0 && l.width+w.spacing+w.width > width {
+					o = append(o, l)
+					l = &renderLine{}
+					w.spaced = false
+				}
+
+				// Add word
+				if w.spaced {
+					l.width += w.spacing
+				}
+				l.width += w.width
+				l.words = append(l.words, w)
+				var m = f.Metrics()
+				if a := m.Ascent.Ceil(); a > l.ascent {
+					l.ascent = a
+				}
+				if h := m.Height.Ceil(); h > l.height {
+					l.height = h
+				}
+			}
+		}
+		if len(l.words) > 0 {
+			o = append(o, l)
+		}
+	}
+	return
+}
+
+// padding returns the extra width taken by synthesized styles
+func (r *renderer) padding(w *renderWord) (p int) {
+	var m = w.face.Metrics()
+	if w.synth.italic {
+		p += int(math.Ceil(float64(m.Ascent.Ceil()) * renderItalicShear))
+	}
+	if w.synth.bold {
+		p += renderBoldWidth(m)
+	}
+	return
+}
+
+// renderBoldWidth returns the width glyphs are emboldened by
+func renderBoldWidth(m font.Metrics) int {
+	return int(math.Max(1, float64(m.Height.Ceil())/24))
+}
+
+// outline returns the outline color and thickness of a style
+// The TTML syntax is "none" or an optional color followed by a thickness and an optional blur radius, the color
+// defaulting to the text color.
+func (r *renderer) outline(sa *astisub.StyleAttributes) (c color.Color, t int) {
+	// Get parts
+	var ps = strings.Fields(sa.TextOutline)
+	if len(ps) == 0 || ps[0] == "none" {
+		return
+	}
+
+	// Get color
+	c = renderColor(sa.Color)
+	if pc, err := astisub.ParseColor(ps[0]); err == nil {
+		c = renderColor(pc)
+		ps = ps[1:]
+	}
+
+	// Get thickness
+	if len(ps) == 0 {
+		return
+	}
+	l, err := astisub.ParseLength(ps[0])
+	if err != nil {
+		return
+	}
+	if l.Unit == astisub.LengthUnitEm {
+		l = astisub.Length{Unit: astisub.LengthUnitPixel, Value: l.Value * r.length(sa.FontSize, true, 0)}
+	}
+	t = int(math.Ceil(r.length([]astisub.Length{l}, true, 0)))
+	return
+}
+
+// mask renders a word into an alpha mask positioned in the frame
+func (r *renderer) mask(w *renderWord, x, baseline int) (m *image.Alpha) {
+	// Get bounds with room for the outline
+	var fm = w.face.Metrics()
+	var _, t = r.outline(w.style)
+	m = image.NewAlpha(image.Rect(x-t, baseline-fm.Ascent.Ceil()-t, x+w.width+t, baseline+fm.Descent.Ceil()+t))
+
+	// Draw text
+	var d = &font.Drawer{Dot: fixed.P(x, baseline), Dst: m, Face: w.face, Src: image.Opaque}
+	d.DrawString(w.text)
+
+	// Synthesize italic
+	if w.synth.italic {
+		var c = image.NewAlpha(m.Bounds())
+		for y := m.Bounds().Min.Y; y < m.Bounds().Max.Y; y++ {
+			var dx = int(float64(baseline-y) * renderItalicShear)
+			for x := m.Bounds().Min.X; x < m.Bounds().Max.X; x++ {
+				c.SetAlpha(x+dx, y, m.AlphaAt(x, y))
+			}
+		}
+		m = c
+	}
+
+	// Synthesize bold
+	if w.synth.bold {
+		var b = renderBoldWidth(fm)
+		var c = image.NewAlpha(m.Bounds())
+		for y := m.Bounds().Min.Y; y < m.Bounds().Max.Y; y++ {
+			for x := m.Bounds().Min.X; x < m.Bounds().Max.X; x++ {
+				var a uint8
+				for dx := 0; dx <= b; dx++ {
+					if v := m.AlphaAt(x-dx, y).A; v > a {
+						a = v
+					}
+				}
+				c.SetAlpha(x, y, color.Alpha{A: a})
+			}
+		}
+		m = c
+	}
+	return
+}
+
+// renderDilate dilates an alpha mask with a disc of radius r
+func renderDilate(m *image.Alpha, r int) (o *image.Alpha) {
+	// Get offsets
+	var offsets []image.Point
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				offsets = append(offsets, image.Pt(dx, dy))
+			}
+		}
+	}
+
+	// Dilate
+	var b = m.Bounds()
+	o = image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if a := m.AlphaAt(x, y).A; a > 0 {
+				for _, p := range offsets {
+					if q := image.Pt(x+p.X, y+p.Y); q.In(b) && o.AlphaAt(q.X, q.Y).A < a {
+						o.SetAlpha(q.X, q.Y, color.Alpha{A: a})
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// renderColor converts a color, which defaults to white
+func renderColor(c *astisub.Color) color.Color {
+	if c == nil {
+		return color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	}
+	return color.NRGBA{R: c.Red, G: c.Green, B: c.Blue, A: c.Alpha}
+}
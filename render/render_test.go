@@ -0,0 +1,88 @@
+package render_test
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/asticode/go-astisub/render"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestRender(t *testing.T) {
+	// Init
+	dir, err := ioutil.TempDir("", "astisub")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "font.ttf")
+	err = ioutil.WriteFile(f, goregular.TTF, 0600)
+	assert.NoError(t, err)
+	s := &astisub.Subtitles{Items: []*astisub.Item{
+		{
+			EndAt:       2 * time.Second,
+			InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorYellow, FontSize: []astisub.Length{{Unit: astisub.LengthUnitPixel, Value: 40}}, TextOutline: "black 3px"},
+			Lines:       []astisub.Line{{{Text: "Hello"}, {InlineStyle: &astisub.StyleAttributes{FontStyle: astisub.FontStyleItalic, FontWeight: "bold"}, Text: "world"}}},
+			StartAt:     time.Second,
+		},
+		{
+			EndAt:    4 * time.Second,
+			Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 20, Left: 0, TextAlign: astisub.TextAlignLeft, Top: 0, Width: 50},
+			Lines:    []astisub.Line{{{Text: "Top"}}, {{Text: "left"}}},
+			StartAt:  3 * time.Second,
+		},
+		{EndAt: 5 * time.Second, Lines: []astisub.Line{{{Text: " "}}}, StartAt: 4 * time.Second},
+	}}
+
+	// Invalid options
+	_, err = render.Render(*s, render.Options{Font: f})
+	assert.Error(t, err)
+	_, err = render.Render(*s, render.Options{FrameHeight: 720, FrameWidth: 1280})
+	assert.Error(t, err)
+
+	// Render
+	r, err := render.Render(*s, render.Options{Font: f, FrameHeight: 720, FrameWidth: 1280})
+	assert.NoError(t, err)
+	assert.Len(t, r.Items, 2)
+
+	// Bottom center with outline
+	i := r.Items[0].Image
+	assert.Equal(t, 1280, i.FrameWidth)
+	assert.Equal(t, 720, i.FrameHeight)
+	b := i.Rectangle()
+	assert.InDelta(t, 640, (b.Min.X+b.Max.X)/2, 20)
+	assert.True(t, b.Max.Y <= 648 && b.Max.Y > 600)
+	assert.True(t, b.Dy() < 60)
+	var yellow, black bool
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(i.Image.At(x, y)).(color.NRGBA)
+			yellow = yellow || c == color.NRGBA{R: 0xff, G: 0xff, A: 0xff}
+			black = black || c == color.NRGBA{A: 0xff}
+		}
+	}
+	assert.True(t, yellow)
+	assert.True(t, black)
+	assert.Equal(t, time.Second, r.Items[0].StartAt)
+	assert.Equal(t, s.Items[0].Lines, r.Items[0].Lines)
+
+	// Top left with 2 lines
+	b = r.Items[1].Image.Rectangle()
+	assert.True(t, b.Min.X < 10 && b.Min.Y < 30)
+	assert.True(t, b.Dy() > 48)
+
+	// PGS
+	w := &bytes.Buffer{}
+	err = r.WriteToPGS(w)
+	assert.NoError(t, err)
+	p, err := astisub.ReadFromPGS(w)
+	assert.NoError(t, err)
+	assert.Len(t, p.Items, 2)
+	assert.Equal(t, b, p.Items[1].Image.Rectangle())
+
+}
@@ -99,6 +99,11 @@ func (s Subtitles) WriteToSRT(o io.Writer) (err error) {
 		return
 	}
 
+	// Images are not supported
+	if err = s.textOnly("srt"); err != nil {
+		return
+	}
+
 	// Add BOM header
 	var c []byte
 	c = append(c, BytesBOM...)
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/asticode/go-astitools/byte"
 	"github.com/asticode/go-astitools/map"
@@ -64,15 +65,26 @@ const (
 
 // STL display standard code
 const (
-	stlDisplayStandardCodeOpenSubtitling = "0"
-	stlDisplayStandardCodeLevel1Teletext = "1"
-	stlDisplayStandardCodeLevel2Teletext = "2"
+	STLDisplayStandardCodeOpenSubtitling = "0"
+	STLDisplayStandardCodeLevel1Teletext = "1"
+	STLDisplayStandardCodeLevel2Teletext = "2"
 )
 
 // STL framerate mapping
-var stlFramerateMapping = astimap.NewMap("STL25.01", 25).
-	Set("STL25.01", 25).
-	Set("STL30.01", 30)
+// EBU Tech 3264 only defines disk format codes for 25 and 30 frames per second
+var stlFramerateMapping = astimap.NewMap("STL25.01", Framerate25).
+	Set("STL25.01", Framerate25).
+	Set("STL30.01", Framerate30)
+
+// STL displayable area
+const (
+	stlMaximumNumberOfDisplayableCharactersOpenSubtitling = 99
+	stlMaximumNumberOfDisplayableCharactersTeletext       = 40
+	stlMaximumNumberOfDisplayableRowsOpenSubtitling       = 99
+	stlMaximumNumberOfDisplayableRowsTeletext             = 23
+	stlMaximumTextFieldLength                             = 112
+	stlTeletextDefaultVerticalPosition                    = 20
+)
 
 // STL justification code
 const (
@@ -94,8 +106,8 @@ var stlLanguageMapping = astimap.NewMap(stlLanguageCodeEnglish, LanguageEnglish)
 
 // STL timecode status
 const (
-	stlTimecodeStatusNotIntendedForUse = "0"
-	stlTimecodeStatusIntendedForUse    = "1"
+	STLTimecodeStatusNotIntendedForUse = "0"
+	STLTimecodeStatusIntendedForUse    = "1"
 )
 
 // STL unicode diacritic
@@ -204,6 +216,7 @@ func ReadFromSTL(i io.Reader) (o *Subtitles, err error) {
 	}
 
 	// Parse Text and Timing Information (TTI) blocks.
+	var comments []string
 	for {
 		// Read TTI block
 		if b, err = readNBytes(i, stlBlockSizeTTI); err != nil {
@@ -214,12 +227,27 @@ func ReadFromSTL(i io.Reader) (o *Subtitles, err error) {
 			return
 		}
 
-		// Init item
+		// Parse TTI block
 		var t = parseTTIBlock(b, g.framerate)
+
+		// Comments are attached to the next subtitle
+		if t.commentFlag == stlCommentFlagTextContainsCommentsNotIntendedForTransmission {
+			for _, text := range strings.Split(t.text, "\n") {
+				if text = strings.TrimSpace(text); len(text) > 0 {
+					comments = append(comments, text)
+				}
+			}
+			continue
+		}
+
+		// Init item
 		var i = &Item{
-			EndAt:   t.timecodeOut - g.timecodeStartOfProgramme,
-			StartAt: t.timecodeIn - g.timecodeStartOfProgramme,
+			Comments:      comments,
+			EndAt:         t.timecodeOut - g.timecodeStartOfProgramme,
+			StartAt:       t.timecodeIn - g.timecodeStartOfProgramme,
+			SubtitleGroup: t.subtitleGroupNumber,
 		}
+		comments = nil
 
 		// Add lines
 		for _, text := range strings.Split(t.text, "\n") {
@@ -230,9 +258,15 @@ func ReadFromSTL(i io.Reader) (o *Subtitles, err error) {
 			i.Lines = append(i.Lines, []LineItem{{Text: text}})
 		}
 
+		// Add geometry
+		i.Geometry = newSTLGeometry(t, len(i.Lines), g)
+
 		// Append item
 		o.Items = append(o.Items, i)
 	}
+
+	// Comments following the last subtitle aren't attached to any item
+	o.Metadata.Comments = comments
 	return
 }
 
@@ -264,7 +298,7 @@ type gsiBlock struct {
 	displayStandardCode                              string
 	editorContactDetails                             string
 	editorName                                       string
-	framerate                                        int
+	framerate                                        Framerate
 	languageCode                                     string
 	maximumNumberOfDisplayableCharactersInAnyTextRow int
 	maximumNumberOfDisplayableRows                   int
@@ -289,7 +323,18 @@ type gsiBlock struct {
 }
 
 // newGSIBlock builds the subtitles GSI block
-func newGSIBlock(s Subtitles) (g *gsiBlock) {
+func newGSIBlock(s Subtitles, o STLOptions) (g *gsiBlock) {
+	// Count blocks
+	var groups = make(map[int]bool)
+	var numberOfTTIBlocks int
+	for _, i := range s.Items {
+		groups[i.SubtitleGroup] = true
+		numberOfTTIBlocks += 1 + len(i.Comments)
+	}
+	if s.Metadata != nil {
+		numberOfTTIBlocks += len(s.Metadata.Comments)
+	}
+
 	// Init
 	g = &gsiBlock{
 		characterCodeTableNumber: stlCharacterCodeTableNumberLatin,
@@ -297,22 +342,44 @@ func newGSIBlock(s Subtitles) (g *gsiBlock) {
 		countryOfOrigin:          stlCountryCodeFrance,
 		creationDate:             Now(),
 		diskSequenceNumber:       1,
-		displayStandardCode:      stlDisplayStandardCodeLevel1Teletext,
-		framerate:                25,
+		displayStandardCode:      STLDisplayStandardCodeLevel1Teletext,
+		framerate:                Framerate25,
 		languageCode:             stlLanguageCodeFrench,
-		maximumNumberOfDisplayableCharactersInAnyTextRow: 40,
-		maximumNumberOfDisplayableRows:                   23,
+		maximumNumberOfDisplayableCharactersInAnyTextRow: stlMaximumNumberOfDisplayableCharactersTeletext,
+		maximumNumberOfDisplayableRows:                   stlMaximumNumberOfDisplayableRowsTeletext,
 		subtitleListReferenceCode:                        "12345678",
-		timecodeStatus:                                   stlTimecodeStatusIntendedForUse,
+		timecodeStartOfProgramme:                         o.TimecodeStartOfProgramme,
+		timecodeStatus:                                   STLTimecodeStatusIntendedForUse,
 		totalNumberOfDisks:                               1,
-		totalNumberOfSubtitleGroups:                      1,
+		totalNumberOfSubtitleGroups:                      len(groups),
 		totalNumberOfSubtitles:                           len(s.Items),
-		totalNumberOfTTIBlocks:                           len(s.Items),
+		totalNumberOfTTIBlocks:                           numberOfTTIBlocks,
+	}
+
+	// There's always at least one subtitle group
+	if g.totalNumberOfSubtitleGroups == 0 {
+		g.totalNumberOfSubtitleGroups = 1
+	}
+
+	// Timecode status
+	if len(o.TimecodeStatus) > 0 {
+		g.timecodeStatus = o.TimecodeStatus
+	}
+
+	// Display standard
+	if len(o.DisplayStandardCode) > 0 {
+		g.displayStandardCode = o.DisplayStandardCode
+	}
+	if g.displayStandardCode == STLDisplayStandardCodeOpenSubtitling {
+		g.maximumNumberOfDisplayableCharactersInAnyTextRow = stlMaximumNumberOfDisplayableCharactersOpenSubtitling
+		g.maximumNumberOfDisplayableRows = stlMaximumNumberOfDisplayableRowsOpenSubtitling
 	}
 
 	// Add metadata
 	if s.Metadata != nil {
-		g.framerate = s.Metadata.Framerate
+		if !s.Metadata.Framerate.IsZero() {
+			g.framerate = s.Metadata.Framerate
+		}
 		g.languageCode = stlLanguageMapping.A(s.Metadata.Language).(string)
 		g.originalProgramTitle = s.Metadata.Title
 		g.publisher = s.Metadata.Copyright
@@ -320,7 +387,7 @@ func newGSIBlock(s Subtitles) (g *gsiBlock) {
 
 	// Timecode first in cue
 	if len(s.Items) > 0 {
-		g.timecodeFirstInCue = s.Items[0].StartAt
+		g.timecodeFirstInCue = s.Items[0].StartAt + g.timecodeStartOfProgramme
 	}
 	return
 }
@@ -335,7 +402,7 @@ func parseGSIBlock(b []byte) (g *gsiBlock, err error) {
 		displayStandardCode:       string(bytes.TrimSpace([]byte{b[11]})),
 		editorName:                string(bytes.TrimSpace(b[309:341])),
 		editorContactDetails:      string(bytes.TrimSpace(b[341:373])),
-		framerate:                 stlFramerateMapping.B(string(b[3:11])).(int),
+		framerate:                 stlFramerateMapping.B(string(b[3:11])).(Framerate),
 		languageCode:              string(bytes.TrimSpace(b[14:16])),
 		originalEpisodeTitle:      string(bytes.TrimSpace(b[48:80])),
 		originalProgramTitle:      string(bytes.TrimSpace(b[16:48])),
@@ -471,7 +538,7 @@ func (b gsiBlock) bytes() (o []byte) {
 }
 
 // parseDurationSTL parses a STL duration
-func parseDurationSTL(i string, framerate int) (d time.Duration, err error) {
+func parseDurationSTL(i string, framerate Framerate) (d time.Duration, err error) {
 	// Parse hours
 	var hours, hoursString = 0, i[0:2]
 	if hours, err = strconv.Atoi(hoursString); err != nil {
@@ -501,43 +568,14 @@ func parseDurationSTL(i string, framerate int) (d time.Duration, err error) {
 	}
 
 	// Set duration
-	d = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second + time.Duration(1e9*frames/framerate)*time.Nanosecond
+	d = framerate.timecodeToDuration(timecode{frames: frames, hours: hours, minutes: minutes, seconds: seconds})
 	return
 }
 
 // formatDurationSTL formats a STL duration
-func formatDurationSTL(d time.Duration, framerate int) (o string) {
-	// Add hours
-	if d.Hours() < 10 {
-		o += "0"
-	}
-	var delta = int(math.Floor(d.Hours()))
-	o += strconv.Itoa(delta)
-	d -= time.Duration(delta) * time.Hour
-
-	// Add minutes
-	if d.Minutes() < 10 {
-		o += "0"
-	}
-	delta = int(math.Floor(d.Minutes()))
-	o += strconv.Itoa(delta)
-	d -= time.Duration(delta) * time.Minute
-
-	// Add seconds
-	if d.Seconds() < 10 {
-		o += "0"
-	}
-	delta = int(math.Floor(d.Seconds()))
-	o += strconv.Itoa(delta)
-	d -= time.Duration(delta) * time.Second
-
-	// Add frames
-	var frames = int(int(d.Nanoseconds()) * framerate / 1e9)
-	if frames < 10 {
-		o += "0"
-	}
-	o += strconv.Itoa(frames)
-	return
+func formatDurationSTL(d time.Duration, framerate Framerate) string {
+	var t = framerate.durationToTimecode(d)
+	return fmt.Sprintf("%.2d%.2d%.2d%.2d", t.hours, t.minutes, t.seconds, t.frames)
 }
 
 // ttiBlock represents a TTI block
@@ -555,18 +593,23 @@ type ttiBlock struct {
 }
 
 // newTTIBlock builds an item TTI block
-func newTTIBlock(i *Item, idx int) (t *ttiBlock) {
+func newTTIBlock(i *Item, idx int, g *gsiBlock) (t *ttiBlock) {
 	// Init
 	t = &ttiBlock{
 		commentFlag:          stlCommentFlagTextContainsSubtitleData,
 		cumulativeStatus:     stlCumulativeStatusSubtitleNotPartOfACumulativeSet,
 		extensionBlockNumber: 255,
-		justificationCode:    stlJustificationCodeLeftJustifiedText,
-		subtitleGroupNumber:  0,
+		justificationCode:    stlJustificationCode(i.geometry()),
+		subtitleGroupNumber:  i.SubtitleGroup,
 		subtitleNumber:       idx,
-		timecodeIn:           i.StartAt,
-		timecodeOut:          i.EndAt,
-		verticalPosition:     20,
+		timecodeIn:           i.StartAt + g.timecodeStartOfProgramme,
+		timecodeOut:          i.EndAt + g.timecodeStartOfProgramme,
+		verticalPosition:     stlVerticalPosition(len(i.Lines), g),
+	}
+
+	// Add vertical position
+	if geometry := i.geometry(); geometry != nil {
+		t.verticalPosition = stlGeometryVerticalPosition(*geometry, len(i.Lines), g)
 	}
 
 	// Add text
@@ -578,8 +621,136 @@ func newTTIBlock(i *Item, idx int) (t *ttiBlock) {
 	return
 }
 
+// stlVerticalPosition returns the vertical position of the first row of a bottom aligned subtitle
+// Teletext rows are numbered from 1 and each line is displayed in double height, therefore taking 2 rows.
+// Open subtitling rows are numbered from 0 and each line takes 1 row.
+func stlVerticalPosition(numberOfLines int, g *gsiBlock) int {
+	if g.displayStandardCode == STLDisplayStandardCodeOpenSubtitling {
+		return g.maximumNumberOfDisplayableRows - numberOfLines
+	}
+	if vp := g.maximumNumberOfDisplayableRows + 1 - 2*numberOfLines; vp < stlTeletextDefaultVerticalPosition {
+		return vp
+	}
+	return stlTeletextDefaultVerticalPosition
+}
+
+// stlRows returns the number of the first row and the number of rows each line takes
+func stlRows(g *gsiBlock) (firstRow, rowsPerLine int) {
+	if g.displayStandardCode == STLDisplayStandardCodeOpenSubtitling {
+		return 0, 1
+	}
+	return 1, 2
+}
+
+// newSTLGeometry creates the geometry of a TTI block
+func newSTLGeometry(t *ttiBlock, numberOfLines int, g *gsiBlock) *Geometry {
+	// Invalid number of rows
+	if g.maximumNumberOfDisplayableRows <= 0 {
+		return nil
+	}
+
+	// Create geometry
+	var firstRow, rowsPerLine = stlRows(g)
+	var rowHeight = 100 / float64(g.maximumNumberOfDisplayableRows)
+	var o = &Geometry{
+		DisplayAlign: DisplayAlignBefore,
+		Height:       math.Min(float64(numberOfLines*rowsPerLine)*rowHeight, 100),
+		Top:          math.Max(0, math.Min(float64(t.verticalPosition-firstRow)*rowHeight, 100)),
+		Width:        100,
+	}
+	o.Height = math.Min(o.Height, 100-o.Top)
+
+	// Add text align
+	switch t.justificationCode {
+	case stlJustificationCodeCentredText:
+		o.TextAlign = TextAlignCenter
+	case stlJustificationCodeLeftJustifiedText:
+		o.TextAlign = TextAlignLeft
+	case stlJustificationCodeRightJustifiedText:
+		o.TextAlign = TextAlignRight
+	}
+	return o
+}
+
+// stlGeometryVerticalPosition returns the vertical position of the first row of a subtitle placed in a geometry
+func stlGeometryVerticalPosition(geometry Geometry, numberOfLines int, g *gsiBlock) int {
+	// Get row the text block is anchored to
+	var firstRow, rowsPerLine = stlRows(g)
+	var row = int(math.Floor(geometry.anchor()*float64(g.maximumNumberOfDisplayableRows)/100 + 0.5))
+
+	// Get first row
+	var rows = numberOfLines * rowsPerLine
+	switch geometry.DisplayAlign {
+	case DisplayAlignAfter:
+		row -= rows
+	case DisplayAlignCenter:
+		row -= rows / 2
+	}
+
+	// Make sure the subtitle is displayable
+	if row > g.maximumNumberOfDisplayableRows-rows {
+		row = g.maximumNumberOfDisplayableRows - rows
+	}
+	if row < 0 {
+		row = 0
+	}
+	return firstRow + row
+}
+
+// stlJustificationCode returns the justification code of a geometry
+func stlJustificationCode(geometry *Geometry) byte {
+	if geometry != nil {
+		switch geometry.TextAlign {
+		case TextAlignCenter:
+			return stlJustificationCodeCentredText
+		case TextAlignEnd, TextAlignRight:
+			return stlJustificationCodeRightJustifiedText
+		}
+	}
+	return stlJustificationCodeLeftJustifiedText
+}
+
+// validateItemSTL checks whether an item can be displayed within the GSI block displayable area
+func validateItemSTL(i *Item, g *gsiBlock) (err error) {
+	// Check rows
+	var minimumVerticalPosition = 1
+	if g.displayStandardCode == STLDisplayStandardCodeOpenSubtitling {
+		minimumVerticalPosition = 0
+	}
+	if stlVerticalPosition(len(i.Lines), g) < minimumVerticalPosition {
+		err = fmt.Errorf("Subtitle between %s and %s has too many lines (%d) for display standard %s", i.StartAt, i.EndAt, len(i.Lines), g.displayStandardCode)
+		return
+	}
+
+	// Check characters
+	var length int
+	for _, l := range i.Lines {
+		var text = l.String()
+		if c := utf8.RuneCountInString(norm.NFC.String(text)); c > g.maximumNumberOfDisplayableCharactersInAnyTextRow {
+			err = fmt.Errorf("Line %s has %d characters, maximum is %d", text, c, g.maximumNumberOfDisplayableCharactersInAnyTextRow)
+			return
+		}
+		length += len(encodeTextSTL(text)) + 1
+	}
+
+	// Check text field
+	if length-1 > stlMaximumTextFieldLength {
+		err = fmt.Errorf("Subtitle between %s and %s is %d bytes long, maximum is %d", i.StartAt, i.EndAt, length-1, stlMaximumTextFieldLength)
+		return
+	}
+	return
+}
+
+// newCommentTTIBlock builds a TTI block containing a comment not intended for transmission
+func newCommentTTIBlock(i *Item, comment string, idx int, g *gsiBlock) (t *ttiBlock) {
+	t = newTTIBlock(i, idx, g)
+	t.commentFlag = stlCommentFlagTextContainsCommentsNotIntendedForTransmission
+	t.text = comment
+	return
+}
+
 // parseTTIBlock parses a TTI block
-func parseTTIBlock(p []byte, framerate int) *ttiBlock {
+func parseTTIBlock(p []byte, framerate Framerate) *ttiBlock {
 	return &ttiBlock{
 		commentFlag:          p[15],
 		cumulativeStatus:     p[4],
@@ -612,31 +783,14 @@ func (t *ttiBlock) bytes(g *gsiBlock) (o []byte) {
 }
 
 // formatDurationSTLBytes formats a STL duration in bytes
-func formatDurationSTLBytes(d time.Duration, framerate int) (o []byte) {
-	// Add hours
-	var hours = int(math.Floor(d.Hours()))
-	o = append(o, byte(uint8(hours)))
-	d -= time.Duration(hours) * time.Hour
-
-	// Add minutes
-	var minutes = int(math.Floor(d.Minutes()))
-	o = append(o, byte(uint8(minutes)))
-	d -= time.Duration(minutes) * time.Minute
-
-	// Add seconds
-	var seconds = int(math.Floor(d.Seconds()))
-	o = append(o, byte(uint8(seconds)))
-	d -= time.Duration(seconds) * time.Second
-
-	// Add frames
-	var frames = int(int(d.Nanoseconds()) * framerate / 1e9)
-	o = append(o, byte(uint8(frames)))
-	return
+func formatDurationSTLBytes(d time.Duration, framerate Framerate) []byte {
+	var t = framerate.durationToTimecode(d)
+	return []byte{byte(uint8(t.hours)), byte(uint8(t.minutes)), byte(uint8(t.seconds)), byte(uint8(t.frames))}
 }
 
 // parseDurationSTLBytes parses a STL duration in bytes
-func parseDurationSTLBytes(b []byte, framerate int) time.Duration {
-	return time.Duration(uint8(b[0]))*time.Hour + time.Duration(uint8(b[1]))*time.Minute + time.Duration(uint8(b[2]))*time.Second + time.Duration(1e9*int(uint8(b[3]))/framerate)*time.Nanosecond
+func parseDurationSTLBytes(b []byte, framerate Framerate) time.Duration {
+	return framerate.timecodeToDuration(timecode{frames: int(uint8(b[3])), hours: int(uint8(b[0])), minutes: int(uint8(b[1])), seconds: int(uint8(b[2]))})
 }
 
 // encodeTextSTL encodes the STL text
@@ -672,28 +826,85 @@ func decodeTextSTL(i []byte) (o string) {
 	return
 }
 
+// STLOptions represents STL write options
+// DisplayStandardCode switches between teletext (default) and open subtitling output modes
+type STLOptions struct {
+	DisplayStandardCode      string
+	TimecodeStartOfProgramme time.Duration
+	TimecodeStatus           string
+}
+
 // WriteToSTL writes subtitles in .stl format
-func (s Subtitles) WriteToSTL(o io.Writer) (err error) {
+func (s Subtitles) WriteToSTL(o io.Writer, opts ...STLOptions) (err error) {
 	// Do not write anything if no subtitles
 	if len(s.Items) == 0 {
 		err = ErrNoSubtitlesToWrite
 		return
 	}
 
+	// Images are not supported
+	if err = s.textOnly("stl"); err != nil {
+		return
+	}
+
+	// Get options
+	var opt STLOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	// Create GSI block
+	var g = newGSIBlock(s, opt)
+
+	// Check framerate since there's a disk format code for 25 and 30 frames per second only
+	if !stlFramerateMapping.InB(g.framerate) {
+		err = fmt.Errorf("Framerate %s is not supported by STL", g.framerate)
+		return
+	}
+
 	// Write GSI block
-	var g = newGSIBlock(s)
 	if _, err = o.Write(g.bytes()); err != nil {
 		err = errors.Wrap(err, "writing gsi block failed")
 		return
 	}
 
 	// Loop through items
+	// Comment tti blocks share the subtitle number of the item they're attached to so that numbers match the total
+	// number of subtitles
+	var block int
 	for idx, item := range s.Items {
+		// Validate item
+		if err = validateItemSTL(item, g); err != nil {
+			err = errors.Wrap(err, "validating item failed")
+			return
+		}
+
+		// Write comment tti blocks
+		for _, comment := range item.Comments {
+			block++
+			if _, err = o.Write(newCommentTTIBlock(item, comment, idx+1, g).bytes(g)); err != nil {
+				err = errors.Wrapf(err, "writing tti block #%d failed", block)
+				return
+			}
+		}
+
 		// Write tti block
-		if _, err = o.Write(newTTIBlock(item, idx+1).bytes(g)); err != nil {
-			err = errors.Wrapf(err, "writing tti block #%d failed", idx+1)
+		block++
+		if _, err = o.Write(newTTIBlock(item, idx+1, g).bytes(g)); err != nil {
+			err = errors.Wrapf(err, "writing tti block #%d failed", block)
 			return
 		}
 	}
+
+	// Write trailing comment tti blocks
+	if s.Metadata != nil {
+		for _, comment := range s.Metadata.Comments {
+			block++
+			if _, err = o.Write(newCommentTTIBlock(s.Items[len(s.Items)-1], comment, len(s.Items), g).bytes(g)); err != nil {
+				err = errors.Wrapf(err, "writing tti block #%d failed", block)
+				return
+			}
+		}
+	}
 	return
 }
@@ -9,15 +9,15 @@ import (
 
 func TestSTLDuration(t *testing.T) {
 	// Default
-	d, err := parseDurationSTL("12345678", 100)
+	d, err := parseDurationSTL("12345678", NewFramerate(100))
 	assert.NoError(t, err)
 	assert.Equal(t, 12*time.Hour+34*time.Minute+56*time.Second+780*time.Millisecond, d)
-	s := formatDurationSTL(d, 100)
+	s := formatDurationSTL(d, NewFramerate(100))
 	assert.Equal(t, "12345678", s)
 
 	// Bytes
-	b := formatDurationSTLBytes(d, 100)
+	b := formatDurationSTLBytes(d, NewFramerate(100))
 	assert.Equal(t, []byte{0xc, 0x22, 0x38, 0x4e}, b)
-	d2 := parseDurationSTLBytes([]byte{0xc, 0x22, 0x38, 0x4e}, 100)
+	d2 := parseDurationSTLBytes([]byte{0xc, 0x22, 0x38, 0x4e}, NewFramerate(100))
 	assert.Equal(t, d, d2)
 }
@@ -2,7 +2,9 @@ package astisub_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"time"
@@ -23,7 +25,7 @@ func TestSTL(t *testing.T) {
 	assert.NoError(t, err)
 	assertSubtitleItems(t, s)
 	// Metadata
-	assert.Equal(t, &astisub.Metadata{Copyright: "Copyright test", Framerate: 25, Language: astisub.LanguageFrench, Title: "Title test"}, s.Metadata)
+	assert.Equal(t, &astisub.Metadata{Copyright: "Copyright test", Framerate: astisub.Framerate25, Language: astisub.LanguageFrench, Title: "Title test"}, s.Metadata)
 
 	// No subtitles to write
 	w := &bytes.Buffer{}
@@ -37,3 +39,78 @@ func TestSTL(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, string(c), w.String())
 }
+
+func TestSTLOptions(t *testing.T) {
+	// Write
+	var s = &astisub.Subtitles{Items: []*astisub.Item{
+		{Comments: []string{"comment-1", "comment-2"}, EndAt: 3 * time.Second, Lines: []astisub.Line{{{Text: "subtitle-1"}}}, StartAt: time.Second},
+		{EndAt: 7 * time.Second, Lines: []astisub.Line{{{Text: "subtitle-2"}}}, StartAt: 3 * time.Second, SubtitleGroup: 1},
+	}, Metadata: &astisub.Metadata{Comments: []string{"comment-3"}}}
+	w := &bytes.Buffer{}
+	err := s.WriteToSTL(w, astisub.STLOptions{TimecodeStartOfProgramme: 10 * time.Hour, TimecodeStatus: astisub.STLTimecodeStatusNotIntendedForUse})
+	assert.NoError(t, err)
+	assert.Equal(t, 1024+5*128, w.Len())
+	assert.Equal(t, "00005000020024", string(w.Bytes()[238:252]))
+	assert.Equal(t, "01000000010000", string(w.Bytes()[255:269]))
+	assert.Equal(t, byte(0x1), w.Bytes()[1024+15])
+	assert.Equal(t, []byte{0xa, 0x0, 0x1, 0x0}, w.Bytes()[1024+5:1024+9])
+	for idx, n := range []uint16{1, 1, 1, 2, 2} {
+		assert.Equal(t, n, binary.LittleEndian.Uint16(w.Bytes()[1024+idx*128+1:]))
+	}
+
+	// Read
+	s, err = astisub.ReadFromSTL(w)
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 2)
+	assert.Equal(t, []string{"comment-1", "comment-2"}, s.Items[0].Comments)
+	assert.Equal(t, time.Second, s.Items[0].StartAt)
+	assert.Equal(t, 3*time.Second, s.Items[0].EndAt)
+	assert.Equal(t, "subtitle-1", s.Items[0].String())
+	assert.Equal(t, 0, s.Items[0].SubtitleGroup)
+	assert.Empty(t, s.Items[1].Comments)
+	assert.Equal(t, 1, s.Items[1].SubtitleGroup)
+	assert.Equal(t, []string{"comment-3"}, s.Metadata.Comments)
+
+	// Split by subtitle group
+	g := s.SplitBySubtitleGroup()
+	assert.Len(t, g, 2)
+	assert.Equal(t, []*astisub.Item{s.Items[0]}, g[0].Items)
+	assert.Equal(t, []*astisub.Item{s.Items[1]}, g[1].Items)
+
+	// Unsupported framerates
+	for _, f := range []astisub.Framerate{astisub.Framerate23976, astisub.Framerate24, astisub.Framerate2997, astisub.Framerate2997DropFrame, astisub.Framerate50, astisub.Framerate5994, astisub.Framerate60} {
+		s.Metadata.Framerate = f
+		err = s.WriteToSTL(&bytes.Buffer{})
+		assert.EqualError(t, err, "Framerate "+f.String()+" is not supported by STL")
+	}
+}
+
+func TestSTLDisplayStandard(t *testing.T) {
+	// Open subtitling
+	var s = &astisub.Subtitles{Items: []*astisub.Item{{EndAt: 3 * time.Second, Lines: []astisub.Line{{{Text: "subtitle-1"}}, {{Text: "subtitle-1"}}}, StartAt: time.Second}}}
+	w := &bytes.Buffer{}
+	err := s.WriteToSTL(w, astisub.STLOptions{DisplayStandardCode: astisub.STLDisplayStandardCodeOpenSubtitling})
+	assert.NoError(t, err)
+	assert.Equal(t, byte('0'), w.Bytes()[11])
+	assert.Equal(t, "9999", string(w.Bytes()[251:255]))
+	assert.Equal(t, byte(97), w.Bytes()[1024+13])
+
+	// Teletext
+	s.Items[0].Lines = append(s.Items[0].Lines, astisub.Line{{Text: "subtitle-1"}})
+	w.Reset()
+	err = s.WriteToSTL(w)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('1'), w.Bytes()[11])
+	assert.Equal(t, "4023", string(w.Bytes()[251:255]))
+	assert.Equal(t, byte(18), w.Bytes()[1024+13])
+
+	// Validation
+	s.Items[0].Lines = []astisub.Line{{{Text: strings.Repeat("a", 41)}}}
+	err = s.WriteToSTL(w)
+	assert.Error(t, err)
+	err = s.WriteToSTL(w, astisub.STLOptions{DisplayStandardCode: astisub.STLDisplayStandardCodeOpenSubtitling})
+	assert.NoError(t, err)
+	s.Items[0].Lines = make([]astisub.Line, 12)
+	err = s.WriteToSTL(w)
+	assert.Error(t, err)
+}
@@ -0,0 +1,389 @@
+package astisub
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Color represents a RGBA color
+type Color struct {
+	Alpha uint8
+	Blue  uint8
+	Green uint8
+	Red   uint8
+}
+
+// Colors
+var (
+	ColorBlack       = Color{Alpha: 0xff}
+	ColorBlue        = Color{Alpha: 0xff, Blue: 0xff}
+	ColorCyan        = Color{Alpha: 0xff, Blue: 0xff, Green: 0xff}
+	ColorGray        = Color{Alpha: 0xff, Blue: 0x80, Green: 0x80, Red: 0x80}
+	ColorGreen       = Color{Alpha: 0xff, Green: 0x80}
+	ColorLime        = Color{Alpha: 0xff, Green: 0xff}
+	ColorMagenta     = Color{Alpha: 0xff, Blue: 0xff, Red: 0xff}
+	ColorMaroon      = Color{Alpha: 0xff, Red: 0x80}
+	ColorNavy        = Color{Alpha: 0xff, Blue: 0x80}
+	ColorOlive       = Color{Alpha: 0xff, Green: 0x80, Red: 0x80}
+	ColorPurple      = Color{Alpha: 0xff, Blue: 0x80, Red: 0x80}
+	ColorRed         = Color{Alpha: 0xff, Red: 0xff}
+	ColorSilver      = Color{Alpha: 0xff, Blue: 0xc0, Green: 0xc0, Red: 0xc0}
+	ColorTeal        = Color{Alpha: 0xff, Blue: 0x80, Green: 0x80}
+	ColorTransparent = Color{}
+	ColorWhite       = Color{Alpha: 0xff, Blue: 0xff, Green: 0xff, Red: 0xff}
+	ColorYellow      = Color{Alpha: 0xff, Green: 0xff, Red: 0xff}
+)
+
+// Named colors
+// Names are ordered so that a color is formatted with the first name it matches
+var colorNames = []struct {
+	c    Color
+	name string
+}{
+	{c: ColorTransparent, name: "transparent"},
+	{c: ColorBlack, name: "black"},
+	{c: ColorSilver, name: "silver"},
+	{c: ColorGray, name: "gray"},
+	{c: ColorWhite, name: "white"},
+	{c: ColorMaroon, name: "maroon"},
+	{c: ColorRed, name: "red"},
+	{c: ColorPurple, name: "purple"},
+	{c: ColorMagenta, name: "magenta"},
+	{c: ColorMagenta, name: "fuchsia"},
+	{c: ColorGreen, name: "green"},
+	{c: ColorLime, name: "lime"},
+	{c: ColorOlive, name: "olive"},
+	{c: ColorYellow, name: "yellow"},
+	{c: ColorNavy, name: "navy"},
+	{c: ColorBlue, name: "blue"},
+	{c: ColorTeal, name: "teal"},
+	{c: ColorCyan, name: "cyan"},
+	{c: ColorCyan, name: "aqua"},
+}
+
+// ParseColor parses a named, "#rgb", "#rrggbb", "#rrggbbaa", "rgb(r,g,b)" or "rgba(r,g,b,a)" color
+func ParseColor(i string) (c *Color, err error) {
+	// Named color
+	var s = strings.ToLower(strings.TrimSpace(i))
+	for _, n := range colorNames {
+		if n.name == s {
+			c = &Color{}
+			*c = n.c
+			return
+		}
+	}
+
+	// Hexadecimal color
+	c = &Color{Alpha: 0xff}
+	if strings.HasPrefix(s, "#") {
+		// Expand short form
+		var h = s[1:]
+		if len(h) == 3 {
+			h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+		}
+
+		// Parse components
+		if len(h) != 6 && len(h) != 8 {
+			err = fmt.Errorf("Invalid color %s", i)
+			return
+		}
+		for idx, v := range []*uint8{&c.Red, &c.Green, &c.Blue, &c.Alpha}[:len(h)/2] {
+			var u uint64
+			if u, err = strconv.ParseUint(h[2*idx:2*idx+2], 16, 8); err != nil {
+				err = errors.Wrapf(err, "parsing color component %s failed", h[2*idx:2*idx+2])
+				return
+			}
+			*v = uint8(u)
+		}
+		return
+	}
+
+	// Functional color
+	var components []string
+	switch {
+	case strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")"):
+		if components = strings.Split(s[4:len(s)-1], ","); len(components) != 3 {
+			err = fmt.Errorf("Invalid color %s", i)
+			return
+		}
+	case strings.HasPrefix(s, "rgba(") && strings.HasSuffix(s, ")"):
+		if components = strings.Split(s[5:len(s)-1], ","); len(components) != 4 {
+			err = fmt.Errorf("Invalid color %s", i)
+			return
+		}
+	default:
+		err = fmt.Errorf("Invalid color %s", i)
+		return
+	}
+	for idx, v := range []*uint8{&c.Red, &c.Green, &c.Blue, &c.Alpha}[:len(components)] {
+		var u uint64
+		if u, err = strconv.ParseUint(strings.TrimSpace(components[idx]), 10, 8); err != nil {
+			err = errors.Wrapf(err, "parsing color component %s failed", components[idx])
+			return
+		}
+		*v = uint8(u)
+	}
+	return
+}
+
+// Name returns the name of the color if it has one
+func (c Color) Name() (string, bool) {
+	for _, n := range colorNames {
+		if n.c == c {
+			return n.name, true
+		}
+	}
+	return "", false
+}
+
+// String implements the Stringer interface
+// Colors are formatted as "#rrggbbaa"
+func (c Color) String() string {
+	return fmt.Sprintf("#%.2x%.2x%.2x%.2x", c.Red, c.Green, c.Blue, c.Alpha)
+}
+
+// Length units
+const (
+	LengthUnitCell    = "c"
+	LengthUnitEm      = "em"
+	LengthUnitPercent = "%"
+	LengthUnitPixel   = "px"
+)
+
+// Length represents a length with its unit
+type Length struct {
+	Unit  string
+	Value float64
+}
+
+// ParseLength parses a length such as "50px", "80%", "1.5em" or "2c"
+func ParseLength(i string) (l Length, err error) {
+	// Get unit
+	var s = strings.TrimSpace(i)
+	for _, u := range []string{LengthUnitPixel, LengthUnitEm, LengthUnitPercent, LengthUnitCell} {
+		if strings.HasSuffix(s, u) {
+			l.Unit = u
+			break
+		}
+	}
+	if len(l.Unit) == 0 {
+		err = fmt.Errorf("Invalid length %s", i)
+		return
+	}
+
+	// Parse value
+	if l.Value, err = strconv.ParseFloat(strings.TrimSuffix(s, l.Unit), 64); err != nil {
+		err = errors.Wrapf(err, "parsing length %s failed", i)
+		return
+	}
+	return
+}
+
+// parseLengths parses a list of lengths split by the provided function
+// Keywords such as "auto" or "normal" are considered as unset values
+func parseLengths(i string, split func(string) []string, keywords ...string) (o []Length, err error) {
+	for _, s := range split(i) {
+		// Keyword
+		for _, k := range keywords {
+			if s == k {
+				return nil, nil
+			}
+		}
+
+		// Parse length
+		var l Length
+		if l, err = ParseLength(s); err != nil {
+			err = errors.Wrapf(err, "parsing length %s failed", s)
+			return
+		}
+		o = append(o, l)
+	}
+	return
+}
+
+// formatLengths formats a list of lengths separated by the provided separator
+func formatLengths(i []Length, sep string) string {
+	var ss []string
+	for _, l := range i {
+		ss = append(ss, l.String())
+	}
+	return strings.Join(ss, sep)
+}
+
+// String implements the Stringer interface
+func (l Length) String() string {
+	return strconv.FormatFloat(math.Floor(l.Value*100+0.5)/100, 'f', -1, 64) + l.Unit
+}
+
+// Viewport represents the frame lengths are relative to
+// Cell resolution defaults to 32 columns and 15 rows
+type Viewport struct {
+	CellResolutionColumns int
+	CellResolutionRows    int
+	Height                int
+	Width                 int
+}
+
+// cells returns the number of cells along an axis
+func (v Viewport) cells(vertical bool) int {
+	if v.CellResolutionColumns <= 0 || v.CellResolutionRows <= 0 {
+		if vertical {
+			return ttmlDefaultCellResolutionRows
+		}
+		return ttmlDefaultCellResolutionColumns
+	}
+	if vertical {
+		return v.CellResolutionRows
+	}
+	return v.CellResolutionColumns
+}
+
+// pixels returns the number of pixels along an axis
+func (v Viewport) pixels(vertical bool) int {
+	if vertical {
+		return v.Height
+	}
+	return v.Width
+}
+
+// Pixels converts the length into pixels along the horizontal or vertical axis of the viewport
+// Percentages are relative to the viewport, em lengths can't be converted.
+func (l Length) Pixels(v Viewport, vertical bool) (o float64, err error) {
+	// Switch on unit
+	switch l.Unit {
+	case LengthUnitPixel:
+		o = l.Value
+		return
+	case LengthUnitPercent:
+		o = l.Value * float64(v.pixels(vertical)) / 100
+	case LengthUnitCell:
+		o = l.Value * float64(v.pixels(vertical)) / float64(v.cells(vertical))
+	default:
+		err = fmt.Errorf("Length %s can't be converted into pixels", l)
+		return
+	}
+
+	// Frame size is unknown
+	if v.pixels(vertical) <= 0 {
+		err = fmt.Errorf("Length %s can't be converted into pixels without frame size", l)
+		return
+	}
+	return
+}
+
+// Convert converts the length into the provided unit along the horizontal or vertical axis of the viewport
+func (l Length) Convert(unit string, v Viewport, vertical bool) (o Length, err error) {
+	// Nothing to convert
+	if l.Unit == unit {
+		o = l
+		return
+	}
+
+	// Percentages and cells don't need the frame size
+	o.Unit = unit
+	switch {
+	case l.Unit == LengthUnitCell && unit == LengthUnitPercent:
+		o.Value = l.Value * 100 / float64(v.cells(vertical))
+		return
+	case l.Unit == LengthUnitPercent && unit == LengthUnitCell:
+		o.Value = l.Value * float64(v.cells(vertical)) / 100
+		return
+	}
+
+	// Convert into pixels
+	var p float64
+	if p, err = l.Pixels(v, vertical); err != nil {
+		err = errors.Wrapf(err, "converting %s into pixels failed", l)
+		return
+	}
+
+	// Frame size is unknown
+	if unit != LengthUnitPixel && v.pixels(vertical) <= 0 {
+		err = fmt.Errorf("Length %s can't be converted into %s without frame size", l, unit)
+		return
+	}
+
+	// Switch on unit
+	switch unit {
+	case LengthUnitPixel:
+		o.Value = p
+	case LengthUnitPercent:
+		o.Value = p * 100 / float64(v.pixels(vertical))
+	case LengthUnitCell:
+		o.Value = p * float64(v.cells(vertical)) / float64(v.pixels(vertical))
+	default:
+		err = fmt.Errorf("Length %s can't be converted into %s", l, unit)
+		return
+	}
+	return
+}
+
+// DisplayAlign represents the block alignment of text within its region
+type DisplayAlign string
+
+// Display aligns
+const (
+	DisplayAlignAfter  DisplayAlign = "after"
+	DisplayAlignBefore DisplayAlign = "before"
+	DisplayAlignCenter DisplayAlign = "center"
+)
+
+// FontStyle represents a font style
+type FontStyle string
+
+// Font styles
+const (
+	FontStyleItalic  FontStyle = "italic"
+	FontStyleNormal  FontStyle = "normal"
+	FontStyleOblique FontStyle = "oblique"
+)
+
+// TextAlign represents the inline alignment of text
+type TextAlign string
+
+// Text aligns
+const (
+	TextAlignCenter TextAlign = "center"
+	TextAlignEnd    TextAlign = "end"
+	TextAlignLeft   TextAlign = "left"
+	TextAlignRight  TextAlign = "right"
+	TextAlignStart  TextAlign = "start"
+)
+
+// parseEnum checks that a value is one of the allowed ones
+func parseEnum(i string, allowed ...string) (o string, err error) {
+	for _, a := range allowed {
+		if i == a {
+			o = i
+			return
+		}
+	}
+	err = fmt.Errorf("Invalid value %s, allowed values are %s", i, strings.Join(allowed, ", "))
+	return
+}
+
+// parseDisplayAlign parses a display align
+func parseDisplayAlign(i string) (DisplayAlign, error) {
+	o, err := parseEnum(i, string(DisplayAlignAfter), string(DisplayAlignBefore), string(DisplayAlignCenter))
+	return DisplayAlign(o), err
+}
+
+// parseFontStyle parses a font style
+func parseFontStyle(i string) (FontStyle, error) {
+	o, err := parseEnum(i, string(FontStyleItalic), string(FontStyleNormal), string(FontStyleOblique))
+	return FontStyle(o), err
+}
+
+// parseTextAlign parses a text align
+// "middle" is the legacy WebVTT value for "center"
+func parseTextAlign(i string) (TextAlign, error) {
+	if i == "middle" {
+		return TextAlignCenter, nil
+	}
+	o, err := parseEnum(i, string(TextAlignCenter), string(TextAlignEnd), string(TextAlignLeft), string(TextAlignRight), string(TextAlignStart))
+	return TextAlign(o), err
+}
@@ -0,0 +1,58 @@
+package astisub_test
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColor(t *testing.T) {
+	for i, e := range map[string]astisub.Color{
+		"white":               astisub.ColorWhite,
+		"Aqua":                astisub.ColorCyan,
+		"#f80":                {Alpha: 0xff, Green: 0x88, Red: 0xff},
+		"#ff8800":             {Alpha: 0xff, Green: 0x88, Red: 0xff},
+		"#ff880080":           {Alpha: 0x80, Green: 0x88, Red: 0xff},
+		"rgb(255, 136, 0)":    {Alpha: 0xff, Green: 0x88, Red: 0xff},
+		"rgba(255,136,0,128)": {Alpha: 0x80, Green: 0x88, Red: 0xff},
+	} {
+		c, err := astisub.ParseColor(i)
+		assert.NoError(t, err)
+		assert.Equal(t, e, *c)
+	}
+	_, err := astisub.ParseColor("#ff88")
+	assert.Error(t, err)
+	_, err = astisub.ParseColor("invalid")
+	assert.Error(t, err)
+	n, ok := astisub.ColorCyan.Name()
+	assert.True(t, ok)
+	assert.Equal(t, "cyan", n)
+	assert.Equal(t, "#ff880080", astisub.Color{Alpha: 0x80, Green: 0x88, Red: 0xff}.String())
+}
+
+func TestLength(t *testing.T) {
+	// Parse
+	l, err := astisub.ParseLength("1.5c")
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.Length{Unit: astisub.LengthUnitCell, Value: 1.5}, l)
+	assert.Equal(t, "1.5c", l.String())
+	_, err = astisub.ParseLength("10")
+	assert.Error(t, err)
+
+	// Convert
+	v := astisub.Viewport{Height: 1080, Width: 1920}
+	p, err := l.Pixels(v, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 108.0, p)
+	c, err := l.Convert(astisub.LengthUnitPercent, v, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "4.69%", c.String())
+	c, err = astisub.Length{Unit: astisub.LengthUnitPixel, Value: 540}.Convert(astisub.LengthUnitCell, v, true)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.Length{Unit: astisub.LengthUnitCell, Value: 7.5}, c)
+	_, err = astisub.Length{Unit: astisub.LengthUnitPixel, Value: 540}.Convert(astisub.LengthUnitCell, astisub.Viewport{}, true)
+	assert.Error(t, err)
+	_, err = astisub.Length{Unit: astisub.LengthUnitEm, Value: 1}.Convert(astisub.LengthUnitPixel, v, true)
+	assert.Error(t, err)
+}
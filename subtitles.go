@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -31,9 +32,20 @@ var Now = func() time.Time {
 
 // Options represents open or write options
 type Options struct {
-	Page int
-	PID  int
-	Src  string
+	CaptionChannel string
+	Dst            string
+	InitSegment    string // Path to the init segment of .m4s media segments
+	LanguageIndex  int
+	Matroska       MatroskaOptions
+	MCC            MCCOptions
+	MP4            MP4Options
+	Page           int
+	PID            int
+	Src            string
+	STL            STLOptions
+	TrackID        int
+	TTML           TTMLOptions
+	WebVTT         WebVTTOptions
 }
 
 // Open opens a subtitle file based on options
@@ -48,12 +60,28 @@ func Open(o Options) (s *Subtitles, err error) {
 
 	// Parse the content
 	switch filepath.Ext(o.Src) {
+	case ".cmfv", ".m4s", ".mp4":
+		s, err = readMP4(f, o.InitSegment, o.TrackID)
+	case ".idx":
+		s, err = readVobSub(f, o.Src, o.LanguageIndex)
+	case ".m3u8":
+		s, err = readHLS(f, filepath.Dir(o.Src))
+	case ".mcc":
+		s, err = ReadFromMCC(f, o.CaptionChannel)
+	case ".mkv", ".mks", ".webm":
+		s, err = ReadFromMatroska(f, o.TrackID)
 	case ".srt":
 		s, err = ReadFromSRT(f)
 	case ".stl":
 		s, err = ReadFromSTL(f)
+	case ".sup":
+		s, err = ReadFromPGS(f)
 	case ".ts":
-		s, err = ReadFromTeletext(f, o.PID, o.Page)
+		if o.CaptionChannel != "" {
+			s, err = ReadFromEmbeddedCaptions(f, o.PID, o.CaptionChannel)
+		} else {
+			s, err = ReadFromTeletext(f, o.PID, o.Page)
+		}
 	case ".ttml":
 		s, err = ReadFromTTML(f)
 	case ".vtt":
@@ -87,13 +115,17 @@ func NewSubtitles() *Subtitles {
 
 // Item represents a text to show between 2 time boundaries with formatting
 type Item struct {
-	Comments    []string
-	EndAt       time.Duration
-	InlineStyle *StyleAttributes
-	Lines       []Line
-	Region      *Region
-	StartAt     time.Duration
-	Style       *Style
+	Comments      []string
+	EndAt         time.Duration
+	Geometry      *Geometry
+	Image         *Image
+	InlineStyle   *StyleAttributes
+	Language      string
+	Lines         []Line
+	Region        *Region
+	StartAt       time.Duration
+	Style         *Style
+	SubtitleGroup int
 }
 
 // String implements the Stringer interface
@@ -105,55 +137,87 @@ func (i Item) String() string {
 	return strings.Join(os, " - ")
 }
 
+// hasSameContent returns whether items have the same text and image
+func (i Item) hasSameContent(j *Item) bool {
+	return i.String() == j.String() && i.Image.equal(j.Image)
+}
+
 // StyleAttributes represents style attributes
 // TODO Need more .ttml, .vtt, .stl, etc. style examples to get common patterns
 type StyleAttributes struct {
-	Align           string // WebVTT
-	BackgroundColor string // TTML
-	Color           string // TTML
-	Direction       string // TTML
-	Display         string // TTML
-	DisplayAlign    string // TTML
-	Extent          string // TTML
-	FontFamily      string // TTML
-	FontSize        string // TTML
-	FontStyle       string // TTML
-	FontWeight      string // TTML
-	Line            string // WebVTT
-	LineHeight      string // TTML
-	Lines           int    // WebVTT
-	Opacity         string // TTML
-	Origin          string // TTML
-	Overflow        string // TTML
-	Padding         string // TTML
-	Position        string // WebVTT
-	RegionAnchor    string // WebVTT
-	Scroll          string // WebVTT
-	ShowBackground  string // TTML
-	Size            string // WebVTT
-	TextAlign       string // TTML
-	TextDecoration  string // TTML
-	TextOutline     string // TTML
-	UnicodeBidi     string // TTML
-	Vertical        string // WebVTT
-	ViewportAnchor  string // WebVTT
-	Visibility      string // TTML
-	Width           string // WebVTT
-	WrapOption      string // TTML
-	WritingMode     string // TTML
-	ZIndex          int    // TTML
+	Align           TextAlign    // WebVTT
+	BackgroundColor *Color       // TTML
+	Color           *Color       // TTML
+	Direction       string       // TTML
+	Display         string       // TTML
+	DisplayAlign    DisplayAlign // TTML
+	Extent          []Length     // TTML
+	FontFamily      string       // TTML
+	FontSize        []Length     // TTML
+	FontStyle       FontStyle    // TTML
+	FontWeight      string       // TTML
+	Line            string       // WebVTT
+	LineHeight      *Length      // TTML
+	Lines           int          // WebVTT
+	Opacity         string       // TTML
+	Origin          []Length     // TTML
+	Overflow        string       // TTML
+	Padding         []Length     // TTML
+	Position        string       // WebVTT
+	RegionAnchor    []Length     // WebVTT
+	Scroll          string       // WebVTT
+	ShowBackground  string       // TTML
+	Size            *Length      // WebVTT
+	TextAlign       TextAlign    // TTML
+	TextDecoration  string       // TTML
+	TextOutline     string       // TTML
+	UnicodeBidi     string       // TTML
+	Vertical        string       // WebVTT
+	ViewportAnchor  []Length     // WebVTT
+	Visibility      string       // TTML
+	Width           *Length      // WebVTT
+	WrapOption      string       // TTML
+	WritingMode     string       // TTML
+	ZIndex          int          // TTML
+}
+
+// mergeStyleAttributes sets the attributes of dst that are not set yet with the ones of src
+// Only attributes whose name is accepted by the filter are merged
+func mergeStyleAttributes(dst, src *StyleAttributes, filter func(name string) bool) {
+	if dst == nil || src == nil {
+		return
+	}
+	var d, s = reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		if isZeroValue(d.Field(i)) && !isZeroValue(s.Field(i)) && (filter == nil || filter(d.Type().Field(i).Name)) {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
+
+// isStyleAttributeSet checks whether a style attribute is set
+func isStyleAttributeSet(sa *StyleAttributes, name string) bool {
+	return sa != nil && !isZeroValue(reflect.ValueOf(sa).Elem().FieldByName(name))
+}
+
+// isZeroValue checks whether a value is its type's zero value
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
 }
 
 // Metadata represents metadata
 type Metadata struct {
-	Copyright string
-	Framerate int
-	Language  string
-	Title     string
+	Comments           []string // Comments that aren't attached to an item, such as the ones following the last item
+	Copyright          string
+	Framerate          Framerate
+	Language           string
+	Title              string
+	WebVTTTimestampMap *WebVTTTimestampMap
 }
 
 // Region represents a subtitle's region
 type Region struct {
+	Geometry    *Geometry
 	ID          string
 	InlineStyle *StyleAttributes
 	Style       *Style
@@ -166,6 +230,54 @@ type Style struct {
 	Style       *Style
 }
 
+// styleAttributes returns the style attributes resolved through the parent styles chain
+func (s *Style) styleAttributes() (o *StyleAttributes) {
+	o = &StyleAttributes{}
+	var visited = make(map[*Style]bool)
+	for p := s; p != nil && !visited[p]; p = p.Style {
+		mergeStyleAttributes(o, p.InlineStyle, nil)
+		visited[p] = true
+	}
+	return
+}
+
+// ComputedStyle returns the style attributes a line item, or the item itself if the line item is nil, is
+// rendered with. It applies the TTML cascade: inline styles take precedence over referential styles, then
+// inheritable attributes are inherited from the line item's item and from the item's region and initial
+// values are used for attributes that are still not set.
+func ComputedStyle(i *Item, l *LineItem) (o *StyleAttributes) {
+	// Item
+	o = &StyleAttributes{}
+	if i != nil {
+		mergeStyleAttributes(o, i.InlineStyle, nil)
+		mergeStyleAttributes(o, i.Style.styleAttributes(), nil)
+		if i.Region != nil {
+			var r = &StyleAttributes{}
+			mergeStyleAttributes(r, i.Region.InlineStyle, nil)
+			mergeStyleAttributes(r, i.Region.Style.styleAttributes(), nil)
+			mergeStyleAttributes(o, r, isInheritableStyleAttribute)
+		}
+	}
+
+	// Line item
+	if l != nil {
+		var p = o
+		o = &StyleAttributes{}
+		mergeStyleAttributes(o, l.InlineStyle, nil)
+		mergeStyleAttributes(o, l.Style.styleAttributes(), nil)
+		mergeStyleAttributes(o, p, isInheritableStyleAttribute)
+	}
+
+	// Initial values
+	mergeStyleAttributes(o, newTTMLInitialStyleAttributes(), nil)
+	return
+}
+
+// isInheritableStyleAttribute checks whether a style attribute is inherited by descendants
+func isInheritableStyleAttribute(name string) bool {
+	return ttmlInheritableStyleAttributes[name]
+}
+
 // Line represents a set of formatted line items
 type Line []LineItem
 
@@ -291,6 +403,59 @@ func (s *Subtitles) Fragment(f time.Duration) {
 	s.Order()
 }
 
+// segment splits subtitles into consecutive segments of duration d starting at 0
+// Items spanning segment boundaries are fragmented so that each segment they overlap contains them, input items
+// being left untouched.
+func (s Subtitles) segment(d time.Duration) (o []*Subtitles) {
+	// Fragment an ordered copy of the subtitles
+	var f = s.ordered()
+	f.Fragment(d)
+
+	// Create segments
+	for startAt := time.Duration(0); startAt < f.Duration(); startAt += d {
+		o = append(o, &Subtitles{
+			Metadata: s.Metadata,
+			Regions:  s.Regions,
+			Styles:   s.Styles,
+		})
+	}
+
+	// Dispatch items
+	for _, i := range f.Items {
+		// Items ending before the start are dropped since they can't be mapped to a segment
+		if i.EndAt <= 0 {
+			continue
+		}
+
+		// Get segment index
+		var idx int
+		if i.StartAt > 0 {
+			idx = int(i.StartAt / d)
+		}
+		if idx >= len(o) {
+			idx = len(o) - 1
+		}
+		o[idx].Items = append(o[idx].Items, i)
+	}
+	return
+}
+
+// ordered returns an ordered copy of the subtitles, input items being left untouched
+func (s Subtitles) ordered() (o *Subtitles) {
+	o = &Subtitles{
+		Metadata: s.Metadata,
+		Regions:  s.Regions,
+		Styles:   s.Styles,
+	}
+	for _, i := range s.Items {
+		var c = &Item{}
+		*c = *i
+		o.Items = append(o.Items, c)
+	}
+	o.Order()
+	return
+}
+
 // IsEmpty returns whether the subtitles are empty
 func (s Subtitles) IsEmpty() bool {
 	return len(s.Items) == 0
@@ -317,6 +482,18 @@ func (s *Subtitles) Merge(i *Subtitles) {
 	}
 }
 
+// removeDuplicates removes ordered items having the same time boundaries and content as a previous item
+func (s *Subtitles) removeDuplicates() {
+	for i := 0; i < len(s.Items); i++ {
+		for j := i + 1; j < len(s.Items) && s.Items[j].StartAt == s.Items[i].StartAt; j++ {
+			if s.Items[j].EndAt == s.Items[i].EndAt && s.Items[j].hasSameContent(s.Items[i]) {
+				s.Items = append(s.Items[:j], s.Items[j+1:]...)
+				j--
+			}
+		}
+	}
+}
+
 // Order orders items
 func (s *Subtitles) Order() {
 	// Nothing to do if less than 1 element
@@ -339,6 +516,44 @@ func (s *Subtitles) Order() {
 	}
 }
 
+// SplitByLanguage splits subtitles into one subtitles per item language
+func (s Subtitles) SplitByLanguage() (o map[string]*Subtitles) {
+	o = make(map[string]*Subtitles)
+	for _, i := range s.Items {
+		if _, ok := o[i.Language]; !ok {
+			o[i.Language] = &Subtitles{
+				Metadata: &Metadata{},
+				Regions:  s.Regions,
+				Styles:   s.Styles,
+			}
+			if s.Metadata != nil {
+				*o[i.Language].Metadata = *s.Metadata
+			}
+			if len(i.Language) > 0 {
+				o[i.Language].Metadata.Language = i.Language
+			}
+		}
+		o[i.Language].Items = append(o[i.Language].Items, i)
+	}
+	return
+}
+
+// SplitBySubtitleGroup splits subtitles into one subtitles per subtitle group
+func (s Subtitles) SplitBySubtitleGroup() (o map[int]*Subtitles) {
+	o = make(map[int]*Subtitles)
+	for _, i := range s.Items {
+		if _, ok := o[i.SubtitleGroup]; !ok {
+			o[i.SubtitleGroup] = &Subtitles{
+				Metadata: s.Metadata,
+				Regions:  s.Regions,
+				Styles:   s.Styles,
+			}
+		}
+		o[i.SubtitleGroup].Items = append(o[i.SubtitleGroup].Items, i)
+	}
+	return
+}
+
 // Unfragment unfragments subtitles
 func (s *Subtitles) Unfragment() {
 	// Nothing to do if less than 1 element
@@ -350,7 +565,8 @@ func (s *Subtitles) Unfragment() {
 	for i := 0; i < len(s.Items)-1; i++ {
 		for j := i + 1; j < len(s.Items); j++ {
 			// Items are the same
-			if s.Items[i].String() == s.Items[j].String() && s.Items[i].EndAt == s.Items[j].StartAt {
+			// Times are checked first since comparing images is expensive
+			if s.Items[i].EndAt == s.Items[j].StartAt && s.Items[i].hasSameContent(s.Items[j]) {
 				s.Items[i].EndAt = s.Items[j].EndAt
 				s.Items = append(s.Items[:j], s.Items[j+1:]...)
 				j--
@@ -363,25 +579,38 @@ func (s *Subtitles) Unfragment() {
 }
 
 // Write writes subtitles to a file
-func (s Subtitles) Write(dst string) (err error) {
+func (s Subtitles) Write(dst string) error {
+	return s.WriteWithOptions(Options{Dst: dst})
+}
+
+// WriteWithOptions writes subtitles to a file based on options
+func (s Subtitles) WriteWithOptions(o Options) (err error) {
 	// Create the file
 	var f *os.File
-	if f, err = os.Create(dst); err != nil {
-		err = errors.Wrapf(err, "creating %s failed", dst)
+	if f, err = os.Create(o.Dst); err != nil {
+		err = errors.Wrapf(err, "creating %s failed", o.Dst)
 		return
 	}
 	defer f.Close()
 
 	// Write the content
-	switch filepath.Ext(dst) {
+	switch filepath.Ext(o.Dst) {
+	case ".mcc":
+		err = s.WriteToMCC(f, o.MCC)
+	case ".mks":
+		err = s.WriteToMatroska(f, o.Matroska)
+	case ".mp4":
+		err = s.WriteToMP4(f, o.MP4)
 	case ".srt":
 		err = s.WriteToSRT(f)
 	case ".stl":
-		err = s.WriteToSTL(f)
+		err = s.WriteToSTL(f, o.STL)
+	case ".sup":
+		err = s.WriteToPGS(f)
 	case ".ttml":
-		err = s.WriteToTTML(f)
+		err = s.WriteToTTML(f, o.TTML)
 	case ".vtt":
-		err = s.WriteToWebVTT(f)
+		err = s.WriteToWebVTT(f, o.WebVTT)
 	default:
 		err = ErrInvalidExtension
 	}
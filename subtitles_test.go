@@ -144,3 +144,41 @@ func TestSubtitles_Order(t *testing.T) {
 	assert.Equal(t, 4*time.Second, s.Items[3].StartAt)
 	assert.Equal(t, 5*time.Second, s.Items[3].EndAt)
 }
+
+func TestComputedStyle(t *testing.T) {
+	var s1 = &astisub.Style{ID: "style_1", InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorYellow, FontStyle: "italic"}}
+	var s2 = &astisub.Style{ID: "style_2", InlineStyle: &astisub.StyleAttributes{FontWeight: "bold"}, Style: s1}
+	var r = &astisub.Region{ID: "region_1", InlineStyle: &astisub.StyleAttributes{DisplayAlign: "after", FontFamily: "monospace"}, Style: s1}
+	var i = &astisub.Item{InlineStyle: &astisub.StyleAttributes{BackgroundColor: &astisub.ColorBlack, Color: &astisub.ColorRed}, Region: r, Style: s2}
+	var l = &astisub.LineItem{InlineStyle: &astisub.StyleAttributes{}, Style: &astisub.Style{InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorGreen}}}
+
+	// Item
+	sa := astisub.ComputedStyle(i, nil)
+	assert.Equal(t, &astisub.ColorBlack, sa.BackgroundColor)
+	assert.Equal(t, &astisub.ColorRed, sa.Color)
+	assert.Equal(t, astisub.DisplayAlignBefore, sa.DisplayAlign)
+	assert.Equal(t, "monospace", sa.FontFamily)
+	assert.Equal(t, astisub.FontStyleItalic, sa.FontStyle)
+	assert.Equal(t, "bold", sa.FontWeight)
+
+	// Line item
+	sa = astisub.ComputedStyle(i, l)
+	assert.Equal(t, &astisub.ColorTransparent, sa.BackgroundColor)
+	assert.Equal(t, &astisub.ColorGreen, sa.Color)
+	assert.Equal(t, "monospace", sa.FontFamily)
+	assert.Equal(t, "bold", sa.FontWeight)
+
+	// Initial values
+	assert.Equal(t, &astisub.ColorWhite, astisub.ComputedStyle(nil, nil).Color)
+
+	// Initial values can't be modified through computed styles
+	sa = astisub.ComputedStyle(nil, nil)
+	sa.BackgroundColor.Alpha = 0xff
+	sa.Color.Blue = 0x0
+	sa.FontSize[0].Value = 2
+	assert.Equal(t, astisub.Color{Alpha: 0xff, Blue: 0xff, Green: 0xff, Red: 0xff}, astisub.ColorWhite)
+	assert.Equal(t, astisub.Color{}, astisub.ColorTransparent)
+	sa = astisub.ComputedStyle(nil, nil)
+	assert.Equal(t, &astisub.ColorWhite, sa.Color)
+	assert.Equal(t, []astisub.Length{{Unit: astisub.LengthUnitCell, Value: 1}}, sa.FontSize)
+}
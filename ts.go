@@ -0,0 +1,336 @@
+package astisub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://www.itu.int/rec/T-REC-H.222.0
+
+// TS constants
+// Unwrapped PTS may be negative.
+const (
+	tsClockRate       = 90000
+	tsPacketSize      = 188
+	tsPIDPAT          = 0
+	tsPTSUndefined    = math.MinInt64
+	tsPTSWrap         = 1 << 33
+	tsStreamIDPrivate = 0xbd
+	tsSyncByte        = 0x47
+	tsTableIDPAT      = 0x0
+	tsTableIDPMT      = 0x2
+)
+
+// tsDescriptor represents a PMT elementary stream descriptor
+type tsDescriptor struct {
+	data []byte
+	tag  uint8
+}
+
+// tsStream represents an elementary stream described by a PMT
+type tsStream struct {
+	descriptors []tsDescriptor
+	pid         int
+	streamType  uint8
+}
+
+// descriptor returns the first descriptor with a given tag
+func (s tsStream) descriptor(tag uint8) (d tsDescriptor, ok bool) {
+	for _, d = range s.descriptors {
+		if d.tag == tag {
+			return d, true
+		}
+	}
+	return tsDescriptor{}, false
+}
+
+// tsPES represents a PES packet
+// PTS is unwrapped, and is tsPTSUndefined when the packet has none.
+type tsPES struct {
+	data     []byte
+	pid      int
+	pts      int64
+	streamID uint8
+}
+
+// tsDemuxer represents an object capable of demuxing PES packets out of a transport stream
+// Streams are discovered through the PAT and the PMTs, and complete PES packets of streams accepted by the filter
+// are passed to the handler in the stream order.
+type tsDemuxer struct {
+	buffers  map[int][]byte
+	filter   func(s tsStream) bool
+	firstPTS int64
+	handler  func(p tsPES) error
+	lastPTS  int64
+	pids     map[int]bool
+	pmts     map[int]bool
+}
+
+// newTSDemuxer creates a new TS demuxer
+func newTSDemuxer(filter func(s tsStream) bool, handler func(p tsPES) error) *tsDemuxer {
+	return &tsDemuxer{
+		buffers:  make(map[int][]byte),
+		filter:   filter,
+		firstPTS: tsPTSUndefined,
+		handler:  handler,
+		lastPTS:  tsPTSUndefined,
+		pids:     make(map[int]bool),
+		pmts:     make(map[int]bool),
+	}
+}
+
+// unwrap unwraps a 33 bits PTS so that it's the closest to the previous PTS of the stream
+func (d *tsDemuxer) unwrap(pts int64) int64 {
+	if pts == tsPTSUndefined {
+		return pts
+	}
+	d.lastPTS = tsUnwrapPTS(pts, d.lastPTS)
+	return d.lastPTS
+}
+
+// tsUnwrapPTS unwraps a 33 bits PTS so that it's the closest to a previous unwrapped PTS, if any
+func tsUnwrapPTS(pts, previous int64) int64 {
+	if previous == tsPTSUndefined {
+		return pts
+	}
+	for pts-previous > tsPTSWrap/2 {
+		pts -= tsPTSWrap
+	}
+	for previous-pts > tsPTSWrap/2 {
+		pts += tsPTSWrap
+	}
+	return pts
+}
+
+// duration returns the duration elapsed between the first PTS of the stream and an unwrapped PTS
+func (d *tsDemuxer) duration(pts int64) time.Duration {
+	if pts == tsPTSUndefined || d.firstPTS == tsPTSUndefined {
+		return 0
+	}
+	return time.Duration(pts-d.firstPTS) * time.Second / tsClockRate
+}
+
+// demux reads the transport stream until its end
+func (d *tsDemuxer) demux(i io.Reader) (err error) {
+	// Loop through packets
+	var r = bufio.NewReader(i)
+	var p = make([]byte, tsPacketSize)
+	for {
+		// Read packet
+		if _, err = io.ReadFull(r, p); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+				break
+			}
+			err = errors.Wrap(err, "reading packet failed")
+			return
+		}
+
+		// Resync
+		if p[0] != tsSyncByte {
+			var n int
+			for n = 1; n < tsPacketSize && p[n] != tsSyncByte; n++ {
+			}
+			copy(p, p[n:])
+			if _, err = io.ReadFull(r, p[tsPacketSize-n:]); err != nil {
+				err = nil
+				break
+			}
+			continue
+		}
+
+		// Handle packet
+		if err = d.handlePacket(p); err != nil {
+			err = errors.Wrap(err, "handling packet failed")
+			return
+		}
+	}
+
+	// Flush buffers in the PID order since map iteration order is random
+	var pids []int
+	for pid := range d.buffers {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		if err = d.flush(pid); err != nil {
+			err = errors.Wrapf(err, "flushing pid %d failed", pid)
+			return
+		}
+	}
+	return
+}
+
+// handlePacket handles a TS packet
+func (d *tsDemuxer) handlePacket(p []byte) (err error) {
+	// Parse header
+	var pusi = p[1]&0x40 > 0
+	var pid = int(binary.BigEndian.Uint16(p[1:]) & 0x1fff)
+	var afc = p[3] >> 4 & 0x3
+
+	// Get payload
+	var payload []byte
+	switch afc {
+	case 1:
+		payload = p[4:]
+	case 3:
+		if 5+int(p[4]) > len(p) {
+			return
+		}
+		payload = p[5+int(p[4]):]
+	default:
+		return
+	}
+
+	// Switch on PID
+	switch {
+	case pid == tsPIDPAT:
+		d.handlePAT(payload, pusi)
+	case d.pmts[pid]:
+		d.handlePMT(payload, pusi)
+	default:
+		// Store first PTS
+		if pusi && d.firstPTS == tsPTSUndefined {
+			if _, pts, ok := parseTSPESHeader(payload); ok && pts != tsPTSUndefined {
+				d.firstPTS = d.unwrap(pts)
+			}
+		}
+
+		// PID is not demuxed
+		if !d.pids[pid] {
+			return
+		}
+
+		// Flush previous PES
+		if pusi {
+			if err = d.flush(pid); err != nil {
+				err = errors.Wrap(err, "flushing failed")
+				return
+			}
+			d.buffers[pid] = append([]byte{}, payload...)
+		} else if b, ok := d.buffers[pid]; ok {
+			d.buffers[pid] = append(b, payload...)
+		}
+
+		// Flush complete PES
+		if b := d.buffers[pid]; len(b) >= 6 {
+			if l := int(binary.BigEndian.Uint16(b[4:])); l > 0 && len(b) >= l+6 {
+				d.buffers[pid] = b[:l+6]
+				if err = d.flush(pid); err != nil {
+					err = errors.Wrap(err, "flushing failed")
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// tsSection returns the section carried by a PSI payload
+func tsSection(payload []byte, pusi bool) (s []byte, ok bool) {
+	// Sections spanning several packets are not supported
+	if !pusi || len(payload) < 1 || 1+int(payload[0]) > len(payload) {
+		return
+	}
+	s = payload[1+int(payload[0]):]
+	if len(s) < 3 {
+		return
+	}
+	var l = int(binary.BigEndian.Uint16(s[1:]) & 0xfff)
+	if 3+l > len(s) || l < 9 {
+		return
+	}
+	// Remove CRC
+	return s[:3+l-4], true
+}
+
+// handlePAT handles a PAT payload
+func (d *tsDemuxer) handlePAT(payload []byte, pusi bool) {
+	var s, ok = tsSection(payload, pusi)
+	if !ok || s[0] != tsTableIDPAT {
+		return
+	}
+	for idx := 8; idx+4 <= len(s); idx += 4 {
+		if binary.BigEndian.Uint16(s[idx:]) != 0 {
+			d.pmts[int(binary.BigEndian.Uint16(s[idx+2:])&0x1fff)] = true
+		}
+	}
+}
+
+// handlePMT handles a PMT payload
+func (d *tsDemuxer) handlePMT(payload []byte, pusi bool) {
+	// Get section
+	var s, ok = tsSection(payload, pusi)
+	if !ok || s[0] != tsTableIDPMT || len(s) < 12 {
+		return
+	}
+
+	// Loop through streams
+	for idx := 12 + int(binary.BigEndian.Uint16(s[10:])&0xfff); idx+5 <= len(s); {
+		// Parse stream
+		var st = tsStream{pid: int(binary.BigEndian.Uint16(s[idx+1:]) & 0x1fff), streamType: s[idx]}
+		var l = int(binary.BigEndian.Uint16(s[idx+3:]) & 0xfff)
+		idx += 5
+		if idx+l > len(s) {
+			return
+		}
+
+		// Parse descriptors
+		for di := idx; di+2 <= idx+l && di+2+int(s[di+1]) <= idx+l; di += 2 + int(s[di+1]) {
+			st.descriptors = append(st.descriptors, tsDescriptor{data: s[di+2 : di+2+int(s[di+1])], tag: s[di]})
+		}
+		idx += l
+
+		// Filter
+		if !d.pids[st.pid] && d.filter(st) {
+			d.pids[st.pid] = true
+		}
+	}
+}
+
+// flush passes the buffered PES of a PID to the handler
+func (d *tsDemuxer) flush(pid int) (err error) {
+	// Get buffer
+	var b, ok = d.buffers[pid]
+	if !ok {
+		return
+	}
+	delete(d.buffers, pid)
+
+	// Parse header
+	var n int
+	var pts int64
+	if n, pts, ok = parseTSPESHeader(b); !ok {
+		return
+	}
+
+	// Handle
+	return d.handler(tsPES{data: b[n:], pid: pid, pts: d.unwrap(pts), streamID: b[3]})
+}
+
+// parseTSPESHeader parses a PES header and returns its length
+func parseTSPESHeader(b []byte) (n int, pts int64, ok bool) {
+	// Check start code
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return
+	}
+
+	// Get header length
+	pts = tsPTSUndefined
+	n = 9 + int(b[8])
+	if n > len(b) {
+		return
+	}
+
+	// Get PTS
+	if b[7]&0x80 > 0 && len(b) >= 14 {
+		pts = int64(b[9]>>1&0x7)<<30 | int64(b[10])<<22 | int64(b[11]>>1)<<15 | int64(b[12])<<7 | int64(b[13]>>1)
+	}
+	return n, pts, true
+}
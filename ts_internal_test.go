@@ -0,0 +1,17 @@
+package astisub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTSDemuxerUnwrap(t *testing.T) {
+	d := newTSDemuxer(nil, nil)
+	d.firstPTS = d.unwrap(tsPTSWrap - tsClockRate)
+	assert.Equal(t, time.Second, d.duration(d.unwrap(0)))
+	assert.Equal(t, 2*time.Second, d.duration(d.unwrap(tsClockRate)))
+	assert.Equal(t, -time.Second, d.duration(d.unwrap(tsPTSWrap-2*tsClockRate)))
+	assert.Equal(t, time.Duration(0), d.duration(d.unwrap(tsPTSUndefined)))
+}
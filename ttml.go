@@ -4,6 +4,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,21 +30,168 @@ const (
 
 // TTML language mapping
 var ttmlLanguageMapping = astimap.NewMap(ttmlLanguageEnglish, LanguageEnglish).
+	Set(ttmlLanguageEnglish, LanguageEnglish).
 	Set(ttmlLanguageFrench, LanguageFrench)
 
+// TTML drop modes
+// dropPAL is not supported and is handled as nonDrop
+const (
+	ttmlDropModeNonDrop = "nonDrop"
+	ttmlDropModeNTSC    = "dropNTSC"
+	ttmlDropModePAL     = "dropPAL"
+)
+
+// TTML marker modes
+const (
+	ttmlMarkerModeContinuous    = "continuous"
+	ttmlMarkerModeDiscontinuous = "discontinuous"
+)
+
+// TTML time containers
+const (
+	ttmlTimeContainerPar = "par"
+	ttmlTimeContainerSeq = "seq"
+)
+
+// TTML indefinite time is used for ends that can't be resolved
+const ttmlIndefinite = time.Duration(math.MaxInt64)
+
+// TTML time bases
+// The clock time base is handled as the media time base since there's no document begin reference
+const (
+	ttmlTimeBaseClock = "clock"
+	ttmlTimeBaseMedia = "media"
+	ttmlTimeBaseSMPTE = "smpte"
+)
+
+// TTML offset time metrics
+const (
+	ttmlMetricFrames       = "f"
+	ttmlMetricHours        = "h"
+	ttmlMetricMilliseconds = "ms"
+	ttmlMetricMinutes      = "m"
+	ttmlMetricSeconds      = "s"
+	ttmlMetricTicks        = "t"
+)
+
 // TTML regexp
-var ttmlRegexpDurationFrames = regexp.MustCompile("\\:[\\d]+$")
+var (
+	ttmlRegexpClockTime  = regexp.MustCompile("^(\\d+):(\\d+):(\\d+)(?:(\\.\\d+)|:(\\d+)(?:\\.(\\d+))?)?$")
+	ttmlRegexpOffsetTime = regexp.MustCompile("^(\\d+(?:\\.\\d+)?)(h|ms|m|s|f|t)$")
+)
 
 // TTMLIn represents an input TTML that must be unmarshaled
 // We split it from the output TTML as we can't add strict namespace without breaking retrocompatibility
 type TTMLIn struct {
-	Framerate int              `xml:"frameRate,attr"`
-	Lang      string           `xml:"lang,attr"`
-	Metadata  TTMLInMetadata   `xml:"head>metadata"`
-	Regions   []TTMLInRegion   `xml:"head>layout>region"`
-	Styles    []TTMLInStyle    `xml:"head>styling>style"`
-	Subtitles []TTMLInSubtitle `xml:"body>div>p"`
-	XMLName   xml.Name         `xml:"tt"`
+	Body                TTMLInItem       `xml:"body"`
+	CellResolution      string           `xml:"cellResolution,attr"`
+	DropMode            string           `xml:"dropMode,attr"`
+	Extent              string           `xml:"extent,attr"`
+	Framerate           int              `xml:"frameRate,attr"`
+	FramerateMultiplier string           `xml:"frameRateMultiplier,attr"`
+	Lang                string           `xml:"lang,attr"`
+	MarkerMode          string           `xml:"markerMode,attr"`
+	Metadata            TTMLInMetadata   `xml:"head>metadata"`
+	Regions             []TTMLInRegion   `xml:"head>layout>region"`
+	Styles              []TTMLInStyle    `xml:"head>styling>style"`
+	SubFramerate        int              `xml:"subFrameRate,attr"`
+	Subtitles           []TTMLInSubtitle `xml:"-"` // Subtitles of the body's divs, kept for retrocompatibility
+	Tickrate            int              `xml:"tickRate,attr"`
+	TimeBase            string           `xml:"timeBase,attr"`
+	XMLName             xml.Name         `xml:"tt"`
+}
+
+// UnmarshalXML implements the XML unmarshaler interface
+func (t *TTMLIn) UnmarshalXML(d *xml.Decoder, start xml.StartElement) (err error) {
+	// Decode
+	type ttmlIn TTMLIn
+	var i ttmlIn
+	if err = d.DecodeElement(&i, &start); err != nil {
+		err = errors.Wrap(err, "decoding xml.StartElement failed")
+		return
+	}
+	*t = TTMLIn(i)
+
+	// Get subtitles
+	var b struct {
+		Subtitles []TTMLInSubtitle `xml:"div>p"`
+	}
+	if err = xml.Unmarshal([]byte("<body>"+t.Body.Items+"</body>"), &b); err != nil {
+		err = errors.Wrap(err, "unmarshaling subtitles failed")
+		return
+	}
+	t.Subtitles = b.Subtitles
+	return
+}
+
+// viewport returns the viewport input TTML lengths are relative to
+func (t TTMLIn) viewport() (v Viewport, err error) {
+	// Parse cell resolution
+	if len(t.CellResolution) > 0 {
+		if _, err = fmt.Sscanf(t.CellResolution, "%d %d", &v.CellResolutionColumns, &v.CellResolutionRows); err != nil {
+			err = errors.Wrapf(err, "parsing cell resolution %s failed", t.CellResolution)
+			return
+		}
+	}
+
+	// Parse extent
+	if len(t.Extent) > 0 && t.Extent != "auto" {
+		if _, err = fmt.Sscanf(t.Extent, "%dpx %dpx", &v.Width, &v.Height); err != nil {
+			err = errors.Wrapf(err, "parsing extent %s failed", t.Extent)
+			return
+		}
+	}
+	return
+}
+
+// timeParameters returns the parameters used to interpret the input TTML time expressions
+func (t TTMLIn) timeParameters() (p ttmlTimeParameters, err error) {
+	// Get framerate
+	if p.framerate, err = t.framerate(); err != nil {
+		err = errors.Wrap(err, "getting framerate failed")
+		return
+	}
+
+	// Init
+	p.markerMode = t.MarkerMode
+	p.subFramerate = t.SubFramerate
+	p.tickrate = t.Tickrate
+	p.timeBase = t.TimeBase
+	return
+}
+
+// framerate returns the effective framerate of the input TTML
+// The frame rate multiplier can be written as "1000 1001" or "1000:1001"
+func (t TTMLIn) framerate() (f Framerate, err error) {
+	// No framerate
+	if t.Framerate <= 0 {
+		return
+	}
+
+	// Init
+	f = NewFramerate(t.Framerate)
+	f.DropFrame = t.DropMode == ttmlDropModeNTSC
+
+	// Apply multiplier
+	if len(t.FramerateMultiplier) > 0 {
+		var parts = strings.FieldsFunc(t.FramerateMultiplier, func(r rune) bool { return r == ' ' || r == ':' })
+		if len(parts) != 2 {
+			err = fmt.Errorf("Invalid frame rate multiplier %s", t.FramerateMultiplier)
+			return
+		}
+		var numerator, denominator int
+		if numerator, err = strconv.Atoi(parts[0]); err != nil {
+			err = errors.Wrapf(err, "atoi of %s failed", parts[0])
+			return
+		}
+		if denominator, err = strconv.Atoi(parts[1]); err != nil {
+			err = errors.Wrapf(err, "atoi of %s failed", parts[1])
+			return
+		}
+		f.Numerator *= numerator
+		f.Denominator *= denominator
+	}
+	return
 }
 
 // TTMLInMetadata represents an input TTML Metadata
@@ -80,33 +229,78 @@ type TTMLInStyleAttributes struct {
 }
 
 // StyleAttributes converts TTMLInStyleAttributes into a StyleAttributes
-func (s TTMLInStyleAttributes) styleAttributes() *StyleAttributes {
-	return &StyleAttributes{
-		BackgroundColor: s.BackgroundColor,
-		Color:           s.Color,
-		Direction:       s.Direction,
-		Display:         s.Display,
-		DisplayAlign:    s.DisplayAlign,
-		Extent:          s.Extent,
-		FontFamily:      s.FontFamily,
-		FontSize:        s.FontSize,
-		FontStyle:       s.FontStyle,
-		FontWeight:      s.FontWeight,
-		LineHeight:      s.LineHeight,
-		Opacity:         s.Opacity,
-		Origin:          s.Origin,
-		Overflow:        s.Overflow,
-		Padding:         s.Padding,
-		ShowBackground:  s.ShowBackground,
-		TextAlign:       s.TextAlign,
-		TextDecoration:  s.TextDecoration,
-		TextOutline:     s.TextOutline,
-		UnicodeBidi:     s.UnicodeBidi,
-		Visibility:      s.Visibility,
-		WrapOption:      s.WrapOption,
-		WritingMode:     s.WritingMode,
-		ZIndex:          s.ZIndex,
+// Values that can't be parsed, such as the ones introduced by later TTML versions, are ignored
+func (s TTMLInStyleAttributes) styleAttributes() (o *StyleAttributes) {
+	// Init
+	o = &StyleAttributes{
+		Direction:      s.Direction,
+		Display:        s.Display,
+		FontFamily:     s.FontFamily,
+		FontWeight:     s.FontWeight,
+		Opacity:        s.Opacity,
+		Overflow:       s.Overflow,
+		ShowBackground: s.ShowBackground,
+		TextDecoration: s.TextDecoration,
+		TextOutline:    s.TextOutline,
+		UnicodeBidi:    s.UnicodeBidi,
+		Visibility:     s.Visibility,
+		WrapOption:     s.WrapOption,
+		WritingMode:    s.WritingMode,
+		ZIndex:         s.ZIndex,
+	}
+
+	// Parse colors
+	for _, v := range []struct {
+		c **Color
+		s string
+	}{
+		{c: &o.BackgroundColor, s: s.BackgroundColor},
+		{c: &o.Color, s: s.Color},
+	} {
+		if len(v.s) > 0 {
+			if c, err := ParseColor(v.s); err == nil {
+				*v.c = c
+			}
+		}
+	}
+
+	// Parse lengths
+	for _, v := range []struct {
+		l *[]Length
+		s string
+	}{
+		{l: &o.Extent, s: s.Extent},
+		{l: &o.FontSize, s: s.FontSize},
+		{l: &o.Origin, s: s.Origin},
+		{l: &o.Padding, s: s.Padding},
+	} {
+		if l, err := parseLengths(v.s, strings.Fields, "auto"); err == nil {
+			*v.l = l
+		}
+	}
+	if len(s.LineHeight) > 0 && s.LineHeight != "normal" {
+		if l, err := ParseLength(s.LineHeight); err == nil {
+			o.LineHeight = &l
+		}
+	}
+
+	// Parse enums
+	if len(s.DisplayAlign) > 0 {
+		if a, err := parseDisplayAlign(s.DisplayAlign); err == nil {
+			o.DisplayAlign = a
+		}
 	}
+	if len(s.FontStyle) > 0 {
+		if f, err := parseFontStyle(s.FontStyle); err == nil {
+			o.FontStyle = f
+		}
+	}
+	if len(s.TextAlign) > 0 {
+		if a, err := parseTextAlign(s.TextAlign); err == nil {
+			o.TextAlign = a
+		}
+	}
+	return
 }
 
 // TTMLInHeader represents an input TTML header
@@ -128,6 +322,19 @@ type TTMLInStyle struct {
 	XMLName xml.Name `xml:"style"`
 }
 
+// TTMLInTiming represents input TTML timing attributes
+type TTMLInTiming struct {
+	Begin         *TTMLInDuration `xml:"begin,attr,omitempty"`
+	Dur           *TTMLInDuration `xml:"dur,attr,omitempty"`
+	End           *TTMLInDuration `xml:"end,attr,omitempty"`
+	TimeContainer string          `xml:"timeContainer,attr,omitempty"`
+}
+
+// isZero returns whether no timing attribute has been set
+func (t TTMLInTiming) isZero() bool {
+	return t.Begin == nil && t.Dur == nil && t.End == nil
+}
+
 // TTMLInSubtitle represents an input TTML subtitle
 type TTMLInSubtitle struct {
 	Begin  *TTMLInDuration `xml:"begin,attr,omitempty"`
@@ -174,47 +381,421 @@ func (i *TTMLInItems) UnmarshalXML(d *xml.Decoder, start xml.StartElement) (err
 	return nil
 }
 
-// TTMLInItem represents an input TTML item
+// TTMLInItem represents an input TTML item which is either an element (body, div, p, span, br, etc.) or a text
 type TTMLInItem struct {
-	Style string `xml:"style,attr,omitempty"`
-	Text  string `xml:",chardata"`
+	ID     string `xml:"id,attr,omitempty"`
+	Items  string `xml:",innerxml"` // We must store inner XML here since there's no tag to describe both any tag and chardata
+	Lang   string `xml:"lang,attr,omitempty"`
+	Region string `xml:"region,attr,omitempty"`
+	Style  string `xml:"style,attr,omitempty"`
+	Text   string `xml:"-"`
 	TTMLInStyleAttributes
+	TTMLInTiming
 	XMLName xml.Name
 }
 
+// items unmarshals the input TTML item children
+func (i TTMLInItem) items() (o TTMLInItems, err error) {
+	if err = xml.Unmarshal([]byte("<span>"+i.Items+"</span>"), &o); err != nil {
+		err = errors.Wrap(err, "unmarshaling items failed")
+		return
+	}
+	return
+}
+
+// ttmlTimeParameters represents the parameters used to interpret input TTML time expressions
+type ttmlTimeParameters struct {
+	framerate    Framerate
+	markerMode   string
+	subFramerate int
+	tickrate     int
+	timeBase     string
+}
+
+// absolute returns whether time expressions are absolute labels rather than offsets from their sync base
+func (p ttmlTimeParameters) absolute() bool {
+	return p.timeBase == ttmlTimeBaseSMPTE && p.markerMode == ttmlMarkerModeDiscontinuous
+}
+
+// frameSeconds returns the duration of a frame in seconds, the default framerate being 30
+func (p ttmlTimeParameters) frameSeconds() *big.Rat {
+	var f = p.framerate
+	if f.IsZero() {
+		f = Framerate30
+	}
+	return big.NewRat(int64(f.Denominator), int64(f.Numerator))
+}
+
+// subFrameSeconds returns the duration of a sub-frame in seconds
+func (p ttmlTimeParameters) subFrameSeconds() *big.Rat {
+	var r = p.frameSeconds()
+	if p.subFramerate > 1 {
+		r.Quo(r, big.NewRat(int64(p.subFramerate), 1))
+	}
+	return r
+}
+
+// tickSeconds returns the duration of a tick in seconds
+// When no tick rate is specified, it defaults to the sub-frame rate if a framerate is specified, 1 otherwise
+func (p ttmlTimeParameters) tickSeconds() *big.Rat {
+	if p.tickrate > 0 {
+		return big.NewRat(1, int64(p.tickrate))
+	} else if !p.framerate.IsZero() {
+		return p.subFrameSeconds()
+	}
+	return big.NewRat(1, 1)
+}
+
 // TTMLInDuration represents an input TTML duration
 type TTMLInDuration struct {
+	count             *big.Rat // Offset time count when the metric is frames or ticks
 	d                 time.Duration
-	frames, framerate int // Framerate is in frame/s
+	frames, subFrames int
+	metric            string
+	ttmlTimeParameters
 }
 
 // UnmarshalText implements the TextUnmarshaler interface
 // Possible formats are:
-// - hh:mm:ss.mmm
-// - hh:mm:ss:fff (fff being frames)
+// - hh:mm:ss, hh:mm:ss.fraction
+// - hh:mm:ss:ff, hh:mm:ss:ff.sss (ff being frames and sss being sub-frames)
+// - <count>h, <count>m, <count>s, <count>ms, <count>f, <count>t (count can have a fraction)
 func (d *TTMLInDuration) UnmarshalText(i []byte) (err error) {
-	var text = string(i)
-	if indexes := ttmlRegexpDurationFrames.FindStringIndex(text); indexes != nil {
-		// Parse frames
-		var s = text[indexes[0]+1 : indexes[1]]
-		if d.frames, err = strconv.Atoi(s); err != nil {
-			err = errors.Wrapf(err, "atoi %s failed", s)
+	// Reset
+	var text = strings.TrimSpace(string(i))
+	*d = TTMLInDuration{ttmlTimeParameters: d.ttmlTimeParameters}
+
+	// Offset time
+	if m := ttmlRegexpOffsetTime.FindStringSubmatch(text); m != nil {
+		var count, ok = new(big.Rat).SetString(m[1])
+		if !ok {
+			err = fmt.Errorf("Invalid count %s", m[1])
 			return
 		}
+		switch m[2] {
+		case ttmlMetricHours:
+			d.d = ratDuration(count.Mul(count, big.NewRat(3600, 1)))
+		case ttmlMetricMinutes:
+			d.d = ratDuration(count.Mul(count, big.NewRat(60, 1)))
+		case ttmlMetricSeconds:
+			d.d = ratDuration(count)
+		case ttmlMetricMilliseconds:
+			d.d = ratDuration(count.Mul(count, big.NewRat(1, 1000)))
+		default:
+			d.count = count
+			d.metric = m[2]
+		}
+		return
+	}
+
+	// Clock time
+	var m = ttmlRegexpClockTime.FindStringSubmatch(text)
+	if m == nil {
+		err = fmt.Errorf("Invalid time expression %s", text)
+		return
+	}
 
-		// Update text
-		text = text[:indexes[0]] + ".000"
+	// Parse integers
+	var hours, minutes, seconds int
+	for _, v := range []struct {
+		i *int
+		s string
+	}{
+		{i: &hours, s: m[1]},
+		{i: &minutes, s: m[2]},
+		{i: &seconds, s: m[3]},
+		{i: &d.frames, s: m[5]},
+		{i: &d.subFrames, s: m[6]},
+	} {
+		if len(v.s) == 0 {
+			continue
+		}
+		if *v.i, err = strconv.Atoi(v.s); err != nil {
+			err = errors.Wrapf(err, "atoi of %s failed", v.s)
+			return
+		}
+	}
+	d.d = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+
+	// Parse fraction
+	if len(m[4]) > 0 {
+		var fraction, ok = new(big.Rat).SetString("0" + m[4])
+		if !ok {
+			err = fmt.Errorf("Invalid fraction %s", m[4])
+			return
+		}
+		d.d += ratDuration(fraction)
 	}
-	d.d, err = parseDuration(text, ".")
 	return
 }
 
 // duration returns the input TTML Duration's time.Duration
-func (d TTMLInDuration) duration() time.Duration {
-	if d.framerate > 0 {
-		return d.d + time.Duration(float64(d.frames)/float64(d.framerate)*1e9)*time.Nanosecond
+// With the smpte time base, clock times are SMPTE timecode labels
+func (d TTMLInDuration) duration() (o time.Duration) {
+	// Offset time in frames or ticks
+	switch d.metric {
+	case ttmlMetricFrames:
+		return ratDuration(new(big.Rat).Mul(d.count, d.frameSeconds()))
+	case ttmlMetricTicks:
+		return ratDuration(new(big.Rat).Mul(d.count, d.tickSeconds()))
+	}
+
+	// Clock time
+	if d.timeBase == ttmlTimeBaseSMPTE && !d.framerate.IsZero() {
+		o = d.framerate.timecodeToDuration(timecode{
+			frames:  d.frames,
+			hours:   int(d.d / time.Hour),
+			minutes: int(d.d % time.Hour / time.Minute),
+			seconds: int(d.d % time.Minute / time.Second),
+		}) + d.d%time.Second
+	} else {
+		o = d.d + ratDuration(new(big.Rat).Mul(big.NewRat(int64(d.frames), 1), d.frameSeconds()))
 	}
-	return d.d
+	return o + ratDuration(new(big.Rat).Mul(big.NewRat(int64(d.subFrames), 1), d.subFrameSeconds()))
+}
+
+// ttmlTimeContainer represents a resolved TTML time container
+// In a par container, children are synchronized on the container begin whereas in a seq container,
+// children are synchronized on the previous sibling end
+type ttmlTimeContainer struct {
+	begin, end time.Duration
+	p          ttmlTimeParameters
+	seq        bool
+	syncBase   time.Duration
+}
+
+// newTTMLTimeContainer creates a new root time container
+func newTTMLTimeContainer(p ttmlTimeParameters) *ttmlTimeContainer {
+	return &ttmlTimeContainer{end: ttmlIndefinite, p: p}
+}
+
+// offset resolves a time expression relative to a sync base
+func (c *ttmlTimeContainer) offset(syncBase time.Duration, d *TTMLInDuration) time.Duration {
+	d.ttmlTimeParameters = c.p
+	if c.p.absolute() {
+		return d.duration()
+	} else if syncBase == ttmlIndefinite {
+		return ttmlIndefinite
+	}
+	return syncBase + d.duration()
+}
+
+// interval resolves the active interval of a child element and updates the container sync base
+func (c *ttmlTimeContainer) interval(t TTMLInTiming) (begin, end time.Duration) {
+	// Begin
+	begin = c.syncBase
+	if t.Begin != nil {
+		begin = c.offset(c.syncBase, t.Begin)
+	}
+
+	// End
+	end = c.end
+	if t.End != nil {
+		if e := c.offset(c.syncBase, t.End); e < end {
+			end = e
+		}
+	}
+	if t.Dur != nil && begin != ttmlIndefinite {
+		t.Dur.ttmlTimeParameters = c.p
+		if e := begin + t.Dur.duration(); e < end {
+			end = e
+		}
+	}
+
+	// Update sync base
+	if c.seq {
+		c.syncBase = end
+	}
+	return
+}
+
+// child creates the time container of a child element
+func (c *ttmlTimeContainer) child(t TTMLInTiming) *ttmlTimeContainer {
+	var b, e = c.interval(t)
+	return &ttmlTimeContainer{
+		begin:    b,
+		end:      e,
+		p:        c.p,
+		seq:      t.TimeContainer == ttmlTimeContainerSeq,
+		syncBase: b,
+	}
+}
+
+// TTML inheritable style attributes
+var ttmlInheritableStyleAttributes = map[string]bool{
+	"Color":          true,
+	"Direction":      true,
+	"FontFamily":     true,
+	"FontSize":       true,
+	"FontStyle":      true,
+	"FontWeight":     true,
+	"LineHeight":     true,
+	"TextAlign":      true,
+	"TextDecoration": true,
+	"TextOutline":    true,
+	"Visibility":     true,
+	"WrapOption":     true,
+}
+
+// newTTMLInitialStyleAttributes creates the TTML initial style attributes
+// "auto" extent and origin and "normal" line height are represented by unset values
+// Values are created on each call since computed styles hold them and can be modified by callers.
+// https://www.w3.org/TR/ttml1/#styling-attribute-vocabulary
+func newTTMLInitialStyleAttributes() *StyleAttributes {
+	var backgroundColor, color = ColorTransparent, ColorWhite
+	return &StyleAttributes{
+		BackgroundColor: &backgroundColor,
+		Color:           &color,
+		Direction:       "ltr",
+		Display:         "auto",
+		DisplayAlign:    DisplayAlignBefore,
+		FontFamily:      "default",
+		FontSize:        []Length{{Unit: LengthUnitCell, Value: 1}},
+		FontStyle:       FontStyleNormal,
+		FontWeight:      "normal",
+		Opacity:         "1.0",
+		Overflow:        "hidden",
+		Padding:         []Length{{Unit: LengthUnitPixel}},
+		ShowBackground:  "always",
+		TextAlign:       TextAlignStart,
+		TextDecoration:  "none",
+		TextOutline:     "none",
+		UnicodeBidi:     "normal",
+		Visibility:      "visible",
+		WrapOption:      "wrap",
+		WritingMode:     "lrtb",
+	}
+}
+
+// ttmlLanguage converts an input TTML language
+// Unknown languages are kept as is
+func ttmlLanguage(lang string) string {
+	if l := astistring.ToLength(lang, " ", 2); ttmlLanguageMapping.InA(l) {
+		return ttmlLanguageMapping.B(l).(string)
+	}
+	return lang
+}
+
+// ttmlInContext represents what an input TTML item inherits from its ancestors
+type ttmlInContext struct {
+	inheritedStyle *StyleAttributes // Inheritable attributes of the parent's computed style
+	inlineStyle    *StyleAttributes // Inline attributes completed with inherited attributes not set by the referential style
+	lang           string
+	region         *Region
+	style          *Style
+	tc             *ttmlTimeContainer
+}
+
+// newTTMLInContext creates a new root context
+func newTTMLInContext(p ttmlTimeParameters, lang string) ttmlInContext {
+	return ttmlInContext{
+		inheritedStyle: &StyleAttributes{},
+		inlineStyle:    &StyleAttributes{},
+		lang:           lang,
+		tc:             newTTMLTimeContainer(p),
+	}
+}
+
+// child creates the context of a child item
+func (c ttmlInContext) child(i TTMLInItem, s *Subtitles) (o ttmlInContext, err error) {
+	// Init
+	o = c
+	o.style = nil
+	o.tc = c.tc.child(i.TTMLInTiming)
+
+	// Add language
+	if len(i.Lang) > 0 {
+		o.lang = i.Lang
+	}
+
+	// Add region
+	if len(i.Region) > 0 {
+		var ok bool
+		if o.region, ok = s.Regions[i.Region]; !ok {
+			err = fmt.Errorf("Region %s requested by %s doesn't exist", i.Region, i.XMLName.Local)
+			return
+		}
+	}
+
+	// Add style
+	var referentialStyle = &StyleAttributes{}
+	if len(i.Style) > 0 {
+		var ok bool
+		if o.style, ok = s.Styles[i.Style]; !ok {
+			err = fmt.Errorf("Style %s requested by %s doesn't exist", i.Style, i.XMLName.Local)
+			return
+		}
+		referentialStyle = o.style.styleAttributes()
+	}
+
+	// Add inline style
+	o.inlineStyle = i.TTMLInStyleAttributes.styleAttributes()
+	mergeStyleAttributes(o.inlineStyle, c.inheritedStyle, func(name string) bool { return !isStyleAttributeSet(referentialStyle, name) })
+
+	// Add inherited style
+	var computedStyle = &StyleAttributes{}
+	mergeStyleAttributes(computedStyle, o.inlineStyle, nil)
+	mergeStyleAttributes(computedStyle, referentialStyle, nil)
+	mergeStyleAttributes(computedStyle, c.inheritedStyle, nil)
+	o.inheritedStyle = &StyleAttributes{}
+	mergeStyleAttributes(o.inheritedStyle, computedStyle, isInheritableStyleAttribute)
+	return
+}
+
+// ttmlInLineItem represents a line item with its active interval
+type ttmlInLineItem struct {
+	begin, end time.Duration
+	lineItem   LineItem
+}
+
+// newTTMLInItems creates the items of a subtitle, splitting it on its line items time boundaries
+func newTTMLInItems(s *Item, lines [][]ttmlInLineItem) (o []*Item) {
+	// Get boundaries
+	var boundaries = []time.Duration{s.StartAt, s.EndAt}
+	for _, l := range lines {
+		for _, li := range l {
+			for _, b := range []time.Duration{li.begin, li.end} {
+				if b > s.StartAt && b < s.EndAt {
+					boundaries = append(boundaries, b)
+				}
+			}
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i] < boundaries[j] })
+
+	// Loop through intervals
+	for idx := 1; idx < len(boundaries); idx++ {
+		// Same boundaries
+		if idx > 1 && boundaries[idx] == boundaries[idx-1] {
+			continue
+		}
+
+		// Init item
+		var i = &Item{}
+		*i = *s
+		i.StartAt = boundaries[idx-1]
+		i.EndAt = boundaries[idx]
+		i.Lines = nil
+
+		// Add active line items
+		for _, l := range lines {
+			var line = Line{}
+			for _, li := range l {
+				if li.begin <= i.StartAt && li.end >= i.EndAt {
+					line = append(line, li.lineItem)
+				}
+			}
+			if len(line) > 0 || len(l) == 0 {
+				i.Lines = append(i.Lines, line)
+			}
+		}
+
+		// Append item
+		if len(i.Lines) > 0 {
+			o = append(o, i)
+		}
+	}
+	return
 }
 
 // ReadFromTTML parses a .ttml content
@@ -229,10 +810,17 @@ func ReadFromTTML(i io.Reader) (o *Subtitles, err error) {
 		return
 	}
 
+	// Get time parameters
+	var tp ttmlTimeParameters
+	if tp, err = ttml.timeParameters(); err != nil {
+		err = errors.Wrap(err, "getting time parameters failed")
+		return
+	}
+
 	// Add metadata
 	o.Metadata = &Metadata{
 		Copyright: ttml.Metadata.Copyright,
-		Framerate: ttml.Framerate,
+		Framerate: tp.framerate,
 		Language:  ttmlLanguageMapping.B(astistring.ToLength(ttml.Lang, " ", 2)).(string),
 		Title:     ttml.Metadata.Title,
 	}
@@ -259,6 +847,13 @@ func ReadFromTTML(i io.Reader) (o *Subtitles, err error) {
 		s.Style = o.Styles[id]
 	}
 
+	// Get viewport
+	var v Viewport
+	if v, err = ttml.viewport(); err != nil {
+		err = errors.Wrap(err, "getting viewport failed")
+		return
+	}
+
 	// Loop through regions
 	for _, tr := range ttml.Regions {
 		var r = &Region{
@@ -272,110 +867,333 @@ func ReadFromTTML(i io.Reader) (o *Subtitles, err error) {
 			}
 			r.Style = o.Styles[tr.Style]
 		}
+		r.Geometry = newTTMLRegionGeometry(r, v)
 		o.Regions[r.ID] = r
 	}
 
-	// Loop through subtitles
-	for _, ts := range ttml.Subtitles {
-		// Init item
-		ts.Begin.framerate = ttml.Framerate
-		ts.End.framerate = ttml.Framerate
-		var s = &Item{
-			EndAt:       ts.End.duration(),
-			InlineStyle: ts.TTMLInStyleAttributes.styleAttributes(),
-			StartAt:     ts.Begin.duration(),
+	// Read body
+	var c ttmlInContext
+	if c, err = newTTMLInContext(tp, ttml.Lang).child(ttml.Body, o); err != nil {
+		err = errors.Wrap(err, "creating body context failed")
+		return
+	}
+	if err = readTTMLInBlock(o, ttml.Body, c); err != nil {
+		err = errors.Wrap(err, "reading body failed")
+		return
+	}
+
+	// Order
+	o.Order()
+	return
+}
+
+// newTTMLRegionGeometry creates the geometry of an input TTML region
+// Geometry can't be computed if lengths can't be converted into percentages or if the region has neither origin
+// nor extent
+func newTTMLRegionGeometry(r *Region, v Viewport) (g *Geometry) {
+	// Get computed style
+	var sa = &StyleAttributes{}
+	mergeStyleAttributes(sa, r.InlineStyle, nil)
+	mergeStyleAttributes(sa, r.Style.styleAttributes(), nil)
+
+	// No origin and no extent
+	if len(sa.Origin) == 0 && len(sa.Extent) == 0 {
+		return
+	}
+
+	// Init
+	g = &Geometry{
+		DisplayAlign: DisplayAlignBefore,
+		TextAlign:    sa.TextAlign,
+	}
+	if len(sa.DisplayAlign) > 0 {
+		g.DisplayAlign = sa.DisplayAlign
+	}
+
+	// Convert origin and extent
+	for _, v1 := range []struct {
+		dst  []*float64
+		ls   []Length
+		dflt float64
+	}{
+		{dst: []*float64{&g.Left, &g.Top}, ls: sa.Origin},
+		{dst: []*float64{&g.Width, &g.Height}, ls: sa.Extent, dflt: 100},
+	} {
+		for idx, dst := range v1.dst {
+			// Default
+			if len(v1.ls) != 2 {
+				*dst = v1.dflt
+				continue
+			}
+
+			// Convert
+			l, err := v1.ls[idx].Convert(LengthUnitPercent, v, idx == 1)
+			if err != nil {
+				return nil
+			}
+			*dst = l.Value
 		}
+	}
+	return
+}
 
-		// Add region
-		if len(ts.Region) > 0 {
-			if _, ok := o.Regions[ts.Region]; !ok {
-				err = fmt.Errorf("Region %s requested by subtitle between %s and %s doesn't exist", ts.Region, s.StartAt, s.EndAt)
+// ttmlGeometryStyleAttributes returns the output TTML style attributes describing a geometry
+// Initial values are omitted
+func ttmlGeometryStyleAttributes(g *Geometry) (o *StyleAttributes) {
+	o = &StyleAttributes{TextAlign: g.TextAlign}
+	if g.DisplayAlign != DisplayAlignBefore {
+		o.DisplayAlign = g.DisplayAlign
+	}
+	if g.Left != 0 || g.Top != 0 {
+		o.Origin = []Length{{Unit: LengthUnitPercent, Value: roundPercentage(g.Left)}, {Unit: LengthUnitPercent, Value: roundPercentage(g.Top)}}
+	}
+	if g.Width != 100 || g.Height != 100 {
+		o.Extent = []Length{{Unit: LengthUnitPercent, Value: roundPercentage(g.Width)}, {Unit: LengthUnitPercent, Value: roundPercentage(g.Height)}}
+	}
+	return
+}
+
+// readTTMLInBlock adds the subtitles contained in an input TTML block (body or div) and its nested divs
+func readTTMLInBlock(o *Subtitles, i TTMLInItem, c ttmlInContext) (err error) {
+	// Get items
+	var items TTMLInItems
+	if items, err = i.items(); err != nil {
+		err = errors.Wrap(err, "getting items failed")
+		return
+	}
+
+	// Loop through items
+	for _, ti := range items {
+		// Switch on tag
+		switch strings.ToLower(ti.XMLName.Local) {
+		case "div":
+			// Create context
+			var cc ttmlInContext
+			if cc, err = c.child(ti, o); err != nil {
+				err = errors.Wrap(err, "creating div context failed")
 				return
 			}
-			s.Region = o.Regions[ts.Region]
-		}
 
-		// Add style
-		if len(ts.Style) > 0 {
-			if _, ok := o.Styles[ts.Style]; !ok {
-				err = fmt.Errorf("Style %s requested by subtitle between %s and %s doesn't exist", ts.Style, s.StartAt, s.EndAt)
+			// Read div
+			if err = readTTMLInBlock(o, ti, cc); err != nil {
+				return
+			}
+		case "p":
+			if err = readTTMLInSubtitle(o, ti, c); err != nil {
 				return
 			}
-			s.Style = o.Styles[ts.Style]
 		}
+	}
+	return
+}
 
-		// Unmarshal items
-		var items = TTMLInItems{}
-		if err = xml.Unmarshal([]byte("<span>"+ts.Items+"</span>"), &items); err != nil {
-			err = errors.Wrap(err, "unmarshaling items failed")
-			return
-		}
+// readTTMLInSubtitle adds the items of an input TTML subtitle
+func readTTMLInSubtitle(o *Subtitles, i TTMLInItem, c ttmlInContext) (err error) {
+	// Create context
+	if c, err = c.child(i, o); err != nil {
+		err = errors.Wrap(err, "creating p context failed")
+		return
+	}
 
-		// Loop through texts
-		var l = &Line{}
-		for _, tt := range items {
-			// New line specified with the "br" tag
-			if strings.ToLower(tt.XMLName.Local) == "br" {
-				s.Lines = append(s.Lines, *l)
-				l = &Line{}
-				continue
-			}
+	// Init item
+	var s = &Item{
+		EndAt:       c.tc.end,
+		InlineStyle: c.inlineStyle,
+		Language:    ttmlLanguage(c.lang),
+		Region:      c.region,
+		StartAt:     c.tc.begin,
+		Style:       c.style,
+	}
+
+	// Subtitle is never active
+	if s.StartAt > s.EndAt {
+		return
+	}
 
-			// New line decoded as a line break. This can happen if there's a "br" tag within the text since
+	// End can't be resolved
+	if s.EndAt == ttmlIndefinite {
+		err = fmt.Errorf("End of subtitle starting at %s can't be resolved", s.StartAt)
+		return
+	}
+
+	// Line items only inherit from their ancestor spans, the rest being carried by the item
+	c.inheritedStyle = &StyleAttributes{}
+	c.inlineStyle = &StyleAttributes{}
+	c.style = nil
+
+	// Read line items
+	var lines [][]ttmlInLineItem
+	var l = []ttmlInLineItem{}
+	if err = readTTMLInLineItems(o, i, c, &lines, &l); err != nil {
+		err = errors.Wrapf(err, "reading line items of subtitle between %s and %s failed", s.StartAt, s.EndAt)
+		return
+	}
+	lines = append(lines, l)
+
+	// Append subtitles
+	o.Items = append(o.Items, newTTMLInItems(s, lines)...)
+	return
+}
+
+// readTTMLInLineItems adds the line items contained in an input TTML subtitle or span and its nested spans
+func readTTMLInLineItems(o *Subtitles, i TTMLInItem, c ttmlInContext, lines *[][]ttmlInLineItem, l *[]ttmlInLineItem) (err error) {
+	// Get items
+	var items TTMLInItems
+	if items, err = i.items(); err != nil {
+		err = errors.Wrap(err, "getting items failed")
+		return
+	}
+
+	// Loop through items
+	for _, tt := range items {
+		// Switch on tag
+		switch strings.ToLower(tt.XMLName.Local) {
+		case "":
+			// New line decoded as a line break. This can happen if there's a "br" tag within the text
 			// since the go xml unmarshaler will unmarshal a "br" tag as a line break if the field has the
 			// chardata xml tag.
 			for idx, li := range strings.Split(tt.Text, "\n") {
 				// New line
 				if idx > 0 {
-					s.Lines = append(s.Lines, *l)
-					l = &Line{}
-				}
-
-				// Init line item
-				var t = LineItem{
-					InlineStyle: tt.TTMLInStyleAttributes.styleAttributes(),
-					Text:        strings.TrimSpace(li),
+					*lines = append(*lines, *l)
+					*l = []ttmlInLineItem{}
 				}
 
-				// Add style
-				if len(tt.Style) > 0 {
-					if _, ok := o.Styles[tt.Style]; !ok {
-						err = fmt.Errorf("Style %s requested by item with text %s doesn't exist", tt.Style, tt.Text)
-						return
-					}
-					t.Style = o.Styles[tt.Style]
-				}
-
-				// Append items
-				*l = append(*l, t)
+				// Append line item
+				var sa = *c.inlineStyle
+				*l = append(*l, ttmlInLineItem{
+					begin: c.tc.begin,
+					end:   c.tc.end,
+					lineItem: LineItem{
+						InlineStyle: &sa,
+						Style:       c.style,
+						Text:        strings.TrimSpace(li),
+					},
+				})
+			}
+		case "br":
+			// New line specified with the "br" tag
+			*lines = append(*lines, *l)
+			*l = []ttmlInLineItem{}
+		case "span":
+			// Create context
+			var cc ttmlInContext
+			if cc, err = c.child(tt, o); err != nil {
+				err = errors.Wrap(err, "creating span context failed")
+				return
 			}
 
+			// Read span
+			if err = readTTMLInLineItems(o, tt, cc, lines, l); err != nil {
+				return
+			}
 		}
-		s.Lines = append(s.Lines, *l)
-
-		// Append subtitle
-		o.Items = append(o.Items, s)
 	}
 	return
 }
 
+// TTML profiles
+const (
+	TTMLProfileEBUTTD      = "ebu-tt-d"
+	TTMLProfileIMSC11Text  = "imsc1.1-text"
+	TTMLProfileNetflixDFXP = "netflix-dfxp"
+	TTMLProfileSMPTETT     = "smpte-tt"
+)
+
+// TTML default cell resolution
+const (
+	ttmlDefaultCellResolutionColumns = 32
+	ttmlDefaultCellResolutionRows    = 15
+)
+
+// ttmlOutProfile represents the requirements of an output TTML profile
+type ttmlOutProfile struct {
+	conformsToStandard string
+	contentProfiles    string
+	extent             bool // Whether tts:extent is written on the tt element
+	frameRate          bool
+	namespaceEBUTTM    bool
+	namespaceSMPTE     bool
+	percentages        bool // Whether font sizes and line heights are expressed in percentages
+	pixels             bool // Whether pixel lengths are allowed
+	profile            string
+}
+
+// TTML output profiles
+// https://www.w3.org/TR/ttml-imsc1.1/
+// https://tech.ebu.ch/docs/tech/tech3380.pdf
+// https://partnerhelp.netflixstudios.com/hc/en-us/articles/215986007
+var ttmlOutProfiles = map[string]ttmlOutProfile{
+	TTMLProfileEBUTTD: {
+		conformsToStandard: "urn:ebu:tt:distribution:2014-01",
+		namespaceEBUTTM:    true,
+		percentages:        true,
+	},
+	TTMLProfileIMSC11Text: {
+		contentProfiles: "http://www.w3.org/ns/ttml/profile/imsc1.1/text",
+		extent:          true,
+		pixels:          true,
+	},
+	TTMLProfileNetflixDFXP: {
+		extent:    true,
+		frameRate: true,
+		pixels:    true,
+		profile:   "http://www.netflix.com/ns/ttml/profile/dfxp-ls-sdh",
+	},
+	TTMLProfileSMPTETT: {
+		extent:         true,
+		frameRate:      true,
+		namespaceSMPTE: true,
+		pixels:         true,
+		profile:        "http://www.smpte-ra.org/schemas/2052-1/2010/profiles/smpte-tt-full",
+	},
+}
+
+// TTMLOptions represents TTML write options
+// Pixel lengths are converted into percentages or cells using the frame size, they can't be written
+// in a profile if the frame size is unknown.
+type TTMLOptions struct {
+	CellResolutionColumns int
+	CellResolutionRows    int
+	FrameHeight           int
+	FrameWidth            int
+	Profile               string
+}
+
 // TTMLOut represents an output TTML that must be marshaled
 // We split it from the input TTML as this time we'll add strict namespaces
 type TTMLOut struct {
-	Lang            string            `xml:"xml:lang,attr,omitempty"`
-	Metadata        *TTMLOutMetadata  `xml:"head>metadata,omitempty"`
-	Styles          []TTMLOutStyle    `xml:"head>styling>style,omitempty"` //!\\ Order is important! Keep Styling above Layout
-	Regions         []TTMLOutRegion   `xml:"head>layout>region,omitempty"`
-	Subtitles       []TTMLOutSubtitle `xml:"body>div>p,omitempty"`
-	XMLName         xml.Name          `xml:"http://www.w3.org/ns/ttml tt"`
-	XMLNamespaceTTM string            `xml:"xmlns:ttm,attr"`
-	XMLNamespaceTTS string            `xml:"xmlns:tts,attr"`
+	CellResolution      string            `xml:"ttp:cellResolution,attr,omitempty"`
+	ContentProfiles     string            `xml:"ttp:contentProfiles,attr,omitempty"`
+	DropMode            string            `xml:"ttp:dropMode,attr,omitempty"`
+	Extent              string            `xml:"tts:extent,attr,omitempty"`
+	FrameRate           int               `xml:"ttp:frameRate,attr,omitempty"`
+	FrameRateMultiplier string            `xml:"ttp:frameRateMultiplier,attr,omitempty"`
+	Lang                string            `xml:"xml:lang,attr,omitempty"`
+	Metadata            *TTMLOutMetadata  `xml:"head>metadata,omitempty"`
+	Profile             string            `xml:"ttp:profile,attr,omitempty"`
+	Styles              []TTMLOutStyle    `xml:"head>styling>style,omitempty"` //!\\ Order is important! Keep Styling above Layout
+	Regions             []TTMLOutRegion   `xml:"head>layout>region,omitempty"`
+	Subtitles           []TTMLOutSubtitle `xml:"body>div>p,omitempty"`
+	TimeBase            string            `xml:"ttp:timeBase,attr,omitempty"`
+	XMLName             xml.Name          `xml:"http://www.w3.org/ns/ttml tt"`
+	XMLNamespaceEBUTTM  string            `xml:"xmlns:ebuttm,attr,omitempty"`
+	XMLNamespaceSMPTE   string            `xml:"xmlns:smpte,attr,omitempty"`
+	XMLNamespaceTTM     string            `xml:"xmlns:ttm,attr"`
+	XMLNamespaceTTP     string            `xml:"xmlns:ttp,attr,omitempty"`
+	XMLNamespaceTTS     string            `xml:"xmlns:tts,attr"`
 }
 
 // TTMLOutMetadata represents an output TTML Metadata
 type TTMLOutMetadata struct {
-	Copyright string `xml:"ttm:copyright,omitempty"`
-	Title     string `xml:"ttm:title,omitempty"`
+	Copyright        string                   `xml:"ttm:copyright,omitempty"`
+	DocumentMetadata *TTMLOutDocumentMetadata `xml:"ebuttm:documentMetadata,omitempty"`
+	Title            string                   `xml:"ttm:title,omitempty"`
+}
+
+// TTMLOutDocumentMetadata represents an output EBU-TT document metadata
+type TTMLOutDocumentMetadata struct {
+	ConformsToStandard string `xml:"ebuttm:conformsToStandard"`
 }
 
 // TTMLOutStyleAttributes represents output TTML style attributes
@@ -411,24 +1229,23 @@ func ttmlOutStyleAttributesFromStyleAttributes(s *StyleAttributes) TTMLOutStyleA
 	if s == nil {
 		return TTMLOutStyleAttributes{}
 	}
-	return TTMLOutStyleAttributes{
-		BackgroundColor: s.BackgroundColor,
-		Color:           s.Color,
+	var o = TTMLOutStyleAttributes{
+		BackgroundColor: formatTTMLColor(s.BackgroundColor),
+		Color:           formatTTMLColor(s.Color),
 		Direction:       s.Direction,
 		Display:         s.Display,
-		DisplayAlign:    s.DisplayAlign,
-		Extent:          s.Extent,
+		DisplayAlign:    string(s.DisplayAlign),
+		Extent:          formatLengths(s.Extent, " "),
 		FontFamily:      s.FontFamily,
-		FontSize:        s.FontSize,
-		FontStyle:       s.FontStyle,
+		FontSize:        formatLengths(s.FontSize, " "),
+		FontStyle:       string(s.FontStyle),
 		FontWeight:      s.FontWeight,
-		LineHeight:      s.LineHeight,
 		Opacity:         s.Opacity,
-		Origin:          s.Origin,
+		Origin:          formatLengths(s.Origin, " "),
 		Overflow:        s.Overflow,
-		Padding:         s.Padding,
+		Padding:         formatLengths(s.Padding, " "),
 		ShowBackground:  s.ShowBackground,
-		TextAlign:       s.TextAlign,
+		TextAlign:       string(s.TextAlign),
 		TextDecoration:  s.TextDecoration,
 		TextOutline:     s.TextOutline,
 		UnicodeBidi:     s.UnicodeBidi,
@@ -437,6 +1254,21 @@ func ttmlOutStyleAttributesFromStyleAttributes(s *StyleAttributes) TTMLOutStyleA
 		WritingMode:     s.WritingMode,
 		ZIndex:          s.ZIndex,
 	}
+	if s.LineHeight != nil {
+		o.LineHeight = s.LineHeight.String()
+	}
+	return o
+}
+
+// formatTTMLColor formats a color, using its name if it has one
+func formatTTMLColor(c *Color) string {
+	if c == nil {
+		return ""
+	}
+	if n, ok := c.Name(); ok {
+		return n
+	}
+	return c.String()
 }
 
 // TTMLOutHeader represents an output TTML header
@@ -485,12 +1317,265 @@ func (t TTMLOutDuration) MarshalText() ([]byte, error) {
 	return []byte(formatDuration(time.Duration(t), ".")), nil
 }
 
+// ttmlOutProfileWriter applies an output TTML profile
+type ttmlOutProfileWriter struct {
+	o TTMLOptions
+	p ttmlOutProfile
+}
+
+// newTTMLOutProfileWriter creates a new output TTML profile writer
+func newTTMLOutProfileWriter(o TTMLOptions) (w *ttmlOutProfileWriter, err error) {
+	// Get profile
+	var ok bool
+	w = &ttmlOutProfileWriter{o: o}
+	if w.p, ok = ttmlOutProfiles[o.Profile]; !ok {
+		err = fmt.Errorf("Invalid TTML profile %s", o.Profile)
+		return
+	}
+
+	// Default cell resolution
+	if w.o.CellResolutionColumns <= 0 || w.o.CellResolutionRows <= 0 {
+		w.o.CellResolutionColumns = ttmlDefaultCellResolutionColumns
+		w.o.CellResolutionRows = ttmlDefaultCellResolutionRows
+	}
+	return
+}
+
+// ttmlOutFramerate adds the framerate parameters to the tt element
+func ttmlOutFramerate(ttml *TTMLOut, f Framerate) {
+	ttml.FrameRate = f.nominal()
+	if r := big.NewRat(int64(f.Numerator), int64(f.Denominator*f.nominal())); r.Cmp(big.NewRat(1, 1)) != 0 {
+		ttml.FrameRateMultiplier = fmt.Sprintf("%s %s", r.Num(), r.Denom())
+	}
+	if f.DropFrame {
+		ttml.DropMode = ttmlDropModeNTSC
+	}
+	ttml.XMLNamespaceTTP = "http://www.w3.org/ns/ttml#parameter"
+}
+
+// hasFrameSize checks whether the frame size is known
+func (w *ttmlOutProfileWriter) hasFrameSize() bool {
+	return w.o.FrameHeight > 0 && w.o.FrameWidth > 0
+}
+
+// tt adds the profile attributes to the tt element
+func (w *ttmlOutProfileWriter) tt(ttml *TTMLOut, s Subtitles) {
+	// Add parameters
+	ttml.CellResolution = fmt.Sprintf("%d %d", w.o.CellResolutionColumns, w.o.CellResolutionRows)
+	ttml.ContentProfiles = w.p.contentProfiles
+	ttml.Profile = w.p.profile
+	ttml.TimeBase = ttmlTimeBaseMedia
+	ttml.XMLNamespaceTTP = "http://www.w3.org/ns/ttml#parameter"
+
+	// Language is mandatory
+	if len(ttml.Lang) == 0 {
+		ttml.Lang = ttmlLanguageEnglish
+	}
+
+	// Add extent
+	if w.p.extent && w.hasFrameSize() {
+		ttml.Extent = fmt.Sprintf("%dpx %dpx", w.o.FrameWidth, w.o.FrameHeight)
+	}
+
+	// Add framerate
+	if w.p.frameRate && s.Metadata != nil && !s.Metadata.Framerate.IsZero() {
+		ttmlOutFramerate(ttml, s.Metadata.Framerate)
+	}
+
+	// Add namespaces
+	if w.p.namespaceEBUTTM {
+		ttml.XMLNamespaceEBUTTM = "urn:ebu:tt:metadata"
+	}
+	if w.p.namespaceSMPTE {
+		ttml.XMLNamespaceSMPTE = "http://www.smpte-ra.org/schemas/2052-1/2010/smpte-tt"
+	}
+
+	// Add standard
+	if len(w.p.conformsToStandard) > 0 {
+		if ttml.Metadata == nil {
+			ttml.Metadata = &TTMLOutMetadata{}
+		}
+		ttml.Metadata.DocumentMetadata = &TTMLOutDocumentMetadata{ConformsToStandard: w.p.conformsToStandard}
+	}
+}
+
+// ttmlOutStyleAttributes converts style attributes into output TTML style attributes
+// If there's a profile, lengths are converted into the profile's units and validated
+func (w *ttmlOutProfileWriter) ttmlOutStyleAttributes(s *StyleAttributes) (o TTMLOutStyleAttributes, err error) {
+	// No profile
+	if w == nil || s == nil {
+		o = ttmlOutStyleAttributesFromStyleAttributes(s)
+		return
+	}
+
+	// Copy attributes
+	var sa = *s
+
+	// Region geometry is expressed in percentages
+	for _, ls := range []*[]Length{&sa.Extent, &sa.Origin} {
+		if *ls, err = w.convert(*ls, LengthUnitPercent, false); err != nil {
+			err = errors.Wrapf(err, "converting geometry %s failed", formatLengths(*ls, " "))
+			return
+		}
+	}
+
+	// Font related lengths are expressed in cells
+	// A single font size is vertical
+	var fontSize = sa.FontSize
+	if sa.FontSize, err = w.convert(sa.FontSize, LengthUnitCell, len(sa.FontSize) == 1); err != nil {
+		err = errors.Wrapf(err, "converting font size %s failed", formatLengths(fontSize, " "))
+		return
+	}
+	if sa.LineHeight != nil {
+		var ls []Length
+		if ls, err = w.convert([]Length{*sa.LineHeight}, LengthUnitCell, true); err != nil {
+			err = errors.Wrapf(err, "converting line height %s failed", sa.LineHeight)
+			return
+		}
+		sa.LineHeight = &ls[0]
+	}
+
+	// Font sizes are expressed in percentages of the cell size, and line heights in percentages of the font size
+	if w.p.percentages {
+		if err = ttmlFontPercentages(&sa); err != nil {
+			err = errors.Wrapf(err, "converting font lengths into percentages for TTML profile %s failed", w.o.Profile)
+			return
+		}
+	}
+
+	// Validate pixels
+	// Pixels are relative to the root extent which can't be written without frame size
+	if !w.p.pixels || !w.hasFrameSize() {
+		for _, l := range sa.Padding {
+			if l.Unit == LengthUnitPixel {
+				err = fmt.Errorf("Pixel padding %s is not allowed in TTML profile %s", formatLengths(sa.Padding, " "), w.o.Profile)
+				return
+			}
+		}
+		if strings.Contains(sa.TextOutline, LengthUnitPixel) {
+			err = fmt.Errorf("Pixel text outline %s is not allowed in TTML profile %s", sa.TextOutline, w.o.Profile)
+			return
+		}
+	}
+
+	// Convert
+	o = ttmlOutStyleAttributesFromStyleAttributes(&sa)
+	return
+}
+
+// ttmlFontPercentages converts font sizes and line heights expressed in cells or ems into percentages
+// Font sizes are relative to the default font size of 1 cell, and line heights to the font size of the style, which
+// defaults to 1 cell as well.
+func ttmlFontPercentages(sa *StyleAttributes) (err error) {
+	// Get font size in cells
+	var size = 1.0
+	if len(sa.FontSize) > 0 {
+		switch l := sa.FontSize[len(sa.FontSize)-1]; l.Unit {
+		case LengthUnitCell:
+			size = l.Value
+		case LengthUnitPercent:
+			size = l.Value / 100
+		}
+	}
+
+	// Convert font size
+	var fs []Length
+	for _, l := range sa.FontSize {
+		switch l.Unit {
+		case LengthUnitCell, LengthUnitEm:
+			fs = append(fs, Length{Unit: LengthUnitPercent, Value: roundPercentage(l.Value * 100)})
+		case LengthUnitPercent:
+			fs = append(fs, l)
+		default:
+			err = fmt.Errorf("Font size %s can't be converted into percentages", formatLengths(sa.FontSize, " "))
+			return
+		}
+	}
+	sa.FontSize = fs
+
+	// Convert line height
+	if sa.LineHeight != nil {
+		switch sa.LineHeight.Unit {
+		case LengthUnitCell:
+			if size <= 0 {
+				err = fmt.Errorf("Line height %s can't be converted into percentages of font size %s", sa.LineHeight, formatLengths(sa.FontSize, " "))
+				return
+			}
+			sa.LineHeight = &Length{Unit: LengthUnitPercent, Value: roundPercentage(sa.LineHeight.Value / size * 100)}
+		case LengthUnitEm:
+			sa.LineHeight = &Length{Unit: LengthUnitPercent, Value: roundPercentage(sa.LineHeight.Value * 100)}
+		case LengthUnitPercent:
+		default:
+			err = fmt.Errorf("Line height %s can't be converted into percentages", sa.LineHeight)
+			return
+		}
+	}
+	return
+}
+
+// convert converts the pixel and cell lengths of a list into the provided unit
+// The first length is horizontal unless specified otherwise, the second one is vertical.
+func (w *ttmlOutProfileWriter) convert(i []Length, unit string, vertical bool) (o []Length, err error) {
+	for idx, l := range i {
+		// Only pixels and cells need to be converted
+		if l.Unit != LengthUnitPixel && (l.Unit != LengthUnitCell || unit != LengthUnitPercent) {
+			o = append(o, l)
+			continue
+		}
+
+		// Pixels can't be converted without frame size, and can't be kept either since the root extent they're
+		// relative to can't be written
+		if l.Unit == LengthUnitPixel && !w.hasFrameSize() {
+			err = fmt.Errorf("Pixel length %s can't be converted without frame size", l)
+			return
+		}
+
+		// Convert
+		var c Length
+		if c, err = l.Convert(unit, w.viewport(), idx == 1 || vertical); err != nil {
+			err = errors.Wrapf(err, "converting %s failed", l)
+			return
+		}
+		o = append(o, c)
+	}
+	return
+}
+
+// viewport returns the viewport lengths are relative to
+func (w *ttmlOutProfileWriter) viewport() Viewport {
+	return Viewport{
+		CellResolutionColumns: w.o.CellResolutionColumns,
+		CellResolutionRows:    w.o.CellResolutionRows,
+		Height:                w.o.FrameHeight,
+		Width:                 w.o.FrameWidth,
+	}
+}
+
 // WriteToTTML writes subtitles in .ttml format
-func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
+// If a profile is provided in the options, the output conforms to it
+func (s Subtitles) WriteToTTML(o io.Writer, opts ...TTMLOptions) (err error) {
 	// Do not write anything if no subtitles
 	if len(s.Items) == 0 {
 		return ErrNoSubtitlesToWrite
 	}
+	return s.writeTTML(o, opts...)
+}
+
+// writeTTML writes subtitles in .ttml format even if there are no subtitles
+func (s Subtitles) writeTTML(o io.Writer, opts ...TTMLOptions) (err error) {
+	// Images are not supported
+	if err = s.textOnly("ttml"); err != nil {
+		return
+	}
+
+	// Get profile writer
+	var pw *ttmlOutProfileWriter
+	if len(opts) > 0 && len(opts[0].Profile) > 0 {
+		if pw, err = newTTMLOutProfileWriter(opts[0]); err != nil {
+			err = errors.Wrap(err, "creating profile writer failed")
+			return
+		}
+	}
 
 	// Init TTML
 	var ttml = TTMLOut{
@@ -507,6 +1592,16 @@ func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
 				Title:     s.Metadata.Title,
 			}
 		}
+
+		// Profiles decide whether the framerate is written
+		if pw == nil && !s.Metadata.Framerate.IsZero() {
+			ttmlOutFramerate(&ttml, s.Metadata.Framerate)
+		}
+	}
+
+	// Add profile
+	if pw != nil {
+		pw.tt(&ttml, s)
 	}
 
 	// Add regions
@@ -516,16 +1611,46 @@ func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
 	}
 	sort.Strings(k)
 	for _, id := range k {
-		var ttmlRegion = TTMLOutRegion{TTMLOutHeader: TTMLOutHeader{
-			ID: s.Regions[id].ID,
-			TTMLOutStyleAttributes: ttmlOutStyleAttributesFromStyleAttributes(s.Regions[id].InlineStyle),
-		}}
+		// Geometry is only described if styles don't already
+		var sa = s.Regions[id].InlineStyle
+		if g := s.Regions[id].Geometry; g != nil {
+			sa = &StyleAttributes{}
+			mergeStyleAttributes(sa, s.Regions[id].InlineStyle, nil)
+			var rs = s.Regions[id].Style.styleAttributes()
+			mergeStyleAttributes(sa, ttmlGeometryStyleAttributes(g), func(name string) bool { return !isStyleAttributeSet(rs, name) })
+		}
+
+		// Add region
+		var ttmlRegion = TTMLOutRegion{TTMLOutHeader: TTMLOutHeader{ID: s.Regions[id].ID}}
+		if ttmlRegion.TTMLOutStyleAttributes, err = pw.ttmlOutStyleAttributes(sa); err != nil {
+			err = errors.Wrapf(err, "converting style attributes of region %s failed", id)
+			return
+		}
 		if s.Regions[id].Style != nil {
 			ttmlRegion.Style = s.Regions[id].Style.ID
 		}
 		ttml.Regions = append(ttml.Regions, ttmlRegion)
 	}
 
+	// Add regions describing the geometry of items without region
+	var geometryRegions = make(map[Geometry]string)
+	for _, item := range s.Items {
+		if item.Region != nil || item.Geometry == nil {
+			continue
+		}
+		if _, ok := geometryRegions[*item.Geometry]; ok {
+			continue
+		}
+		var id = fmt.Sprintf("geometry_%d", len(geometryRegions)+1)
+		var ttmlRegion = TTMLOutRegion{TTMLOutHeader: TTMLOutHeader{ID: id}}
+		if ttmlRegion.TTMLOutStyleAttributes, err = pw.ttmlOutStyleAttributes(ttmlGeometryStyleAttributes(item.Geometry)); err != nil {
+			err = errors.Wrapf(err, "converting style attributes of region %s failed", id)
+			return
+		}
+		geometryRegions[*item.Geometry] = id
+		ttml.Regions = append(ttml.Regions, ttmlRegion)
+	}
+
 	// Add styles
 	k = []string{}
 	for _, style := range s.Styles {
@@ -533,10 +1658,11 @@ func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
 	}
 	sort.Strings(k)
 	for _, id := range k {
-		var ttmlStyle = TTMLOutStyle{TTMLOutHeader: TTMLOutHeader{
-			ID: s.Styles[id].ID,
-			TTMLOutStyleAttributes: ttmlOutStyleAttributesFromStyleAttributes(s.Styles[id].InlineStyle),
-		}}
+		var ttmlStyle = TTMLOutStyle{TTMLOutHeader: TTMLOutHeader{ID: s.Styles[id].ID}}
+		if ttmlStyle.TTMLOutStyleAttributes, err = pw.ttmlOutStyleAttributes(s.Styles[id].InlineStyle); err != nil {
+			err = errors.Wrapf(err, "converting style attributes of style %s failed", id)
+			return
+		}
 		if s.Styles[id].Style != nil {
 			ttmlStyle.Style = s.Styles[id].Style.ID
 		}
@@ -549,12 +1675,17 @@ func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
 		var ttmlSubtitle = TTMLOutSubtitle{
 			Begin: TTMLOutDuration(item.StartAt),
 			End:   TTMLOutDuration(item.EndAt),
-			TTMLOutStyleAttributes: ttmlOutStyleAttributesFromStyleAttributes(item.InlineStyle),
+		}
+		if ttmlSubtitle.TTMLOutStyleAttributes, err = pw.ttmlOutStyleAttributes(item.InlineStyle); err != nil {
+			err = errors.Wrapf(err, "converting style attributes of subtitle starting at %s failed", item.StartAt)
+			return
 		}
 
 		// Add region
 		if item.Region != nil {
 			ttmlSubtitle.Region = item.Region.ID
+		} else if item.Geometry != nil {
+			ttmlSubtitle.Region = geometryRegions[*item.Geometry]
 		}
 
 		// Add style
@@ -569,9 +1700,12 @@ func (s Subtitles) WriteToTTML(o io.Writer) (err error) {
 			for _, lineItem := range line {
 				// Init ttml item
 				var ttmlItem = TTMLOutItem{
-					Text: lineItem.Text,
-					TTMLOutStyleAttributes: ttmlOutStyleAttributesFromStyleAttributes(lineItem.InlineStyle),
-					XMLName:                xml.Name{Local: "span"},
+					Text:    lineItem.Text,
+					XMLName: xml.Name{Local: "span"},
+				}
+				if ttmlItem.TTMLOutStyleAttributes, err = pw.ttmlOutStyleAttributes(lineItem.InlineStyle); err != nil {
+					err = errors.Wrapf(err, "converting style attributes of line item %s failed", lineItem.Text)
+					return
 				}
 
 				// Add style
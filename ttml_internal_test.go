@@ -29,6 +29,38 @@ func TestTTMLDuration(t *testing.T) {
 	assert.Equal(t, 2, d.frames)
 
 	// Duration
-	d.framerate = 8
+	d.framerate = NewFramerate(8)
 	assert.Equal(t, 12*time.Hour+34*time.Minute+56*time.Second+250*time.Millisecond, d.duration())
 }
+
+func TestTTMLTimeExpressions(t *testing.T) {
+	for _, v := range []struct {
+		d time.Duration
+		p ttmlTimeParameters
+		s string
+	}{
+		{d: 12500 * time.Millisecond, s: "12.5s"},
+		{d: 90 * time.Minute, s: "1.5h"},
+		{d: 90 * time.Second, s: "1.5m"},
+		{d: 250 * time.Millisecond, s: "250ms"},
+		{d: 12 * time.Second, p: ttmlTimeParameters{framerate: Framerate25}, s: "300f"},
+		{d: 10 * time.Second, s: "300f"},
+		{d: time.Second, p: ttmlTimeParameters{tickrate: 10000000}, s: "10000000t"},
+		{d: 2 * time.Second, p: ttmlTimeParameters{framerate: Framerate25, subFramerate: 2}, s: "100t"},
+		{d: 10 * time.Second, s: "10t"},
+		{d: time.Second + 123456789*time.Nanosecond, s: "00:00:01.123456789"},
+		{d: 1500 * time.Millisecond, p: ttmlTimeParameters{framerate: Framerate25, subFramerate: 2}, s: "00:00:01:12.1"},
+		{d: time.Hour, p: ttmlTimeParameters{framerate: Framerate2997}, s: "01:00:00:00"},
+		{d: 3603600 * time.Millisecond, p: ttmlTimeParameters{framerate: Framerate2997, timeBase: ttmlTimeBaseSMPTE}, s: "01:00:00:00"},
+		{d: 3599996400 * time.Microsecond, p: ttmlTimeParameters{framerate: Framerate2997DropFrame, timeBase: ttmlTimeBaseSMPTE}, s: "01:00:00:00"},
+	} {
+		var d = &TTMLInDuration{ttmlTimeParameters: v.p}
+		err := d.UnmarshalText([]byte(v.s))
+		assert.NoError(t, err)
+		assert.Equal(t, v.d, d.duration(), v.s)
+	}
+
+	// Invalid
+	err := (&TTMLInDuration{}).UnmarshalText([]byte("1.5x"))
+	assert.EqualError(t, err, "Invalid time expression 1.5x")
+}
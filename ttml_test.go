@@ -2,8 +2,11 @@ package astisub_test
 
 import (
 	"bytes"
+	"encoding/xml"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/asticode/go-astisub"
 	"github.com/stretchr/testify/assert"
@@ -15,23 +18,23 @@ func TestTTML(t *testing.T) {
 	assert.NoError(t, err)
 	assertSubtitleItems(t, s)
 	// Metadata
-	assert.Equal(t, &astisub.Metadata{Copyright: "Copyright test", Framerate: 25, Language: astisub.LanguageFrench, Title: "Title test"}, s.Metadata)
+	assert.Equal(t, &astisub.Metadata{Copyright: "Copyright test", Framerate: astisub.Framerate25, Language: astisub.LanguageFrench, Title: "Title test"}, s.Metadata)
 	// Styles
 	assert.Equal(t, 3, len(s.Styles))
-	assert.Equal(t, astisub.Style{ID: "style_0", InlineStyle: &astisub.StyleAttributes{Color: "white", Extent: "100% 10%", FontFamily: "sansSerif", FontStyle: "normal", Origin: "0% 90%", TextAlign: "center"}, Style: s.Styles["style_2"]}, *s.Styles["style_0"])
-	assert.Equal(t, astisub.Style{ID: "style_1", InlineStyle: &astisub.StyleAttributes{Color: "white", Extent: "100% 13%", FontFamily: "sansSerif", FontStyle: "normal", Origin: "0% 87%", TextAlign: "center"}}, *s.Styles["style_1"])
-	assert.Equal(t, astisub.Style{ID: "style_2", InlineStyle: &astisub.StyleAttributes{Color: "white", Extent: "100% 20%", FontFamily: "sansSerif", FontStyle: "normal", Origin: "0% 80%", TextAlign: "center"}}, *s.Styles["style_2"])
+	assert.Equal(t, astisub.Style{ID: "style_0", InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorWhite, Extent: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 100}, {Unit: astisub.LengthUnitPercent, Value: 10}}, FontFamily: "sansSerif", FontStyle: "normal", Origin: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 0}, {Unit: astisub.LengthUnitPercent, Value: 90}}, TextAlign: "center"}, Style: s.Styles["style_2"]}, *s.Styles["style_0"])
+	assert.Equal(t, astisub.Style{ID: "style_1", InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorWhite, Extent: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 100}, {Unit: astisub.LengthUnitPercent, Value: 13}}, FontFamily: "sansSerif", FontStyle: "normal", Origin: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 0}, {Unit: astisub.LengthUnitPercent, Value: 87}}, TextAlign: "center"}}, *s.Styles["style_1"])
+	assert.Equal(t, astisub.Style{ID: "style_2", InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorWhite, Extent: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 100}, {Unit: astisub.LengthUnitPercent, Value: 20}}, FontFamily: "sansSerif", FontStyle: "normal", Origin: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 0}, {Unit: astisub.LengthUnitPercent, Value: 80}}, TextAlign: "center"}}, *s.Styles["style_2"])
 	// Regions
 	assert.Equal(t, 3, len(s.Regions))
-	assert.Equal(t, astisub.Region{ID: "region_0", Style: s.Styles["style_0"], InlineStyle: &astisub.StyleAttributes{Color: "blue"}}, *s.Regions["region_0"])
-	assert.Equal(t, astisub.Region{ID: "region_1", Style: s.Styles["style_1"], InlineStyle: &astisub.StyleAttributes{}}, *s.Regions["region_1"])
-	assert.Equal(t, astisub.Region{ID: "region_2", Style: s.Styles["style_2"], InlineStyle: &astisub.StyleAttributes{}}, *s.Regions["region_2"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 10, TextAlign: astisub.TextAlignCenter, Top: 90, Width: 100}, ID: "region_0", Style: s.Styles["style_0"], InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorBlue}}, *s.Regions["region_0"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 13, TextAlign: astisub.TextAlignCenter, Top: 87, Width: 100}, ID: "region_1", Style: s.Styles["style_1"], InlineStyle: &astisub.StyleAttributes{}}, *s.Regions["region_1"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignBefore, Height: 20, TextAlign: astisub.TextAlignCenter, Top: 80, Width: 100}, ID: "region_2", Style: s.Styles["style_2"], InlineStyle: &astisub.StyleAttributes{}}, *s.Regions["region_2"])
 	// Items
 	assert.Equal(t, s.Regions["region_1"], s.Items[0].Region)
 	assert.Equal(t, s.Styles["style_1"], s.Items[0].Style)
-	assert.Equal(t, &astisub.StyleAttributes{Color: "red"}, s.Items[0].InlineStyle)
-	assert.Equal(t, []astisub.Line{{{Style: s.Styles["style_1"], InlineStyle: &astisub.StyleAttributes{Color: "black"}, Text: "(deep rumbling)"}}}, s.Items[0].Lines)
-	assert.Equal(t, []astisub.Line{{{InlineStyle: &astisub.StyleAttributes{}, Text: "MAN:"}}, {{InlineStyle: &astisub.StyleAttributes{}, Text: "How did we"}, {InlineStyle: &astisub.StyleAttributes{Color: "green"}, Style: s.Styles["style_1"], Text: "end up"}, {InlineStyle: &astisub.StyleAttributes{}, Text: "here?"}}}, s.Items[1].Lines)
+	assert.Equal(t, &astisub.StyleAttributes{Color: &astisub.ColorRed}, s.Items[0].InlineStyle)
+	assert.Equal(t, []astisub.Line{{{Style: s.Styles["style_1"], InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorBlack}, Text: "(deep rumbling)"}}}, s.Items[0].Lines)
+	assert.Equal(t, []astisub.Line{{{InlineStyle: &astisub.StyleAttributes{}, Text: "MAN:"}}, {{InlineStyle: &astisub.StyleAttributes{}, Text: "How did we"}, {InlineStyle: &astisub.StyleAttributes{Color: &astisub.ColorGreen}, Style: s.Styles["style_1"], Text: "end up"}, {InlineStyle: &astisub.StyleAttributes{}, Text: "here?"}}}, s.Items[1].Lines)
 	assert.Equal(t, []astisub.Line{{{InlineStyle: &astisub.StyleAttributes{}, Style: s.Styles["style_1"], Text: "This place is horrible."}}}, s.Items[2].Lines)
 	assert.Equal(t, []astisub.Line{{{InlineStyle: &astisub.StyleAttributes{}, Style: s.Styles["style_1"], Text: "Smells like balls."}}}, s.Items[3].Lines)
 	assert.Equal(t, []astisub.Line{{{InlineStyle: &astisub.StyleAttributes{}, Style: s.Styles["style_2"], Text: "We don't belong"}}, {{InlineStyle: &astisub.StyleAttributes{}, Style: s.Styles["style_1"], Text: "in this shithole."}}}, s.Items[4].Lines)
@@ -48,4 +51,191 @@ func TestTTML(t *testing.T) {
 	err = s.WriteToTTML(w)
 	assert.NoError(t, err)
 	assert.Equal(t, string(c), w.String())
+
+	// Drop-frame
+	s.Metadata.Framerate = astisub.Framerate2997DropFrame
+	w.Reset()
+	err = s.WriteToTTML(w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `ttp:dropMode="dropNTSC" ttp:frameRate="30" ttp:frameRateMultiplier="1000 1001"`)
+	s, err = astisub.ReadFromTTML(w)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.Framerate2997DropFrame, s.Metadata.Framerate)
+}
+
+func TestTTMLTiming(t *testing.T) {
+	s, err := astisub.ReadFromTTML(strings.NewReader(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" ttp:tickRate="10">
+	<body begin="10s">
+		<div begin="1s" timeContainer="seq">
+			<p dur="2s">subtitle-1</p>
+			<p begin="1s" end="30t">subtitle-2</p>
+		</div>
+		<div begin="20s" end="25s">
+			<p begin="1s">
+				<span>subtitle-3</span>
+				<span begin="2s">subtitle-4</span>
+				<br/>
+				<span begin="3s" dur="500ms">subtitle-5</span>
+			</p>
+		</div>
+	</body>
+</tt>`))
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 6)
+	for i, v := range []struct {
+		endAt, startAt time.Duration
+		text           string
+	}{
+		{endAt: 13 * time.Second, startAt: 11 * time.Second, text: "subtitle-1"},
+		{endAt: 16 * time.Second, startAt: 14 * time.Second, text: "subtitle-2"},
+		{endAt: 33 * time.Second, startAt: 31 * time.Second, text: "subtitle-3"},
+		{endAt: 34 * time.Second, startAt: 33 * time.Second, text: "subtitle-3 subtitle-4"},
+		{endAt: 34*time.Second + 500*time.Millisecond, startAt: 34 * time.Second, text: "subtitle-3 subtitle-4 - subtitle-5"},
+		{endAt: 35 * time.Second, startAt: 34*time.Second + 500*time.Millisecond, text: "subtitle-3 subtitle-4"},
+	} {
+		assert.Equal(t, v.startAt, s.Items[i].StartAt)
+		assert.Equal(t, v.endAt, s.Items[i].EndAt)
+		assert.Equal(t, v.text, s.Items[i].String())
+	}
+
+	// Unresolved end
+	_, err = astisub.ReadFromTTML(strings.NewReader(`<tt><body><div><p begin="1s">subtitle-1</p></div></body></tt>`))
+	assert.Error(t, err)
+
+	// Subtitles of the body's divs are still unmarshaled
+	var ttml astisub.TTMLIn
+	err = xml.Unmarshal([]byte(`<tt><body begin="1s"><div><p begin="1s" end="2s">subtitle-1</p></div></body></tt>`), &ttml)
+	assert.NoError(t, err)
+	assert.Len(t, ttml.Subtitles, 1)
+	assert.Equal(t, "subtitle-1", ttml.Subtitles[0].Items)
+}
+
+func TestTTMLInheritance(t *testing.T) {
+	s, err := astisub.ReadFromTTML(strings.NewReader(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling" xml:lang="en">
+	<head>
+		<styling>
+			<style xml:id="style_1" tts:color="white" tts:fontStyle="italic"/>
+			<style xml:id="style_2" tts:backgroundColor="black"/>
+		</styling>
+		<layout>
+			<region xml:id="region_1"/>
+			<region xml:id="region_2"/>
+		</layout>
+	</head>
+	<body region="region_1" style="style_1">
+		<div tts:color="yellow" tts:textAlign="center">
+			<div style="style_2" xml:lang="fr">
+				<p begin="1s" end="2s" region="region_2">
+					<span tts:fontWeight="bold">subtitle-1<span style="style_1">subtitle-2</span></span>
+				</p>
+			</div>
+			<p begin="3s" end="4s" style="style_1">subtitle-3</p>
+		</div>
+	</body>
+</tt>`))
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 2)
+
+	// Nested divs
+	assert.Equal(t, &astisub.StyleAttributes{Color: &astisub.ColorYellow, FontStyle: "italic", TextAlign: "center"}, s.Items[0].InlineStyle)
+	assert.Equal(t, s.Regions["region_2"], s.Items[0].Region)
+	assert.Equal(t, "french", s.Items[0].Language)
+	assert.Nil(t, s.Items[0].Style)
+
+	// Nested spans
+	assert.Len(t, s.Items[0].Lines, 1)
+	assert.Equal(t, astisub.Line{
+		{InlineStyle: &astisub.StyleAttributes{FontWeight: "bold"}, Text: "subtitle-1"},
+		{InlineStyle: &astisub.StyleAttributes{FontWeight: "bold"}, Style: s.Styles["style_1"], Text: "subtitle-2"},
+	}, s.Items[0].Lines[0])
+
+	// Referential style
+	assert.Equal(t, &astisub.StyleAttributes{TextAlign: "center"}, s.Items[1].InlineStyle)
+	assert.Equal(t, s.Regions["region_1"], s.Items[1].Region)
+	assert.Equal(t, "english", s.Items[1].Language)
+	assert.Equal(t, s.Styles["style_1"], s.Items[1].Style)
+
+	// Split by language
+	ls := s.SplitByLanguage()
+	assert.Len(t, ls, 2)
+	assert.Equal(t, "french", ls["french"].Metadata.Language)
+	assert.Equal(t, []*astisub.Item{s.Items[1]}, ls["english"].Items)
+
+	// Unparseable values are ignored
+	s, err = astisub.ReadFromTTML(strings.NewReader(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling">
+	<body>
+		<div>
+			<p begin="1s" end="2s" tts:color="yellow" tts:fontSize="5rh" tts:fontStyle="reverseOblique" tts:textAlign="justify">subtitle-1</p>
+		</div>
+	</body>
+</tt>`))
+	assert.NoError(t, err)
+	assert.Equal(t, &astisub.StyleAttributes{Color: &astisub.ColorYellow}, s.Items[0].InlineStyle)
+}
+
+func TestTTMLProfiles(t *testing.T) {
+	s := astisub.NewSubtitles()
+	s.Metadata = &astisub.Metadata{Framerate: astisub.Framerate2997, Language: astisub.LanguageFrench}
+	s.Regions["region_1"] = &astisub.Region{ID: "region_1", InlineStyle: &astisub.StyleAttributes{Extent: []astisub.Length{{Unit: astisub.LengthUnitPixel, Value: 1920}, {Unit: astisub.LengthUnitPixel, Value: 216}}, Origin: []astisub.Length{{Unit: astisub.LengthUnitCell, Value: 2}, {Unit: astisub.LengthUnitCell, Value: 12}}}}
+	s.Items = append(s.Items, &astisub.Item{
+		EndAt:       2 * time.Second,
+		InlineStyle: &astisub.StyleAttributes{FontSize: []astisub.Length{{Unit: astisub.LengthUnitPixel, Value: 72}}, LineHeight: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 125}},
+		Lines:       []astisub.Line{{{Text: "subtitle-1"}}},
+		Region:      s.Regions["region_1"],
+		StartAt:     time.Second,
+	})
+
+	// IMSC 1.1 text
+	w := &bytes.Buffer{}
+	err := s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileIMSC11Text})
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `<tt xmlns="http://www.w3.org/ns/ttml" ttp:cellResolution="32 15" ttp:contentProfiles="http://www.w3.org/ns/ttml/profile/imsc1.1/text" tts:extent="1920px 1080px" xml:lang="fr" ttp:timeBase="media" xmlns:ttm="http://www.w3.org/ns/ttml#metadata" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" xmlns:tts="http://www.w3.org/ns/ttml#styling">`)
+	assert.Contains(t, w.String(), `<region xml:id="region_1" tts:extent="100% 20%" tts:origin="6.25% 80%"></region>`)
+	assert.Contains(t, w.String(), `tts:fontSize="1c" tts:lineHeight="125%"`)
+
+	// EBU-TT-D
+	w.Reset()
+	err = s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileEBUTTD})
+	assert.NoError(t, err)
+	assert.NotContains(t, w.String(), `tts:extent="1920px 1080px"`)
+	assert.Contains(t, w.String(), `<ebuttm:conformsToStandard>urn:ebu:tt:distribution:2014-01</ebuttm:conformsToStandard>`)
+	assert.Contains(t, w.String(), `xmlns:ebuttm="urn:ebu:tt:metadata"`)
+	assert.Contains(t, w.String(), `tts:fontSize="100%" tts:lineHeight="125%"`)
+	w.Reset()
+	s.Items[0].InlineStyle.LineHeight = &astisub.Length{Unit: astisub.LengthUnitCell, Value: 1}
+	err = s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileEBUTTD})
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `tts:fontSize="100%" tts:lineHeight="100%"`)
+	w.Reset()
+	s.Items[0].InlineStyle.FontSize = []astisub.Length{{Unit: astisub.LengthUnitCell, Value: 2}}
+	s.Items[0].InlineStyle.LineHeight = &astisub.Length{Unit: astisub.LengthUnitPixel, Value: 108}
+	err = s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileEBUTTD})
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `tts:fontSize="200%" tts:lineHeight="75%"`)
+	s.Items[0].InlineStyle.FontSize = []astisub.Length{{Unit: astisub.LengthUnitPixel, Value: 72}}
+	s.Items[0].InlineStyle.LineHeight = nil
+
+	// SMPTE-TT
+	w.Reset()
+	err = s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileSMPTETT})
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `ttp:frameRate="30" ttp:frameRateMultiplier="1000 1001"`)
+	assert.Contains(t, w.String(), `ttp:profile="http://www.smpte-ra.org/schemas/2052-1/2010/profiles/smpte-tt-full"`)
+	assert.Contains(t, w.String(), `xmlns:smpte="http://www.smpte-ra.org/schemas/2052-1/2010/smpte-tt"`)
+
+	// Netflix DFXP
+	w.Reset()
+	err = s.WriteToTTML(w, astisub.TTMLOptions{FrameHeight: 1080, FrameWidth: 1920, Profile: astisub.TTMLProfileNetflixDFXP})
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `ttp:profile="http://www.netflix.com/ns/ttml/profile/dfxp-ls-sdh"`)
+
+	// Unknown frame size
+	err = s.WriteToTTML(w, astisub.TTMLOptions{Profile: astisub.TTMLProfileEBUTTD})
+	assert.Error(t, err)
+	err = s.WriteToTTML(w, astisub.TTMLOptions{Profile: astisub.TTMLProfileIMSC11Text})
+	assert.Error(t, err)
+
+	// Invalid profile
+	err = s.WriteToTTML(w, astisub.TTMLOptions{Profile: "invalid"})
+	assert.Error(t, err)
 }
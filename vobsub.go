@@ -0,0 +1,426 @@
+package astisub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astitools/map"
+	"github.com/pkg/errors"
+)
+
+// http://sam.zoy.org/writings/dvd/subtitles/
+
+// VobSub constants
+const (
+	vobSubDelayUnit       = 1024 * time.Second / tsClockRate
+	vobSubPaletteSize     = 16
+	vobSubStartCodePack   = 0xba
+	vobSubSubstreamIDBase = 0x20
+)
+
+// VobSub control commands
+const (
+	vobSubCommandAlpha               = 0x04
+	vobSubCommandChangeColorContrast = 0x07
+	vobSubCommandDisplayArea         = 0x05
+	vobSubCommandEnd                 = 0xff
+	vobSubCommandForcedStart         = 0x00
+	vobSubCommandPalette             = 0x03
+	vobSubCommandPixelDataAddress    = 0x06
+	vobSubCommandStartDisplay        = 0x01
+	vobSubCommandStopDisplay         = 0x02
+)
+
+// VobSub languages are ISO 639-1 codes
+var vobSubLanguageMapping = astimap.NewMap("", "").
+	Set("en", LanguageEnglish).
+	Set("fr", LanguageFrench)
+
+// vobSubTimestamp represents a .idx timestamp
+type vobSubTimestamp struct {
+	filepos int64
+	t       time.Duration
+}
+
+// vobSubIndex represents a parsed .idx file
+type vobSubIndex struct {
+	height     int
+	language   string
+	palette    [vobSubPaletteSize]color.NRGBA
+	timestamps []vobSubTimestamp
+	width      int
+}
+
+// readVobSub opens the .sub file matching a .idx file and reads them
+func readVobSub(idx io.Reader, src string, languageIndex int) (o *Subtitles, err error) {
+	// Open the .sub file
+	var p = strings.TrimSuffix(src, ".idx") + ".sub"
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		err = errors.Wrapf(err, "opening %s failed", p)
+		return
+	}
+	defer f.Close()
+
+	// Read
+	return ReadFromVobSub(idx, f, languageIndex)
+}
+
+// ReadFromVobSub parses a DVD VobSub .idx content and the SPU packets of the matching .sub content
+// Only the subtitles of the language whose index is provided are read. Each SPU leads to an item starting and
+// ending at its timestamp shifted by its start and stop display delays.
+func ReadFromVobSub(idx io.Reader, sub io.ReadSeeker, languageIndex int) (o *Subtitles, err error) {
+	// Parse .idx
+	var i *vobSubIndex
+	if i, err = parseVobSubIndex(idx, languageIndex); err != nil {
+		err = errors.Wrap(err, "parsing idx failed")
+		return
+	}
+
+	// Init
+	o = NewSubtitles()
+	if len(i.language) > 0 {
+		o.Metadata = &Metadata{Language: i.language}
+	}
+
+	// Loop through timestamps
+	for n, ts := range i.timestamps {
+		// Read SPU
+		var b []byte
+		if b, err = readVobSubSPU(sub, ts.filepos, uint8(vobSubSubstreamIDBase+languageIndex)); err != nil {
+			err = errors.Wrapf(err, "reading spu at filepos %d failed", ts.filepos)
+			return
+		}
+
+		// Parse SPU
+		var it *Item
+		if it, err = i.parseSPU(b); err != nil {
+			err = errors.Wrapf(err, "parsing spu at filepos %d failed", ts.filepos)
+			return
+		}
+		if it == nil {
+			continue
+		}
+
+		// Update times
+		it.StartAt += ts.t
+		if it.EndAt < 0 {
+			it.EndAt = it.StartAt
+			if n+1 < len(i.timestamps) {
+				it.EndAt = i.timestamps[n+1].t
+			}
+		} else {
+			it.EndAt += ts.t
+		}
+		o.Items = append(o.Items, it)
+	}
+	return
+}
+
+// parseVobSubIndex parses a .idx content and keeps the timestamps of a language
+func parseVobSubIndex(i io.Reader, languageIndex int) (o *vobSubIndex, err error) {
+	// Loop through lines
+	o = &vobSubIndex{}
+	var currentIndex = -1
+	var scanner = bufio.NewScanner(i)
+	for scanner.Scan() {
+		// Split line
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var split = strings.SplitN(line, ":", 2)
+		if len(split) < 2 {
+			continue
+		}
+		var k, v = strings.TrimSpace(split[0]), strings.TrimSpace(split[1])
+
+		// Switch on key
+		switch k {
+		case "id":
+			// Parse language
+			var l string
+			if _, err = fmt.Sscanf(v, "%2s, index: %d", &l, &currentIndex); err != nil {
+				err = errors.Wrapf(err, "parsing id %s failed", v)
+				return
+			}
+			if currentIndex == languageIndex && vobSubLanguageMapping.InA(l) {
+				o.language = vobSubLanguageMapping.B(l).(string)
+			}
+		case "palette":
+			for idx, c := range strings.Split(v, ",") {
+				var rgb uint64
+				if rgb, err = strconv.ParseUint(strings.TrimSpace(c), 16, 32); err != nil {
+					err = errors.Wrapf(err, "parsing palette color %s failed", c)
+					return
+				}
+				if idx < vobSubPaletteSize {
+					o.palette[idx] = color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}
+				}
+			}
+		case "size":
+			if _, err = fmt.Sscanf(v, "%dx%d", &o.width, &o.height); err != nil {
+				err = errors.Wrapf(err, "parsing size %s failed", v)
+				return
+			}
+		case "timestamp":
+			// Only the timestamps of the selected language are kept
+			if currentIndex != languageIndex {
+				continue
+			}
+
+			// Parse timestamp
+			var h, m, s, ms int
+			var ts vobSubTimestamp
+			if _, err = fmt.Sscanf(v, "%d:%d:%d:%d, filepos: %x", &h, &m, &s, &ms, &ts.filepos); err != nil {
+				err = errors.Wrapf(err, "parsing timestamp %s failed", v)
+				return
+			}
+			ts.t = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond
+			o.timestamps = append(o.timestamps, ts)
+		}
+	}
+	return
+}
+
+// readVobSubSPU reads the SPU of a substream starting in the pack at a position of the MPEG-PS content
+// SPUs may be split across several packs, and are complete once their size has been reached.
+func readVobSubSPU(i io.ReadSeeker, filepos int64, substreamID uint8) (o []byte, err error) {
+	// Seek
+	if _, err = i.Seek(filepos, io.SeekStart); err != nil {
+		err = errors.Wrap(err, "seeking failed")
+		return
+	}
+
+	// Loop through packets
+	var r = bufio.NewReader(i)
+	var h = make([]byte, 6)
+	for len(o) < 2 || len(o) < int(binary.BigEndian.Uint16(o)) {
+		// Read start code
+		if _, err = io.ReadFull(r, h[:4]); err != nil {
+			err = errors.Wrap(err, "reading start code failed")
+			return
+		}
+		if h[0] != 0 || h[1] != 0 || h[2] != 1 {
+			err = fmt.Errorf("Invalid start code %x", h[:4])
+			return
+		}
+
+		// Pack header
+		if h[3] == vobSubStartCodePack {
+			// MPEG-1 pack headers are 8 bytes long
+			var p = make([]byte, 8)
+			if _, err = io.ReadFull(r, p); err != nil {
+				err = errors.Wrap(err, "reading pack header failed")
+				return
+			}
+
+			// MPEG-2 pack headers are 10 bytes long and may be stuffed
+			if p[0]>>6 == 0x1 {
+				p = append(p, 0, 0)
+				if _, err = io.ReadFull(r, p[8:]); err != nil {
+					err = errors.Wrap(err, "reading pack header failed")
+					return
+				}
+				if _, err = r.Discard(int(p[9] & 0x7)); err != nil {
+					err = errors.Wrap(err, "discarding stuffing failed")
+					return
+				}
+			}
+			continue
+		}
+
+		// Read packet
+		if _, err = io.ReadFull(r, h[4:]); err != nil {
+			err = errors.Wrap(err, "reading packet length failed")
+			return
+		}
+		var p = make([]byte, binary.BigEndian.Uint16(h[4:]))
+		if _, err = io.ReadFull(r, p); err != nil {
+			err = errors.Wrap(err, "reading packet failed")
+			return
+		}
+
+		// Only private streams carry subtitles
+		if h[3] != tsStreamIDPrivate {
+			continue
+		}
+
+		// Parse PES header
+		var n int
+		var ok bool
+		if n, _, ok = parseTSPESHeader(append(append([]byte{}, h...), p...)); !ok || n-6 >= len(p) {
+			continue
+		}
+
+		// Check substream
+		if p[n-6] != substreamID {
+			continue
+		}
+		o = append(o, p[n-5:]...)
+	}
+	o = o[:binary.BigEndian.Uint16(o)]
+	return
+}
+
+// parseSPU parses a SPU into an item whose times are relative to the SPU timestamp
+// EndAt is negative when the SPU has no stop display command.
+func (i vobSubIndex) parseSPU(b []byte) (o *Item, err error) {
+	// Check size
+	if len(b) < 4 {
+		err = errors.New("SPU is too short")
+		return
+	}
+
+	// Loop through control sequences
+	var area image.Rectangle
+	var alphas, colors [4]uint8
+	var offsets [2]int
+	var startAt, endAt = time.Duration(0), time.Duration(-1)
+	var started bool
+	for offset, previous := int(binary.BigEndian.Uint16(b[2:])), -1; offset != previous && offset+4 <= len(b); {
+		// Parse header
+		var delay = time.Duration(binary.BigEndian.Uint16(b[offset:])) * vobSubDelayUnit
+		previous, offset = offset, int(binary.BigEndian.Uint16(b[offset+2:]))
+
+		// Loop through commands
+		for idx := previous + 4; idx < len(b) && b[idx] != vobSubCommandEnd; {
+			var c = b[idx]
+			idx++
+			switch c {
+			case vobSubCommandForcedStart, vobSubCommandStartDisplay:
+				startAt = delay
+				started = true
+			case vobSubCommandStopDisplay:
+				endAt = delay
+			case vobSubCommandPalette, vobSubCommandAlpha:
+				if idx+2 > len(b) {
+					err = errors.New("Command overflows SPU")
+					return
+				}
+				var v = [4]uint8{b[idx+1] & 0xf, b[idx+1] >> 4, b[idx] & 0xf, b[idx] >> 4}
+				if c == vobSubCommandPalette {
+					colors = v
+				} else {
+					alphas = v
+				}
+				idx += 2
+			case vobSubCommandDisplayArea:
+				if idx+6 > len(b) {
+					err = errors.New("Command overflows SPU")
+					return
+				}
+				var x1, x2 = int(b[idx])<<4 | int(b[idx+1]>>4), int(b[idx+1]&0xf)<<8 | int(b[idx+2])
+				var y1, y2 = int(b[idx+3])<<4 | int(b[idx+4]>>4), int(b[idx+4]&0xf)<<8 | int(b[idx+5])
+				area = image.Rect(x1, y1, x2+1, y2+1)
+				idx += 6
+			case vobSubCommandPixelDataAddress:
+				if idx+4 > len(b) {
+					err = errors.New("Command overflows SPU")
+					return
+				}
+				offsets = [2]int{int(binary.BigEndian.Uint16(b[idx:])), int(binary.BigEndian.Uint16(b[idx+2:]))}
+				idx += 4
+			case vobSubCommandChangeColorContrast:
+				// Not supported: skip its parameters
+				if idx+2 > len(b) {
+					err = errors.New("Command overflows SPU")
+					return
+				}
+				idx += int(binary.BigEndian.Uint16(b[idx:]))
+			default:
+				err = fmt.Errorf("Invalid command %#x", c)
+				return
+			}
+		}
+	}
+
+	// Nothing to display
+	if !started || area.Empty() {
+		return
+	}
+
+	// Get colors
+	var cs [4]color.NRGBA
+	for idx := range cs {
+		cs[idx] = i.palette[colors[idx]]
+		cs[idx].A = alphas[idx] * 0x11
+	}
+
+	// Decode fields
+	var img = image.NewNRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	for f, offset := range offsets {
+		vobSubDecodeField(img, b, offset, f, cs)
+	}
+
+	// Create item
+	o = &Item{
+		EndAt: endAt,
+		Image: &Image{
+			FrameHeight: i.height,
+			FrameWidth:  i.width,
+			Image:       img,
+			Left:        area.Min.X,
+			Top:         area.Min.Y,
+		},
+		StartAt: startAt,
+	}
+	return
+}
+
+// vobSubDecodeField decodes the 2-bit RLE lines of a field
+// Lines are interlaced: the top field holds even lines and the bottom field odd lines.
+func vobSubDecodeField(img *image.NRGBA, b []byte, offset, field int, cs [4]color.NRGBA) {
+	// Nibble reader
+	var pos = offset * 2
+	var nibble = func() int {
+		if pos/2 >= len(b) {
+			return 0
+		}
+		var v = b[pos/2]
+		if pos%2 == 0 {
+			v >>= 4
+		}
+		pos++
+		return int(v & 0xf)
+	}
+
+	// Loop through lines
+	var w = img.Bounds().Dx()
+	for y := field; y < img.Bounds().Dy() && pos/2 < len(b); y += 2 {
+		for x := 0; x < w; {
+			// Get code
+			var c = nibble()
+			if c < 0x4 {
+				c = c<<4 | nibble()
+				if c < 0x10 {
+					c = c<<4 | nibble()
+					if c < 0x40 {
+						c = c<<4 | nibble()
+					}
+				}
+			}
+
+			// Get run
+			var n = c >> 2
+			if n == 0 || x+n > w {
+				n = w - x
+			}
+			for ; n > 0; n-- {
+				img.SetNRGBA(x, y, cs[c&0x3])
+				x++
+			}
+		}
+
+		// Lines are byte aligned
+		pos += pos % 2
+	}
+}
@@ -0,0 +1,71 @@
+package astisub_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+)
+
+// vobSubTestPack builds an MPEG-2 pack holding a SPU chunk
+func vobSubTestPack(substreamID uint8, spu []byte) []byte {
+	var b = []byte{0x0, 0x0, 0x1, 0xba, 0x44, 0x0, 0x4, 0x0, 0x4, 0x1, 0x1, 0x89, 0xc3, 0xf8}
+	return append(b, tsTestPES(0xbd, 0, append([]byte{substreamID}, spu...))...)
+}
+
+func TestReadFromVobSub(t *testing.T) {
+	// Init
+	spu := []byte{
+		// Size and control sequence offset
+		0x0, 0x28, 0x0, 0xa,
+		// Top and bottom fields: 2 pixels of color 1 and the rest of the line with color 0
+		0x90, 0x0, 0x0, 0x90, 0x0, 0x0,
+		// Start display, palette, alpha, display area and pixel data addresses
+		0x0, 0x0, 0x0, 0x22, 0x1, 0x3, 0x32, 0x10, 0x4, 0x0, 0xf0, 0x5, 0x0, 0xa0, 0xd, 0x1, 0x40, 0x15, 0x6, 0x0, 0x4, 0x0, 0x7, 0xff,
+		// Stop display
+		0x0, 0x58, 0x0, 0x22, 0x2, 0xff,
+	}
+	sub := append(vobSubTestPack(0x20, spu[:20]), vobSubTestPack(0x20, spu[20:])...)
+	filepos := len(sub)
+	sub = append(sub, vobSubTestPack(0x21, spu)...)
+	idx := strings.NewReader(fmt.Sprintf(`# VobSub index file, v7 (do not modify this line!)
+size: 720x576
+palette: 000000, ffffff, 808080, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000
+
+id: en, index: 0
+timestamp: 00:00:01:500, filepos: 000000000
+
+id: fr, index: 1
+timestamp: 00:01:00:000, filepos: %09x
+`, filepos))
+
+	// First language
+	s, err := astisub.ReadFromVobSub(idx, bytes.NewReader(sub), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageEnglish, s.Metadata.Language)
+	assert.Len(t, s.Items, 1)
+	assert.Equal(t, 1500*time.Millisecond, s.Items[0].StartAt)
+	assert.InDelta(t, 2501*time.Millisecond, s.Items[0].EndAt, float64(time.Millisecond))
+	i := s.Items[0].Image
+	assert.Equal(t, image.Rect(10, 20, 14, 22), i.Rectangle())
+	assert.Equal(t, 720, i.FrameWidth)
+	assert.Equal(t, 576, i.FrameHeight)
+	for y := 0; y < 2; y++ {
+		assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, i.Image.At(1, y))
+		assert.Equal(t, uint8(0), i.Image.At(2, y).(color.NRGBA).A)
+	}
+
+	// Second language
+	idx.Seek(0, 0)
+	s, err = astisub.ReadFromVobSub(idx, bytes.NewReader(sub), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.LanguageFrench, s.Metadata.Language)
+	assert.Len(t, s.Items, 1)
+	assert.Equal(t, time.Minute, s.Items[0].StartAt)
+}
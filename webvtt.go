@@ -2,8 +2,10 @@ package astisub
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +26,23 @@ const (
 	webvttTimeBoundariesSeparator = " --> "
 )
 
+// WebVTT line height in percentage of the frame height
+const webvttLineHeight = 5.33
+
+// WebVTT position alignments
+const (
+	webvttPositionAlignCenter    = "center"
+	webvttPositionAlignLineLeft  = "line-left"
+	webvttPositionAlignLineRight = "line-right"
+)
+
+// WebVTT legacy position alignments
+var webvttLegacyPositionAlignments = map[string]string{
+	"end":    webvttPositionAlignLineRight,
+	"middle": webvttPositionAlignCenter,
+	"start":  webvttPositionAlignLineLeft,
+}
+
 // Vars
 var (
 	bytesWebVTTTimeBoundariesSeparator = []byte(webvttTimeBoundariesSeparator)
@@ -44,22 +63,25 @@ func ReadFromWebVTT(i io.Reader) (o *Subtitles, err error) {
 	var scanner = bufio.NewScanner(i)
 	var line string
 
-	// Skip the header
-	for scanner.Scan() {
-		line = scanner.Text()
-		if len(line) > 0 && line != "WEBVTT" {
-			break
-		}
-	}
-
 	// Scan
 	var item = &Item{}
 	var blockName string
 	var comments []string
+	var region *Region
+	var signatureSkipped bool
 	for scanner.Scan() {
 		// Fetch line
 		line = scanner.Text()
 
+		// Skip the signature
+		if !signatureSkipped {
+			if l := strings.TrimPrefix(line, "\ufeff"); len(l) == 0 {
+				continue
+			} else if signatureSkipped = true; strings.HasPrefix(l, "WEBVTT") {
+				continue
+			}
+		}
+
 		// Check prefixes
 		switch {
 		// Comment
@@ -68,42 +90,36 @@ func ReadFromWebVTT(i io.Reader) (o *Subtitles, err error) {
 			comments = append(comments, strings.TrimPrefix(line, "NOTE "))
 		// Empty line
 		case len(line) == 0:
+			// Add region
+			if blockName == webvttBlockNameRegion {
+				addWebVTTRegion(o, region)
+			}
+
 			// Reset block name
 			blockName = ""
-		// Region
+		// Legacy region header
 		case strings.HasPrefix(line, "Region: "):
-			// Add region styles
+			// Add region settings
 			var r = &Region{InlineStyle: &StyleAttributes{}}
-			for _, part := range strings.Split(strings.TrimPrefix(line, "Region: "), " ") {
-				// Split on "="
-				var split = strings.Split(part, "=")
-				if len(split) <= 1 {
-					err = fmt.Errorf("Invalid region style %s", part)
-					return
-				}
-
-				// Switch on key
-				switch split[0] {
-				case "id":
-					r.ID = split[1]
-				case "lines":
-					if r.InlineStyle.Lines, err = strconv.Atoi(split[1]); err != nil {
-						err = errors.Wrapf(err, "atoi of %s failed", split[1])
-						return
-					}
-				case "regionanchor":
-					r.InlineStyle.RegionAnchor = split[1]
-				case "scroll":
-					r.InlineStyle.Scroll = split[1]
-				case "viewportanchor":
-					r.InlineStyle.ViewportAnchor = split[1]
-				case "width":
-					r.InlineStyle.Width = split[1]
-				}
+			for _, setting := range strings.Fields(strings.TrimPrefix(line, "Region: ")) {
+				parseWebVTTRegionSetting(r, setting, "=")
 			}
 
 			// Add region
-			o.Regions[r.ID] = r
+			addWebVTTRegion(o, r)
+		// Timestamp map
+		case strings.HasPrefix(line, "X-TIMESTAMP-MAP="):
+			if o.Metadata == nil {
+				o.Metadata = &Metadata{}
+			}
+			if o.Metadata.WebVTTTimestampMap, err = parseWebVTTTimestampMap(strings.TrimPrefix(line, "X-TIMESTAMP-MAP=")); err != nil {
+				err = errors.Wrapf(err, "parsing timestamp map %s failed", line)
+				return
+			}
+		// Region block
+		case strings.TrimSpace(line) == "REGION" && len(blockName) == 0:
+			blockName = webvttBlockNameRegion
+			region = &Region{InlineStyle: &StyleAttributes{}}
 		// Style
 		case strings.HasPrefix(line, "STYLE "):
 			blockName = webvttBlockNameStyle
@@ -133,38 +149,8 @@ func ReadFromWebVTT(i io.Reader) (o *Subtitles, err error) {
 				return
 			}
 
-			// Parse style
-			if len(partsRight) > 1 {
-				// Add styles
-				for index := 1; index < len(partsRight); index++ {
-					// Split line on ":"
-					var split = strings.Split(partsRight[index], ":")
-					if len(split) <= 1 {
-						err = fmt.Errorf("Invalid inline style %s", partsRight[index])
-						return
-					}
-
-					// Switch on key
-					switch split[0] {
-					case "align":
-						item.InlineStyle.Align = split[1]
-					case "line":
-						item.InlineStyle.Line = split[1]
-					case "position":
-						item.InlineStyle.Position = split[1]
-					case "region":
-						if _, ok := o.Regions[split[1]]; !ok {
-							err = fmt.Errorf("Unknown region %s", split[1])
-							return
-						}
-						item.Region = o.Regions[split[1]]
-					case "size":
-						item.InlineStyle.Size = split[1]
-					case "vertical":
-						item.InlineStyle.Vertical = split[1]
-					}
-				}
-			}
+			// Parse settings
+			parseWebVTTCueSettings(item, partsRight[1:], o.Regions)
 
 			// Reset comments
 			comments = []string{}
@@ -177,6 +163,10 @@ func ReadFromWebVTT(i io.Reader) (o *Subtitles, err error) {
 			switch blockName {
 			case webvttBlockNameComment:
 				comments = append(comments, line)
+			case webvttBlockNameRegion:
+				for _, setting := range strings.Fields(line) {
+					parseWebVTTRegionSetting(region, setting, ":")
+				}
 			case webvttBlockNameStyle:
 				// TODO Do something with the style
 			case webvttBlockNameText:
@@ -187,25 +177,498 @@ func ReadFromWebVTT(i io.Reader) (o *Subtitles, err error) {
 			}
 		}
 	}
+
+	// Add last region
+	if blockName == webvttBlockNameRegion {
+		addWebVTTRegion(o, region)
+	}
+	return
+}
+
+// parseWebVTTCueSettings parses the settings of a .vtt cue and adds the geometry they describe to the item
+func parseWebVTTCueSettings(item *Item, settings []string, regions map[string]*Region) {
+	// Loop through settings
+	for _, setting := range settings {
+		// Split setting on ":"
+		// Invalid settings are ignored as per the specs
+		var split = strings.SplitN(setting, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+
+		// Switch on key
+		switch split[0] {
+		case "align":
+			if a, err := parseTextAlign(split[1]); err == nil {
+				item.InlineStyle.Align = a
+			}
+		case "line":
+			if webvttValidLine(split[1]) {
+				item.InlineStyle.Line = split[1]
+			}
+		case "position":
+			if webvttValidPosition(split[1]) {
+				item.InlineStyle.Position = split[1]
+			}
+		case "region":
+			if r, ok := regions[split[1]]; ok {
+				item.Region = r
+			}
+		case "size":
+			if v, ok := parseWebVTTPercentage(split[1]); ok {
+				item.InlineStyle.Size = &Length{Unit: LengthUnitPercent, Value: v}
+			}
+		case "vertical":
+			item.InlineStyle.Vertical = split[1]
+		}
+	}
+
+	// Add geometry
+	item.Geometry = newWebVTTGeometry(item.InlineStyle)
+}
+
+// parseWebVTTTimestampMap parses a .vtt X-TIMESTAMP-MAP header value such as "MPEGTS:900000,LOCAL:00:00:00.000"
+func parseWebVTTTimestampMap(i string) (m *WebVTTTimestampMap, err error) {
+	m = &WebVTTTimestampMap{}
+	for _, part := range strings.Split(i, ",") {
+		// Split
+		var split = strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(split) != 2 {
+			err = fmt.Errorf("Invalid timestamp map part %s", part)
+			return
+		}
+
+		// Switch on key
+		switch split[0] {
+		case "LOCAL":
+			if m.Local, err = parseDurationWebVTT(split[1]); err != nil {
+				err = errors.Wrapf(err, "parsing local %s failed", split[1])
+				return
+			}
+		case "MPEGTS":
+			if m.MPEGTS, err = strconv.ParseInt(split[1], 10, 64); err != nil {
+				err = errors.Wrapf(err, "parseint of %s failed", split[1])
+				return
+			}
+		}
+	}
+	return
+}
+
+// parseWebVTTRegionSetting parses a .vtt region setting whose key and value are split by the provided separator
+// As per the spec, invalid settings are ignored.
+func parseWebVTTRegionSetting(r *Region, setting, sep string) {
+	// Split
+	var split = strings.SplitN(setting, sep, 2)
+	if len(split) != 2 {
+		return
+	}
+
+	// Switch on key
+	switch split[0] {
+	case "id":
+		if !strings.Contains(split[1], strings.TrimSpace(webvttTimeBoundariesSeparator)) {
+			r.ID = split[1]
+		}
+	case "lines":
+		if l, err := strconv.Atoi(split[1]); err == nil && l >= 0 {
+			r.InlineStyle.Lines = l
+		}
+	case "regionanchor":
+		if a, err := parseAnchorWebVTT(split[1]); err == nil {
+			r.InlineStyle.RegionAnchor = a
+		}
+	case "scroll":
+		if split[1] == "up" {
+			r.InlineStyle.Scroll = split[1]
+		}
+	case "viewportanchor":
+		if a, err := parseAnchorWebVTT(split[1]); err == nil {
+			r.InlineStyle.ViewportAnchor = a
+		}
+	case "width":
+		if l, err := ParseLength(split[1]); err == nil && l.Unit == LengthUnitPercent {
+			r.InlineStyle.Width = &l
+		}
+	}
+}
+
+// parseAnchorWebVTT parses a .vtt anchor made of 2 percentages
+func parseAnchorWebVTT(i string) (o []Length, err error) {
+	if o, err = parseLengthsWebVTT(i); err != nil {
+		return
+	}
+	if len(o) != 2 || o[0].Unit != LengthUnitPercent || o[1].Unit != LengthUnitPercent {
+		err = fmt.Errorf("Invalid anchor %s", i)
+		return
+	}
+	return
+}
+
+// addWebVTTRegion adds a .vtt region to the subtitles
+// As per the spec, regions without id or with an id that already exists are dropped.
+func addWebVTTRegion(o *Subtitles, r *Region) {
+	// No id or region already exists
+	if _, ok := o.Regions[r.ID]; ok || len(r.ID) == 0 {
+		return
+	}
+
+	// Add region
+	r.Geometry = newWebVTTRegionGeometry(r.InlineStyle)
+	o.Regions[r.ID] = r
+}
+
+// isWebVTTRegion checks whether the region is described by .vtt settings
+func isWebVTTRegion(r *Region) bool {
+	return r.InlineStyle != nil && (r.InlineStyle.Width != nil || r.InlineStyle.Lines > 0 || len(r.InlineStyle.RegionAnchor) > 0 || len(r.InlineStyle.ViewportAnchor) > 0)
+}
+
+// newWebVTTRegionGeometry creates the geometry described by .vtt region settings
+// The region anchor is placed at the viewport anchor, the region being as high as its lines.
+func newWebVTTRegionGeometry(sa *StyleAttributes) *Geometry {
+	// Init
+	var width, lines = 100.0, 3
+	var regionAnchor, viewportAnchor = []float64{0, 100}, []float64{0, 100}
+	if sa.Width != nil {
+		width = sa.Width.Value
+	}
+	if sa.Lines > 0 {
+		lines = sa.Lines
+	}
+	if len(sa.RegionAnchor) == 2 {
+		regionAnchor = []float64{sa.RegionAnchor[0].Value, sa.RegionAnchor[1].Value}
+	}
+	if len(sa.ViewportAnchor) == 2 {
+		viewportAnchor = []float64{sa.ViewportAnchor[0].Value, sa.ViewportAnchor[1].Value}
+	}
+
+	// Create geometry
+	var height = math.Min(float64(lines)*webvttLineHeight, 100)
+	return &Geometry{
+		DisplayAlign: DisplayAlignAfter,
+		Height:       roundPercentage(height),
+		Left:         roundPercentage(viewportAnchor[0] - regionAnchor[0]*width/100),
+		Top:          roundPercentage(viewportAnchor[1] - regionAnchor[1]*height/100),
+		Width:        roundPercentage(width),
+	}
+}
+
+// parseWebVTTPercentage parses a .vtt percentage, which must be between 0 and 100
+func parseWebVTTPercentage(i string) (v float64, ok bool) {
+	l, err := ParseLength(i)
+	if err != nil || l.Unit != LengthUnitPercent || l.Value < 0 || l.Value > 100 {
+		return
+	}
+	return l.Value, true
+}
+
+// webvttValidLine checks whether a .vtt line setting is valid
+func webvttValidLine(i string) bool {
+	// Get parts
+	var parts = strings.Split(i, ",")
+	if len(parts) > 2 {
+		return false
+	}
+
+	// Check line
+	if _, ok := parseWebVTTPercentage(parts[0]); !ok && parts[0] != "auto" {
+		if _, err := strconv.Atoi(parts[0]); err != nil {
+			return false
+		}
+	}
+
+	// Check alignment
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "center", "end", "start":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// webvttValidPosition checks whether a .vtt position setting is valid
+func webvttValidPosition(i string) bool {
+	// Get parts
+	var parts = strings.Split(i, ",")
+	if len(parts) > 2 {
+		return false
+	}
+
+	// Check position
+	if _, ok := parseWebVTTPercentage(parts[0]); !ok && parts[0] != "auto" {
+		return false
+	}
+
+	// Check alignment
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "auto", webvttPositionAlignCenter, webvttPositionAlignLineLeft, webvttPositionAlignLineRight:
+		default:
+			if _, ok := webvttLegacyPositionAlignments[parts[1]]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newWebVTTGeometry creates the geometry described by .vtt cue settings
+// Vertical cues are not supported
+func newWebVTTGeometry(sa *StyleAttributes) (g *Geometry) {
+	// No positioning
+	if sa == nil || len(sa.Vertical) > 0 {
+		return
+	}
+	var line = sa.Line
+	if strings.HasPrefix(line, "auto") {
+		line = ""
+	}
+	if len(sa.Align) == 0 && len(line) == 0 && len(sa.Position) == 0 && sa.Size == nil {
+		return
+	}
+
+	// Init
+	g = newDefaultGeometry()
+	if len(sa.Align) > 0 {
+		g.TextAlign = sa.Align
+	}
+	if sa.Size != nil {
+		g.Width = sa.Size.Value
+	}
+
+	// Get default position
+	var position, positionAlign = 50.0, webvttPositionAlignCenter
+	switch g.TextAlign {
+	case TextAlignLeft, TextAlignStart:
+		position, positionAlign = 0, webvttPositionAlignLineLeft
+	case TextAlignEnd, TextAlignRight:
+		position, positionAlign = 100, webvttPositionAlignLineRight
+	}
+
+	// Parse position
+	if len(sa.Position) > 0 {
+		var parts = strings.Split(sa.Position, ",")
+		if v, ok := parseWebVTTPercentage(parts[0]); ok {
+			position = v
+		}
+		if len(parts) > 1 && parts[1] != "auto" {
+			positionAlign = parts[1]
+			if a, ok := webvttLegacyPositionAlignments[positionAlign]; ok {
+				positionAlign = a
+			}
+		}
+	}
+
+	// Add horizontal position
+	switch positionAlign {
+	case webvttPositionAlignLineLeft:
+		g.Left = position
+	case webvttPositionAlignLineRight:
+		g.Left = position - g.Width
+	default:
+		g.Left = position - g.Width/2
+	}
+	g.Left = math.Max(0, math.Min(g.Left, 100-g.Width))
+
+	// No line
+	if len(line) == 0 {
+		return
+	}
+
+	// Parse line
+	var parts = strings.Split(line, ",")
+	var anchor, lineAlign = 0.0, "start"
+	if len(parts) > 1 {
+		lineAlign = parts[1]
+	}
+	if v, ok := parseWebVTTPercentage(parts[0]); ok {
+		anchor = v
+	} else if n, err := strconv.Atoi(parts[0]); err == nil {
+		// Line numbers are counted from the top when positive and from the bottom when negative
+		if n >= 0 {
+			anchor, lineAlign = float64(n)*webvttLineHeight, "start"
+		} else {
+			anchor, lineAlign = 100+float64(n+1)*webvttLineHeight, "end"
+		}
+		anchor = math.Max(0, math.Min(anchor, 100))
+	}
+
+	// Add vertical position
+	switch lineAlign {
+	case "center":
+		var half = math.Min(anchor, 100-anchor)
+		g.DisplayAlign, g.Height, g.Top = DisplayAlignCenter, 2*half, anchor-half
+	case "end":
+		g.DisplayAlign, g.Height, g.Top = DisplayAlignAfter, anchor, 0
+	default:
+		g.DisplayAlign, g.Height, g.Top = DisplayAlignBefore, 100-anchor, anchor
+	}
 	return
 }
 
+// webvttCueSettings derives .vtt cue settings from a geometry
+// Settings matching default values are omitted
+func webvttCueSettings(g Geometry) (align TextAlign, line, position string, size *Length) {
+	// Line
+	switch g.DisplayAlign {
+	case DisplayAlignBefore:
+		if line = "0"; g.Top > 0 {
+			line = Length{Unit: LengthUnitPercent, Value: g.Top}.String()
+		}
+	case DisplayAlignCenter:
+		line = Length{Unit: LengthUnitPercent, Value: g.anchor()}.String() + ",center"
+	default:
+		if g.Bottom() < 100 {
+			line = Length{Unit: LengthUnitPercent, Value: g.Bottom()}.String() + ",end"
+		}
+	}
+
+	// Size
+	if g.Width < 100 {
+		size = &Length{Unit: LengthUnitPercent, Value: roundPercentage(g.Width)}
+	}
+
+	// Position
+	switch g.TextAlign {
+	case TextAlignLeft, TextAlignStart:
+		align = g.TextAlign
+		if g.Left > 0 {
+			position = Length{Unit: LengthUnitPercent, Value: g.Left}.String() + "," + webvttPositionAlignLineLeft
+		}
+	case TextAlignEnd, TextAlignRight:
+		align = g.TextAlign
+		if g.Right() < 100 {
+			position = Length{Unit: LengthUnitPercent, Value: g.Right()}.String() + "," + webvttPositionAlignLineRight
+		}
+	default:
+		if c := g.Left + g.Width/2; roundPercentage(c) != 50 {
+			position = Length{Unit: LengthUnitPercent, Value: c}.String() + "," + webvttPositionAlignCenter
+		}
+	}
+	return
+}
+
+// parseLengthsWebVTT parses a comma separated list of .vtt lengths
+func parseLengthsWebVTT(i string) ([]Length, error) {
+	return parseLengths(i, func(s string) []string { return strings.Split(s, ",") })
+}
+
 // formatDurationWebVTT formats a .vtt duration
 func formatDurationWebVTT(i time.Duration) string {
 	return formatDuration(i, ".")
 }
 
+// webvttItemSettings returns the .vtt cue settings of an item
+func webvttItemSettings(item *Item) (o []string) {
+	// Derive styles from the geometry if cue settings are missing
+	// Cues of a region described by .vtt settings are positioned by the region itself.
+	var sa = item.InlineStyle
+	var g = item.geometry()
+	if item.Geometry == nil && item.Region != nil && isWebVTTRegion(item.Region) {
+		g = nil
+	}
+	if g != nil && (sa == nil || (len(sa.Align) == 0 && len(sa.Line) == 0 && len(sa.Position) == 0 && sa.Size == nil)) {
+		sa = &StyleAttributes{}
+		if item.InlineStyle != nil {
+			*sa = *item.InlineStyle
+		}
+		sa.Align, sa.Line, sa.Position, sa.Size = webvttCueSettings(*g)
+	}
+
+	// Add settings
+	if sa == nil {
+		sa = &StyleAttributes{}
+	}
+	if sa.Align != "" {
+		o = append(o, "align:"+string(sa.Align))
+	}
+	if sa.Line != "" {
+		o = append(o, "line:"+sa.Line)
+	}
+	if sa.Position != "" {
+		o = append(o, "position:"+sa.Position)
+	}
+	if item.Region != nil {
+		o = append(o, "region:"+item.Region.ID)
+	}
+	if sa.Size != nil {
+		o = append(o, "size:"+sa.Size.String())
+	}
+	if sa.Vertical != "" {
+		o = append(o, "vertical:"+sa.Vertical)
+	}
+	return
+}
+
+// WebVTTOptions represents .vtt write options
+type WebVTTOptions struct {
+	TimestampMap *WebVTTTimestampMap
+}
+
+// WebVTTTimestampMap represents a .vtt X-TIMESTAMP-MAP header mapping a local .vtt time to a MPEG-TS time
+// MPEGTS is expressed in 90kHz units.
+type WebVTTTimestampMap struct {
+	Local  time.Duration
+	MPEGTS int64
+}
+
+// Offset returns the duration to add to local .vtt times to get MPEG-TS times
+func (m WebVTTTimestampMap) Offset() time.Duration {
+	return time.Duration(m.MPEGTS)*time.Second/hlsClockRate - m.Local
+}
+
+// String implements the Stringer interface
+func (m WebVTTTimestampMap) String() string {
+	return fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:%s", m.MPEGTS, formatDurationWebVTT(m.Local))
+}
+
 // WriteToWebVTT writes subtitles in .vtt format
-func (s Subtitles) WriteToWebVTT(o io.Writer) (err error) {
+func (s Subtitles) WriteToWebVTT(o io.Writer, opts ...WebVTTOptions) (err error) {
 	// Do not write anything if no subtitles
 	if len(s.Items) == 0 {
 		err = ErrNoSubtitlesToWrite
 		return
 	}
 
+	// Get options
+	var opt WebVTTOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	return s.writeWebVTT(o, opt)
+}
+
+// webvttHeader returns the .vtt header made of the signature and the region blocks
+func (s Subtitles) webvttHeader() (o []byte, err error) {
+	var buf = &bytes.Buffer{}
+	if err = (Subtitles{Regions: s.Regions}).writeWebVTT(buf, WebVTTOptions{}); err != nil {
+		err = errors.Wrap(err, "writing webvtt failed")
+		return
+	}
+	o = bytes.TrimSpace(buf.Bytes())
+	return
+}
+
+// writeWebVTT writes subtitles in .vtt format even if there are no subtitles
+func (s Subtitles) writeWebVTT(o io.Writer, opt WebVTTOptions) (err error) {
+	// Images are not supported
+	if err = s.textOnly("webvtt"); err != nil {
+		return
+	}
+
 	// Add header
 	var c []byte
-	c = append(c, []byte("WEBVTT\n\n")...)
+	c = append(c, []byte("WEBVTT\n")...)
+	if opt.TimestampMap == nil && s.Metadata != nil {
+		opt.TimestampMap = s.Metadata.WebVTTTimestampMap
+	}
+	if opt.TimestampMap != nil {
+		c = append(c, []byte(opt.TimestampMap.String())...)
+		c = append(c, bytesLineSeparator...)
+	}
+	c = append(c, bytesLineSeparator...)
 
 	// Add regions
 	var k []string
@@ -214,35 +677,44 @@ func (s Subtitles) WriteToWebVTT(o io.Writer) (err error) {
 	}
 	sort.Strings(k)
 	for _, id := range k {
-		c = append(c, []byte("Region: id="+s.Regions[id].ID)...)
-		if s.Regions[id].InlineStyle.Lines != 0 {
-			c = append(c, bytesSpace...)
-			c = append(c, []byte("lines="+strconv.Itoa(s.Regions[id].InlineStyle.Lines))...)
-		}
-		if s.Regions[id].InlineStyle.RegionAnchor != "" {
-			c = append(c, bytesSpace...)
-			c = append(c, []byte("regionanchor="+s.Regions[id].InlineStyle.RegionAnchor)...)
-		}
-		if s.Regions[id].InlineStyle.Scroll != "" {
-			c = append(c, bytesSpace...)
-			c = append(c, []byte("scroll="+s.Regions[id].InlineStyle.Scroll)...)
-		}
-		if s.Regions[id].InlineStyle.ViewportAnchor != "" {
-			c = append(c, bytesSpace...)
-			c = append(c, []byte("viewportanchor="+s.Regions[id].InlineStyle.ViewportAnchor)...)
-		}
-		if s.Regions[id].InlineStyle.Width != "" {
-			c = append(c, bytesSpace...)
-			c = append(c, []byte("width="+s.Regions[id].InlineStyle.Width)...)
-		}
+		c = append(c, []byte("REGION\n")...)
+		c = append(c, []byte("id:"+s.Regions[id].ID)...)
 		c = append(c, bytesLineSeparator...)
-	}
-	if len(s.Regions) > 0 {
+		if sa := s.Regions[id].InlineStyle; sa != nil {
+			if sa.Width != nil {
+				c = append(c, []byte("width:"+sa.Width.String())...)
+				c = append(c, bytesLineSeparator...)
+			}
+			if sa.Lines != 0 {
+				c = append(c, []byte("lines:"+strconv.Itoa(sa.Lines))...)
+				c = append(c, bytesLineSeparator...)
+			}
+			if len(sa.RegionAnchor) > 0 {
+				c = append(c, []byte("regionanchor:"+formatLengths(sa.RegionAnchor, ","))...)
+				c = append(c, bytesLineSeparator...)
+			}
+			if len(sa.ViewportAnchor) > 0 {
+				c = append(c, []byte("viewportanchor:"+formatLengths(sa.ViewportAnchor, ","))...)
+				c = append(c, bytesLineSeparator...)
+			}
+			if sa.Scroll != "" {
+				c = append(c, []byte("scroll:"+sa.Scroll)...)
+				c = append(c, bytesLineSeparator...)
+			}
+		}
 		c = append(c, bytesLineSeparator...)
 	}
 
 	// Loop through subtitles
 	for index, item := range s.Items {
+		// Validate region
+		if item.Region != nil {
+			if _, ok := s.Regions[item.Region.ID]; !ok {
+				err = fmt.Errorf("Region %s of subtitle starting at %s doesn't exist", item.Region.ID, item.StartAt)
+				return
+			}
+		}
+
 		// Add comments
 		if len(item.Comments) > 0 {
 			c = append(c, []byte("NOTE ")...)
@@ -260,32 +732,10 @@ func (s Subtitles) WriteToWebVTT(o io.Writer) (err error) {
 		c = append(c, bytesSRTTimeBoundariesSeparator...)
 		c = append(c, []byte(formatDurationWebVTT(item.EndAt))...)
 
-		// Add styles
-		if item.InlineStyle != nil {
-			if item.InlineStyle.Align != "" {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("align:"+item.InlineStyle.Align)...)
-			}
-			if item.InlineStyle.Line != "" {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("line:"+item.InlineStyle.Line)...)
-			}
-			if item.InlineStyle.Position != "" {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("position:"+item.InlineStyle.Position)...)
-			}
-			if item.Region != nil {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("region:"+item.Region.ID)...)
-			}
-			if item.InlineStyle.Size != "" {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("size:"+item.InlineStyle.Size)...)
-			}
-			if item.InlineStyle.Vertical != "" {
-				c = append(c, bytesSpace...)
-				c = append(c, []byte("vertical:"+item.InlineStyle.Vertical)...)
-			}
+		// Add settings
+		for _, setting := range webvttItemSettings(item) {
+			c = append(c, bytesSpace...)
+			c = append(c, []byte(setting)...)
 		}
 
 		// Add new line
@@ -19,12 +19,12 @@ func TestWebVTT(t *testing.T) {
 	assert.Equal(t, []string{"This a comment inside the VTT", "and this is the second line"}, s.Items[1].Comments)
 	// Regions
 	assert.Equal(t, 2, len(s.Regions))
-	assert.Equal(t, astisub.Region{ID: "fred", InlineStyle: &astisub.StyleAttributes{Lines: 3, RegionAnchor: "0%,100%", Scroll: "up", ViewportAnchor: "10%,90%", Width: "40%"}}, *s.Regions["fred"])
-	assert.Equal(t, astisub.Region{ID: "bill", InlineStyle: &astisub.StyleAttributes{Lines: 3, RegionAnchor: "100%,100%", Scroll: "up", ViewportAnchor: "90%,90%", Width: "40%"}}, *s.Regions["bill"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 15.99, Left: 10, Top: 74.01, Width: 40}, ID: "fred", InlineStyle: &astisub.StyleAttributes{Lines: 3, RegionAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 0}, {Unit: astisub.LengthUnitPercent, Value: 100}}, Scroll: "up", ViewportAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 10}, {Unit: astisub.LengthUnitPercent, Value: 90}}, Width: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 40}}}, *s.Regions["fred"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 15.99, Left: 50, Top: 74.01, Width: 40}, ID: "bill", InlineStyle: &astisub.StyleAttributes{Lines: 3, RegionAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 100}, {Unit: astisub.LengthUnitPercent, Value: 100}}, Scroll: "up", ViewportAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 90}, {Unit: astisub.LengthUnitPercent, Value: 90}}, Width: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 40}}}, *s.Regions["bill"])
 	assert.Equal(t, s.Regions["bill"], s.Items[0].Region)
 	assert.Equal(t, s.Regions["fred"], s.Items[1].Region)
 	// Styles
-	assert.Equal(t, astisub.StyleAttributes{Align: "left", Position: "10%,start", Size: "35%"}, *s.Items[1].InlineStyle)
+	assert.Equal(t, astisub.StyleAttributes{Align: "left", Position: "10%,start", Size: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 35}}, *s.Items[1].InlineStyle)
 
 	// No subtitles to write
 	w := &bytes.Buffer{}
@@ -37,4 +37,108 @@ func TestWebVTT(t *testing.T) {
 	err = s.WriteToWebVTT(w)
 	assert.NoError(t, err)
 	assert.Equal(t, string(c), w.String())
+
+	// Invalid settings are ignored
+	s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000 align:justify size:auto\nHello\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, astisub.StyleAttributes{}, *s.Items[0].InlineStyle)
+	s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000 position:abc size:150% align:top invalid\nHello\n\n00:00:03.000 --> 00:00:04.000 position:10%,sideways size:abc% align:bottom\nWorld\n")))
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 2)
+	for _, i := range s.Items {
+		assert.Equal(t, astisub.StyleAttributes{}, *i.InlineStyle)
+		assert.Nil(t, i.Geometry)
+	}
+
+	// Auto and invalid lines
+	s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000 line:auto\nHello\n\n00:00:03.000 --> 00:00:04.000 line:abc position:10%,line-left size:50%\nWorld\n\n00:00:05.000 --> 00:00:06.000 line:-1,start\n!\n")))
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 3)
+	assert.Equal(t, "auto", s.Items[0].InlineStyle.Line)
+	assert.Nil(t, s.Items[0].Geometry)
+	assert.Empty(t, s.Items[1].InlineStyle.Line)
+	assert.Equal(t, &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 100, Left: 10, TextAlign: astisub.TextAlignCenter, Width: 50}, s.Items[1].Geometry)
+	assert.Equal(t, astisub.DisplayAlignAfter, s.Items[2].Geometry.DisplayAlign)
+}
+
+func TestWebVTTRegions(t *testing.T) {
+	// Region blocks
+	s, err := astisub.ReadFromWebVTT(bytes.NewReader([]byte(`WEBVTT
+Kind: captions
+
+REGION
+id:fred
+width:40%
+lines:3
+regionanchor:0%,100%
+viewportanchor:10%,90%
+scroll:up
+
+REGION
+id:fred
+width:100%
+
+REGION
+id:bill width:40%
+
+00:00:01.000 --> 00:00:02.000 region:fred
+Hello
+
+00:00:03.000 --> 00:00:04.000 region:bill
+World
+`)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(s.Regions))
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 15.99, Left: 10, Top: 74.01, Width: 40}, ID: "fred", InlineStyle: &astisub.StyleAttributes{Lines: 3, RegionAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 0}, {Unit: astisub.LengthUnitPercent, Value: 100}}, Scroll: "up", ViewportAnchor: []astisub.Length{{Unit: astisub.LengthUnitPercent, Value: 10}, {Unit: astisub.LengthUnitPercent, Value: 90}}, Width: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 40}}}, *s.Regions["fred"])
+	assert.Equal(t, astisub.Region{Geometry: &astisub.Geometry{DisplayAlign: astisub.DisplayAlignAfter, Height: 15.99, Left: 0, Top: 84.01, Width: 40}, ID: "bill", InlineStyle: &astisub.StyleAttributes{Width: &astisub.Length{Unit: astisub.LengthUnitPercent, Value: 40}}}, *s.Regions["bill"])
+	assert.Equal(t, 2, len(s.Items))
+	assert.Equal(t, s.Regions["fred"], s.Items[0].Region)
+	assert.Equal(t, s.Regions["bill"], s.Items[1].Region)
+
+	// Write
+	w := &bytes.Buffer{}
+	err = s.WriteToWebVTT(w)
+	assert.NoError(t, err)
+	assert.Equal(t, "WEBVTT\n\nREGION\nid:bill\nwidth:40%\n\nREGION\nid:fred\nwidth:40%\nlines:3\nregionanchor:0%,100%\nviewportanchor:10%,90%\nscroll:up\n\n1\n00:00:01.000 --> 00:00:02.000 region:fred\nHello\n\n2\n00:00:03.000 --> 00:00:04.000 region:bill\nWorld\n", w.String())
+
+	// Region copy without inline style
+	s.Items[1].Region = &astisub.Region{ID: "bill"}
+	s.Items[1].InlineStyle = nil
+	w.Reset()
+	err = s.WriteToWebVTT(w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "00:00:03.000 --> 00:00:04.000 region:bill\n")
+
+	// Unknown region
+	s.Items[1].Region = &astisub.Region{ID: "unknown"}
+	err = s.WriteToWebVTT(&bytes.Buffer{})
+	assert.Error(t, err)
+	s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000 region:unknown\nHello\n")))
+	assert.NoError(t, err)
+	assert.Len(t, s.Items, 1)
+	assert.Nil(t, s.Items[0].Region)
+
+	// Region ids can contain dashes
+	s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\nREGION\nid:a--b\n\nREGION\nid:c-->d\n\n00:00:01.000 --> 00:00:02.000 region:a--b\nHello\n")))
+	assert.NoError(t, err)
+	assert.Len(t, s.Regions, 1)
+	assert.Equal(t, s.Regions["a--b"], s.Items[0].Region)
+
+	// Invalid settings are ignored
+	for r, e := range map[string]*astisub.StyleAttributes{
+		"id:fred width:40px":       {},
+		"id:fred lines:-1":         {},
+		"id:fred regionanchor:10%": {},
+		"id:fred scroll:down":      {},
+		"id:fred lines:2 width:x":  {Lines: 2},
+		"width:40%":                nil,
+	} {
+		s, err = astisub.ReadFromWebVTT(bytes.NewReader([]byte("WEBVTT\n\nREGION\n" + r + "\n")))
+		assert.NoError(t, err, r)
+		if e == nil {
+			assert.Len(t, s.Regions, 0, r)
+		} else if assert.Contains(t, s.Regions, "fred", r) {
+			assert.Equal(t, e, s.Regions["fred"].InlineStyle, r)
+		}
+	}
 }